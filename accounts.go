@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+)
+
+// Every nick on a server is an account with a password. The hello either
+// registers the nick, which has to be free, or logs in to it, and the
+// server only welcomes the client once that worked. The client asks for
+// the nick and password on a login screen before showing the chat, and
+// reconnects with the session it gets, see tokens.go. A nick can also be
+// an identity at the server's identity provider instead, see oidc.go, or
+// come from a directory, see ldap.go.
+
+const minPasswordLength = 8
+
+var (
+	errBadLogin     = errors.New("wrong nick or password")
+	errNickTaken    = errors.New("that nick is already registered")
+	errWeakPassword = errors.New("passwords need at least 8 characters")
+)
+
+// loginErrors are the handshake failures sent as frameLoginFailed, for
+// the client to ask for the nick and password again.
+var loginErrors = []error{errBadLogin, errNickTaken, errWeakPassword, errSessionExpired,
+	errNoSSO, errSSODenied, errSSOExpired, errNoRegistration, errInviteRequired, errBadInvite,
+	errNoGuests, errGuestNickInUse}
+
+// --- Server side ---
+
+// account is a registered nick.
+type account struct {
+	Hash string `json:"hash"` // of the password, see passwordhash.go
+	// Identity is who signs in to it at the identity provider, with no
+	// password then
+	Identity string `json:"identity,omitempty"`
+	// Directory accounts log in with the directory, which gives them Roles
+	Directory bool      `json:"directory,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// isAdmin is whether nick is a server admin or owner (see roles.go).
+func (srv *server) isAdmin(nick string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.serverRoleLocked(nick) >= roleAdmin
+}
+
+// authenticate registers or logs in to the nick of a hello, starting a
+// session (see tokens.go), or resumes one. Hashing is slow on purpose, so
+// it is done without holding srv.mu.
+func (srv *server) authenticate(s *session, hello helloData) (*sessionTokens, error) {
+	if hello.SSO {
+		return srv.authenticateSSO(s)
+	}
+	if hello.Guest {
+		return srv.authenticateGuest(s)
+	}
+	if hello.Password == "" {
+		return srv.resumeSession(s, hello)
+	}
+
+	srv.mu.Lock()
+	acct, exists := srv.accounts[s.nick]
+	srv.mu.Unlock()
+
+	if !hello.Register {
+		if srv.directory != nil && (!exists || acct.Directory) {
+			return srv.authenticateDirectory(s, hello.Password)
+		}
+		// Compare against something even for unknown nicks and ones
+		// without a password, so the time taken doesn't tell which exist
+		hash := acct.Hash
+		if hash == "" {
+			hash = srv.dummyHash
+		}
+		ok, stale, err := checkPassword(hash, hello.Password, srv.passwordCost)
+		if err != nil {
+			log.Printf("accounts: %s: %v", s.nick, err)
+		}
+		if !ok || acct.Hash == "" {
+			return nil, errBadLogin
+		}
+		if stale {
+			srv.rehash(s, acct, hello.Password)
+		}
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return srv.issueTokensLocked(s)
+	}
+
+	if srv.directory != nil {
+		return nil, errNoRegistration
+	}
+	if exists {
+		return nil, errNickTaken
+	}
+	if len(hello.Password) < minPasswordLength {
+		return nil, errWeakPassword
+	}
+	// Checked before hashing too, so registering without a code is cheap
+	srv.mu.Lock()
+	_, err := srv.inviteForLocked(hello.Invite, s.stamp.Time)
+	srv.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(hello.Password, srv.passwordCost)
+	if err != nil {
+		return nil, err
+	}
+	acct = account{Hash: hash, Created: s.stamp.Time}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, taken := srv.accounts[s.nick]; taken {
+		return nil, errNickTaken
+	}
+	inv, err := srv.inviteForLocked(hello.Invite, s.stamp.Time)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveAccount(s.nick, acct) }); err != nil {
+		return nil, err
+	}
+	srv.accounts[s.nick] = acct
+	srv.publish(clusterEvent{Kind: clusterAccount, Nick: s.nick, Account: &acct})
+	if inv != nil {
+		srv.redeemLocked(s, *inv)
+	}
+	return srv.issueTokensLocked(s)
+}
+
+// rehash replaces the hash of an account whose owner just logged in with
+// one at the current cost. Failing that only means it is tried again next
+// time.
+func (srv *server) rehash(s *session, old account, password string) {
+	hash, err := hashPassword(password, srv.passwordCost)
+	if err != nil {
+		log.Printf("accounts: rehashing %s: %v", s.nick, err)
+		return
+	}
+	acct := account{Hash: hash, Created: old.Created}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	// Unless the password changed meanwhile
+	if srv.accounts[s.nick].Hash != old.Hash {
+		return
+	}
+	if s.persistLocked(func(st serverStore) error { return st.saveAccount(s.nick, acct) }) != nil {
+		return
+	}
+	srv.accounts[s.nick] = acct
+	srv.publish(clusterEvent{Kind: clusterAccount, Nick: s.nick, Account: &acct})
+}
+
+// handshakeFailure is the frame telling a client why it wasn't let in.
+func handshakeFailure(err error) frame {
+	if slices.ContainsFunc(loginErrors, func(target error) bool { return errors.Is(err, target) }) {
+		return frame{Type: frameLoginFailed, Error: err.Error()}
+	}
+	return frame{Type: frameError, Error: err.Error()}
+}
+
+// --- Client side ---
+
+// credentials are what a network logs in with.
+type credentials struct {
+	nick     string
+	password string // empty until asked for, and once there is a session
+	register bool   // register the nick rather than log in
+	invite   string // code to register with, see invites.go
+	sso      bool   // sign in with the server's identity provider instead
+	guest    bool   // come in without an account, see guests.go
+	tokens   sessionTokens
+	resume   string // takes the last connection's session over, see resume.go
+}
+
+// usable is whether there is something to log in with.
+func (c credentials) usable() bool {
+	return c.password != "" || c.sso || c.guest || c.tokens.Refresh != ""
+}
+
+// loginError is a handshake the server turned down over the nick or
+// password.
+type loginError struct{ reason string }
+
+func (e *loginError) Error() string { return e.reason }
+
+// loginMsg is the login screen submitted for a network.
+type loginMsg struct {
+	n     *network
+	creds credentials
+}
+
+const (
+	loginFieldNick = iota
+	loginFieldPassword
+	loginFieldRegister
+	loginFieldInvite // only while registering
+	loginFieldSSO
+	loginFieldGuest
+	loginFieldCount
+)
+
+// loginScreen asks for the nick and password of a network, in place of
+// the chat until it is submitted. While a single sign-on waits for the
+// user it shows the code to approve instead, see oidc.go.
+type loginScreen struct {
+	n        *network
+	nick     textinput.Model
+	password textinput.Model
+	invite   textinput.Model
+	register bool
+	sso      bool
+	guest    bool
+	field    int
+	err      string
+
+	pending *client     // connection waiting on device
+	device  *deviceCode // nil unless waiting
+}
+
+func newLoginScreen(n *network, reason string) *loginScreen {
+	nick := newTextInput()
+	nick.Prompt = ""
+	nick.Placeholder = "nick"
+	nick.CharLimit = 32
+	nick.SetValue(n.creds.nick)
+
+	password := newTextInput()
+	password.Prompt = ""
+	password.Placeholder = "password"
+	password.EchoMode = textinput.EchoPassword
+	password.EchoCharacter = '•'
+
+	invite := newTextInput()
+	invite.Prompt = ""
+	invite.Placeholder = "invite code, if the server wants one"
+	invite.SetValue(n.creds.invite)
+
+	l := &loginScreen{n: n, nick: nick, password: password, invite: invite, register: n.creds.register, sso: n.creds.sso, guest: n.creds.guest, err: reason}
+	if n.creds.nick == "" {
+		l.focusField(loginFieldNick)
+	} else {
+		l.focusField(loginFieldPassword)
+	}
+	return l
+}
+
+func (l *loginScreen) focusField(i int) {
+	l.field = (i + loginFieldCount) % loginFieldCount
+	l.nick.Blur()
+	l.password.Blur()
+	l.invite.Blur()
+	switch l.field {
+	case loginFieldNick:
+		l.nick.Focus()
+	case loginFieldPassword:
+		l.password.Focus()
+	case loginFieldInvite:
+		l.invite.Focus()
+	}
+}
+
+// stepField moves the focus by d, past the invite code unless
+// registering.
+func (l *loginScreen) stepField(d int) {
+	l.focusField(l.field + d)
+	if l.field == loginFieldInvite && !l.register {
+		l.focusField(l.field + d)
+	}
+}
+
+func (l *loginScreen) Update(msg tea.Msg) (*loginScreen, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return l, nil
+	}
+	if l.device != nil {
+		l.updateDevice(keyMsg)
+		return l, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, keys.NextField):
+		l.stepField(1)
+		return l, nil
+	case key.Matches(keyMsg, keys.PrevField):
+		l.stepField(-1)
+		return l, nil
+	case l.field == loginFieldRegister && key.Matches(keyMsg, keys.Toggle):
+		l.register = !l.register
+		return l, nil
+	case l.field == loginFieldSSO && key.Matches(keyMsg, keys.Toggle):
+		l.sso = !l.sso
+		return l, nil
+	case l.field == loginFieldGuest && key.Matches(keyMsg, keys.Toggle):
+		l.guest = !l.guest
+		return l, nil
+	case key.Matches(keyMsg, keys.Select):
+		creds := credentials{nick: strings.TrimSpace(l.nick.Value()), password: l.password.Value(), register: l.register}
+		if l.register {
+			creds.invite = strings.TrimSpace(l.invite.Value())
+		}
+		if l.sso {
+			// The nick is only used if the identity has none yet
+			creds = credentials{nick: creds.nick, sso: true}
+		}
+		if l.guest {
+			creds = credentials{nick: strings.TrimPrefix(creds.nick, guestPrefix), guest: true}
+		}
+		switch {
+		case creds.nick == "" || strings.ContainsAny(creds.nick, " #@"):
+			l.err = "Nicks can't be empty or contain spaces, # or @"
+			l.focusField(loginFieldNick)
+			return l, nil
+		case isGuestNick(creds.nick):
+			l.err = "Only guests' nicks start with " + guestPrefix
+			l.focusField(loginFieldNick)
+			return l, nil
+		case creds.sso, creds.guest:
+		case creds.password == "":
+			l.err = "Enter your password"
+			l.focusField(loginFieldPassword)
+			return l, nil
+		case creds.register && len(creds.password) < minPasswordLength:
+			l.err = errWeakPassword.Error()
+			l.focusField(loginFieldPassword)
+			return l, nil
+		}
+		n := l.n
+		return nil, func() tea.Msg { return loginMsg{n: n, creds: creds} }
+	}
+
+	var cmd tea.Cmd
+	switch l.field {
+	case loginFieldNick:
+		l.nick, cmd = l.nick.Update(msg)
+	case loginFieldPassword:
+		l.password, cmd = l.password.Update(msg)
+	case loginFieldInvite:
+		l.invite, cmd = l.invite.Update(msg)
+	}
+	return l, cmd
+}
+
+func (l *loginScreen) View(width, height int) string {
+	w := min(50, width-4)
+
+	label := func(i int, s string) string {
+		if i == l.field {
+			return overlayPromptStyle.Render("› " + s)
+		}
+		return overlayHintStyle.Render("  " + s)
+	}
+	check := func(on bool) string {
+		if on {
+			return "[x]"
+		}
+		return "[ ]"
+	}
+	action := "log in"
+	switch {
+	case l.guest:
+		action = "come in as a guest"
+	case l.sso:
+		action = "sign in"
+	case l.register:
+		action = "register"
+	}
+
+	var lines []string
+	if l.device != nil {
+		lines = l.deviceLines()
+	} else {
+		lines = []string{
+			overlayTitleStyle.Render("Log in to " + l.n.name),
+			"",
+			label(loginFieldNick, "Nick"),
+			"  " + l.nick.View(),
+			label(loginFieldPassword, "Password"),
+			"  " + l.password.View(),
+			label(loginFieldRegister, "New account  "+check(l.register)),
+		}
+		if l.register {
+			lines = append(lines, label(loginFieldInvite, "Invite code"), "  "+l.invite.View())
+		}
+		lines = append(lines, label(loginFieldSSO, "Single sign-on  "+check(l.sso)),
+			label(loginFieldGuest, "Guest, no account  "+check(l.guest)))
+		if l.err != "" {
+			lines = append(lines, "", errorTextStyle.Width(w-2).Render(l.err))
+		}
+		lines = append(lines, "", overlayHintStyle.Render("tab field • space toggle • enter "+action))
+	}
+
+	form := overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, form)
+}
+
+// envPassword is the password to log in with from $GOCHAT_PASSWORD, for
+// scripts, skipping the login screen.
+func envPassword() string {
+	return os.Getenv("GOCHAT_PASSWORD")
+}
+
+// askPassword reads a password from the terminal without echoing it, for
+// the command line tools.
+func askPassword(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	if !term.IsTerminal(fd) {
+		return "", errors.New("set GOCHAT_PASSWORD to log in without a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(p), err
+}
+
+// nextLogin shows the login screen for the first network that has no
+// password or session yet, or nothing if they all have one.
+func (m *model) nextLogin() {
+	m.login = nil
+	for _, n := range m.networks {
+		if !n.creds.usable() {
+			m.login = newLoginScreen(n, "")
+			return
+		}
+	}
+}
+
+// applyLogin connects a network with the credentials just entered, and
+// moves on to the next one that needs them.
+func (m *model) applyLogin(msg loginMsg) tea.Cmd {
+	msg.n.creds = msg.creds
+	m.nextLogin()
+	return m.connectNetwork(msg.n)
+}
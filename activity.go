@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxActivity caps how many entries the activity center keeps.
+const maxActivity = 200
+
+const activityVisibleRows = 12
+
+type activityKind int
+
+const (
+	activityMention activityKind = iota
+	activityReply
+	activityReaction
+)
+
+func (k activityKind) icon() string {
+	switch k {
+	case activityReply:
+		return "↪"
+	case activityReaction:
+		return "+"
+	default:
+		return "@"
+	}
+}
+
+// activity is one entry in the activity center: something that happened to
+// or about us in some buffer.
+type activity struct {
+	kind    activityKind
+	channel string
+	msgID   string
+	nick    string // who mentioned, replied or reacted
+	text    string
+	time    time.Time
+}
+
+type jumpToMessageMsg struct {
+	channel string
+	id      string
+}
+
+func (m *model) recordActivity(a activity) {
+	m.activity = append(m.activity, a)
+	if len(m.activity) > maxActivity {
+		m.activity = m.activity[len(m.activity)-maxActivity:]
+	}
+	m.activityUnseen++
+}
+
+// noteActivity records msg in the activity center if it mentions us or
+// replies to one of our messages.
+func (m *model) noteActivity(ch *channel, msg message) {
+	if msg.system || m.nick == "" || msg.nick == m.nick {
+		return
+	}
+	a := activity{channel: ch.name, msgID: msg.id, nick: msg.nick, text: msg.text, time: msg.time}
+	switch {
+	case msg.replyTo != "" && m.isOwnMessage(ch, msg.replyTo):
+		a.kind = activityReply
+	case m.isMention(msg):
+		a.kind = activityMention
+	default:
+		return
+	}
+	m.recordActivity(a)
+}
+
+func (m *model) isOwnMessage(ch *channel, id string) bool {
+	i := ch.messageIndex(id)
+	return i >= 0 && ch.messages[i].nick == m.nick
+}
+
+// activityCenter is the overlay listing recent activity, newest first.
+type activityCenter struct {
+	entries []activity
+	cursor  int
+	offset  int
+}
+
+func newActivityCenter(m *model) *activityCenter {
+	c := &activityCenter{}
+	for i := len(m.activity) - 1; i >= 0; i-- {
+		c.entries = append(c.entries, m.activity[i])
+	}
+	m.activityUnseen = 0
+	return c
+}
+
+func (c *activityCenter) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return c, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if c.cursor > 0 {
+			c.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if c.cursor < len(c.entries)-1 {
+			c.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		if len(c.entries) == 0 {
+			return c, nil
+		}
+		a := c.entries[c.cursor]
+		return nil, func() tea.Msg { return jumpToMessageMsg{channel: a.channel, id: a.msgID} }
+	}
+
+	if c.cursor < c.offset {
+		c.offset = c.cursor
+	}
+	if c.cursor >= c.offset+activityVisibleRows {
+		c.offset = c.cursor - activityVisibleRows + 1
+	}
+	return c, nil
+}
+
+func (c *activityCenter) View(width, height int) string {
+	w := 70
+	if width-4 < w {
+		w = width - 4
+	}
+	whereW := 26
+	textW := w - 2 - 2 - whereW - 2 - 5
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Activity (%d)", len(c.entries))), ""}
+	if len(c.entries) == 0 {
+		lines = append(lines, overlayHintStyle.Render("Mentions, replies and reactions to you show up here"))
+	}
+	end := c.offset + activityVisibleRows
+	if end > len(c.entries) {
+		end = len(c.entries)
+	}
+	for i := c.offset; i < end; i++ {
+		a := c.entries[i]
+		where := truncate(a.nick+" in "+a.channel, whereW)
+		row := a.kind.icon() + " " +
+			lipgloss.NewStyle().Width(whereW).Render(where) + "  " +
+			lipgloss.NewStyle().Width(textW).Render(truncate(a.text, textW)) +
+			timestampStyle.Render(a.time.Local().Format("15:04"))
+		if i == c.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("enter jump to message • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func cmdActivity(m *model, _ string) tea.Cmd {
+	m.overlay = newActivityCenter(m)
+	return nil
+}
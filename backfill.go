@@ -0,0 +1,191 @@
+package main
+
+import (
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// backfillPageSize is how many older messages one backfill step asks for.
+const backfillPageSize = 50
+
+// olderMessagesMsg carries messages read from the store for the top of a
+// buffer. An empty page means the store has nothing older.
+type olderMessagesMsg struct {
+	channel string
+	msgs    []message
+}
+
+// backfill starts loading messages older than the top of p's buffer, if p
+// is scrolled up to the first loaded message and nothing is in flight.
+// The store is tried first, then the server.
+func (m *model) backfill(p *pane) tea.Cmd {
+	ch := m.channelByName(p.buffer)
+	if ch == nil || ch.loadingOlder || ch.noMoreOlder || len(ch.messages) == 0 {
+		return nil
+	}
+	if p.follow || (p.viewport.YOffset > 0 && p.selected != 0) {
+		return nil
+	}
+	ch.loadingOlder = true
+	before, name := ch.messages[0].time, ch.name
+
+	load := func() tea.Msg { return olderMessagesMsg{channel: name} }
+	if s := m.store; s != nil {
+		network := m.storeNetwork()
+		load = func() tea.Msg {
+			msgs, err := s.loadMessagesBefore(network, name, before, backfillPageSize)
+			if err != nil {
+				return errMsg{err}
+			}
+			return olderMessagesMsg{channel: name, msgs: msgs}
+		}
+	}
+	cmd := tea.Cmd(load)
+	if n := m.currentNetwork(); n != nil {
+		cmd = tagCmd(n, cmd)
+	}
+	return tea.Batch(cmd, m.startSpinner())
+}
+
+// handleOlderMessages stitches a store page onto its buffer, or asks the
+// server once the store has run out.
+func (m *model) handleOlderMessages(msg olderMessagesMsg) tea.Cmd {
+	ch := m.channelByName(msg.channel)
+	if ch == nil {
+		return nil
+	}
+	if len(msg.msgs) > 0 {
+		m.prependMessages(ch, msg.msgs)
+		ch.loadingOlder = false
+		return nil
+	}
+	if m.client == nil || len(ch.messages) == 0 {
+		// Offline there may still be more on the server, try again later
+		ch.loadingOlder = false
+		return nil
+	}
+	id, cmd := m.client.send(frameHistory, historyRequest{
+		Channel: ch.name,
+		Before:  ch.messages[0].time,
+		Limit:   backfillPageSize,
+	})
+	ch.historyReq = id
+	return cmd
+}
+
+// handleHistoryPage stitches a server page onto its buffer and keeps it in
+// the store, so the next scroll back finds it locally.
+func (m *model) handleHistoryPage(f frame) {
+	var page historyPage
+	if err := f.decode(&page); err != nil {
+		return
+	}
+	ch := m.channelByName(page.Channel)
+	if ch == nil {
+		return
+	}
+	msgs := make([]message, 0, len(page.Messages))
+	for _, w := range page.Messages {
+		msgs = append(msgs, m.openMessage(w))
+	}
+	m.prependMessages(ch, msgs)
+	ch.loadingOlder = false
+	ch.noMoreOlder = !page.More
+	ch.historyReq = ""
+	m.saveMessages(ch, msgs)
+}
+
+// historyFailed stops the backfill a rejected history request belonged to.
+func (m *model) historyFailed(id string) {
+	for _, ch := range m.channels {
+		if id != "" && ch.historyReq == id {
+			ch.loadingOlder = false
+			ch.noMoreOlder = true
+			ch.historyReq = ""
+		}
+	}
+}
+
+// prependMessages puts older messages in front of ch, keeping the panes
+// showing it on the same selected message.
+func (m *model) prependMessages(ch *channel, older []message) {
+	n := ch.prependMessages(older)
+	for _, p := range m.panes {
+		if p.buffer == ch.name && p.selected >= 0 {
+			p.selected += n
+		}
+	}
+}
+
+// prependMessages puts older messages in front of the buffer, skipping any
+// that are already loaded. It returns how many were added.
+func (c *channel) prependMessages(older []message) int {
+	var first time.Time
+	if len(c.messages) > 0 {
+		first = c.messages[0].time
+	}
+	keep := make([]message, 0, len(older))
+	for _, msg := range older {
+		if (first.IsZero() || !msg.time.After(first)) && c.messageIndex(msg.id) < 0 {
+			keep = append(keep, msg)
+		}
+	}
+	c.messages = append(keep, c.messages...)
+	return len(keep)
+}
+
+// --- Loading row ---
+
+// startSpinner starts the loading row animation unless it is running.
+func (m *model) startSpinner() tea.Cmd {
+	if m.spinning || reduceMotion {
+		return nil
+	}
+	m.spinning = true
+	return m.spinner.Tick
+}
+
+// updateSpinner advances the loading row animation, letting it stop once
+// no buffer on any network is loading.
+func (m *model) updateSpinner(msg spinner.TickMsg) tea.Cmd {
+	if !m.backfilling() || reduceMotion {
+		m.spinning = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+	return cmd
+}
+
+func (m *model) backfilling() bool {
+	for _, ch := range m.channels {
+		if ch.loadingOlder {
+			return true
+		}
+	}
+	for i, n := range m.networks {
+		if i == m.net {
+			continue
+		}
+		for _, ch := range n.stash.channels {
+			if ch.loadingOlder {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadingRow is the line shown above a buffer's messages while older ones
+// are on their way.
+func (m *model) loadingRow(ch *channel) string {
+	if ch == nil || !ch.loadingOlder {
+		return ""
+	}
+	if reduceMotion {
+		return systemMessageStyle.Render("Loading older messages…")
+	}
+	return systemMessageStyle.Render(m.spinner.View() + " Loading older messages…")
+}
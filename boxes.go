@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The header, the panes, the composer and the sidebars are each drawn in a
+// box. [ui] borders picks their lines: "normal", the default, with square
+// boxes and a rounded composer, "rounded" or "square" for all of them, or
+// "none" for no boxes at all, which gives back two rows each to the
+// messages. Without boxes a pane or the composer that has focus gets a
+// thick bar down its left side instead. [ui] density = "compact" leaves
+// out the blank rows above the header and the sidebars as well.
+
+// boxLook is how the layout's boxes are drawn.
+type boxLook struct {
+	borders string // normal, rounded, square or none
+	compact bool
+}
+
+// chrome is the current look, see boxLookFor.
+var chrome = boxLook{borders: "normal"}
+
+// boxLookFor is the look [ui] borders and density pick.
+func boxLookFor(borders, density string) (boxLook, error) {
+	look := boxLook{borders: borders}
+	switch borders {
+	case "":
+		look.borders = "normal"
+	case "normal", "rounded", "square", "none":
+	default:
+		return chrome, fmt.Errorf("ui: borders is normal, rounded, square or none, not %q", borders)
+	}
+	switch density {
+	case "", "comfortable":
+	case "compact":
+		look.compact = true
+	default:
+		return chrome, fmt.Errorf("ui: density is comfortable or compact, not %q", density)
+	}
+	return look, nil
+}
+
+// gap is the blank rows above the header and the sidebars.
+func (b boxLook) gap() int {
+	if b.compact {
+		return 0
+	}
+	return 1
+}
+
+// box is a style with shape's border in color, or whichever border
+// [ui] borders puts in its place.
+func (b boxLook) box(shape lipgloss.Border, color lipgloss.Color) lipgloss.Style {
+	switch b.borders {
+	case "none":
+		return lipgloss.NewStyle()
+	case "rounded":
+		shape = lipgloss.RoundedBorder()
+	case "square":
+		shape = lipgloss.NormalBorder()
+	}
+	return lipgloss.NewStyle().Border(shape).BorderForeground(color)
+}
+
+// focusBox is box for what can have focus: without borders it keeps a
+// blank left edge for focusedBorder to draw on.
+func (b boxLook) focusBox(shape lipgloss.Border, color lipgloss.Color) lipgloss.Style {
+	if b.borders == "none" {
+		return lipgloss.NewStyle().
+			Border(lipgloss.HiddenBorder(), false, false, false, true).
+			BorderForeground(color)
+	}
+	return b.box(shape, color)
+}
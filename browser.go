@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const browserVisibleRows = 12
+
+type joinChannelMsg struct{ name string }
+
+// channelBrowser is the /list overlay of the server's public channels.
+type channelBrowser struct {
+	input   textinput.Model
+	all     []channelInfo
+	results []channelInfo
+	cursor  int
+	offset  int
+	joined  map[string]bool
+
+	archived bool // browsing the archive rather than live channels
+}
+
+func newChannelBrowser(list []channelInfo, archived bool, m *model) *channelBrowser {
+	ti := newTextInput()
+	ti.Placeholder = "Filter channels"
+	ti.Prompt = icons.Search + " "
+	ti.PromptStyle = overlayPromptStyle
+	ti.Focus()
+
+	b := &channelBrowser{input: ti, all: list, joined: make(map[string]bool), archived: archived}
+	for _, ch := range m.channels {
+		b.joined[ch.name] = true
+	}
+	b.filter()
+	return b
+}
+
+func (b *channelBrowser) filter() {
+	q := strings.ToLower(strings.TrimSpace(b.input.Value()))
+	b.results = b.results[:0]
+	for _, ch := range b.all {
+		if q == "" || strings.Contains(strings.ToLower(ch.Name), q) || strings.Contains(strings.ToLower(ch.Topic), q) {
+			b.results = append(b.results, ch)
+		}
+	}
+	b.cursor, b.offset = 0, 0
+}
+
+func (b *channelBrowser) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return b, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if b.cursor > 0 {
+			b.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if b.cursor < len(b.results)-1 {
+			b.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		if len(b.results) == 0 {
+			return b, nil
+		}
+		name := b.results[b.cursor].Name
+		return nil, func() tea.Msg { return joinChannelMsg{name: name} }
+	default:
+		var cmd tea.Cmd
+		prev := b.input.Value()
+		b.input, cmd = b.input.Update(msg)
+		if b.input.Value() != prev {
+			b.filter()
+		}
+		return b, cmd
+	}
+
+	// Keep the cursor inside the visible window
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+browserVisibleRows {
+		b.offset = b.cursor - browserVisibleRows + 1
+	}
+	return b, nil
+}
+
+func (b *channelBrowser) View(width, height int) string {
+	w := 70
+	if width-4 < w {
+		w = width - 4
+	}
+	nameW := 20
+	countW := 6
+	topicW := w - 2 - nameW - countW - 4
+
+	title := "Channels"
+	hint := "type to filter • enter join • esc close"
+	if b.archived {
+		title = "Archived channels"
+		hint = "type to filter • enter browse history • esc close"
+	}
+	lines := []string{
+		overlayTitleStyle.Render(fmt.Sprintf("%s (%d)", title, len(b.all))),
+		b.input.View(),
+		"",
+	}
+	if len(b.results) == 0 {
+		lines = append(lines, overlayHintStyle.Render("No channels match"))
+	}
+	end := b.offset + browserVisibleRows
+	if end > len(b.results) {
+		end = len(b.results)
+	}
+	for i := b.offset; i < end; i++ {
+		ch := b.results[i]
+		name := truncate(ch.Name, nameW)
+		if b.joined[ch.Name] {
+			name = truncate("✓ "+ch.Name, nameW)
+		}
+		row := lipgloss.NewStyle().Width(nameW).Render(name) + "  " +
+			overlayHintStyle.Width(countW).Align(lipgloss.Right).Render(fmt.Sprintf("%d", ch.Members)) + "  " +
+			overlayHintStyle.Render(truncate(ch.Topic, topicW))
+		if i == b.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render(hint))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// nickStyle picks a stable color for nick so the same person always looks
+// the same across buffers.
+func nickStyle(nick string) lipgloss.Style {
+	h := fnv.New32a()
+	h.Write([]byte(nick))
+	return lipgloss.NewStyle().
+		Foreground(palette.Nicks[h.Sum32()%uint32(len(palette.Nicks))]).
+		Bold(true)
+}
+
+// defaultMessageFormat lays out messages unless the config file's [ui]
+// message_format says otherwise. What comes after {body} ends its text.
+const defaultMessageFormat = "{time} {nick} {flags} {body} {edited}"
+
+// messageFields are the fields of message formats, besides {body}.
+var messageFields = map[string]func(msg message, layout messageLayout, col nickColumn) string{
+	"time": func(msg message, layout messageLayout, col nickColumn) string {
+		return timestampStyle.Render(msg.time.Format(layout.time))
+	},
+	"date": func(msg message, _ messageLayout, _ nickColumn) string {
+		return timestampStyle.Render(msg.time.Format("2006-01-02"))
+	},
+	"nick": func(msg message, layout messageLayout, col nickColumn) string {
+		return layout.nick.renderNick(msg, col)
+	},
+	"flags": func(msg message, _ messageLayout, _ nickColumn) string {
+		if msg.unverified {
+			// Its signature didn't check out, see signing.go
+			return unverifiedStyle.Render("⚠")
+		}
+		if msg.imported {
+			// Who sent it and when are the importer's word
+			return editedStyle.Render("(imported)")
+		}
+		return ""
+	},
+	"edited": func(msg message, _ messageLayout, _ nickColumn) string {
+		if msg.edited.IsZero() {
+			return ""
+		}
+		return editedStyle.Render("(edited)")
+	},
+}
+
+// messageLayout is a parsed message format, split at {body}, with the time
+// layout {time} is written in and how {nick} is drawn.
+type messageLayout struct {
+	prefix, suffix []statusToken
+	time           string
+	nick           nickLook
+}
+
+// parseMessageFormat parses format, the default if empty, with {time} in
+// timeLayout, 15:04 if empty.
+func parseMessageFormat(format, timeLayout string) (messageLayout, error) {
+	prefix, suffix, ok := strings.Cut(cmp.Or(format, defaultMessageFormat), "{body}")
+	if !ok {
+		return messageLayout{}, fmt.Errorf("ui: message_format %q has no {body}", format)
+	}
+	layout := messageLayout{prefix: parseStatusFormat(prefix), suffix: parseStatusFormat(suffix), time: cmp.Or(timeLayout, "15:04")}
+	for _, t := range slices.Concat(layout.prefix, layout.suffix) {
+		if t.segment && messageFields[t.text] == nil {
+			return messageLayout{}, fmt.Errorf("ui: message_format has no field {%s}, want {time}, {date}, {nick}, {flags}, {body} or {edited}", t.text)
+		}
+	}
+	return layout, nil
+}
+
+// messageFormat is how messages are laid out, see parseMessageFormat.
+var messageFormat, _ = parseMessageFormat("", "")
+
+// expand renders tokens for msg, in a buffer with nick column col,
+// dropping the spaces before a field that came out empty.
+func (layout messageLayout) expand(msg message, col nickColumn, tokens []statusToken) string {
+	values := make([]string, len(tokens))
+	for i, t := range tokens {
+		if t.segment {
+			values[i] = messageFields[t.text](msg, layout, col)
+		}
+	}
+	var b strings.Builder
+	for i, t := range tokens {
+		switch {
+		case t.segment:
+			b.WriteString(values[i])
+		case strings.TrimSpace(t.text) == "" && i+1 < len(tokens) && values[i+1] == "":
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
+
+// renderMessage renders one message as messageFormat lays it out, "15:04
+// nick text" by default, with the nicks in col, wrapping the body with a
+// hanging indent so continuation lines line up under the text.
+func renderMessage(msg message, col nickColumn, width int) string {
+	prefix := messageFormat.expand(msg, col, messageFormat.prefix)
+	text := msg.text + messageFormat.expand(msg, col, messageFormat.suffix)
+	prefixW := lipgloss.Width(prefix)
+
+	bodyW := width - prefixW
+	if bodyW < 10 {
+		// Too narrow for a hanging indent, put the body on its own lines
+		return prefix + "\n" + lipgloss.NewStyle().Width(width).Render(text)
+	}
+
+	bodyStyle := lipgloss.NewStyle().Width(bodyW)
+	switch {
+	case msg.system:
+		bodyStyle = bodyStyle.Inherit(systemMessageStyle)
+	case msg.pending:
+		bodyStyle = bodyStyle.Inherit(pendingMessageStyle)
+	}
+	body := bodyStyle.Render(text)
+	lines := strings.Split(body, "\n")
+	indent := strings.Repeat(" ", prefixW)
+	for i := range lines {
+		if i == 0 {
+			lines[i] = prefix + lines[i]
+		} else {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// messageIndent lines extras up under the nick, past the "15:04 " timestamp.
+const messageIndent = "      "
+
+// renderReplyContext renders the "↪ nick: text" line shown above a reply.
+func renderReplyContext(ch *channel, id string, width int) string {
+	quote := "↪ earlier message"
+	if i := ch.messageIndex(id); i >= 0 {
+		parent := ch.messages[i]
+		quote = "↪ " + parent.nick + ": " + strings.ReplaceAll(parent.text, "\n", " ")
+	}
+	return replyContextStyle.Render(truncate(messageIndent+quote, width))
+}
+
+// renderBuffer renders every message in ch for a pane of the given width.
+// It also returns the first line of each message, for keeping a selection
+// in view.
+func renderBuffer(ch *channel, width, selected int) (string, []int) {
+	if ch == nil || len(ch.messages) == 0 {
+		return emptyBufferStyle.Render("No messages yet"), nil
+	}
+
+	var b strings.Builder
+	offsets := make([]int, len(ch.messages))
+	line := 0
+	col := messageFormat.nick.columnFor(ch)
+	for i, msg := range ch.messages {
+		rendered := renderMessage(msg, col, width)
+		if msg.replyTo != "" {
+			rendered = renderReplyContext(ch, msg.replyTo, width) + "\n" + rendered
+		}
+		if len(msg.reactions) > 0 {
+			rendered += "\n" + reactionStyle.Render(truncate(messageIndent+reactionSummary(msg.reactions), width))
+		}
+		if i == selected {
+			rendered = selectedMessageStyle.Width(width).Render(rendered)
+		}
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		offsets[i] = line
+		line += lipgloss.Height(rendered)
+		b.WriteString(rendered)
+		if msg.id != "" && msg.id == ch.markerID && i < len(ch.messages)-1 {
+			b.WriteString("\n" + renderUnreadLine(width))
+			line++
+		}
+	}
+	return b.String(), offsets
+}
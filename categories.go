@@ -0,0 +1,165 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sidebarTop is the screen row of the first sidebar content line:
+// app padding + sidebar margin + border, 3 in all with the default look.
+func sidebarTop() int {
+	return appStyle.GetPaddingTop() + leftSidebarStyle.GetMarginTop() + leftSidebarStyle.GetBorderTopSize()
+}
+
+// category is a named, collapsible group of buffers in the sidebar.
+type category struct {
+	Name      string   `json:"name"`
+	Channels  []string `json:"channels"`
+	Collapsed bool     `json:"collapsed,omitempty"`
+}
+
+func (m *model) findCategory(name string) *category {
+	acct := m.account()
+	for i := range acct.Categories {
+		if strings.EqualFold(acct.Categories[i].Name, name) {
+			return &acct.Categories[i]
+		}
+	}
+	return nil
+}
+
+// categoryOf returns the category holding buffer, or nil.
+func (m *model) categoryOf(buffer string) *category {
+	acct := m.account()
+	for i := range acct.Categories {
+		if slices.Contains(acct.Categories[i].Channels, buffer) {
+			return &acct.Categories[i]
+		}
+	}
+	return nil
+}
+
+// moveToCategory files buffer under the named category, creating it if
+// needed. An empty name moves it back to the uncategorized list.
+func (m *model) moveToCategory(buffer, name string) tea.Cmd {
+	acct := m.account()
+	for i := range acct.Categories {
+		cat := &acct.Categories[i]
+		if j := slices.Index(cat.Channels, buffer); j >= 0 {
+			cat.Channels = slices.Delete(cat.Channels, j, j+1)
+		}
+	}
+	if name != "" {
+		cat := m.findCategory(name)
+		if cat == nil {
+			acct.Categories = append(acct.Categories, category{Name: name})
+			cat = &acct.Categories[len(acct.Categories)-1]
+		}
+		cat.Channels = append(cat.Channels, buffer)
+	}
+	// Drop categories that were emptied
+	acct.Categories = slices.DeleteFunc(acct.Categories, func(c category) bool {
+		return len(c.Channels) == 0
+	})
+	return saveSettingsCmd(m.settings)
+}
+
+func (m *model) toggleCategory(name string) tea.Cmd {
+	cat := m.findCategory(name)
+	if cat == nil {
+		return nil
+	}
+	cat.Collapsed = !cat.Collapsed
+	return saveSettingsCmd(m.settings)
+}
+
+func (m *model) renderCategoryHeader(cat category, unread, mentions int, width int) string {
+	arrow := "▾ "
+	if cat.Collapsed {
+		arrow = "▸ "
+	}
+	title := memberGroupStyle.Render(arrow + strings.ToUpper(truncate(cat.Name, width-6)))
+	if !cat.Collapsed {
+		return title
+	}
+	// Collapsed categories summarise what's hidden inside them
+	badge := unreadBadge(&channel{unread: unread, mentions: mentions})
+	gap := width - lipgloss.Width(title) - lipgloss.Width(badge)
+	if gap < 1 {
+		gap = 1
+	}
+	return title + strings.Repeat(" ", gap) + badge
+}
+
+func cmdCategory(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil
+	}
+	name := strings.TrimSpace(args)
+	if name == "" || name == "-" {
+		m.notice("Moved " + ch.name + " out of its category")
+		return m.moveToCategory(ch.name, "")
+	}
+	m.notice("Moved " + ch.name + " to " + name)
+	return m.moveToCategory(ch.name, name)
+}
+
+// sidebarRowAt maps a screen position to a sidebar row.
+func (m *model) sidebarRowAt(x, y int) (sidebarRow, bool) {
+	if x >= m.leftSidebarRenderedWidth()-1 {
+		return sidebarRow{}, false
+	}
+	i := y - sidebarTop()
+	if i < 0 || i >= len(m.sidebarRows) {
+		return sidebarRow{}, false
+	}
+	return m.sidebarRows[i], true
+}
+
+// handleSidebarMouse toggles category headers on click and files a buffer
+// into a category when it is dragged onto the header or one of its entries.
+func (m *model) handleSidebarMouse(msg tea.MouseMsg) (bool, tea.Cmd) {
+	if msg.Button != tea.MouseButtonLeft && msg.Action != tea.MouseActionRelease {
+		return false, nil
+	}
+	row, ok := m.sidebarRowAt(msg.X, msg.Y)
+
+	switch msg.Action {
+	case tea.MouseActionPress:
+		if !ok {
+			return false, nil
+		}
+		m.sidebarDrag = row.buffer
+		return true, nil
+
+	case tea.MouseActionRelease:
+		dragged := m.sidebarDrag
+		m.sidebarDrag = ""
+		if !ok {
+			return dragged != "", nil
+		}
+		if dragged == "" || dragged == row.buffer {
+			// Plain click
+			switch {
+			case row.category != "":
+				return true, m.toggleCategory(row.category)
+			case row.buffer != "":
+				m.switchToBuffer(row.buffer)
+				return true, nil
+			}
+			return true, nil
+		}
+		target := row.category
+		if target == "" && row.buffer != "" {
+			if cat := m.categoryOf(row.buffer); cat != nil {
+				target = cat.Name
+			}
+		}
+		return true, m.moveToCategory(dragged, target)
+	}
+	return false, nil
+}
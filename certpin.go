@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A server started with --tls-cert and --tls-key takes clients over TLS, and
+// a client connects to it with "tls": true in its entry in settings.json,
+// or a tls:// address. The certificate is checked against the system's
+// CAs unless the entry pins it:
+//
+//	{"addr": "chat.example.com:6697", "pins": ["sha256/<base64>"]}
+//
+// A pin is the SHA-256 of a certificate's public key (SPKI), which a
+// renewal with the same key keeps, or with "cert-sha256/" of the whole
+// certificate. A pinned server is let in when its own certificate matches a
+// pin, whoever signed it, or one in a chain to a trusted CA does, so a
+// rogue CA can't stand in for it and a self-signed certificate works. A
+// second pin for the key it will move to lets it rotate without a break.
+// When none match the client refuses to send anything, showing the pin the
+// server has now; /repin trusts that one instead, once the server's admin
+// has confirmed the change. The server logs its pin as it starts.
+
+const (
+	pinSPKI = "sha256/"      // SHA-256 of the public key
+	pinCert = "cert-sha256/" // SHA-256 of the certificate
+)
+
+var errBadPin = errors.New(`pins are "sha256/" or "cert-sha256/" and a base64 SHA-256 hash`)
+
+// spkiPin and certPin are the ways to pin cert.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinSPKI + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func certPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return pinCert + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkPins reports whether every pin is well-formed.
+func checkPins(pins []string) error {
+	for _, pin := range pins {
+		hash, ok := strings.CutPrefix(pin, pinSPKI)
+		if !ok {
+			hash, ok = strings.CutPrefix(pin, pinCert)
+		}
+		raw, err := base64.StdEncoding.DecodeString(hash)
+		if !ok || err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("%q: %w", pin, errBadPin)
+		}
+	}
+	return nil
+}
+
+// --- Server side ---
+
+// loadServerTLS is what to listen with for a PEM certificate, or chain,
+// and key.
+func loadServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// --- Client side ---
+
+// certPinError is a pinned server presenting a certificate none of its
+// pins match.
+type certPinError struct {
+	presented string // the SPKI pin of its certificate
+}
+
+func (e *certPinError) Error() string {
+	return "the server's certificate doesn't match its pins, it now has " + e.presented +
+		" (if its admin says it changed, /repin trusts it)"
+}
+
+// usesTLS is whether the server is connected to over TLS.
+func (s serverSettings) usesTLS() bool {
+	return s.TLS || len(s.Pins) > 0
+}
+
+// tlsConfig is what to connect to the server with over TLS.
+func (s serverSettings) tlsConfig() *tls.Config {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		host = s.Addr
+	}
+	conf := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if len(s.Pins) == 0 {
+		return conf
+	}
+	// The pins decide, see verifyPins
+	pins := slices.Clone(s.Pins)
+	conf.InsecureSkipVerify = true
+	conf.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		return verifyPins(host, raw, pins)
+	}
+	return conf
+}
+
+// verifyPins checks the certificates a server presented against its pins:
+// its own certificate may match, or one in a chain verified up to a CA.
+func verifyPins(host string, raw [][]byte, pins []string) error {
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return errors.New("the server sent no certificate")
+	}
+	pinned := func(cert *x509.Certificate) bool {
+		return slices.Contains(pins, spkiPin(cert)) || slices.Contains(pins, certPin(cert))
+	}
+	leaf := certs[0]
+	if pinned(leaf) {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	if err == nil {
+		for _, chain := range chains {
+			if slices.ContainsFunc(chain, pinned) {
+				return nil
+			}
+		}
+	}
+	return &certPinError{presented: spkiPin(leaf)}
+}
+
+// dialServer connects to a server, over TLS if it uses it.
+func dialServer(s serverSettings) (net.Conn, error) {
+	if !s.usesTLS() {
+		return net.DialTimeout("tcp", s.Addr, dialTimeout)
+	}
+	c, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", s.Addr, s.tlsConfig())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// findServer is the configured server named or at addr v, or else just v.
+func findServer(v string, list []serverSettings) serverSettings {
+	s := parseServers(v)
+	if len(s) == 0 {
+		return serverSettings{Addr: v}
+	}
+	for _, c := range list {
+		if c.Name == s[0].Addr || c.Addr == s[0].Addr {
+			return c
+		}
+	}
+	return s[0]
+}
+
+// cmdRepin pins the network to the certificate its server presented last
+// time, when it matched none of the pins, and reconnects.
+func cmdRepin(m *model, _ string) tea.Cmd {
+	n := m.currentNetwork()
+	var mismatch *certPinError
+	if n == nil || !errors.As(n.err, &mismatch) {
+		m.notice("The server's certificate didn't fail its pins, there is nothing to repin")
+		return nil
+	}
+	n.server.Pins = []string{mismatch.presented}
+	n.err = nil
+	m.notice("Pinned " + n.name + " to " + mismatch.presented)
+	i := m.net
+	cmds := []tea.Cmd{func() tea.Msg { return reconnectNetworkMsg{index: i} }}
+	if j := slices.IndexFunc(m.settings.Servers, func(s serverSettings) bool { return s.Addr == n.addr }); j >= 0 {
+		m.settings.Servers[j].Pins = n.server.Pins
+		cmds = append(cmds, saveSettingsCmd(m.settings))
+	} else {
+		m.notice(`It came from --server, so add "pins": ["` + mismatch.presented + `"] to its entry in settings.json to keep it`)
+	}
+	return tea.Batch(cmds...)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// applyPin updates the channel's pins from a pinned event.
+func (c *channel) applyPin(p pinData) {
+	c.pins = slices.DeleteFunc(c.pins, func(msg message) bool { return msg.id == p.ID })
+	if p.Pinned && p.Message != nil {
+		c.pins = append(c.pins, p.Message.toMessage())
+	}
+}
+
+func cmdPin(m *model, _ string) tea.Cmd {
+	return m.setPinned(true)
+}
+
+func cmdUnpin(m *model, _ string) tea.Cmd {
+	return m.setPinned(false)
+}
+
+// setPinned pins or unpins the selected or latest message.
+func (m *model) setPinned(pinned bool) tea.Cmd {
+	ch, msg := m.targetMessage()
+	if ch == nil || ch.isDM() {
+		m.notice("Pins are only for channels")
+		return nil
+	}
+	if msg == nil || msg.id == "" {
+		m.notice("Nothing to pin")
+		return nil
+	}
+	if !m.allowed(ch, permPin, "pin messages") {
+		return nil
+	}
+	return m.request(framePin, pinData{Channel: ch.name, ID: msg.id, Pinned: pinned})
+}
+
+// infoPanel is the overlay describing a channel: topic, creation date,
+// members and pinned messages. Pins can be selected to jump to them.
+type infoPanel struct {
+	ch     *channel
+	role   role       // ours in it
+	perms  permission // what that lets us do
+	cursor int
+}
+
+func newInfoPanel(m *model, ch *channel) *infoPanel {
+	p := &infoPanel{ch: ch}
+	p.role, p.perms = m.roleIn(ch)
+	return p
+}
+
+func (p *infoPanel) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return p, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if p.cursor < len(p.ch.pins)-1 {
+			p.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		if len(p.ch.pins) == 0 {
+			return p, nil
+		}
+		jump := jumpToMessageMsg{channel: p.ch.name, id: p.ch.pins[p.cursor].id}
+		return nil, func() tea.Msg { return jump }
+	}
+	return p, nil
+}
+
+func (p *infoPanel) View(width, height int) string {
+	w := 60
+	if width-4 < w {
+		w = width - 4
+	}
+	inner := w - 2
+	ch := p.ch
+
+	title := ch.name
+	var flags []string
+	if ch.private {
+		flags = append(flags, "private")
+	}
+	if ch.archived {
+		flags = append(flags, "archived")
+	}
+	if len(flags) > 0 {
+		title += " (" + strings.Join(flags, ", ") + ")"
+	}
+
+	topic := ch.topic
+	if topic == "" {
+		topic = "No topic set"
+	}
+	created := "unknown"
+	if !ch.created.IsZero() {
+		created = ch.created.Local().Format("2 Jan 2006")
+	}
+
+	online := 0
+	var staff []string
+	for _, mem := range ch.sortedMembers() {
+		if mem.presence != presenceOffline {
+			online++
+		}
+		if mem.role > roleMember {
+			staff = append(staff, mem.nick+" ("+strings.ToLower(roleLabel(mem.role))+")")
+		}
+	}
+
+	lines := []string{
+		overlayTitleStyle.Render(title),
+		lipgloss.NewStyle().Width(inner).Render(topic),
+		"",
+		overlayHintStyle.Render("Created  ") + created,
+		overlayHintStyle.Render("Members  ") + fmt.Sprintf("%d, %d online", len(ch.members), online),
+	}
+	if len(staff) > 0 {
+		lines = append(lines, overlayHintStyle.Render("Staff    ")+truncate(strings.Join(staff, ", "), inner-9))
+	}
+	you := strings.ToLower(roleLabel(p.role)) + ": " + p.perms.String()
+	lines = append(lines, overlayHintStyle.Render("You      ")+truncate(you, inner-9))
+	if len(ch.overrides) > 0 {
+		var changed []string
+		for _, r := range slices.Sorted(maps.Keys(ch.overrides)) {
+			changed = append(changed, strings.ToLower(roleLabel(r))+": "+ch.overrides[r].String())
+		}
+		lines = append(lines, overlayHintStyle.Render("Here     ")+truncate(strings.Join(changed, "; "), inner-9))
+	}
+
+	lines = append(lines, "", overlayTitleStyle.Render(fmt.Sprintf("Pinned (%d)", len(ch.pins))))
+	if len(ch.pins) == 0 {
+		lines = append(lines, overlayHintStyle.Render("Pin a message with /pin"))
+	}
+	for i, pin := range ch.pins {
+		row := nickStyle(pin.nick).Render(pin.nick) + " " + truncate(pin.text, inner-lipgloss.Width(pin.nick)-1)
+		if i == p.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(inner).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+
+	hint := "esc close"
+	if len(ch.pins) > 0 {
+		hint = "enter jump to pin • " + hint
+	}
+	lines = append(lines, "", overlayHintStyle.Render(hint))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// openInfoPanel shows the info panel for the active channel.
+func (m *model) openInfoPanel() {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Channel info is only for channels")
+		return
+	}
+	m.overlay = newInfoPanel(m, ch)
+}
+
+func cmdInfo(m *model, _ string) tea.Cmd {
+	m.openInfoPanel()
+	return nil
+}
+
+// handleHeaderMouse opens the activity center or info panel when their
+// header icons are clicked.
+func (m *model) handleHeaderMouse(msg tea.MouseMsg) bool {
+	if msg.Action != tea.MouseActionRelease {
+		return false
+	}
+	switch {
+	case m.bellArea.contains(msg.X, msg.Y):
+		m.overlay = newActivityCenter(m)
+		return true
+	case m.infoArea.contains(msg.X, msg.Y):
+		m.openInfoPanel()
+		return true
+	}
+	return false
+}
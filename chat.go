@@ -0,0 +1,481 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// presence is a member's availability as last reported by the server.
+type presence int
+
+const (
+	presenceOffline presence = iota
+	presenceAway
+	presenceOnline
+)
+
+func (p presence) String() string {
+	switch p {
+	case presenceOnline:
+		return "Online"
+	case presenceAway:
+		return "Away"
+	default:
+		return "Offline"
+	}
+}
+
+// role is a member's standing in a channel. Higher values outrank lower ones.
+type role int
+
+const (
+	roleMember role = iota
+	roleModerator
+	roleAdmin
+	roleOwner
+
+	// roleGuest is a server role below every other (see roles.go), apart
+	// so the ones stored keep their values
+	roleGuest role = -1
+)
+
+func (r role) String() string {
+	switch r {
+	case roleOwner:
+		return "Owners"
+	case roleAdmin:
+		return "Admins"
+	case roleModerator:
+		return "Moderators"
+	case roleGuest:
+		return "Guests"
+	default:
+		return "Members"
+	}
+}
+
+type member struct {
+	nick     string
+	role     role
+	presence presence
+	status   userStatus // see status.go
+}
+
+type message struct {
+	id      string
+	channel string
+	nick    string
+	text    string
+	time    time.Time
+	system  bool      // client-generated notice, not a chat line
+	edited  time.Time // last edited, zero if never
+	pending bool      // ours, not yet confirmed by the server (see outbox.go)
+	// unverified is whether its signature didn't check out (see signing.go)
+	unverified bool
+	imported   bool // brought in from another chat system, see import.go
+
+	replyTo   string              // ID of the message this answers
+	reactions map[string][]string // emoji -> nicks who reacted
+}
+
+type channel struct {
+	name       string
+	topic      string
+	private    bool
+	archived   bool                // read-only, hidden from the sidebar unless open
+	overrides  map[role]permission // what roles may do here, see overrides.go
+	mute       *sanction           // ours, see moderation.go
+	slowMode   time.Duration       // between our messages, see slowmode.go
+	lastPost   time.Time           // when we last posted, for slow mode
+	created    time.Time
+	members    map[string]*member
+	messages   []message
+	pins       []message
+	unread     int       // messages not yet scrolled into view
+	mentions   int       // unread messages that mention us
+	lastActive time.Time // last time this buffer was viewed
+	readID     string    // newest message known to have been seen
+	markerID   string    // the unread line is drawn after this message
+
+	loadingOlder bool   // a backfill page is on its way
+	noMoreOlder  bool   // the server has nothing older than messages[0]
+	historyReq   string // ID of the outstanding history request
+
+	seq uint64 // newest server event seen, see eventlog.go
+
+	typing map[string]time.Time // who is typing here, since when, see typing.go
+}
+
+// isDM reports whether the buffer is a direct message conversation. DM
+// buffers are named after the peer, prefixed with "@".
+func (c *channel) isDM() bool {
+	return strings.HasPrefix(c.name, "@")
+}
+
+func newChannel(name, topic string) *channel {
+	return &channel{
+		name:    name,
+		topic:   topic,
+		members: make(map[string]*member),
+	}
+}
+
+// sortedMembers returns the channel members ordered by role (highest first),
+// then presence, then nick.
+func (c *channel) sortedMembers() []*member {
+	list := make([]*member, 0, len(c.members))
+	for _, mem := range c.members {
+		list = append(list, mem)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.role != b.role {
+			return a.role > b.role
+		}
+		if a.presence != b.presence {
+			return a.presence > b.presence
+		}
+		return strings.ToLower(a.nick) < strings.ToLower(b.nick)
+	})
+	return list
+}
+
+// --- Chat events ---
+// These are delivered to the model as tea.Msg values by whatever feeds the
+// client (network layer, replay, etc).
+
+type memberJoinMsg struct {
+	channel  string
+	nick     string
+	role     role
+	presence presence
+	status   userStatus
+}
+
+type memberPartMsg struct {
+	channel string
+	nick    string
+}
+
+type presenceMsg struct {
+	nick     string
+	presence presence
+}
+
+type chatMessageMsg struct {
+	msg message
+}
+
+type reactionMsg struct {
+	channel string
+	id      string
+	emoji   string
+	nick    string
+	added   bool
+}
+
+func (m *model) channelByName(name string) *channel {
+	for _, ch := range m.channels {
+		if ch.name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (m *model) activeChannel() *channel {
+	if m.active < 0 || m.active >= len(m.channels) {
+		return nil
+	}
+	return m.channels[m.active]
+}
+
+// setActive makes the buffer at index i the current one.
+func (m *model) setActive(i int) {
+	if i < 0 || i >= len(m.channels) {
+		return
+	}
+	if cur := m.activeChannel(); cur != nil {
+		cur.lastActive = time.Now()
+		// Coming back, what arrived meanwhile is below the line
+		cur.markerID = cur.readID
+		m.stashDraft(cur.name)
+	}
+	m.active = i
+	m.restoreDraft(m.channels[i].name)
+	m.channels[i].lastActive = time.Now()
+	m.touchRecent(m.channels[i].name)
+	if p := m.currentPane(); p != nil && p.buffer != m.channels[i].name {
+		p.buffer = m.channels[i].name
+		p.selected = -1
+		p.follow = true
+	}
+}
+
+func (m *model) switchToBuffer(name string) {
+	for i, ch := range m.channels {
+		if ch.name == name {
+			m.setActive(i)
+			return
+		}
+	}
+}
+
+// openDM switches to the DM buffer for nick, creating it if needed.
+func (m *model) openDM(nick string) {
+	m.switchToBuffer(m.ensureDM(nick).name)
+}
+
+// ensureDM returns the DM buffer for nick, creating it if needed.
+func (m *model) ensureDM(nick string) *channel {
+	name := "@" + nick
+	if dm := m.channelByName(name); dm != nil {
+		return dm
+	}
+	dm := newChannel(name, "")
+	dm.members[m.nick] = &member{nick: m.nick, presence: presenceOnline}
+	p := presenceOnline
+	if known := m.knownPresence(nick); known != nil {
+		p = *known
+	}
+	dm.members[nick] = &member{nick: nick, presence: p}
+	m.channels = append(m.channels, dm)
+	m.applyChannelOrder()
+	m.persist(func(s store, network string) error { return s.saveChannel(network, dm) })
+	return dm
+}
+
+// dmPeer returns the other member of a DM buffer.
+func (c *channel) dmPeer() *member {
+	return c.members[c.dmPeerNick()]
+}
+
+// dmPeerNick is the nick on the other side of a DM buffer.
+func (c *channel) dmPeerNick() string {
+	return strings.TrimPrefix(c.name, "@")
+}
+
+// lastMessage returns the newest non-system message, if any.
+func (c *channel) lastMessage() (message, bool) {
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if !c.messages[i].system {
+			return c.messages[i], true
+		}
+	}
+	return message{}, false
+}
+
+// messageIndex returns the index of the message with the given ID, or -1.
+func (c *channel) messageIndex(id string) int {
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// handleChatEvent applies a chat event to the client state. It reports
+// whether msg was a chat event.
+func (m *model) handleChatEvent(msg tea.Msg) bool {
+	switch msg := msg.(type) {
+	case memberJoinMsg:
+		ch := m.channelByName(msg.channel)
+		if ch == nil {
+			return true
+		}
+		p := msg.presence
+		// Keep whatever presence we already know for this nick
+		if known := m.knownPresence(msg.nick); known != nil {
+			p = *known
+		}
+		ch.members[msg.nick] = &member{nick: msg.nick, role: msg.role, presence: p, status: msg.status}
+		m.persist(func(s store, network string) error { return s.saveChannel(network, ch) })
+		m.logEvent(ch.name, msg.nick+" has joined "+ch.name)
+	case memberPartMsg:
+		if ch := m.channelByName(msg.channel); ch != nil {
+			delete(ch.members, msg.nick)
+			delete(ch.typing, msg.nick)
+			m.persist(func(s store, network string) error { return s.saveChannel(network, ch) })
+			m.logEvent(ch.name, msg.nick+" has left "+ch.name)
+		}
+	case presenceMsg:
+		for _, ch := range m.channels {
+			if mem, ok := ch.members[msg.nick]; ok {
+				mem.presence = msg.presence
+			}
+		}
+	case statusMsg:
+		for _, ch := range m.channels {
+			if mem, ok := ch.members[msg.nick]; ok {
+				mem.status = msg.status
+			}
+		}
+	case typingMsg:
+		ch := m.channelByName(msg.channel)
+		switch {
+		case ch == nil || msg.nick == m.nick:
+		case msg.typing:
+			if ch.typing == nil {
+				ch.typing = make(map[string]time.Time)
+			}
+			ch.typing[msg.nick] = time.Now()
+		default:
+			delete(ch.typing, msg.nick)
+		}
+	case chatMessageMsg:
+		ch := m.channelByName(msg.msg.channel)
+		if ch == nil && strings.HasPrefix(msg.msg.channel, "@") {
+			ch = m.ensureDM(strings.TrimPrefix(msg.msg.channel, "@"))
+		}
+		if ch == nil || m.confirmMessage(ch, msg.msg) {
+			return true
+		}
+		delete(ch.typing, msg.msg.nick)
+		ch.messages = append(ch.messages, msg.msg)
+		if !msg.msg.system {
+			m.saveMessages(ch, []message{msg.msg})
+			m.logMessage(msg.msg)
+		}
+		m.noteActivity(ch, msg.msg)
+		// Quiet buffers still get the message, just no badges
+		a := m.classifyMessage(ch, msg.msg)
+		m.soundFor(ch, a)
+		m.notifyDesktop(ch, msg.msg, a)
+		if !m.isReadingBottom(ch) {
+			if a.unread {
+				ch.unread++
+			}
+			if a.mention {
+				ch.mentions++
+			}
+		}
+	case reactionMsg:
+		ch := m.channelByName(msg.channel)
+		if ch == nil {
+			return true
+		}
+		if i := ch.messageIndex(msg.id); i >= 0 {
+			ch.messages[i].applyReaction(msg.emoji, msg.nick, msg.added)
+			if msg.added && msg.nick != m.nick && ch.messages[i].nick == m.nick {
+				m.recordActivity(activity{
+					kind:    activityReaction,
+					channel: ch.name,
+					msgID:   msg.id,
+					nick:    msg.nick,
+					text:    msg.emoji + " on \"" + ch.messages[i].text + "\"",
+					time:    time.Now(),
+				})
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// notice shows a system line in the active buffer.
+func (m *model) notice(text string) {
+	if ch := m.activeChannel(); ch != nil {
+		m.noticeIn(ch, text)
+	}
+}
+
+// noticeIn shows a system line in ch.
+func (m *model) noticeIn(ch *channel, text string) {
+	m.handleChatEvent(chatMessageMsg{msg: message{
+		channel: ch.name,
+		text:    text,
+		time:    time.Now(),
+		system:  true,
+	}})
+}
+
+// isMention reports whether msg mentions our nick as a whole word, or a
+// highlight word.
+func (m *model) isMention(msg message) bool {
+	if r := m.ruleFor(msg); r != nil && (r.action == ruleHighlight || r.action == ruleIgnore) {
+		return r.action == ruleHighlight
+	}
+	if m.nick != "" && containsWord(strings.ToLower(msg.text), strings.ToLower(m.nick)) {
+		return true
+	}
+	return m.opts.highlight.matches(msg.text)
+}
+
+// containsWord reports whether text has word in it as a whole word, both
+// lowercased.
+func containsWord(text, word string) bool {
+	for i := strings.Index(text, word); i >= 0; {
+		end := i + len(word)
+		if (i == 0 || !isNickRune(text[i-1])) && (end == len(text) || !isNickRune(text[end])) {
+			return true
+		}
+		next := strings.Index(text[i+1:], word)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+func isNickRune(b byte) bool {
+	return b == '_' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z'
+}
+
+// isReadingBottom reports whether some pane shows ch scrolled to the bottom,
+// i.e. new messages there are seen as they arrive.
+func (m *model) isReadingBottom(ch *channel) bool {
+	if m.background {
+		return false
+	}
+	for _, p := range m.panes {
+		if p.buffer == ch.name && p.follow {
+			return true
+		}
+	}
+	return false
+}
+
+// markVisibleRead clears the badges of buffers whose pane has reached the
+// bottom of the scrollback.
+func (m *model) markVisibleRead() tea.Cmd {
+	if m.background {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, p := range m.panes {
+		if !p.follow {
+			continue
+		}
+		if ch := m.channelByName(p.buffer); ch != nil {
+			ch.unread = 0
+			ch.mentions = 0
+			cmds = append(cmds, m.markRead(ch))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// unreadTotals sums badges across all buffers.
+func (m *model) unreadTotals() (unread, mentions int) {
+	for _, ch := range m.channels {
+		unread += ch.unread
+		mentions += ch.mentions
+	}
+	return unread, mentions
+}
+
+func (m *model) knownPresence(nick string) *presence {
+	for _, ch := range m.channels {
+		if mem, ok := ch.members[nick]; ok {
+			p := mem.presence
+			return &p
+		}
+	}
+	return nil
+}
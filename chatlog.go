@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultLogPath is where plain-text logs go unless settings say otherwise.
+// {network}, {channel} and {date} are filled in per line, so a template
+// with {date} starts a new file every day. Relative paths are under the
+// gochat config directory.
+const defaultLogPath = "logs/{network}/{channel}/{date}.log"
+
+// logSettings configure the plain-text logs, written alongside (and
+// independently of) the message store.
+type logSettings struct {
+	All      bool            `json:"all,omitempty"`      // log every buffer
+	Channels map[string]bool `json:"channels,omitempty"` // per-buffer override of All
+	Path     string          `json:"path,omitempty"`     // file name template, see defaultLogPath
+}
+
+// enabled reports whether the buffer name is logged.
+func (s logSettings) enabled(name string) bool {
+	if on, ok := s.Channels[name]; ok {
+		return on
+	}
+	return s.All
+}
+
+// path expands the template for a line of network's buffer name logged at t.
+func (s logSettings) path(network, name string, t time.Time) (string, error) {
+	tmpl := s.Path
+	if tmpl == "" {
+		tmpl = defaultLogPath
+	}
+	// Keep the names from adding directories, or a drive letter on Windows
+	clean := strings.NewReplacer("/", "_", `\`, "_", ":", "_")
+	p := strings.NewReplacer(
+		"{network}", clean.Replace(network),
+		"{channel}", clean.Replace(name),
+		"{date}", t.Format("2006-01-02"),
+	).Replace(tmpl)
+
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, rest), nil
+	}
+	if filepath.IsAbs(p) {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gochat", p), nil
+}
+
+// chatLogger writes irssi-style logs, one file per buffer (and per day,
+// with the default template), kept open between lines.
+type chatLogger struct {
+	files map[string]*logFile // by network and buffer
+}
+
+type logFile struct {
+	f    *os.File
+	path string
+	day  string // date of the last line written
+}
+
+func newChatLogger() *chatLogger {
+	return &chatLogger{files: make(map[string]*logFile)}
+}
+
+// write appends a line to the buffer's log, switching files when the path
+// changes and marking the day changing in a file that doesn't.
+func (l *chatLogger) write(st logSettings, network, name string, t time.Time, line string) error {
+	t = t.Local()
+	path, err := st.path(network, name, t)
+	if err != nil {
+		return err
+	}
+	key := network + "\x00" + name
+	lf := l.files[key]
+	if lf != nil && lf.path != path {
+		lf.closeLog()
+		lf = nil
+	}
+	if lf == nil {
+		if lf, err = openLog(path); err != nil {
+			return err
+		}
+		l.files[key] = lf
+		lf.day = t.Format("2006-01-02")
+	}
+	var b strings.Builder
+	if day := t.Format("2006-01-02"); day != lf.day {
+		lf.day = day
+		fmt.Fprintf(&b, "--- Day changed %s\n", t.Format("Mon Jan 02 2006"))
+	}
+	for _, text := range strings.Split(line, "\n") {
+		fmt.Fprintf(&b, "%s %s\n", t.Format("15:04"), text)
+	}
+	_, err = lf.f.WriteString(b.String())
+	return err
+}
+
+func openLog(path string) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(f, "--- Log opened %s\n", time.Now().Format("Mon Jan 02 15:04:05 2006")); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logFile{f: f, path: path}, nil
+}
+
+func (lf *logFile) closeLog() {
+	fmt.Fprintf(lf.f, "--- Log closed %s\n", time.Now().Format("Mon Jan 02 15:04:05 2006"))
+	lf.f.Close()
+}
+
+// stop closes the buffer's log, if it is open.
+func (l *chatLogger) stop(network, name string) {
+	key := network + "\x00" + name
+	if lf := l.files[key]; lf != nil {
+		lf.closeLog()
+		delete(l.files, key)
+	}
+}
+
+func (l *chatLogger) close() {
+	for key, lf := range l.files {
+		lf.closeLog()
+		delete(l.files, key)
+	}
+}
+
+// --- Model side ---
+
+// logLine adds a line to ch's log, if it is being logged.
+func (m *model) logLine(ch string, t time.Time, line string) {
+	st := m.settings.Logging
+	if m.logs == nil || !st.enabled(ch) {
+		return
+	}
+	if err := m.logs.write(st, m.storeNetwork(), ch, t, line); err != nil {
+		m.lastErr = err
+	}
+}
+
+// logMessage logs a chat line as "<nick> text".
+func (m *model) logMessage(msg message) {
+	if msg.system {
+		return
+	}
+	m.logLine(msg.channel, msg.time, "<"+msg.nick+"> "+msg.text)
+}
+
+// logEvent logs a join, part, topic change and the like as "-!- text".
+func (m *model) logEvent(ch, text string) {
+	m.logLine(ch, time.Now(), "-!- "+text)
+}
+
+// cmdLog shows or changes whether the buffer is logged.
+func cmdLog(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil
+	}
+	st := &m.settings.Logging
+	switch strings.ToLower(args) {
+	case "":
+	case "on", "off":
+		if st.Channels == nil {
+			st.Channels = make(map[string]bool)
+		}
+		st.Channels[ch.name] = strings.EqualFold(args, "on")
+	case "default":
+		delete(st.Channels, ch.name)
+	default:
+		m.notice("Usage: /log [on|off|default]")
+		return nil
+	}
+	if !st.enabled(ch.name) {
+		m.logs.stop(m.storeNetwork(), ch.name)
+		m.notice("Not logging " + ch.name)
+	} else if path, err := st.path(m.storeNetwork(), ch.name, time.Now()); err == nil {
+		m.notice("Logging " + ch.name + " to " + path)
+	}
+	if args == "" {
+		return nil
+	}
+	return saveSettingsCmd(m.settings)
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const dialTimeout = 10 * time.Second
+
+// client is the connection to a gochat server. Frames read from the server
+// are delivered to the model one at a time as frameMsg.
+type client struct {
+	addr    string
+	conn    *frameConn
+	seq     atomic.Uint64
+	network *network // whose connection it is, set once connected
+	// role is our server role and permissions what each role allows, as
+	// the welcome said (see roles.go)
+	role        role
+	permissions map[role]permission
+	guest       bool // we came in as a guest, see guests.go
+}
+
+type connectedMsg struct {
+	c      *client
+	nick   string
+	tokens *sessionTokens // a new session, if the server started one
+	// resume takes this session over after a drop, and resumed is set if
+	// this took the last one over (see resume.go)
+	resume  string
+	resumed bool
+}
+
+type disconnectedMsg struct{ err error }
+
+type frameMsg struct{ f frame }
+
+// connectCmd dials srv and performs the hello handshake, saying how far
+// the buffers were seen so a reconnect only gets what was missed, and
+// whether we are away.
+func connectCmd(srv serverSettings, creds credentials, seen map[string]uint64, away bool) tea.Cmd {
+	return func() tea.Msg {
+		nc, err := dialServer(srv)
+		if err != nil {
+			return disconnectedMsg{err}
+		}
+		c := &client{addr: srv.Addr, conn: newFrameConn(nc)}
+		if err := c.conn.write(newFrame(frameHello, helloData{
+			Nick:     creds.nick,
+			Password: creds.password,
+			Register: creds.register,
+			Invite:   creds.invite,
+			Token:    creds.tokens.Access,
+			Refresh:  creds.tokens.Refresh,
+			SSO:      creds.sso,
+			Guest:    creds.guest,
+			Resume:   creds.resume,
+			Client:   clientName(),
+			Seen:     seen,
+			Away:     away,
+		})); err != nil {
+			nc.Close()
+			return disconnectedMsg{err}
+		}
+		return c.awaitWelcome()
+	}
+}
+
+// awaitWelcome reads the answer to the hello. A single sign-on first
+// sends the code to approve, and the model calls it again after showing it.
+func (c *client) awaitWelcome() tea.Msg {
+	f, err := c.conn.read()
+	if err != nil {
+		c.close()
+		return disconnectedMsg{err}
+	}
+	switch f.Type {
+	case frameLoginFailed:
+		c.close()
+		return disconnectedMsg{&loginError{f.Error}}
+	case frameDeviceCode:
+		var code deviceCode
+		if err := f.decode(&code); err != nil {
+			c.close()
+			return disconnectedMsg{err}
+		}
+		return deviceCodeMsg{c: c, code: code}
+	case frameWelcome:
+	default:
+		c.close()
+		return disconnectedMsg{fmt.Errorf("handshake failed: %s", f.Error)}
+	}
+	var w welcomeData
+	if err := f.decode(&w); err != nil {
+		c.close()
+		return disconnectedMsg{err}
+	}
+	c.role, c.permissions, c.guest = w.Role, w.Permissions, w.Guest
+	return connectedMsg{c: c, nick: w.Nick, tokens: w.Tokens, resume: w.Resume, resumed: w.Resumed}
+}
+
+// listen waits for the next frame from the server. The model re-issues it
+// after every frameMsg.
+func (c *client) listen() tea.Cmd {
+	return func() tea.Msg {
+		f, err := c.conn.read()
+		if err != nil {
+			return disconnectedMsg{err}
+		}
+		return frameMsg{f}
+	}
+}
+
+// send writes a frame in the background, returning its request id.
+func (c *client) send(typ string, v any) (string, tea.Cmd) {
+	f := newFrame(typ, v)
+	f.ID = strconv.FormatUint(c.seq.Add(1), 10)
+	return f.ID, func() tea.Msg {
+		if err := c.conn.write(f); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
+func (c *client) close() {
+	c.conn.close()
+}
+
+// --- Model side ---
+
+// request sends a frame if connected, otherwise reports that we're offline.
+func (m *model) request(typ string, v any) tea.Cmd {
+	if m.client == nil {
+		m.notice("Not connected to a server")
+		return nil
+	}
+	_, cmd := m.client.send(typ, v)
+	return cmd
+}
+
+// handleFrame turns a server frame into client state changes.
+func (m *model) handleFrame(f frame) tea.Cmd {
+	switch f.Type {
+	case frameChannelState:
+		var st channelStateData
+		if err := f.decode(&st); err != nil {
+			return nil
+		}
+		m.applyChannelState(st)
+		return tea.Batch(m.lookupSigners(), m.lookupSealers())
+	case frameMemberJoin, frameMemberPart:
+		var ev memberEvent
+		if err := f.decode(&ev); err != nil {
+			return nil
+		}
+		m.sawSeq(ev.Channel, ev.Seq)
+		ch := m.channelByName(ev.Channel)
+		if f.Type == frameMemberPart {
+			if ev.Nick == m.nick {
+				m.removeChannel(ev.Channel)
+				if ev.By != "" {
+					m.notice(ev.By + " removed you from " + ev.Channel)
+				}
+				return nil
+			}
+			m.handleChatEvent(memberPartMsg{channel: ev.Channel, nick: ev.Nick})
+			if ch != nil && ev.By != "" {
+				m.noticeIn(ch, ev.By+" removed "+ev.Nick)
+			}
+			return nil
+		}
+		m.handleChatEvent(memberJoinMsg{channel: ev.Channel, nick: ev.Nick, role: ev.Role, presence: ev.Presence, status: ev.Status})
+		if ch != nil && ev.By != "" {
+			m.noticeIn(ch, ev.By+" invited "+ev.Nick)
+		}
+	case frameMessage:
+		var w wireMessage
+		if err := f.decode(&w); err != nil {
+			return nil
+		}
+		m.handleChatEvent(chatMessageMsg{msg: m.openMessage(w)})
+		m.sawSeq(w.Channel, w.Seq)
+		return tea.Batch(m.lookupSigners(), m.lookupSealers())
+	case frameReaction:
+		var r reactionData
+		if err := f.decode(&r); err != nil {
+			return nil
+		}
+		m.handleChatEvent(reactionMsg{channel: r.Channel, id: r.ID, emoji: r.Emoji, nick: r.Nick, added: r.Added})
+		m.sawSeq(r.Channel, r.Seq)
+	case frameEdited:
+		var e editData
+		if err := f.decode(&e); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(e.Channel); ch != nil {
+			unverified := m.checkSignature(e.Channel, e.ID, e.Nick, e.Text, e.Sig, e.Time)
+			e.Text = m.openText(e.Channel, e.Nick, e.Text)
+			m.applyEdit(ch, e, unverified)
+		}
+		m.sawSeq(e.Channel, e.Seq)
+		return tea.Batch(m.lookupSigners(), m.lookupSealers())
+	case frameDeleted:
+		var d deleteData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(d.Channel); ch != nil {
+			m.applyDelete(ch, d)
+		}
+		m.sawSeq(d.Channel, d.Seq)
+	case frameTokens:
+		var t sessionTokens
+		if err := f.decode(&t); err != nil || m.client == nil {
+			return nil
+		}
+		return m.applyTokens(m.client, t)
+	case frameKey:
+		var k e2eKeyData
+		if err := f.decode(&k); err != nil {
+			return nil
+		}
+		return m.applyKey(k)
+	case frameInviteCreated:
+		var d inviteCreated
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.showInviteCreated(d)
+	case frameInviteList:
+		var list inviteList
+		if err := f.decode(&list); err != nil {
+			return nil
+		}
+		m.showInvites(list)
+	case frameDeviceList:
+		var list deviceList
+		if err := f.decode(&list); err != nil {
+			return nil
+		}
+		m.showDevices(list)
+	case frameEditHistory:
+		var e editHistoryData
+		if err := f.decode(&e); err != nil || len(e.Revisions) == 0 {
+			return nil
+		}
+		nick := "someone"
+		if ch := m.channelByName(e.Channel); ch != nil {
+			if i := ch.messageIndex(e.ID); i >= 0 {
+				nick = ch.messages[i].nick
+			}
+		}
+		for i := range e.Revisions {
+			e.Revisions[i].Text = m.openText(e.Channel, nick, e.Revisions[i].Text)
+		}
+		m.overlay = newEditHistory(nick, e.Revisions)
+	case frameUserArchive:
+		var a userArchive
+		if err := f.decode(&a); err != nil {
+			return nil
+		}
+		m.applyUserArchive(a)
+	case frameUserErased:
+		var r eraseResult
+		if err := f.decode(&r); err != nil {
+			return nil
+		}
+		m.notice(fmt.Sprintf("Erased %s and their %d messages", r.Nick, r.Messages))
+	case framePresence:
+		var p presenceData
+		if err := f.decode(&p); err != nil {
+			return nil
+		}
+		m.handleChatEvent(presenceMsg{nick: p.Nick, presence: p.Presence})
+	case frameStatus:
+		var st statusData
+		if err := f.decode(&st); err != nil {
+			return nil
+		}
+		m.handleChatEvent(statusMsg{nick: st.Nick, status: st.userStatus})
+	case frameTypingState:
+		var t typingData
+		if err := f.decode(&t); err != nil {
+			return nil
+		}
+		m.handleChatEvent(typingMsg{channel: t.Channel, nick: t.Nick, typing: t.Typing})
+		return tea.Tick(typingTimeout, func(time.Time) tea.Msg { return typingExpiredMsg{} })
+	case frameTopicChanged:
+		var t topicData
+		if err := f.decode(&t); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(t.Channel); ch != nil {
+			ch.topic = t.Topic
+			m.persist(func(s store, network string) error { return s.saveChannel(network, ch) })
+			m.noticeIn(ch, t.Nick+" changed the topic to: "+t.Topic)
+			m.logEvent(ch.name, t.Nick+" changed the topic of "+ch.name+" to: "+t.Topic)
+		}
+	case frameKV:
+		var e kvData
+		if err := f.decode(&e); err != nil {
+			return nil
+		}
+		m.applyKV(e)
+		return saveSettingsCmd(m.settings)
+	case frameKVSnapshot:
+		var snap kvSnapshot
+		if err := f.decode(&snap); err != nil {
+			return nil
+		}
+		return m.applyKVSnapshot(snap)
+	case frameReadMarker:
+		var r readData
+		if err := f.decode(&r); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(r.Channel); ch != nil {
+			m.applyReadMarker(ch, r)
+		}
+	case framePurged:
+		var p purgeData
+		if err := f.decode(&p); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(p.Channel); ch != nil {
+			m.applyPurge(ch, p)
+		}
+	case frameArchived:
+		var a archiveData
+		if err := f.decode(&a); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(a.Channel); ch != nil {
+			ch.archived = a.Archived
+			m.persist(func(s store, network string) error { return s.saveChannel(network, ch) })
+			if a.Archived {
+				m.noticeIn(ch, a.Nick+" archived this channel, it is now read-only")
+			} else {
+				m.noticeIn(ch, a.Nick+" restored this channel from the archive")
+			}
+		}
+	case frameOverridden:
+		var o overrideData
+		if err := f.decode(&o); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(o.Channel); ch != nil {
+			m.applyOverride(ch, o)
+		}
+	case frameSlowModeSet:
+		var d slowModeData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(d.Channel); ch != nil {
+			m.applySlowMode(ch, d)
+		}
+	case frameFilters:
+		var d filtersData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.showFilters(d)
+	case frameFilterHits:
+		var d filterLogData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.overlay = newFilterLogView(d)
+	case frameModLogEntries:
+		var d modLogData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.overlay = newModLogView(d)
+	case frameReportFiled:
+		var r report
+		if err := f.decode(&r); err != nil {
+			return nil
+		}
+		m.applyReportFiled(r)
+	case frameReportQueue:
+		var d reportQueueData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.overlay = newReportQueue(d)
+	case frameResolved:
+		var d resolveData
+		if err := f.decode(&d); err != nil {
+			return nil
+		}
+		m.applyResolved(d)
+	case frameModerated:
+		var ev moderationEvent
+		if err := f.decode(&ev); err != nil {
+			return nil
+		}
+		m.applyModeration(ev)
+	case framePong:
+		m.handlePong(f)
+		m.showStartupSummary()
+	case frameHistoryPage:
+		m.handleHistoryPage(f)
+		return tea.Batch(m.lookupSigners(), m.lookupSealers())
+	case framePinned:
+		var p pinData
+		if err := f.decode(&p); err != nil {
+			return nil
+		}
+		if ch := m.channelByName(p.Channel); ch != nil {
+			ch.applyPin(p)
+			if p.Pinned && p.Message != nil {
+				m.noticeIn(ch, p.Nick+" pinned a message: "+p.Message.Text)
+			} else {
+				m.noticeIn(ch, p.Nick+" unpinned a message")
+			}
+		}
+	case frameChannelList:
+		var list channelListData
+		if err := f.decode(&list); err != nil {
+			return nil
+		}
+		m.overlay = newChannelBrowser(list.Channels, list.Archived, m)
+	case frameError:
+		m.historyFailed(f.ID)
+		m.notice("Server: " + f.Error)
+	}
+	return nil
+}
+
+// applyChannelState replaces our view of a channel with the server's, or
+// catches it up with the events it missed.
+func (m *model) applyChannelState(st channelStateData) {
+	ch := m.channelByName(st.Name)
+	if ch == nil {
+		ch = newChannel(st.Name, st.Topic)
+		m.channels = append(m.channels, ch)
+		m.applyChannelOrder()
+	}
+	ch.topic = st.Topic
+	ch.private = st.Private
+	ch.archived = st.Archived
+	ch.overrides = st.Overrides
+	ch.mute = st.Mute
+	ch.slowMode = time.Duration(st.SlowMode) * time.Second
+	ch.created = st.Created
+	ch.pins = ch.pins[:0]
+	for _, w := range st.Pins {
+		ch.pins = append(ch.pins, w.toMessage())
+	}
+	ch.members = make(map[string]*member, len(st.Members))
+	for _, wm := range st.Members {
+		ch.members[wm.Nick] = &member{nick: wm.Nick, role: wm.Role, presence: wm.Presence, status: wm.Status}
+	}
+	m.persist(func(s store, network string) error { return s.saveChannel(network, ch) })
+	if st.Since > 0 {
+		m.applyGap(ch, st)
+	} else {
+		pending := pendingMessages(ch.messages)
+		ch.messages = ch.messages[:0]
+		ch.noMoreOlder = false
+		for _, w := range st.History {
+			ch.messages = append(ch.messages, m.openMessage(w))
+		}
+		keepPending(ch, pending)
+		ch.seq = st.Seq
+		m.saveMessages(ch, ch.messages)
+		m.mergeStoredHistory(ch)
+		if !m.isReadingBottom(ch) && ch.messageIndex(ch.readID) >= 0 {
+			// What came while we were away is in the history now
+			ch.unread, ch.mentions = m.unreadAfter(ch, ch.readID)
+		}
+	}
+	if st.Read != nil {
+		m.applyReadMarker(ch, *st.Read)
+	}
+	if m.pendingJoin == st.Name || m.pendingCreate == st.Name {
+		m.pendingCreate = ""
+		m.pendingJoin = ""
+		m.switchToBuffer(st.Name)
+	}
+}
+
+// sawSeq notes that the server's log for a buffer has reached seq.
+func (m *model) sawSeq(name string, seq uint64) {
+	if ch := m.channelByName(name); ch != nil {
+		ch.seq = max(ch.seq, seq)
+	}
+}
+
+// removeChannel drops a buffer after we left it.
+func (m *model) removeChannel(name string) {
+	m.persist(func(s store, network string) error { return s.deleteChannel(network, name) })
+	for i, ch := range m.channels {
+		if ch.name != name {
+			continue
+		}
+		m.channels = append(m.channels[:i], m.channels[i+1:]...)
+		if i < m.active {
+			// Still on the same buffer, now one up
+			m.active--
+		}
+		if m.active >= len(m.channels) {
+			m.active = len(m.channels) - 1
+		}
+		for _, p := range m.panes {
+			if p.buffer == name && m.active >= 0 {
+				p.buffer = m.channels[m.active].name
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Several servers can share the load behind a load balancer by joining a
+// pub/sub bus. Every request that changes something is published once it
+// succeeded and the other instances re-run it for a replica of the client.
+// So all of them hold the same channels and history, and each delivers the
+// resulting frames to the clients connected to it. Sessions are announced
+// too, so presence covers the whole cluster. Instances should share a --db
+// so one that starts later loads what happened before it joined.
+
+const (
+	// clusterHeartbeat is how often an instance tells the others who is
+	// connected to it. One not heard from for clusterPeerTimeout is taken
+	// to be gone along with its sessions.
+	clusterHeartbeat   = 10 * time.Second
+	clusterPeerTimeout = 3 * clusterHeartbeat
+)
+
+// Kinds of clusterEvent.
+const (
+	clusterRequest      = "request"
+	clusterConnect      = "connect"
+	clusterDisconnect   = "disconnect"
+	clusterOnline       = "online"
+	clusterAccount      = "account"
+	clusterToken        = "token"
+	clusterTokenRevoked = "token_revoked"
+	// clusterDeviceRevoked disconnects a device, see devices.go
+	clusterDeviceRevoked = "device_revoked"
+	// clusterInvite and clusterInviteRevoked are an invite being made or
+	// used and revoked, see invites.go
+	clusterInvite        = "invite"
+	clusterInviteRevoked = "invite_revoked"
+	// clusterAway is a session going away or coming back, see presence.go
+	clusterAway = "away"
+)
+
+// clusterEvent is what instances tell each other over the bus.
+type clusterEvent struct {
+	Kind   string `json:"kind"`
+	Origin string `json:"origin"` // instance ID, an instance skips its own
+	Nick   string `json:"nick,omitempty"`
+	// Frame and Stamp are a request and what the origin gave it to create
+	Frame *frame      `json:"frame,omitempty"`
+	Stamp *eventStamp `json:"stamp,omitempty"`
+	// Online counts the origin's sessions per nick, with clusterOnline,
+	// and AwaySessions those of them that are away
+	Online       map[string]int `json:"online,omitempty"`
+	AwaySessions map[string]int `json:"away_sessions,omitempty"`
+	// Away is whether the session that connected, disconnected or changed
+	// with clusterAway is away
+	Away bool `json:"away,omitempty"`
+	// Account is one Nick just registered
+	Account *account `json:"account,omitempty"`
+	// Token is a session started or ended
+	Token *refreshToken `json:"token,omitempty"`
+	// Invite is one made, used or revoked
+	Invite *invite `json:"invite,omitempty"`
+}
+
+// clusterBus carries clusterEvents between instances.
+type clusterBus interface {
+	publish(ev clusterEvent) error
+	// subscribe calls fn with every event published, in order, until the
+	// bus is closed.
+	subscribe(fn func(ev clusterEvent)) error
+	close() error
+}
+
+// peerState is what an instance knows about another one.
+type peerState struct {
+	seen   time.Time
+	online map[string]int // sessions per nick
+	away   map[string]int // of them away
+}
+
+// readOnlyFrames aren't replicated. They change nothing, or in the case of
+// refresh, revoking a device and invites, tell the other instances what
+// changed with their own events.
+var readOnlyFrames = map[string]bool{
+	framePing:         true,
+	frameHistory:      true,
+	frameList:         true,
+	frameEdits:        true,
+	frameUserData:     true,
+	frameRefresh:      true,
+	frameGetKey:       true,
+	frameDevices:      true,
+	frameRevokeDevice: true,
+	frameCreateInvite: true,
+	frameInvites:      true,
+	frameRevokeInvite: true,
+	frameAway:         true,
+}
+
+// joinCluster starts exchanging events over bus.
+func (srv *server) joinCluster(bus clusterBus) error {
+	srv.cluster = bus
+	if err := bus.subscribe(srv.handleClusterEvent); err != nil {
+		return err
+	}
+	go srv.heartbeatLoop()
+	return nil
+}
+
+// publish sends ev to the other instances, if there are any.
+func (srv *server) publish(ev clusterEvent) {
+	if srv.cluster == nil {
+		return
+	}
+	ev.Origin = srv.instance
+	if err := srv.cluster.publish(ev); err != nil {
+		log.Printf("cluster: publishing %s: %v", ev.Kind, err)
+	}
+}
+
+// replicate publishes a request s made that succeeded, for the other
+// instances to re-run.
+func (srv *server) replicate(s *session, f frame) {
+	if s.replica || readOnlyFrames[f.Type] {
+		return
+	}
+	// The request ID only means something to the client that sent it
+	f.ID = ""
+	stamp := s.stamp
+	srv.publish(clusterEvent{Kind: clusterRequest, Nick: s.nick, Frame: &f, Stamp: &stamp})
+}
+
+func (srv *server) handleClusterEvent(ev clusterEvent) {
+	if ev.Origin == srv.instance {
+		return
+	}
+	switch ev.Kind {
+	case clusterRequest:
+		if ev.Frame == nil || ev.Stamp == nil {
+			return
+		}
+		h, ok := srv.handlers[ev.Frame.Type]
+		if !ok {
+			return
+		}
+		s := &session{srv: srv, conn: discardFrameConn(), nick: ev.Nick, replica: true, stamp: *ev.Stamp}
+		if err := h(s, *ev.Frame); err != nil {
+			// The instances disagree, which a late join without a shared
+			// database can cause
+			log.Printf("cluster: replaying %s from %s for %s: %v", ev.Frame.Type, ev.Origin, ev.Nick, err)
+		}
+	case clusterConnect, clusterDisconnect:
+		delta := 1
+		if ev.Kind == clusterDisconnect {
+			delta = -1
+		}
+		srv.mu.Lock()
+		was := srv.presenceOfLocked(ev.Nick)
+		p := srv.peerLocked(ev.Origin)
+		p.online[ev.Nick] = max(p.online[ev.Nick]+delta, 0)
+		if ev.Away {
+			p.away[ev.Nick] = max(p.away[ev.Nick]+delta, 0)
+		}
+		now := srv.presenceOfLocked(ev.Nick)
+		srv.mu.Unlock()
+		srv.presenceChanged(ev.Nick, was, now)
+	case clusterAway:
+		delta := 1
+		if !ev.Away {
+			delta = -1
+		}
+		srv.mu.Lock()
+		was := srv.presenceOfLocked(ev.Nick)
+		p := srv.peerLocked(ev.Origin)
+		p.away[ev.Nick] = max(p.away[ev.Nick]+delta, 0)
+		now := srv.presenceOfLocked(ev.Nick)
+		srv.mu.Unlock()
+		srv.presenceChanged(ev.Nick, was, now)
+	case clusterAccount:
+		if ev.Account == nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.accounts[ev.Nick] = *ev.Account
+		srv.mu.Unlock()
+	case clusterToken, clusterTokenRevoked:
+		if ev.Token == nil {
+			return
+		}
+		srv.mu.Lock()
+		if ev.Kind == clusterToken {
+			srv.refreshTokens[ev.Token.Hash] = *ev.Token
+		} else {
+			delete(srv.refreshTokens, ev.Token.Hash)
+		}
+		srv.mu.Unlock()
+	case clusterInvite, clusterInviteRevoked:
+		if ev.Invite == nil {
+			return
+		}
+		srv.mu.Lock()
+		if ev.Kind == clusterInvite {
+			srv.invites[ev.Invite.Hash] = *ev.Invite
+		} else {
+			delete(srv.invites, ev.Invite.Hash)
+		}
+		srv.mu.Unlock()
+	case clusterDeviceRevoked:
+		if ev.Token == nil {
+			return
+		}
+		srv.mu.Lock()
+		kicked := srv.deviceSessionsLocked(ev.Nick, ev.Token.Device)
+		srv.mu.Unlock()
+		kickDevice(kicked)
+	case clusterOnline:
+		srv.mu.Lock()
+		p := srv.peerLocked(ev.Origin)
+		changed := srv.setPeerOnlineLocked(p, ev.Online, ev.AwaySessions)
+		srv.mu.Unlock()
+		for nick, now := range changed {
+			srv.broadcastPresence(nick, now)
+		}
+	}
+}
+
+// peerLocked returns what is known about instance id, noting that it was
+// just heard from.
+func (srv *server) peerLocked(id string) *peerState {
+	p, ok := srv.peers[id]
+	if !ok {
+		p = &peerState{online: make(map[string]int), away: make(map[string]int)}
+		srv.peers[id] = p
+	}
+	p.seen = time.Now()
+	return p
+}
+
+// setPeerOnlineLocked replaces a peer's session counts, returning the
+// nicks whose cluster-wide presence changed with it and what it is now.
+func (srv *server) setPeerOnlineLocked(p *peerState, online, away map[string]int) map[string]presence {
+	nicks := make(map[string]presence)
+	for nick := range p.online {
+		nicks[nick] = srv.presenceOfLocked(nick)
+	}
+	for nick := range online {
+		if _, ok := nicks[nick]; !ok {
+			nicks[nick] = srv.presenceOfLocked(nick)
+		}
+	}
+	if online == nil {
+		online = make(map[string]int)
+	}
+	if away == nil {
+		away = make(map[string]int)
+	}
+	p.online, p.away = online, away
+	changed := make(map[string]presence)
+	for nick, was := range nicks {
+		if now := srv.presenceOfLocked(nick); now != was {
+			changed[nick] = now
+		}
+	}
+	return changed
+}
+
+// presenceChanged tells about nick's presence, if it changed.
+func (srv *server) presenceChanged(nick string, was, now presence) {
+	if now != was {
+		srv.broadcastPresence(nick, now)
+	}
+}
+
+// heartbeatLoop tells the other instances who is connected here every
+// clusterHeartbeat, and forgets instances that stopped doing the same.
+func (srv *server) heartbeatLoop() {
+	for {
+		srv.mu.Lock()
+		online, away := make(map[string]int), make(map[string]int)
+		for s := range srv.sessions {
+			online[s.nick]++
+			if s.away {
+				away[s.nick]++
+			}
+		}
+		changed := make(map[string]presence)
+		for id, p := range srv.peers {
+			if time.Since(p.seen) < clusterPeerTimeout {
+				continue
+			}
+			for nick, now := range srv.setPeerOnlineLocked(p, nil, nil) {
+				changed[nick] = now
+			}
+			delete(srv.peers, id)
+			log.Printf("cluster: lost instance %s", id)
+		}
+		srv.mu.Unlock()
+
+		for nick, now := range changed {
+			srv.broadcastPresence(nick, now)
+		}
+		srv.publish(clusterEvent{Kind: clusterOnline, Online: online, AwaySessions: away})
+		time.Sleep(clusterHeartbeat)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisChannel is the pub/sub channel instances talk on.
+const redisChannel = "gochat:cluster"
+
+// redisBus is a clusterBus on Redis pub/sub.
+type redisBus struct {
+	client *redis.Client
+	sub    *redis.PubSub
+}
+
+// openRedisBus connects to the Redis server at url, e.g.
+// redis://:password@localhost:6379/0.
+func openRedisBus(url string) (*redisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisBus{client: client}, nil
+}
+
+func (b *redisBus) publish(ev clusterEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(context.Background(), redisChannel, data).Err()
+}
+
+func (b *redisBus) subscribe(fn func(ev clusterEvent)) error {
+	ctx := context.Background()
+	b.sub = b.client.Subscribe(ctx, redisChannel)
+	// Wait for the subscription, so nothing published after this returns
+	// is missed
+	if _, err := b.sub.Receive(ctx); err != nil {
+		return err
+	}
+	go func() {
+		for msg := range b.sub.Channel() {
+			var ev clusterEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				log.Printf("cluster: bad event: %v", err)
+				continue
+			}
+			fn(ev)
+		}
+	}()
+	return nil
+}
+
+func (b *redisBus) close() error {
+	if b.sub != nil {
+		b.sub.Close()
+	}
+	return b.client.Close()
+}
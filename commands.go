@@ -0,0 +1,273 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// command is a slash command typed into the composer.
+type command struct {
+	name string
+	args string // usage, e.g. "<#channel>"
+	help string
+	run  func(m *model, args string) tea.Cmd
+}
+
+var commands = map[string]command{}
+
+// registerCommand makes c available as /name. Two commands can't share a
+// name, the second would quietly replace the first.
+func registerCommand(c command) {
+	if _, ok := commands[c.name]; ok {
+		panic("command /" + c.name + " registered twice")
+	}
+	commands[c.name] = c
+}
+
+func init() {
+	registerCommand(command{name: "list", help: "browse public channels", run: cmdList})
+	registerCommand(command{name: "join", args: "<#channel>", help: "join a channel", run: cmdJoin})
+	registerCommand(command{name: "part", args: "[#channel]", help: "leave a channel", run: cmdPart})
+	registerCommand(command{name: "switch", args: "<buffer>", help: "show another buffer", run: cmdSwitch})
+	registerCommand(command{name: "create", args: "[#channel]", help: "create a channel", run: cmdCreate})
+	registerCommand(command{name: "topic", args: "[text]", help: "show or set the channel topic", run: cmdTopic})
+	registerCommand(command{name: "mute", args: "[#channel] | <nick> [duration] [reason]", help: "silence a buffer's badges and notifications, or stop someone posting (moderators)", run: cmdMuteOr(cmdMute, modMute)})
+	registerCommand(command{name: "unmute", args: "[#channel] | <nick>", help: "undo /mute of a buffer, or lift someone's mute or timeout (moderators)", run: cmdMuteOr(cmdUnmute, modUnmute)})
+	registerCommand(command{name: "notify", args: "[all|mentions|nothing]", help: "set notifications for the buffer", run: cmdNotify})
+	registerCommand(command{name: "notifications", args: "[all|mentions|dms|nothing]", help: "show or set how far sounds and desktop notifications go, over every buffer", run: cmdNotifications})
+	registerCommand(command{name: "dnd", args: "[on|off|duration]", help: "hold back sounds and notifications, for good or e.g. 1h", run: cmdDND})
+	registerCommand(command{name: "away", help: "tell everyone you are away, until /back", run: cmdAway})
+	registerCommand(command{name: "back", help: "undo /away", run: cmdBack})
+	registerCommand(command{name: "status", args: "[emoji] [text] [+duration]|clear", help: "show or set what you are up to, for a while with e.g. +1h", run: cmdStatus})
+	registerCommand(command{name: "whois", args: "<nick>", help: "show someone's presence, status and the channels you share", run: cmdWhois})
+	registerCommand(command{name: "autoaway", args: "[on|off|system|tui|minutes]", help: "show or set going away after a while without input, in gochat or anywhere", run: cmdAutoAway})
+	registerCommand(command{name: "desktop", args: "[on|off|osc|native|auto]", help: "show or set desktop notifications of mentions and DMs, and how they are shown", run: cmdDesktop})
+	registerCommand(command{name: "category", args: "[name|-]", help: "file the buffer under a sidebar category", run: cmdCategory})
+	registerCommand(command{name: "archive", args: "[#channel]", help: "archive a channel (admins)", run: cmdArchive})
+	registerCommand(command{name: "permissions", args: "[role perm,...|none|default]", help: "show or override what roles may do in the channel", run: cmdPermissions})
+	registerCommand(command{name: "slowmode", args: "[seconds|off]", help: "show or set how long members wait between messages (moderators)", run: cmdSlowMode})
+	registerCommand(command{name: "filter", args: "[block|redact|flag <regexp> | remove <n> | server on|off]", help: "show or change the channel's word filter (moderators)", run: cmdFilter})
+	registerCommand(command{name: "filterlog", help: "review what the word filter caught in the channel (moderators)", run: cmdFilterLog})
+	registerCommand(command{name: "report", args: "[reason]", help: "report the selected or latest message to the channel's moderators", run: cmdReport})
+	registerCommand(command{name: "reports", help: "review the reported messages in channels you moderate", run: cmdReports})
+	registerCommand(command{name: "kick", args: "<nick> [reason]", help: "remove someone from the channel (moderators)", run: cmdModerate(modKick)})
+	registerCommand(command{name: "ban", args: "<nick> [duration] [reason]", help: "remove someone and keep them out, for good or e.g. 7d (moderators)", run: cmdModerate(modBan)})
+	registerCommand(command{name: "unban", args: "<nick>", help: "lift a ban (moderators)", run: cmdModerate(modUnban)})
+	registerCommand(command{name: "timeout", args: "<nick> <duration> [reason]", help: "mute someone for a while, e.g. 10m (moderators)", run: cmdModerate("timeout")})
+	registerCommand(command{name: "shadowban", args: "<nick> [duration] [reason]", help: "hide someone's messages from everyone else without telling them (moderators)", run: cmdModerate(modShadowBan)})
+	registerCommand(command{name: "unshadowban", args: "<nick>", help: "lift a shadow ban (moderators)", run: cmdModerate(modUnshadowBan)})
+	registerCommand(command{name: "modlog", help: "review the channel's moderation actions, shadow bans too (moderators)", run: cmdModLog})
+	registerCommand(command{name: "unarchive", args: "[#channel]", help: "restore an archived channel (admins)", run: cmdUnarchive})
+	registerCommand(command{name: "archived", help: "browse archived channels", run: cmdArchived})
+	registerCommand(command{name: "members", help: "manage the channel's members", run: cmdMembers})
+	registerCommand(command{name: "invite", args: "<nick> [#channel]", help: "add someone to a channel", run: cmdInvite})
+	registerCommand(command{name: "remove", args: "<nick> [#channel]", help: "remove someone from a channel (moderators)", run: cmdRemove})
+	registerCommand(command{name: "reply", help: "reply to the selected or latest message", run: cmdReply})
+	registerCommand(command{name: "react", args: "<emoji>", help: "react to the selected or latest message", run: cmdReact})
+	registerCommand(command{name: "edit", args: "[text]", help: "edit the selected message or your latest one", run: cmdEdit})
+	registerCommand(command{name: "edits", help: "show the earlier versions of the selected or latest message", run: cmdEdits})
+	registerCommand(command{name: "delete", help: "delete the selected message or your latest one", run: cmdDelete})
+	registerCommand(command{name: "verify", help: "compare encryption keys with the DM's peer", run: cmdVerify})
+	registerCommand(command{name: "activity", help: "show mentions, replies and reactions to you", run: cmdActivity})
+	registerCommand(command{name: "summary", args: "[on|off]", help: "show what is unread, or set whether that shows on starting up", run: cmdSummary})
+	registerCommand(command{name: "info", help: "show the channel's details and pins", run: cmdInfo})
+	registerCommand(command{name: "pin", help: "pin the selected or latest message", run: cmdPin})
+	registerCommand(command{name: "unpin", help: "unpin the selected or latest message", run: cmdUnpin})
+	registerCommand(command{name: "star", help: "star the selected or latest message, or unstar it", run: cmdStar})
+	registerCommand(command{name: "starred", help: "list starred messages", run: cmdStarred})
+	registerCommand(command{name: "statusline", args: "[format|reset]", help: "show or set the status line format", run: cmdStatusLine})
+	registerCommand(command{name: "reload", help: "read the config file again", run: cmdReload})
+	registerCommand(command{name: "profile", args: "[name|-]", help: "list the config file's profiles, or switch to one", run: cmdProfile})
+	registerCommand(command{name: "export", args: "[markdown|html|json] [since]", help: "save the buffer's history to a file", run: cmdExport})
+	registerCommand(command{name: "repin", help: "trust the certificate the server changed to", run: cmdRepin})
+	registerCommand(command{name: "register", args: "[invite code]", help: "make your guest nick an account", run: cmdRegister})
+	registerCommand(command{name: "invites", args: "[create [uses] [duration] [#channel]]", help: "list and revoke the server's invite codes, or make one (admins)", run: cmdInvites})
+	registerCommand(command{name: "devices", help: "list the devices logged in to your account, and log them out", run: cmdDevices})
+	registerCommand(command{name: "userdata", args: "<nick>", help: "save everything a user posted as JSON (server admins)", run: cmdUserData})
+	registerCommand(command{name: "erase", args: "<nick> confirm", help: "erase a user and everything they posted (server admins)", run: cmdErase})
+	registerCommand(command{name: "purge", args: "<days>|all", help: "delete the channel's history on the server (admins)", run: cmdPurge})
+	registerCommand(command{name: "log", args: "[on|off|default]", help: "show or set whether the buffer is logged to a text file", run: cmdLog})
+	registerCommand(command{name: "help", help: "list commands", run: cmdHelp})
+	registerCommand(command{name: "quit", help: "leave gochat", run: func(*model, string) tea.Cmd { return tea.Quit }})
+}
+
+// runCommand parses and runs a "/name args" line from the composer.
+func (m *model) runCommand(line string) tea.Cmd {
+	line = strings.TrimPrefix(line, "/")
+	name, args, _ := strings.Cut(line, " ")
+	c, ok := commands[strings.ToLower(name)]
+	if !ok {
+		m.notice("Unknown command /" + name + ", try /help")
+		return nil
+	}
+	return c.run(m, strings.TrimSpace(args))
+}
+
+func cmdHelp(m *model, _ string) tea.Cmd {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		usage := "/" + c.name
+		if c.args != "" {
+			usage += " " + c.args
+		}
+		m.notice(usage + " — " + c.help)
+	}
+	return nil
+}
+
+func cmdList(m *model, _ string) tea.Cmd {
+	return m.request(frameList, nil)
+}
+
+func cmdArchived(m *model, _ string) tea.Cmd {
+	return m.request(frameList, listData{Archived: true})
+}
+
+func cmdArchive(m *model, args string) tea.Cmd {
+	return m.setArchived(args, true)
+}
+
+func cmdUnarchive(m *model, args string) tea.Cmd {
+	return m.setArchived(args, false)
+}
+
+func (m *model) setArchived(args string, archived bool) tea.Cmd {
+	ch := m.bufferArg(args)
+	if ch == nil || ch.isDM() {
+		m.notice("No such channel: " + args)
+		return nil
+	}
+	if !m.allowed(ch, permManageChannels, "archive channels") {
+		return nil
+	}
+	return m.request(frameArchive, archiveData{Channel: ch.name, Archived: archived})
+}
+
+func cmdJoin(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice("Usage: /join <#channel>")
+		return nil
+	}
+	return m.joinChannel(args)
+}
+
+func cmdPart(m *model, args string) tea.Cmd {
+	if args == "" {
+		ch := m.activeChannel()
+		if ch == nil || ch.isDM() {
+			m.notice("Usage: /part [#channel]")
+			return nil
+		}
+		args = ch.name
+	}
+	return m.request(framePart, channelRef{Channel: args})
+}
+
+func cmdCreate(m *model, args string) tea.Cmd {
+	if m.serverRole() == roleGuest {
+		m.notice("Guests can't create channels")
+		return nil
+	}
+	m.overlay = newCreateForm(args)
+	return nil
+}
+
+func cmdTopic(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Topics are only for channels")
+		return nil
+	}
+	if args == "" {
+		if ch.topic == "" {
+			m.notice("No topic is set for " + ch.name)
+		} else {
+			m.notice("Topic for " + ch.name + ": " + ch.topic)
+		}
+		return nil
+	}
+	if !m.allowed(ch, permPost, "set the topic") {
+		return nil
+	}
+	return m.request(frameTopic, topicData{Channel: ch.name, Topic: args})
+}
+
+// bufferArg resolves an optional buffer argument, defaulting to the active
+// buffer.
+func (m *model) bufferArg(args string) *channel {
+	if args == "" {
+		return m.activeChannel()
+	}
+	if ch := m.channelByName(args); ch != nil {
+		return ch
+	}
+	if !strings.HasPrefix(args, "#") && !strings.HasPrefix(args, "@") {
+		return m.channelByName("#" + args)
+	}
+	return nil
+}
+
+func cmdSwitch(m *model, args string) tea.Cmd {
+	ch := m.bufferArg(args)
+	if args == "" || ch == nil {
+		m.notice("No such buffer: " + args)
+		return nil
+	}
+	m.switchToBuffer(ch.name)
+	return nil
+}
+
+// cmdMuteOr runs /mute and /unmute: of a buffer, the one shown unless a
+// #channel or @nick is given, or else of someone in the channel, as the
+// moderation action.
+func cmdMuteOr(buffer func(m *model, args string) tea.Cmd, action string) func(m *model, args string) tea.Cmd {
+	moderate := cmdModerate(action)
+	return func(m *model, args string) tea.Cmd {
+		args = strings.TrimSpace(args)
+		if args == "" || strings.HasPrefix(args, "#") || strings.HasPrefix(args, "@") {
+			return buffer(m, args)
+		}
+		return moderate(m, args)
+	}
+}
+
+func cmdMute(m *model, args string) tea.Cmd {
+	ch := m.bufferArg(args)
+	if ch == nil {
+		m.notice("No such buffer: " + args)
+		return nil
+	}
+	m.notice("Muted " + ch.name)
+	return m.setMuted(ch.name, true)
+}
+
+func cmdUnmute(m *model, args string) tea.Cmd {
+	ch := m.bufferArg(args)
+	if ch == nil {
+		m.notice("No such buffer: " + args)
+		return nil
+	}
+	m.notice("Unmuted " + ch.name)
+	return m.setMuted(ch.name, false)
+}
+
+// joinChannel switches to name if we're already in it, otherwise asks the
+// server to join and switches once the channel state arrives.
+func (m *model) joinChannel(name string) tea.Cmd {
+	if !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+	if m.channelByName(name) != nil {
+		m.switchToBuffer(name)
+		return nil
+	}
+	m.pendingJoin = name
+	return m.request(frameJoin, channelRef{Channel: name})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+	"gopkg.in/yaml.v3"
+)
+
+// What the user sets up by hand goes in a config file, config.toml or
+// config.yaml under gochat in $XDG_CONFIG_HOME (~/.config unless set) or
+// the OS config directory, or the file given with --config; settings.json
+// stays for what gochat writes back itself. It lists the servers, with the
+// nick and password each logs in with, and sets the theme, keys and UI:
+//
+//	nick = "alice"
+//	highlight = ["gochat", "/OPS-[0-9]+/"]
+//	quiet_hours = ["22:00-07:30"]
+//
+//	[[servers]]
+//	name = "work"
+//	addr = "chat.example.com:6697"
+//	tls = true
+//	password = "command:pass show gochat/work"
+//
+//	[theme]
+//	palette = "catppuccin"
+//
+//	[keys]
+//	quit = ["ctrl+q"]
+//
+//	[ui]
+//	show_members = false
+//
+//	[sounds]
+//	mention = "bell"
+//
+//	[[rules]]
+//	from = "deploybot"
+//	action = "ignore"
+//
+// Profiles are other sets of servers, nick and theme, picked with
+// "gochat connect work" or /profile (see profiles.go):
+//
+//	[profiles.work]
+//	nick = "alice.w"
+//	[[profiles.work.servers]]
+//	addr = "chat.example.com:6697"
+//	autojoin = ["#ops"]
+//
+// A password is only ever a reference to one: "env:NAME" reads $NAME,
+// "command:..." takes the first line a shell command prints, and
+// "keychain:item" reads the item from the OS keychain (see keychain.go).
+// Flags, and the GOCHAT_ variables standing in for them, win over the
+// file, and the file over settings.json.
+
+var errPlainPassword = errors.New(`a password in the config file refers to one, as "env:NAME", "command:..." or "keychain:item"`)
+
+// config is the config file.
+type config struct {
+	Nick    string                 `toml:"nick" yaml:"nick"`
+	Servers []configServer         `toml:"servers" yaml:"servers"` // used when --server isn't given
+	Theme   themeConfig            `toml:"theme" yaml:"theme"`
+	Themes  map[string]themeConfig `toml:"themes" yaml:"themes"` // custom ones, see theme.go
+	Keys    map[string][]string    `toml:"keys" yaml:"keys"`     // binding, as in keyMap in snake_case, to its keys
+	UI      uiConfig               `toml:"ui" yaml:"ui"`
+	Sounds  soundConfig            `toml:"sounds" yaml:"sounds"` // see sounds.go
+	// Highlight words count as mentions, see highlight.go
+	Highlight []string `toml:"highlight" yaml:"highlight"`
+	// QuietHours hold back sounds and notifications daily, see dnd.go
+	QuietHours []string `toml:"quiet_hours" yaml:"quiet_hours"`
+	// Rules weigh incoming messages first, see rules.go
+	Rules []ruleConfig `toml:"rules" yaml:"rules"`
+	// Profiles stand in for Nick, Servers and Theme when picked
+	Profiles map[string]configProfile `toml:"profiles" yaml:"profiles"`
+}
+
+// configProfile is a profile in the config file, see withProfile.
+type configProfile struct {
+	Nick    string         `toml:"nick" yaml:"nick"`
+	Servers []configServer `toml:"servers" yaml:"servers"`
+	Theme   themeConfig    `toml:"theme" yaml:"theme"`
+}
+
+// configServer is a server in the config file, see serverSettings.
+type configServer struct {
+	Name     string   `toml:"name" yaml:"name"`
+	Addr     string   `toml:"addr" yaml:"addr"`
+	TLS      bool     `toml:"tls" yaml:"tls"`
+	Pins     []string `toml:"pins" yaml:"pins"`
+	Nick     string   `toml:"nick" yaml:"nick"`         // defaults to the top-level one
+	Password string   `toml:"password" yaml:"password"` // a reference, see resolvePassword
+	Autojoin []string `toml:"autojoin" yaml:"autojoin"` // channels joined on connecting
+}
+
+type uiConfig struct {
+	// StatusFormat lays out the status line unless /statusline set one
+	StatusFormat string `toml:"status_format" yaml:"status_format"`
+	ShowMembers  *bool  `toml:"show_members" yaml:"show_members"` // starts with the member list shown, the default
+	Mouse        *bool  `toml:"mouse" yaml:"mouse"`               // takes mouse input, the default
+	Colors       string `toml:"colors" yaml:"colors"`             // how many the terminal has, see setColors
+	Icons        string `toml:"icons" yaml:"icons"`               // nerd, ascii or auto, see icons.go
+	Borders      string `toml:"borders" yaml:"borders"`           // normal, rounded, square or none, see boxes.go
+	Density      string `toml:"density" yaml:"density"`           // comfortable or compact
+	WindowName   bool   `toml:"window_name" yaml:"window_name"`   // renames the tmux or screen window, see title.go
+	// MessageFormat lays out messages, see defaultMessageFormat, with
+	// {time} in TimeFormat, a Go time layout such as 15:04:05
+	MessageFormat string `toml:"message_format" yaml:"message_format"`
+	TimeFormat    string `toml:"time_format" yaml:"time_format"`
+	// ReduceMotion stops cursors blinking and spinners, see motion.go
+	ReduceMotion bool `toml:"reduce_motion" yaml:"reduce_motion"`
+	// How nicks show in messages, see nicks.go
+	NickWidth   int    `toml:"nick_width" yaml:"nick_width"`
+	NickAlign   string `toml:"nick_align" yaml:"nick_align"` // left, the default, or right
+	HideDMNicks bool   `toml:"hide_dm_nicks" yaml:"hide_dm_nicks"`
+	NickRoles   bool   `toml:"nick_roles" yaml:"nick_roles"`
+}
+
+// configNames are the names the config file is looked for under.
+var configNames = []string{"config.toml", "config.yaml", "config.yml"}
+
+// configDirs are where the config file is looked for, in order.
+func configDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		dirs = append(dirs, dir)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil && !slices.Contains(dirs, dir) {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// loadConfig reads the config file at path, or the first one found if
+// path is empty. No file at all is an empty config.
+func loadConfig(path string) (config, error) {
+	if path = findConfig(path); path == "" {
+		return config{}, nil
+	}
+	return readConfig(path)
+}
+
+// findConfig is path if given, or else the first config file found, empty
+// if there is none.
+func findConfig(path string) string {
+	if path != "" {
+		return path
+	}
+	for _, dir := range configDirs() {
+		for _, name := range configNames {
+			path := filepath.Join(dir, "gochat", name)
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// readConfig reads the config file at path, as YAML if it is named so and
+// TOML otherwise. Settings it doesn't know are errors, typos being likely.
+func readConfig(path string) (config, error) {
+	var c config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		md, err := toml.Decode(string(data), &c)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+		if unknown := md.Undecoded(); len(unknown) > 0 {
+			return c, fmt.Errorf("%s: unknown setting %s", path, unknown[0])
+		}
+	}
+	return c, nil
+}
+
+// apply sets the theme and keys from c, and fills in what flags left
+// unset in opts.
+func (c config) apply(opts *options) error {
+	if err := c.applyLooks(); err != nil {
+		return err
+	}
+	if err := c.Sounds.check(); err != nil {
+		return err
+	}
+	h, err := parseHighlights(c.Highlight)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseQuietHours(c.QuietHours)
+	if err != nil {
+		return err
+	}
+	rules, err := parseRules(c.Rules, c.Sounds)
+	if err != nil {
+		return err
+	}
+	opts.highlight, opts.quietHours, opts.rules = h, quiet, rules
+	if opts.nick == "" {
+		opts.nick = c.Nick
+	}
+	if len(opts.servers) == 0 {
+		servers, err := c.serverList()
+		if err != nil {
+			return err
+		}
+		opts.servers = servers
+	}
+	opts.ui, opts.sounds = c.UI, c.Sounds
+	return nil
+}
+
+// serverList is the servers c lists, with their passwords looked up.
+func (c config) serverList() ([]serverSettings, error) {
+	var list []serverSettings
+	for _, cs := range c.Servers {
+		srv, err := cs.settings()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, srv)
+	}
+	return list, nil
+}
+
+// settings is the server cs lists, with its password looked up.
+func (cs configServer) settings() (serverSettings, error) {
+	if cs.Addr == "" {
+		return serverSettings{}, errors.New("a server in the config file has no addr")
+	}
+	password, err := resolvePassword(cs.Password)
+	if err != nil {
+		return serverSettings{}, fmt.Errorf("password for %s: %w", cs.Addr, err)
+	}
+	return serverSettings{
+		Name: cs.Name, Addr: cs.Addr, TLS: cs.TLS, Pins: cs.Pins,
+		nick: cs.Nick, password: password, autojoin: cs.Autojoin,
+	}, nil
+}
+
+// applyLooks sets the colors, theme, icons, keys, motion and message format
+// from c, all or, on an error, none of them.
+func (c config) applyLooks() error {
+	layout, err := parseMessageFormat(c.UI.MessageFormat, c.UI.TimeFormat)
+	if err != nil {
+		return err
+	}
+	if layout.nick, err = nickLookFor(c.UI); err != nil {
+		return err
+	}
+	set, err := iconsFor(c.UI.Icons)
+	if err != nil {
+		return err
+	}
+	look, err := boxLookFor(c.UI.Borders, c.UI.Density)
+	if err != nil {
+		return err
+	}
+	km := defaultKeys
+	if err := remapKeys(&km, c.Keys); err != nil {
+		return err
+	}
+	profile := lipgloss.ColorProfile()
+	if err := setColors(c.UI.Colors); err != nil {
+		return err
+	}
+	t, err := resolveTheme(c.Theme, c.Themes)
+	if err != nil {
+		lipgloss.SetColorProfile(profile)
+		return err
+	}
+	icons, chrome, reduceMotion = set, look, c.UI.ReduceMotion
+	setTheme(t)
+	keys, messageFormat = km, layout
+	return nil
+}
+
+// resolvePassword looks up the password ref refers to.
+func resolvePassword(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	scheme, rest, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "env":
+		return os.Getenv(rest), nil
+	case "command":
+		cmd := exec.Command("sh", "-c", rest)
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", rest)
+		}
+		// Password managers may prompt for their own passphrase
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("running %q: %w", rest, err)
+		}
+		password, _, _ := strings.Cut(string(out), "\n")
+		return strings.TrimSuffix(password, "\r"), nil
+	case "keychain":
+		password, err := keychainGet(rest)
+		if err == nil && password == "" {
+			err = fmt.Errorf("%q isn't in the keychain", rest)
+		}
+		return password, err
+	}
+	return "", errPlainPassword
+}
+
+// defaultKeys are the bindings before the config file remaps them.
+var defaultKeys = keys
+
+// remapKeys binds the bindings of km named in remap, switch_focus for
+// SwitchFocus, to the keys given, turning off ones given none.
+func remapKeys(km *keyMap, remap map[string][]string) error {
+	v := reflect.ValueOf(km).Elem()
+	for name, ks := range remap {
+		f := v.FieldByNameFunc(func(field string) bool { return snakeCase(field) == name })
+		if !f.IsValid() {
+			return fmt.Errorf("keys: there is no binding %q", name)
+		}
+		b := f.Addr().Interface().(*key.Binding)
+		if len(ks) == 0 {
+			b.SetEnabled(false)
+			continue
+		}
+		b.SetKeys(ks...)
+		b.SetHelp(strings.Join(ks, "/"), b.Help().Desc)
+	}
+	return nil
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type createChannelMsg struct{ createData }
+
+const (
+	createFieldName = iota
+	createFieldTopic
+	createFieldPrivate
+	createFieldCount
+)
+
+// createForm is the /create overlay: channel name, topic and private flag.
+type createForm struct {
+	name    textinput.Model
+	topic   textinput.Model
+	private bool
+	field   int
+	err     string
+}
+
+func newCreateForm(name string) *createForm {
+	n := newTextInput()
+	n.Prompt = ""
+	n.Placeholder = "#channel-name"
+	n.CharLimit = 33
+	n.SetValue(name)
+	n.Focus()
+
+	t := newTextInput()
+	t.Prompt = ""
+	t.Placeholder = "What's it about?"
+	t.CharLimit = maxTopicLength
+
+	return &createForm{name: n, topic: t}
+}
+
+func (f *createForm) focusField(i int) {
+	f.field = (i + createFieldCount) % createFieldCount
+	f.name.Blur()
+	f.topic.Blur()
+	switch f.field {
+	case createFieldName:
+		f.name.Focus()
+	case createFieldTopic:
+		f.topic.Focus()
+	}
+}
+
+func (f *createForm) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return f, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.NextField):
+		f.focusField(f.field + 1)
+		return f, nil
+	case key.Matches(keyMsg, keys.PrevField):
+		f.focusField(f.field - 1)
+		return f, nil
+	case f.field == createFieldPrivate && key.Matches(keyMsg, keys.Toggle):
+		f.private = !f.private
+		return f, nil
+	case key.Matches(keyMsg, keys.Select):
+		name := strings.TrimSpace(f.name.Value())
+		if !strings.HasPrefix(name, "#") {
+			name = "#" + name
+		}
+		if !channelNameRE.MatchString(name) {
+			f.err = errBadChannel.Error()
+			f.focusField(createFieldName)
+			return f, nil
+		}
+		req := createData{Name: name, Topic: strings.TrimSpace(f.topic.Value()), Private: f.private}
+		return nil, func() tea.Msg { return createChannelMsg{req} }
+	}
+
+	var cmd tea.Cmd
+	switch f.field {
+	case createFieldName:
+		f.name, cmd = f.name.Update(msg)
+	case createFieldTopic:
+		f.topic, cmd = f.topic.Update(msg)
+	}
+	return f, cmd
+}
+
+func (f *createForm) View(width, height int) string {
+	w := 50
+	if width-4 < w {
+		w = width - 4
+	}
+
+	label := func(i int, s string) string {
+		if i == f.field {
+			return overlayPromptStyle.Render("› " + s)
+		}
+		return overlayHintStyle.Render("  " + s)
+	}
+	check := "[ ]"
+	if f.private {
+		check = "[x]"
+	}
+
+	lines := []string{
+		overlayTitleStyle.Render("Create channel"),
+		"",
+		label(createFieldName, "Name"),
+		"  " + f.name.View(),
+		label(createFieldTopic, "Topic"),
+		"  " + f.topic.View(),
+		label(createFieldPrivate, "Private  "+check),
+	}
+	if f.err != "" {
+		lines = append(lines, "", errorTextStyle.Width(w-2).Render(f.err))
+	}
+	lines = append(lines, "", overlayHintStyle.Render("tab field • space toggle • enter create • esc cancel"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A client reconnecting says in its hello how far it has seen each buffer's
+// log, by sequence number. Channels it is behind in come with just the
+// frames for the events it missed, and DMs get those streamed, so nothing
+// that happened while it was away is lost and nothing it has is sent again.
+
+// maxGapEvents is the most events sent to catch up a conversation. A
+// channel further behind gets its recent history again instead, a DM only
+// its newest events.
+const maxGapEvents = 1000
+
+// --- Server side ---
+
+// gapSince returns up to limit of the newest events of c after seen, and
+// whether that is all of them. It isn't when seen is ahead of the log, as
+// after the server lost its state.
+func (c *conversation) gapSince(seen uint64, limit int) ([]serverEvent, bool) {
+	if seen > c.seq {
+		return nil, false
+	}
+	i := sort.Search(len(c.events), func(i int) bool { return c.events[i].Seq > seen })
+	gap := c.events[i:]
+	if len(gap) > limit {
+		return slices.Clone(gap[len(gap)-limit:]), false
+	}
+	return slices.Clone(gap), true
+}
+
+// eventFrames turns events into the frames that told clients about them,
+// for the buffer the receiving client knows the conversation by. Joins and
+// parts are left to the channel's member list.
+func eventFrames(buffer string, evs []serverEvent) []frame {
+	var frames []frame
+	for _, ev := range evs {
+		switch ev.Kind {
+		case eventMessage:
+			w := *ev.Message
+			w.Channel, w.Seq = buffer, ev.Seq
+			frames = append(frames, newFrame(frameMessage, w))
+		case eventEdit:
+			frames = append(frames, newFrame(frameEdited, editData{Channel: buffer, ID: ev.ID, Text: ev.Text, Nick: ev.Nick, Time: ev.Time, Seq: ev.Seq, Sig: ev.Sig}))
+		case eventDelete:
+			frames = append(frames, newFrame(frameDeleted, deleteData{Channel: buffer, ID: ev.ID, Nick: ev.Nick, Seq: ev.Seq}))
+		case eventReaction:
+			frames = append(frames, newFrame(frameReaction, reactionData{Channel: buffer, ID: ev.ID, Emoji: ev.Emoji, Nick: ev.Nick, Added: ev.Added, Seq: ev.Seq}))
+		}
+	}
+	return frames
+}
+
+// sendDMGaps streams what s missed in its DMs. Ones the client hasn't
+// seen at all get their last historyReplay events.
+func (srv *server) sendDMGaps(s *session, seen map[string]uint64) {
+	srv.mu.Lock()
+	var frames []frame
+	for key, dm := range srv.dms {
+		a, b, _ := strings.Cut(key, "\x00")
+		peer := a
+		switch s.nick {
+		case a:
+			peer = b
+		case b:
+		default:
+			continue
+		}
+		buffer := "@" + peer
+		limit := maxGapEvents
+		if seen[buffer] == 0 {
+			limit = historyReplay
+		}
+		gap, _ := dm.gapSince(seen[buffer], limit)
+		frames = append(frames, eventFrames(buffer, gap)...)
+	}
+	srv.mu.Unlock()
+
+	for _, f := range frames {
+		s.conn.write(f)
+	}
+}
+
+// --- Client side ---
+
+// lastSeen is how far each of channels has been seen, for the hello.
+func lastSeen(channels []*channel) map[string]uint64 {
+	seen := make(map[string]uint64)
+	for _, ch := range channels {
+		if ch.seq > 0 {
+			seen[ch.name] = ch.seq
+		}
+	}
+	return seen
+}
+
+// applyGap catches ch up with the events it missed, from a channel state
+// sent with Since.
+func (m *model) applyGap(ch *channel, st channelStateData) {
+	ch.seq = st.Since
+	for _, f := range st.Gap {
+		m.handleFrame(f)
+	}
+	ch.seq = max(ch.seq, st.Seq)
+}
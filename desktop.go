@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Mentions and DMs that come in while the terminal isn't focused pop up a
+// desktop notification, saying who wrote what where: through notify-send
+// on Linux and the BSDs, osascript on macOS and a PowerShell toast on
+// Windows, or the terminal itself (see termnotify.go). Clicking one,
+// where notify-send can tell (libnotify 0.7.9 and later), shows the
+// message. The terminal has to report focus for any of this, as most do;
+// tmux wants "set -g focus-events on". /desktop off turns them off on this
+// machine, and on again.
+
+const (
+	// desktopGap is how long after a notification another one waits, so a
+	// burst of messages pops up one.
+	desktopGap = 3 * time.Second
+	// desktopClickWait is how long a notification can be clicked.
+	desktopClickWait = 10 * time.Minute
+	// maxDesktopBody is how much of a message a notification shows.
+	maxDesktopBody = 200
+)
+
+// desktopNote is a desktop notification about a message.
+type desktopNote struct {
+	title, body string
+	channel, id string // the message, to show when clicked
+}
+
+// desktopClickMsg is a desktop notification clicked, on network n if any.
+type desktopClickMsg struct {
+	n    *network
+	note desktopNote
+}
+
+// desktopNotifier is the command that shows desktop notifications here,
+// or empty if there is none.
+func desktopNotifier() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "osascript"
+	case "windows":
+		return "powershell"
+	}
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return "notify-send"
+	}
+	return ""
+}
+
+// notifyDesktop queues a notification about msg in ch, if a calls for one
+// and the terminal isn't focused.
+func (m *model) notifyDesktop(ch *channel, msg message, a alert) {
+	if !a.mention && !a.notify || !m.unfocused || !m.settings.DesktopNotify || time.Since(m.lastDesktopNote) < desktopGap || !m.notifies(ch, a) {
+		return
+	}
+	title := msg.nick + " in " + ch.name
+	if ch.isDM() {
+		title = msg.nick
+	}
+	if m.client != nil && m.client.network != nil && len(m.networks) > 1 {
+		title += " on " + m.client.network.name
+	}
+	body := truncate(strings.Join(strings.Fields(msg.text), " "), maxDesktopBody)
+	m.desktopNote = &desktopNote{title: title, body: body, channel: ch.name, id: msg.id}
+	m.lastDesktopNote = time.Now()
+}
+
+// sendDesktopNote shows the notification queued, if any.
+func (m *model) sendDesktopNote() tea.Cmd {
+	note := m.desktopNote
+	m.desktopNote = nil
+	if note == nil {
+		return nil
+	}
+	if useOSCNotify(m.settings.DesktopVia) {
+		return writeTerminal(oscNotification(*note))
+	}
+	var n *network
+	if m.client != nil {
+		n = m.client.network
+	}
+	return func() tea.Msg {
+		clicked, err := showDesktopNote(*note)
+		switch {
+		case err != nil:
+			return errMsg{err}
+		case clicked:
+			return desktopClickMsg{n: n, note: *note}
+		}
+		return nil
+	}
+}
+
+// showDesktopNote shows note, reporting whether it was clicked. The text
+// goes in through the environment, so nothing in it needs quoting.
+func showDesktopNote(note desktopNote) (bool, error) {
+	env := append(os.Environ(), "GOCHAT_TITLE="+note.title, "GOCHAT_BODY="+note.body)
+	switch desktopNotifier() {
+	case "osascript":
+		cmd := exec.Command("osascript", "-e", `display notification (system attribute "GOCHAT_BODY") with title (system attribute "GOCHAT_TITLE")`)
+		cmd.Env = env
+		return false, cmd.Run()
+	case "powershell":
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+		cmd.Env = env
+		return false, cmd.Run()
+	case "notify-send":
+		ctx, cancel := context.WithTimeout(context.Background(), desktopClickWait)
+		defer cancel()
+		var stderr strings.Builder
+		cmd := exec.CommandContext(ctx, "notify-send", "--app-name=gochat", "--action=default=Show", "--wait", "--", note.title, note.body)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil && strings.Contains(stderr.String(), "--action") {
+			// Older than 0.7.9, and no clicking
+			return false, exec.Command("notify-send", "--app-name=gochat", "--", note.title, note.body).Run()
+		}
+		if ctx.Err() != nil {
+			return false, nil
+		}
+		return strings.TrimSpace(string(out)) == "default", err
+	}
+	return false, nil
+}
+
+// windowsToast shows $env:GOCHAT_TITLE and $env:GOCHAT_BODY as a toast.
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:GOCHAT_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:GOCHAT_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('gochat').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+// openDesktopNote shows the message a clicked notification was about.
+func (m *model) openDesktopNote(click desktopClickMsg) {
+	if i := slices.Index(m.networks, click.n); i >= 0 {
+		m.switchNetwork(i)
+	}
+	if !m.jumpToMessage(click.note.channel, click.note.id) && m.channelByName(click.note.channel) != nil {
+		m.switchToBuffer(click.note.channel)
+	}
+}
+
+func cmdDesktop(m *model, args string) tea.Cmd {
+	args = strings.TrimSpace(args)
+	switch args {
+	case "":
+	case "on":
+		m.settings.DesktopNotify = true
+	case "off":
+		m.settings.DesktopNotify = false
+	case "auto":
+		m.settings.DesktopNotify, m.settings.DesktopVia = true, desktopAuto
+	case desktopOSC, desktopNative:
+		m.settings.DesktopNotify, m.settings.DesktopVia = true, args
+	default:
+		m.notice("Usage: /desktop [on|off|osc|native|auto]")
+		return nil
+	}
+	state := "off"
+	switch {
+	case !m.settings.DesktopNotify:
+	case useOSCNotify(m.settings.DesktopVia):
+		state = "on, through the terminal (OSC " + oscNotifyCode() + ")"
+	case desktopNotifier() == "":
+		state = "on, once notify-send is installed"
+	default:
+		state = "on, through " + desktopNotifier()
+	}
+	if args == "" {
+		m.notice("Desktop notifications are " + state)
+		return nil
+	}
+	m.notice("Desktop notifications " + state)
+	return saveSettingsCmd(m.settings)
+}
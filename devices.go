@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Every login is a device, which keeps its ID while its tokens are
+// refreshed (see tokens.go), along with what the client said it is and
+// the address it last connected from. /devices lists the devices of the
+// account with when they were last active, and revokes one: its tokens
+// stop working and its connections, on every instance, are closed, so it
+// has to log in again.
+
+// maxClientLength caps what a client may call itself.
+const maxClientLength = 64
+
+var (
+	errNoSuchDevice  = errors.New("no such device")
+	errRevokeCurrent = errors.New("that is the device you are using")
+)
+
+// --- Server side ---
+
+// addr is the IP address the client of s connects from.
+func (s *session) addr() string {
+	if s.conn == nil || s.conn.conn == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(s.conn.conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// deviceLocked returns the newest token of one of nick's devices.
+func (srv *server) deviceLocked(nick, device string) (refreshToken, bool) {
+	var newest refreshToken
+	found := false
+	for _, rt := range srv.refreshTokens {
+		if device != "" && rt.Nick == nick && rt.Device == device && (!found || rt.Created.After(newest.Created)) {
+			newest, found = rt, true
+		}
+	}
+	return newest, found
+}
+
+// devicesLocked lists the devices of s.nick, the one s is on first, then
+// the most recently active.
+func (srv *server) devicesLocked(s *session) deviceList {
+	online := make(map[string]bool)
+	for sess := range srv.sessions {
+		if sess.nick == s.nick {
+			online[sess.device] = true
+		}
+	}
+	byID := make(map[string]deviceInfo)
+	for _, rt := range srv.refreshTokens {
+		if rt.Nick != s.nick || rt.Device == "" {
+			continue
+		}
+		if d, ok := byID[rt.Device]; ok && !rt.Created.After(d.Active) {
+			continue
+		}
+		byID[rt.Device] = deviceInfo{ID: rt.Device, Client: rt.Client, Addr: rt.Addr, Started: rt.Started, Active: rt.Created,
+			Current: rt.Device == s.device, Online: online[rt.Device]}
+	}
+	list := deviceList{Devices: []deviceInfo{}}
+	for _, d := range byID {
+		list.Devices = append(list.Devices, d)
+	}
+	slices.SortFunc(list.Devices, func(a, b deviceInfo) int {
+		switch {
+		case a.Current != b.Current:
+			if a.Current {
+				return -1
+			}
+			return 1
+		case a.Online != b.Online:
+			if a.Online {
+				return -1
+			}
+			return 1
+		}
+		return b.Active.Compare(a.Active)
+	})
+	return list
+}
+
+func (srv *server) handleDevices(s *session, f frame) error {
+	srv.mu.Lock()
+	list := srv.devicesLocked(s)
+	srv.mu.Unlock()
+	s.reply(f, newFrame(frameDeviceList, list))
+	return nil
+}
+
+// handleRevokeDevice logs one of the caller's other devices out, and
+// replies with what is left.
+func (srv *server) handleRevokeDevice(s *session, f frame) error {
+	var req deviceRef
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if req.ID == s.device {
+		return errRevokeCurrent
+	}
+
+	srv.mu.Lock()
+	revoked := false
+	for _, rt := range srv.refreshTokens {
+		if rt.Nick == s.nick && rt.Device == req.ID && req.ID != "" {
+			srv.revokeLocked(s, rt)
+			revoked = true
+		}
+	}
+	if !revoked {
+		srv.mu.Unlock()
+		return errNoSuchDevice
+	}
+	kicked := srv.deviceSessionsLocked(s.nick, req.ID)
+	list := srv.devicesLocked(s)
+	srv.mu.Unlock()
+
+	srv.publish(clusterEvent{Kind: clusterDeviceRevoked, Nick: s.nick, Token: &refreshToken{Nick: s.nick, Device: req.ID}})
+	kickDevice(kicked)
+	s.reply(f, newFrame(frameDeviceList, list))
+	return nil
+}
+
+// deviceSessionsLocked are the sessions connected here from a device.
+func (srv *server) deviceSessionsLocked(nick, device string) []*session {
+	var found []*session
+	for sess := range srv.sessions {
+		if sess.nick == nick && sess.device == device {
+			found = append(found, sess)
+		}
+	}
+	return found
+}
+
+// kickDevice disconnects the sessions of a revoked device.
+func kickDevice(sessions []*session) {
+	for _, sess := range sessions {
+		sess.conn.write(frame{Type: frameError, Error: "This device was logged out from another one"})
+		sess.conn.close()
+	}
+}
+
+// --- Client side ---
+
+// clientName is what the client calls itself in the device list.
+func clientName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "gochat (" + runtime.GOOS + ")"
+	}
+	return fmt.Sprintf("gochat on %s (%s)", host, runtime.GOOS)
+}
+
+// revokeDeviceMsg asks to log a device out.
+type revokeDeviceMsg struct{ id string }
+
+func cmdDevices(m *model, _ string) tea.Cmd {
+	return m.request(frameDevices, nil)
+}
+
+// showDevices opens the device list, or refreshes it if it is open.
+func (m *model) showDevices(list deviceList) {
+	if d, ok := m.overlay.(*deviceManager); ok {
+		d.devices = list.Devices
+		d.cursor = min(d.cursor, max(len(d.devices)-1, 0))
+		return
+	}
+	m.overlay = &deviceManager{devices: list.Devices}
+}
+
+// deviceManager is the overlay listing the devices logged in to the
+// account.
+type deviceManager struct {
+	devices []deviceInfo
+	cursor  int
+}
+
+func (d *deviceManager) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return d, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		d.cursor = max(d.cursor-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		d.cursor = min(d.cursor+1, max(len(d.devices)-1, 0))
+	case key.Matches(keyMsg, keys.Remove):
+		if len(d.devices) == 0 || d.devices[d.cursor].Current {
+			return d, nil
+		}
+		revoke := revokeDeviceMsg{id: d.devices[d.cursor].ID}
+		return d, func() tea.Msg { return revoke }
+	}
+	return d, nil
+}
+
+// lastActive says when a device was last active, roughly.
+func lastActive(dev deviceInfo) string {
+	switch since := time.Since(dev.Active); {
+	case dev.Current:
+		return "this device"
+	case dev.Online:
+		return "online now"
+	case since < time.Hour:
+		return "active in the last hour"
+	case since < 24*time.Hour:
+		return fmt.Sprintf("active %dh ago", int(since.Hours()))
+	default:
+		return "active " + dev.Active.Local().Format("2 Jan")
+	}
+}
+
+func (d *deviceManager) View(width, height int) string {
+	w := min(64, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render("Devices logged in to your account"), ""}
+	if len(d.devices) == 0 {
+		lines = append(lines, overlayHintStyle.Render("None"))
+	}
+	for i, dev := range d.devices {
+		name := dev.Client
+		if name == "" {
+			name = "Unknown client"
+		}
+		status := lastActive(dev)
+		detail := "logged in " + dev.Started.Local().Format("2 Jan 2006")
+		if dev.Addr != "" {
+			detail += " from " + dev.Addr
+		}
+		row := lipgloss.NewStyle().Width(inner-lipgloss.Width(status)).Render(truncate(name, inner-lipgloss.Width(status)-1)) + status
+		if i == d.cursor {
+			row = overlaySelectedStyle.Width(inner).Render(row)
+		}
+		lines = append(lines, row, overlayHintStyle.Render("  "+truncate(detail, inner-2)))
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ device • x log out • esc close"))
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
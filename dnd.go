@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// /dnd turns on do not disturb, on this machine: no sounds and no desktop
+// notifications, until /dnd off, or for a while with /dnd 1h. quiet_hours
+// in the config file does the same every day, between the times each of
+// its ranges gives, across midnight if the end comes first:
+//
+//	quiet_hours = ["22:00-07:30", "12:00-13:00"]
+//
+// Either way badges still count up and mentions still go to the activity
+// center, for later, and {dnd} in the status line says it is on.
+
+// quietRange is a daily range of quiet hours, in minutes after midnight.
+type quietRange struct{ start, end int }
+
+// parseQuietHours parses quiet_hours.
+func parseQuietHours(list []string) ([]quietRange, error) {
+	var ranges []quietRange
+	for _, entry := range list {
+		from, to, ok := strings.Cut(entry, "-")
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil || start == end {
+			return nil, fmt.Errorf("quiet_hours: %q isn't a range of times like 22:00-07:30", entry)
+		}
+		ranges = append(ranges, quietRange{start, end})
+	}
+	return ranges, nil
+}
+
+// parseClock parses 15:04 as minutes after midnight, 24:00 being the end
+// of the day.
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t falls in r.
+func (r quietRange) contains(t time.Time) bool {
+	now := t.Hour()*60 + t.Minute()
+	if r.start < r.end {
+		return now >= r.start && now < r.end
+	}
+	return now >= r.start || now < r.end
+}
+
+// dndOn reports whether /dnd is on at t.
+func (m *model) dndOn(t time.Time) bool {
+	return m.settings.DND && (m.settings.DNDUntil.IsZero() || t.Before(m.settings.DNDUntil))
+}
+
+// quietHours reports whether t is in quiet hours.
+func (m *model) quietHours(t time.Time) bool {
+	for _, r := range m.opts.quietHours {
+		if r.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// quiet reports whether sounds and notifications are held back now.
+func (m *model) quiet() bool {
+	now := time.Now()
+	return m.dndOn(now) || m.quietHours(now)
+}
+
+// dndEndMsg is due when /dnd for a while runs out.
+type dndEndMsg struct{}
+
+// endDND turns /dnd off once it ran out.
+func (m *model) endDND() tea.Cmd {
+	if !m.settings.DND || m.dndOn(time.Now()) {
+		return nil
+	}
+	m.settings.DND, m.settings.DNDUntil = false, time.Time{}
+	m.notice("Do not disturb is over")
+	return saveSettingsCmd(m.settings)
+}
+
+// clockLabel is t as a time of day, with the date unless it is today.
+func clockLabel(t time.Time) string {
+	t = t.Local()
+	if now := time.Now(); t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return t.Format("15:04")
+	}
+	return t.Format("2 Jan 15:04")
+}
+
+func segmentDND(m *model) string {
+	now := time.Now()
+	switch {
+	case m.dndOn(now) && !m.settings.DNDUntil.IsZero():
+		return "DND until " + clockLabel(m.settings.DNDUntil)
+	case m.dndOn(now):
+		return "DND"
+	case m.quietHours(now):
+		return "quiet hours"
+	case m.settings.NotifyMode == modeNothing:
+		return "notifications off"
+	case m.settings.NotifyMode != modeAll:
+		return m.settings.NotifyMode.label()
+	}
+	return ""
+}
+
+func cmdDND(m *model, args string) tea.Cmd {
+	args = strings.TrimSpace(args)
+	var tick tea.Cmd
+	switch args {
+	case "":
+		if state := segmentDND(m); state != "" {
+			m.notice("Do not disturb: " + state)
+		} else {
+			m.notice("Do not disturb is off, /dnd on or /dnd 1h turns it on")
+		}
+		return nil
+	case "on":
+		m.settings.DND, m.settings.DNDUntil = true, time.Time{}
+		m.notice("Do not disturb is on, until /dnd off")
+	case "off":
+		m.settings.DND, m.settings.DNDUntil = false, time.Time{}
+		m.notice("Do not disturb is off")
+	default:
+		d, ok := parseModDuration(args)
+		if !ok {
+			m.notice("Usage: /dnd [on|off|duration], the duration like 30m, 2h or 1d")
+			return nil
+		}
+		m.settings.DND, m.settings.DNDUntil = true, time.Now().Add(d)
+		m.notice("Do not disturb until " + clockLabel(m.settings.DNDUntil))
+		tick = tea.Tick(d, func(time.Time) tea.Msg { return dndEndMsg{} })
+	}
+	return tea.Batch(saveSettingsCmd(m.settings), tick)
+}
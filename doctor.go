@@ -0,0 +1,348 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/muesli/termenv"
+	"github.com/spf13/cobra"
+)
+
+// "gochat doctor" looks over what the client would start with and says
+// what is wrong and what to do about it: whether the config file parses
+// and its theme, keys, looks and password references all work, what the
+// terminal can do (colors, icons, a graphics protocol, a clipboard to copy
+// to), and whether each server can be reached and logged in to. Logging
+// in needs a password, from the config file or GOCHAT_PASSWORD; without
+// one only reaching the server is checked. It exits nonzero if a check
+// failed, warnings being things that work, only not as well as they could.
+
+// doctor writes out the results of its checks.
+type doctor struct {
+	w      io.Writer
+	failed int
+}
+
+// check results, as they are shown
+const (
+	checkOK   = "ok  "
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+// section starts the results about what.
+func (d *doctor) section(what string) {
+	fmt.Fprintln(d.w, what)
+}
+
+// report writes out one result, with a hint of what to do about it.
+func (d *doctor) report(result, what, hint string) {
+	if result == checkFail {
+		d.failed++
+	}
+	fmt.Fprintf(d.w, "  %s  %s\n", result, what)
+	if hint != "" {
+		fmt.Fprintf(d.w, "        %s\n", hint)
+	}
+}
+
+// checkConfig checks the config file at path, or the one found, with
+// profile name, returning it and whether it could be read.
+func (d *doctor) checkConfig(path, name string) (config, bool) {
+	path = findConfig(path)
+	if path == "" {
+		d.section("Config file")
+		d.report(checkWarn, "none found", "gochat connect, with no servers to connect to, walks you through making one")
+		return config{}, true
+	}
+	d.section("Config file " + path)
+	c, err := readConfig(path)
+	if err != nil {
+		d.report(checkFail, "doesn't parse: "+err.Error(), "fix the setting it names, the rest of the file isn't checked until then")
+		return config{}, false
+	}
+	d.report(checkOK, "parses", "")
+	// Every profile's looks, whichever is used now
+	for _, p := range append([]string{""}, slices.Sorted(maps.Keys(c.Profiles))...) {
+		pc, _ := c.withProfile(p)
+		what := "theme, keys and [ui] settings"
+		if p != "" {
+			what = "profile " + p + "'s theme"
+		}
+		if err := pc.applyLooks(); err != nil {
+			d.report(checkFail, what+": "+err.Error(), "")
+		} else if p == "" {
+			d.report(checkOK, what, "")
+		}
+	}
+	if err := c.Sounds.check(); err != nil {
+		d.report(checkFail, err.Error(), "")
+	}
+	if _, err := parseHighlights(c.Highlight); err != nil {
+		d.report(checkFail, err.Error(), "")
+	}
+	if _, err := parseQuietHours(c.QuietHours); err != nil {
+		d.report(checkFail, err.Error(), "")
+	}
+	if c, err = c.withProfile(name); err != nil {
+		d.report(checkFail, err.Error(), "the profiles are "+strings.Join(slices.Sorted(maps.Keys(c.Profiles)), ", "))
+		return c, true
+	}
+	for _, cs := range c.Servers {
+		if _, err := cs.settings(); err != nil {
+			d.report(checkFail, err.Error(), passwordHint(err))
+		} else if name, ok := strings.CutPrefix(cs.Password, "env:"); ok && os.Getenv(name) == "" {
+			d.report(checkWarn, "password for "+cs.Addr+": $"+name+" isn't set", "set it, or the password is asked for at login")
+		}
+	}
+	return c, true
+}
+
+// passwordHint says what to do about a server in the config file that
+// can't be used.
+func passwordHint(err error) string {
+	var exit *exec.ExitError
+	switch {
+	case errors.Is(err, errPlainPassword):
+		return `put the password in the keychain or a password manager, or in $GOCHAT_PASSWORD as password = "env:GOCHAT_PASSWORD"`
+	case errors.As(err, &exit):
+		return "run the command by hand to see why it fails"
+	case strings.Contains(err.Error(), "keychain"):
+		return "add it to the system keychain under that item, for gochat, or refer to it another way"
+	case strings.Contains(err.Error(), "no addr"):
+		return `give it an addr, as addr = "chat.example.com:6697"`
+	}
+	return ""
+}
+
+// checkTerminal checks what the terminal gochat runs in can do, going by
+// its environment.
+func (d *doctor) checkTerminal(c config) {
+	d.section("Terminal " + cmp.Or(os.Getenv("TERM_PROGRAM"), os.Getenv("TERM"), "(TERM isn't set)"))
+
+	profile := termenv.NewOutput(os.Stdout, termenv.WithTTY(true)).EnvColorProfile()
+	switch colors := cmp.Or(c.UI.Colors, "auto"); {
+	case colors != "auto":
+		d.report(checkOK, "colors: "+colors+", as [ui] colors says", "")
+	case profile == termenv.TrueColor:
+		d.report(checkOK, "colors: truecolor", "")
+	case profile == termenv.Ascii && os.Getenv("NO_COLOR") != "":
+		d.report(checkOK, "colors: none, as NO_COLOR asks", "")
+	case profile == termenv.Ascii:
+		d.report(checkWarn, "colors: none", `set TERM to your terminal's, such as xterm-256color, or [ui] colors = "16"`)
+	default:
+		d.report(checkWarn, "colors: "+colorName(profile)+", themes are cut down to fit",
+			`if the terminal shows 24-bit color, set COLORTERM=truecolor or [ui] colors = "truecolor"`)
+	}
+
+	switch {
+	case c.UI.Icons == "nerd" || c.UI.Icons == "ascii":
+		d.report(checkOK, "icons: "+c.UI.Icons+", as [ui] icons says", "")
+	case nerdFontLikely():
+		d.report(checkOK, "icons: Nerd Font", `if they show as boxes, use a Nerd Font or set [ui] icons = "ascii"`)
+	default:
+		d.report(checkWarn, "icons: ASCII, the locale not being UTF-8", `set LANG to a UTF-8 one, such as en_US.UTF-8, or [ui] icons = "nerd" if the font has them`)
+	}
+
+	if protocol := graphicsProtocol(); protocol != "" {
+		d.report(checkOK, "graphics: "+protocol, "")
+	} else {
+		d.report(checkWarn, "graphics: no image protocol found", "kitty, WezTerm, Ghostty and iTerm2 can show images, most other terminals can't")
+	}
+
+	switch {
+	case useOSCNotify(desktopAuto) && os.Getenv("TMUX") != "":
+		d.report(checkOK, "desktop notifications: through the terminal, OSC "+oscNotifyCode(), "tmux passes them on with set -g allow-passthrough on")
+	case useOSCNotify(desktopAuto):
+		d.report(checkOK, "desktop notifications: through the terminal, OSC "+oscNotifyCode(), "if none show, the terminal doesn't take them: /desktop native, with notify-send installed")
+	default:
+		d.report(checkOK, "desktop notifications: "+desktopNotifier(), "")
+	}
+
+	if tool := clipboardTool(); tool != "" {
+		d.report(checkOK, "clipboard: "+tool, "")
+	} else {
+		d.report(checkWarn, "clipboard: nothing to copy with found", "install wl-clipboard, xclip or xsel, or use a terminal that takes OSC 52 copies")
+	}
+
+	if tool := idleTool(); tool != "" {
+		d.report(checkOK, "system idle time: "+tool, "")
+	} else {
+		d.report(checkOK, "system idle time: can't be read here", "/autoaway system goes by keys pressed in gochat instead; on X11 install xprintidle")
+	}
+}
+
+// colorName is how checkTerminal names a color profile.
+func colorName(p termenv.Profile) string {
+	switch p {
+	case termenv.ANSI256:
+		return "256"
+	case termenv.ANSI:
+		return "16"
+	}
+	return "truecolor"
+}
+
+// graphicsProtocol is the image protocol the terminal takes, as far as
+// its environment tells, or empty.
+func graphicsProtocol() string {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || program == "ghostty" || term == "xterm-ghostty":
+		return "kitty"
+	case program == "WezTerm":
+		return "kitty and iTerm2"
+	case program == "iTerm.app":
+		return "iTerm2"
+	case strings.HasPrefix(term, "foot") || strings.Contains(term, "mlterm") || strings.Contains(term, "sixel"):
+		return "sixel"
+	}
+	return ""
+}
+
+// clipboardTool is what there is to copy to the clipboard with, or empty.
+func clipboardTool() string {
+	var tools []string
+	switch {
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		tools = []string{"wl-copy"}
+	case os.Getenv("DISPLAY") != "":
+		tools = []string{"xclip", "xsel"}
+	}
+	tools = append(tools, "pbcopy", "clip.exe")
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool
+		}
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "ghostty", "vscode", "tmux":
+		return "OSC 52"
+	}
+	if os.Getenv("KITTY_WINDOW_ID") != "" || strings.HasPrefix(os.Getenv("TERM"), "foot") {
+		return "OSC 52"
+	}
+	return ""
+}
+
+// checkServers checks that each of c's servers, or else settings.json's,
+// can be reached and logged in to.
+func (d *doctor) checkServers(c config) {
+	d.section("Servers")
+	var servers []serverSettings
+	for _, cs := range c.Servers {
+		// Those that can't be used were reported with the config file
+		if srv, err := cs.settings(); err == nil {
+			servers = append(servers, srv)
+		}
+	}
+	if len(c.Servers) == 0 {
+		st, _ := loadSettings()
+		servers = st.Servers
+	}
+	if len(servers) == 0 {
+		d.report(checkWarn, "none configured", "add a [[servers]] to the config file, or give gochat connect an address")
+		return
+	}
+	for _, srv := range servers {
+		d.checkServer(srv, cmp.Or(srv.nick, c.Nick, os.Getenv("USER")))
+	}
+}
+
+// checkServer connects to srv and logs in as nick, if there is a password
+// to do it with.
+func (d *doctor) checkServer(srv serverSettings, nick string) {
+	name := srv.Addr
+	if srv.Name != "" {
+		name = srv.Name + " (" + srv.Addr + ")"
+	}
+	if err := checkPins(srv.Pins); err != nil {
+		d.report(checkFail, name+": "+err.Error(), "copy the pin again from the server's admin")
+		return
+	}
+	nc, err := dialServer(srv)
+	if err != nil {
+		d.report(checkFail, name+": can't connect: "+err.Error(), dialHint(srv, err))
+		return
+	}
+	over := "plain TCP"
+	if srv.usesTLS() {
+		over = "TLS"
+	}
+	password := cmp.Or(srv.password, envPassword())
+	if password == "" {
+		nc.Close()
+		d.report(checkOK, name+": reachable over "+over, "logging in not checked, there being no password to do it with (set GOCHAT_PASSWORD)")
+		return
+	}
+	conn := newFrameConn(nc)
+	defer conn.close()
+	if err := conn.write(newFrame(frameHello, helloData{Nick: nick, Password: password, Client: clientName()})); err != nil {
+		d.report(checkFail, name+": reachable over "+over+", but the handshake failed: "+err.Error(), "")
+		return
+	}
+	switch r, err := conn.read(); {
+	case err != nil:
+		d.report(checkFail, name+": reachable over "+over+", but the handshake failed: "+err.Error(), "is it a gochat server, and does it take "+over+"?")
+	case r.Type == frameLoginFailed:
+		d.report(checkFail, name+": can't log in as "+nick+": "+r.Error, "check the nick and the password it refers to")
+	case r.Type != frameWelcome:
+		d.report(checkFail, name+": reachable over "+over+", but the handshake failed: "+cmp.Or(r.Error, r.Type), "")
+	default:
+		d.report(checkOK, name+": logged in as "+nick+" over "+over, "")
+	}
+}
+
+// dialHint says what to do about not reaching srv.
+func dialHint(srv serverSettings, err error) string {
+	var pinErr *certPinError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &pinErr):
+		return "ask the server's admin whether its certificate changed, then update pins in the config file"
+	case errors.As(err, &dnsErr):
+		return "check the address, the host's name doesn't resolve"
+	case srv.usesTLS() && strings.Contains(err.Error(), "first record does not look like a TLS handshake"):
+		return "the server doesn't take TLS there, drop tls = true or the tls:// prefix"
+	case strings.Contains(err.Error(), "certificate"):
+		return "the certificate isn't trusted: pin it with pins = [...] in the config file, or have it signed by a CA"
+	case errors.As(err, &opErr) && opErr.Timeout():
+		return "nothing answered in time: check the address and port, and that no firewall is in the way"
+	}
+	return "check that the server is running and the address and port are right"
+}
+
+// doctorCommand is "gochat doctor".
+func doctorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor [profile]",
+		Short: "Check the config file, the terminal and the servers, and say what to fix",
+		Args:  cobra.MaximumNArgs(1),
+	}
+	configFile := cmd.Flags().String("config", "", "check the config `file` (default config.toml in gochat's config directory)")
+	cmd.RunE = func(_ *cobra.Command, args []string) error {
+		d := &doctor{w: os.Stdout}
+		c, ok := d.checkConfig(*configFile, strings.Join(args, ""))
+		d.checkTerminal(c)
+		if ok {
+			d.checkServers(c)
+		}
+		switch d.failed {
+		case 0:
+			return nil
+		case 1:
+			return errors.New("a check failed")
+		}
+		return fmt.Errorf("%d checks failed", d.failed)
+	}
+	return cmd
+}
@@ -0,0 +1,43 @@
+package main
+
+// maxDraftLength bounds a synced draft, longer ones are kept whole locally.
+const maxDraftLength = 4000
+
+// stashDraft keeps what is in the composer as the draft of buffer name.
+func (m *model) stashDraft(name string) {
+	acct := m.account()
+	text := m.messageInput.Value()
+	if text == "" {
+		delete(acct.Drafts, name)
+		return
+	}
+	if acct.Drafts == nil {
+		acct.Drafts = make(map[string]string)
+	}
+	acct.Drafts[name] = text
+}
+
+// applySyncedDrafts takes drafts from another device, restoring the open
+// buffer's one unless something is being typed here.
+func (m *model) applySyncedDrafts(drafts map[string]string) {
+	m.account().Drafts = drafts
+	if ch := m.activeChannel(); ch != nil && m.messageInput.Value() == "" {
+		m.restoreDraft(ch.name)
+	}
+}
+
+// restoreDraft puts buffer name's draft, if any, back in the composer.
+func (m *model) restoreDraft(name string) {
+	m.messageInput.SetValue(m.account().Drafts[name])
+}
+
+// syncedDrafts are the drafts short enough to send to the server.
+func (m *model) syncedDrafts() map[string]string {
+	drafts := make(map[string]string)
+	for name, text := range m.account().Drafts {
+		if len(text) <= maxDraftLength {
+			drafts[name] = text
+		}
+	}
+	return drafts
+}
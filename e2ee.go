@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Direct messages are end-to-end encrypted with NaCl box (X25519 and
+// XSalsa20-Poly1305). Each client makes a key pair per server and nick,
+// keeps it in the local store and publishes the public half; the server
+// hands peers' keys out but never sees a private one. A DM is sealed with
+// the sender's private key and the peer's public key, so either side can
+// open it again later, and the server stores and replays only the sealed
+// text, in place of the text:
+//
+//	e2e1:<base64 of sender key | recipient key | nonce | box>
+//
+// The key the server says a peer has is pinned, and their DMs are sealed
+// to it. Another one it says they have now replaces it with a warning in
+// the DM, and for a verified peer nothing more is sealed until they are
+// verified again. A message sealed with a key they were never seen with
+// gets a warning too, but doesn't change what we seal to. A peer that has never published a key gets plain DMs, and the buffer
+// shows no lock; once it has a pinned key nothing is sent to it in the
+// clear again. Edits are sealed the same way. Reactions, and who talks to
+// whom and when, are not.
+//
+// Keys belong to one client: messages sealed for another one, such as
+// those sent to or from the same nick on another machine, can't be opened.
+
+const e2ePrefix = "e2e1:"
+
+var errBadKey = errors.New("encryption keys are 32 bytes, base64")
+
+// --- Server side ---
+
+// handleSetKey publishes the caller's public key, and signing key if they
+// have one, and tells the people they have DMs with, and about a new
+// signing key the ones they share a channel with.
+func (srv *server) handleSetKey(s *session, f frame) error {
+	var req e2eKeyData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if _, ok := parseKey(req.Key); !ok {
+		return errBadKey
+	}
+	if _, ok := parseKey(req.Signing); !ok && req.Signing != "" {
+		return errBadKey
+	}
+
+	srv.mu.Lock()
+	if srv.e2eKeys[s.nick] == req.Key && srv.signingKeys[s.nick] == req.Signing {
+		srv.mu.Unlock()
+		return nil
+	}
+	err := s.persistLocked(func(st serverStore) error {
+		if err := st.saveE2EKey(s.nick, req.Key); err != nil {
+			return err
+		}
+		return st.saveSigningKey(s.nick, req.Signing)
+	})
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	resigned := srv.signingKeys[s.nick] != req.Signing
+	srv.e2eKeys[s.nick] = req.Key
+	if req.Signing != "" {
+		srv.signingKeys[s.nick] = req.Signing
+	} else {
+		delete(srv.signingKeys, s.nick)
+	}
+	peers := make(map[string]bool)
+	for conv := range srv.dms {
+		if buffer, ok := bufferFor(conv, s.nick); ok {
+			peers[strings.TrimPrefix(buffer, "@")] = true
+		}
+	}
+	var tell []*session
+	for sess := range srv.sessions {
+		if peers[sess.nick] || resigned && sess.nick != s.nick && srv.sharesChannelLocked(s.nick, sess.nick) {
+			tell = append(tell, sess)
+		}
+	}
+	srv.mu.Unlock()
+
+	update := newFrame(frameKey, e2eKeyData{Nick: s.nick, Key: req.Key, Signing: req.Signing})
+	for _, sess := range tell {
+		sess.conn.write(update)
+	}
+	return nil
+}
+
+// handleGetKey replies with the public keys of a nick, empty if they have
+// none.
+func (srv *server) handleGetKey(s *session, f frame) error {
+	var req e2eKeyData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	req.Key, req.Signing = srv.e2eKeys[req.Nick], srv.signingKeys[req.Nick]
+	srv.mu.Unlock()
+	s.reply(f, newFrame(frameKey, req))
+	return nil
+}
+
+// --- Client side ---
+
+// keyPair is one of our keys.
+type keyPair struct {
+	public, private *[32]byte
+}
+
+// e2eState is what a network encrypts DMs with.
+type e2eState struct {
+	own   map[string][]keyPair // ours, by nick, newest last
+	peers map[string]*[32]byte // pinned, by nick
+	// trusted are every key a peer has been pinned to, to whose nick,
+	// learned those of them only seen sealing a message, and verified the
+	// ones compared in person (see keyverify.go)
+	trusted  map[[32]byte]string
+	learned  map[[32]byte]bool
+	verified map[[32]byte]bool
+	// asked are the peers whose key was asked for on this connection,
+	// known the ones the server answered for, and lookups those to ask for
+	// after sealing a message with a key we didn't have for them
+	asked, known map[string]bool
+	lookups      []string
+}
+
+func newE2EState() *e2eState {
+	return &e2eState{
+		own:      make(map[string][]keyPair),
+		peers:    make(map[string]*[32]byte),
+		trusted:  make(map[[32]byte]string),
+		learned:  make(map[[32]byte]bool),
+		verified: make(map[[32]byte]bool),
+		asked:    make(map[string]bool),
+		known:    make(map[string]bool),
+	}
+}
+
+// storedKey is an encryption key kept in the store: one of ours, with its
+// private half, or the one a peer is pinned to.
+type storedKey struct {
+	nick     string
+	public   string
+	private  string // empty for a peer's
+	added    time.Time
+	verified bool // a peer's, see keyverify.go
+}
+
+func encodeKey(k *[32]byte) string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+func parseKey(s string) (*[32]byte, bool) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(raw) != 32 {
+		return nil, false
+	}
+	return (*[32]byte)(raw), true
+}
+
+// loadKeys picks up the stored keys of every network.
+func (m *model) loadKeys() {
+	for _, n := range m.networks {
+		n.e2e = newE2EState()
+		if m.store == nil {
+			continue
+		}
+		keys, err := m.store.loadKeys(n.addr)
+		if err != nil {
+			m.lastErr = err
+			continue
+		}
+		for _, k := range keys {
+			public, ok := parseKey(k.public)
+			if !ok {
+				continue
+			}
+			if k.private == "" {
+				n.e2e.peers[k.nick] = public
+				n.e2e.trusted[*public] = k.nick
+				n.e2e.verified[*public] = k.verified
+			} else if private, ok := parseKey(k.private); ok {
+				n.e2e.own[k.nick] = append(n.e2e.own[k.nick], keyPair{public: public, private: private})
+			}
+		}
+	}
+}
+
+// e2e is the encryption state of the network the model has the state of,
+// nil when there is none.
+func (m *model) e2e() *e2eState {
+	if m.client != nil && m.client.network != nil {
+		return m.client.network.e2e
+	}
+	if n := m.currentNetwork(); n != nil && !m.background {
+		return n.e2e
+	}
+	return nil
+}
+
+// saveKey keeps a key of the network of c in the store.
+func (m *model) saveKey(c *client, k storedKey) {
+	if m.store == nil || c.network == nil {
+		return
+	}
+	if err := m.store.saveKey(c.network.addr, k); err != nil {
+		m.lastErr = err
+	}
+}
+
+// publishKey makes sure we have a key for our nick on the network of c,
+// publishes it, with our signing key if we sign, and asks for the keys of
+// the peers we have DMs with.
+func (m *model) publishKey(c *client) tea.Cmd {
+	e := m.e2e()
+	if e == nil || c.guest {
+		return nil
+	}
+	clear(e.asked)
+	clear(e.known)
+	if len(e.own[m.nick]) == 0 {
+		public, private, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		e.own[m.nick] = append(e.own[m.nick], keyPair{public: public, private: private})
+		m.saveKey(c, storedKey{nick: m.nick, public: encodeKey(public), private: encodeKey(private), added: time.Now()})
+	}
+	own := e.own[m.nick]
+	_, cmd := c.send(frameSetKey, e2eKeyData{Key: encodeKey(own[len(own)-1].public), Signing: m.signingKey(c)})
+	cmds := []tea.Cmd{cmd}
+	for _, ch := range m.channels {
+		if ch.isDM() {
+			cmds = append(cmds, m.lookupKey(ch.dmPeerNick()))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// lookupKey asks the server for the key of peer, once per connection.
+func (m *model) lookupKey(peer string) tea.Cmd {
+	e := m.e2e()
+	if m.client == nil || e == nil || e.asked[peer] {
+		return nil
+	}
+	e.asked[peer] = true
+	_, cmd := m.client.send(frameGetKey, e2eKeyData{Nick: peer})
+	return cmd
+}
+
+// lookupSealers asks the server for the keys of the peers whose messages
+// were sealed before we had one for them.
+func (m *model) lookupSealers() tea.Cmd {
+	e := m.e2e()
+	if e == nil {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, peer := range e.lookups {
+		cmds = append(cmds, m.lookupKey(peer))
+	}
+	e.lookups = nil
+	return tea.Batch(cmds...)
+}
+
+// applyKey takes the key the server has for a peer, then sends what was
+// waiting on it.
+func (m *model) applyKey(k e2eKeyData) tea.Cmd {
+	e := m.e2e()
+	if e == nil || m.client == nil {
+		return nil
+	}
+	e.known[k.Nick] = true
+	m.applySigningKey(k)
+	if key, ok := parseKey(k.Key); ok {
+		m.pinKey(k.Nick, key, true)
+	}
+	if dm := m.channelByName("@" + k.Nick); dm != nil {
+		return m.resendPendingIn(dm)
+	}
+	return nil
+}
+
+// pinKey trusts key as one of peer's, warning in their DM if they had
+// another. With current set it is the key they have now, which their DMs
+// are sealed to, even if it was trusted before. Without, it was only seen
+// sealing a message, which whoever is in between could have done too, so
+// it opens their messages quietly from then on but nothing is sealed to
+// it, and it isn't stored.
+func (m *model) pinKey(peer string, key *[32]byte, current bool) {
+	e := m.e2e()
+	known := e.trusted[*key] == peer
+	if !current {
+		if known {
+			return
+		}
+		e.trusted[*key], e.learned[*key] = peer, true
+		if e.peers[peer] == nil {
+			// The server will say which key is theirs
+			e.lookups = append(e.lookups, peer)
+		} else if dm := m.channelByName("@" + peer); dm != nil {
+			m.noticeIn(dm, "⚠ A message from "+peer+" was sealed with a key they were never seen with. Your DMs are still sealed to the one they have.")
+		}
+		return
+	}
+	// One only seen sealing a message becoming theirs is still news
+	known = known && !e.learned[*key]
+	if known && *e.peers[peer] == *key {
+		return
+	}
+	delete(e.learned, *key)
+	old := e.peers[peer]
+	e.peers[peer] = key
+	e.trusted[*key] = peer
+	if m.client != nil {
+		m.saveKey(m.client, storedKey{nick: peer, public: encodeKey(key), added: time.Now()})
+	}
+	if old == nil || known {
+		return
+	}
+	dm := m.channelByName("@" + peer)
+	if dm == nil {
+		return
+	}
+	if _, changed := m.verifiedState(dm); changed {
+		warning := "⚠ " + peer + "'s encryption key changed since you verified them! Unless they have a new device, someone may be reading along. Nothing is sent to them until you /verify them again."
+		m.noticeIn(dm, warning)
+		if m.activeChannel() != dm {
+			m.notice(warning)
+		}
+		return
+	}
+	m.noticeIn(dm, "⚠ "+peer+"'s encryption key changed. They may have a new device, or someone may be in between.")
+}
+
+// encrypted is whether messages to ch are end-to-end encrypted.
+func (m *model) encrypted(ch *channel) bool {
+	e := m.e2e()
+	return ch.isDM() && e != nil && e.peers[ch.dmPeerNick()] != nil
+}
+
+// sealFor seals text for ch if it is a DM with a peer that has a key. It
+// isn't ready while the server hasn't said whether the peer has one, nor
+// while their key changed since they were verified.
+func (m *model) sealFor(ch *channel, text string) (sealed string, ready bool) {
+	e := m.e2e()
+	if !ch.isDM() || e == nil {
+		return text, true
+	}
+	peer := ch.dmPeerNick()
+	peerKey := e.peers[peer]
+	if peerKey == nil {
+		return text, e.known[peer]
+	}
+	if m.keyUnverified(ch) != "" {
+		return "", false
+	}
+	own := e.own[m.nick]
+	if len(own) == 0 {
+		return "", false
+	}
+	ours := own[len(own)-1]
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", false
+	}
+	out := make([]byte, 0, 32+32+24+len(text)+box.Overhead)
+	out = append(out, ours.public[:]...)
+	out = append(out, peerKey[:]...)
+	out = append(out, nonce[:]...)
+	out = box.Seal(out, []byte(text), &nonce, peerKey, ours.private)
+	return e2ePrefix + base64.RawStdEncoding.EncodeToString(out), true
+}
+
+// openText opens a DM from nick in buffer if it is sealed. A message
+// sealed with a key the peer was never seen with pins it.
+func (m *model) openText(buffer, nick, text string) string {
+	armored, ok := strings.CutPrefix(text, e2ePrefix)
+	if !ok || !strings.HasPrefix(buffer, "@") {
+		return text
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(armored)
+	e := m.e2e()
+	if err != nil || len(raw) < 32+32+24+box.Overhead || e == nil {
+		return "🔒 This message couldn't be decrypted"
+	}
+	from, to := (*[32]byte)(raw[:32]), (*[32]byte)(raw[32:64])
+	nonce := (*[24]byte)(raw[64:88])
+
+	var ours *keyPair
+	var other *[32]byte
+	for _, pairs := range e.own {
+		for i := range pairs {
+			switch *pairs[i].public {
+			case *from:
+				ours, other = &pairs[i], to
+			case *to:
+				ours, other = &pairs[i], from
+			}
+		}
+	}
+	if ours == nil {
+		return "🔒 This message was encrypted for another key of yours"
+	}
+	plain, ok := box.Open(nil, raw[88:], nonce, other, ours.private)
+	if !ok {
+		return "🔒 This message couldn't be decrypted"
+	}
+	if other == from && nick != m.nick {
+		m.pinKey(nick, from, false)
+	}
+	return string(plain)
+}
+
+// openMessage is w as a message, opened if it is sealed, with its
+// signature checked.
+func (m *model) openMessage(w wireMessage) message {
+	msg := w.toMessage()
+	if !msg.imported {
+		// Imported ones are never signed, and say so instead
+		msg.unverified = m.checkSignature(w.Channel, w.ID, w.Nick, w.Text, w.Sig, w.Time)
+	}
+	msg.text = m.openText(w.Channel, w.Nick, w.Text)
+	return msg
+}
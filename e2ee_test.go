@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// newE2EModel is a model logged in as nick on one network, with a key pair
+// of its own.
+func newE2EModel(t *testing.T, nick string) *model {
+	t.Helper()
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &network{e2e: newE2EState()}
+	n.e2e.own[nick] = []keyPair{{public: public, private: private}}
+	return &model{nick: nick, networks: []*network{n}}
+}
+
+// ownKey is m's current public key.
+func ownKey(m *model) *[32]byte {
+	own := m.e2e().own[m.nick]
+	return own[len(own)-1].public
+}
+
+// introduce pins each of a and b's keys for the other.
+func introduce(a, b *model) {
+	a.pinKey(b.nick, ownKey(b), true)
+	b.pinKey(a.nick, ownKey(a), true)
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	alice, bob := newE2EModel(t, "alice"), newE2EModel(t, "bob")
+	introduce(alice, bob)
+
+	const text = "meet at the usual place 🌉"
+	sealed, ready := alice.sealFor(newChannel("@bob", ""), text)
+	if !ready {
+		t.Fatal("sealFor isn't ready with bob's key pinned")
+	}
+	if !strings.HasPrefix(sealed, e2ePrefix) || strings.Contains(sealed, "usual") {
+		t.Fatalf("sealed text %q isn't sealed", sealed)
+	}
+	if got := bob.openText("@alice", "alice", sealed); got != text {
+		t.Errorf("bob opened %q, want %q", got, text)
+	}
+	// The sender can read it back too, as when their history is replayed
+	if got := alice.openText("@bob", "alice", sealed); got != text {
+		t.Errorf("alice opened her own message as %q, want %q", got, text)
+	}
+
+	again, _ := alice.sealFor(newChannel("@bob", ""), text)
+	if again == sealed {
+		t.Error("sealing the same text twice gave the same box")
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	alice, bob := newE2EModel(t, "alice"), newE2EModel(t, "bob")
+	introduce(alice, bob)
+	sealed, _ := alice.sealFor(newChannel("@bob", ""), "transfer 10 coins")
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, e2ePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reseal := func(raw []byte) string { return e2ePrefix + base64.RawStdEncoding.EncodeToString(raw) }
+	flip := func(i int) string {
+		b := append([]byte(nil), raw...)
+		b[i] ^= 1
+		return reseal(b)
+	}
+
+	for _, tc := range []struct {
+		name, sealed string
+	}{
+		{"box", flip(len(raw) - 1)},
+		{"authenticator", flip(88)},
+		{"nonce", flip(64)},
+		{"sender key", flip(0)},
+		{"truncated", reseal(raw[:88+box.Overhead-1])},
+		{"not base64", e2ePrefix + "!!!"},
+	} {
+		got := bob.openText("@alice", "alice", tc.sealed)
+		if !strings.HasPrefix(got, "🔒") {
+			t.Errorf("%s changed: opened as %q", tc.name, got)
+		}
+	}
+	if got := bob.openText("@alice", "alice", sealed); got != "transfer 10 coins" {
+		t.Errorf("the untouched message opened as %q", got)
+	}
+
+	carol := newE2EModel(t, "carol")
+	if got := carol.openText("@alice", "alice", sealed); got != "🔒 This message was encrypted for another key of yours" {
+		t.Errorf("someone else opened it as %q", got)
+	}
+}
+
+func TestSealForWithoutKey(t *testing.T) {
+	alice := newE2EModel(t, "alice")
+	dm := newChannel("@bob", "")
+
+	if _, ready := alice.sealFor(dm, "hi"); ready {
+		t.Error("sealFor is ready before the server said whether bob has a key")
+	}
+	alice.e2e().known["bob"] = true
+	if text, ready := alice.sealFor(dm, "hi"); !ready || text != "hi" {
+		t.Errorf("sealFor for a peer without a key = %q, %v, want it plain", text, ready)
+	}
+	if text, ready := alice.sealFor(newChannel("#general", ""), "hi"); !ready || text != "hi" {
+		t.Errorf("sealFor for a channel = %q, %v, want it plain", text, ready)
+	}
+	// Only DMs are ever opened
+	if got := alice.openText("#general", "bob", e2ePrefix+"AAAA"); got != e2ePrefix+"AAAA" {
+		t.Errorf("openText in a channel = %q, want it as it was", got)
+	}
+}
+
+func TestMessageKeyDoesntRedirect(t *testing.T) {
+	alice, bob := newE2EModel(t, "alice"), newE2EModel(t, "bob")
+	introduce(alice, bob)
+	// Someone in between seals a message to bob as alice, with their own key
+	mallory := newE2EModel(t, "alice")
+	mallory.pinKey("bob", ownKey(bob), true)
+	forged, _ := mallory.sealFor(newChannel("@bob", ""), "send me your password")
+
+	if got := bob.openText("@alice", "alice", forged); got != "send me your password" {
+		t.Fatalf("the forged message opened as %q", got)
+	}
+	if *bob.e2e().peers["alice"] != *ownKey(alice) {
+		t.Fatal("a key seen in a message replaced the one bob seals to")
+	}
+	sealed, _ := bob.sealFor(newChannel("@alice", ""), "no")
+	if got := alice.openText("@bob", "bob", sealed); got != "no" {
+		t.Errorf("alice opened bob's answer as %q", got)
+	}
+	if got := mallory.openText("@bob", "bob", sealed); strings.HasPrefix(got, "no") {
+		t.Error("bob's answer was sealed to the forger's key")
+	}
+
+	// The server then saying it is alice's is still news
+	bob.channels = []*channel{newChannel("@alice", "")}
+	bob.pinKey("alice", ownKey(mallory), true)
+	dm := bob.channelByName("@alice")
+	if len(dm.messages) == 0 || !strings.Contains(dm.messages[len(dm.messages)-1].text, "key changed") {
+		t.Error("no warning when a key only seen in a message became alice's")
+	}
+}
+
+func TestVerifiedKeyChangeBlocksSealing(t *testing.T) {
+	alice, bob := newE2EModel(t, "alice"), newE2EModel(t, "bob")
+	introduce(alice, bob)
+	dm := newChannel("@bob", "")
+	alice.channels = []*channel{dm}
+	alice.e2e().verified[*ownKey(bob)] = true
+
+	other := newE2EModel(t, "bob")
+	alice.pinKey("bob", ownKey(other), true)
+	if _, ready := alice.sealFor(dm, "hi"); ready {
+		t.Error("sealFor sealed to a verified peer's changed key")
+	}
+	if why := alice.postBlocked(dm); why == "" {
+		t.Error("posting isn't blocked after a verified peer's key changed")
+	}
+
+	alice.e2e().verified[*ownKey(other)] = true
+	if sealed, ready := alice.sealFor(dm, "hi"); !ready || other.openText("@alice", "alice", sealed) != "hi" {
+		t.Error("sealFor doesn't seal to the new key once it is verified")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var errEmptyEdit = errors.New("an edit can't be empty, /delete removes a message")
+
+// --- Server side ---
+
+// messageLocked finds a message in a channel the caller is in or one of
+// their DMs. It returns the conversation it is in and, for a channel, the
+// channel.
+func (srv *server) messageLocked(s *session, name, id string) (conv string, ch *serverChannel, msg wireMessage, err error) {
+	var c *conversation
+	if peer, ok := strings.CutPrefix(name, "@"); ok {
+		conv = dmKey(s.nick, peer)
+		if c = srv.dms[conv]; c == nil {
+			return "", nil, msg, errNoSuchMessage
+		}
+	} else {
+		if ch = srv.channels[name]; ch == nil {
+			return "", nil, msg, errNoSuchChannel
+		}
+		if _, member := ch.members[s.nick]; !member {
+			return "", nil, msg, errNotJoined
+		}
+		conv, c = name, &ch.conversation
+	}
+	i := c.messageIndex(id)
+	if i < 0 {
+		return "", nil, msg, errNoSuchMessage
+	}
+	return conv, ch, c.history[i], nil
+}
+
+// changeableLocked is messageLocked for a message the caller changes,
+// which archived channels don't allow. byOthers is whether it is deleted,
+// which roles with delete-others may do to others' messages, rather than
+// edited, which takes one that may post.
+func (srv *server) changeableLocked(s *session, name, id string, byOthers bool) (string, wireMessage, error) {
+	conv, ch, msg, err := srv.messageLocked(s, name, id)
+	switch {
+	case err != nil:
+		return "", msg, err
+	case ch != nil && ch.archived:
+		return "", msg, errArchived
+	case msg.Nick != s.nick && (!byOthers || ch == nil || !srv.canLocked(ch, s.nick, permDeleteOthers)):
+		return "", msg, errNotPermitted
+	case !byOthers:
+		if err := srv.mayPostLocked(s, ch); err != nil {
+			return "", msg, err
+		}
+	}
+	return conv, msg, nil
+}
+
+// tellConversation sends a frame about a channel or DM to everyone in it.
+// payload builds it for the buffer name each side knows it by.
+func (srv *server) tellConversation(s *session, name, typ string, payload func(buffer string) any) {
+	peer, ok := strings.CutPrefix(name, "@")
+	if !ok {
+		srv.broadcast(name, newFrame(typ, payload(name)))
+		return
+	}
+	srv.mu.Lock()
+	peers, own := srv.dmSessionsLocked(s.nick, peer)
+	srv.mu.Unlock()
+	for _, sess := range own {
+		sess.conn.write(newFrame(typ, payload(name)))
+	}
+	for _, sess := range peers {
+		sess.conn.write(newFrame(typ, payload("@"+s.nick)))
+	}
+}
+
+// handleEdit changes the text of one of the caller's messages.
+func (srv *server) handleEdit(s *session, f frame) error {
+	var req editData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return errEmptyEdit
+	}
+	if !validSignature(req.Sig) {
+		return errBadSignature
+	}
+
+	srv.mu.Lock()
+	conv, msg, err := srv.changeableLocked(s, req.Channel, req.ID, false)
+	if err == nil {
+		if ch, ok := srv.channels[conv]; ok {
+			text, err = srv.filterLocked(s, ch, text)
+		}
+	}
+	if err != nil || msg.Text == text {
+		srv.mu.Unlock()
+		return err
+	}
+	evs, err := srv.appendLocked(s, conv, serverEvent{Kind: eventEdit, Nick: s.nick, Time: s.stamp.Time, ID: req.ID, Text: text, Sig: req.Sig})
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	srv.tellConversation(s, req.Channel, frameEdited, func(buffer string) any {
+		return editData{Channel: buffer, ID: req.ID, Text: text, Nick: s.nick, Time: s.stamp.Time, Seq: evs[0].Seq, Sig: req.Sig}
+	})
+	return nil
+}
+
+// handleDelete removes a message. Anyone can delete their own, roles with
+// delete-others any in their channel.
+func (srv *server) handleDelete(s *session, f frame) error {
+	var req deleteData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	conv, _, err := srv.changeableLocked(s, req.Channel, req.ID, true)
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	evs, err := srv.appendLocked(s, conv, serverEvent{Kind: eventDelete, Nick: s.nick, Time: s.stamp.Time, ID: req.ID})
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	srv.tellConversation(s, req.Channel, frameDeleted, func(buffer string) any {
+		return deleteData{Channel: buffer, ID: req.ID, Nick: s.nick, Seq: evs[0].Seq}
+	})
+	return nil
+}
+
+// handleEdits replies with the versions of a message, from its log.
+func (srv *server) handleEdits(s *session, f frame) error {
+	var req editHistoryData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	conv, ch, msg, err := srv.messageLocked(s, req.Channel, req.ID)
+	if err == nil && srv.privateEdits && msg.Nick != s.nick && (ch == nil || srv.roleInLocked(ch, s.nick) < roleModerator) {
+		err = errNotPermitted
+	}
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	c, _ := srv.conversationLocked(conv)
+	for _, ev := range c.events {
+		switch {
+		case ev.Kind == eventMessage && ev.Message.ID == req.ID:
+			req.Revisions = append(req.Revisions, revision{Text: ev.Message.Text, Time: ev.Message.Time})
+		case ev.Kind == eventEdit && ev.ID == req.ID:
+			req.Revisions = append(req.Revisions, revision{Text: ev.Text, Time: ev.Time})
+		}
+	}
+	srv.mu.Unlock()
+
+	s.reply(f, newFrame(frameEditHistory, req))
+	return nil
+}
+
+// --- Client side ---
+
+// cmdEdit replaces the text of the selected or latest message of ours.
+// Without text it puts the message in the composer to be edited.
+func cmdEdit(m *model, args string) tea.Cmd {
+	ch, msg := m.ownTargetMessage()
+	if msg == nil || msg.id == "" {
+		m.notice("Nothing to edit")
+		return nil
+	}
+	if msg.nick != m.nick {
+		m.notice("You can only edit your own messages")
+		return nil
+	}
+	if !m.allowed(ch, permPost, "edit messages") {
+		return nil
+	}
+	if args == "" {
+		m.messageInput.SetValue("/edit " + msg.text)
+		m.messageInput.CursorEnd()
+		m.setFocus(focusComposer)
+		return nil
+	}
+	if why := m.keyUnverified(ch); why != "" {
+		m.notice(why)
+		return nil
+	}
+	text, ready := m.sealFor(ch, args)
+	if !ready {
+		m.notice("Still fetching " + ch.dmPeerNick() + "'s encryption key, try again in a moment")
+		return m.lookupKey(ch.dmPeerNick())
+	}
+	return m.request(frameEdit, editData{Channel: ch.name, ID: msg.id, Text: text, Sig: m.sign(ch.name, msg.id, text)})
+}
+
+// ownTargetMessage is the selected message, or else our latest one in
+// the active buffer.
+func (m *model) ownTargetMessage() (*channel, *message) {
+	if p := m.currentPane(); p != nil && p.selected >= 0 {
+		return m.targetMessage()
+	}
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil, nil
+	}
+	for i := len(ch.messages) - 1; i >= 0; i-- {
+		if msg := &ch.messages[i]; !msg.system && msg.nick == m.nick {
+			return ch, msg
+		}
+	}
+	return ch, nil
+}
+
+// cmdDelete deletes the selected message, or our latest one.
+func cmdDelete(m *model, _ string) tea.Cmd {
+	ch, msg := m.ownTargetMessage()
+	if msg == nil || msg.id == "" {
+		m.notice("Nothing to delete")
+		return nil
+	}
+	if msg.nick != m.nick && !m.allowed(ch, permDeleteOthers, "delete others' messages") {
+		return nil
+	}
+	return m.request(frameDelete, deleteData{Channel: ch.name, ID: msg.id})
+}
+
+// cmdEdits shows the earlier versions of the selected or latest message.
+func cmdEdits(m *model, _ string) tea.Cmd {
+	_, msg := m.targetMessage()
+	if msg == nil || msg.id == "" {
+		m.notice("No message to show the edits of")
+		return nil
+	}
+	if msg.edited.IsZero() {
+		m.notice("That message hasn't been edited")
+		return nil
+	}
+	return m.request(frameEdits, editHistoryData{Channel: msg.channel, ID: msg.id})
+}
+
+// editHistory is the overlay listing a message's versions, newest first.
+type editHistory struct {
+	nick      string
+	revisions []revision
+	offset    int // revisions scrolled past
+}
+
+func newEditHistory(nick string, revisions []revision) *editHistory {
+	slices.Reverse(revisions)
+	return &editHistory{nick: nick, revisions: revisions}
+}
+
+func (e *editHistory) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return e, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel), key.Matches(keyMsg, keys.Select):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		e.offset = max(e.offset-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		e.offset = min(e.offset+1, len(e.revisions)-1)
+	}
+	return e, nil
+}
+
+func (e *editHistory) View(width, height int) string {
+	w := min(70, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Edits of %s's message (%d)", e.nick, len(e.revisions)-1))}
+	used := 4
+	for i, r := range e.revisions[e.offset:] {
+		label := "edited "
+		if e.offset+i == len(e.revisions)-1 {
+			label = "original "
+		}
+		entry := lipgloss.JoinVertical(lipgloss.Left,
+			"",
+			overlayHintStyle.Render(label+r.Time.Local().Format("2 Jan 15:04")),
+			lipgloss.NewStyle().Width(inner).Render(r.Text),
+		)
+		if used += lipgloss.Height(entry); used > height-2 && i > 0 {
+			break
+		}
+		lines = append(lines, entry)
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ scroll • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// applyEdit takes an edited message's new text.
+func (m *model) applyEdit(ch *channel, e editData, unverified bool) {
+	i := ch.messageIndex(e.ID)
+	if i < 0 {
+		return
+	}
+	msg := &ch.messages[i]
+	msg.text, msg.edited, msg.unverified = e.Text, e.Time, unverified
+	for j := range ch.pins {
+		if ch.pins[j].id == e.ID {
+			ch.pins[j].text, ch.pins[j].edited = e.Text, e.Time
+		}
+	}
+	m.persist(func(s store, network string) error { return s.editMessage(network, ch.name, e.ID, e.Text, unverified) })
+	if m.search != nil {
+		if err := m.search.add(m.storeNetwork(), ch.name, []message{*msg}); err != nil {
+			m.lastErr = err
+		}
+	}
+}
+
+// applyDelete drops a deleted message from ch, the store and the index.
+func (m *model) applyDelete(ch *channel, d deleteData) {
+	i := ch.messageIndex(d.ID)
+	if i < 0 {
+		return
+	}
+	author := ch.messages[i].nick
+	ch.messages = slices.Delete(ch.messages, i, i+1)
+	ch.pins = slices.DeleteFunc(ch.pins, func(msg message) bool { return msg.id == d.ID })
+	for _, p := range m.panes {
+		if p.buffer == ch.name && p.selected >= len(ch.messages) {
+			p.selected = len(ch.messages) - 1
+		}
+	}
+	m.persist(func(s store, network string) error {
+		err := s.deleteMessages(network, ch.name, []string{d.ID})
+		if err == nil && m.search != nil {
+			err = m.search.remove([]messageRef{{network: network, channel: ch.name, id: d.ID}})
+		}
+		return err
+	})
+	// Tombstones of an erased user's messages have no nick
+	if d.Nick != "" && d.Nick != author {
+		m.noticeIn(ch, d.Nick+" deleted a message from "+author)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// Every change to a channel's or DM's messages and membership is an event
+// in that conversation's append-only log, numbered from 1. The history and
+// member list are what replaying the log gives, and that is all the store
+// keeps of them. Frames about an event carry its sequence number so
+// clients can tell when they missed some.
+
+// Kinds of serverEvent.
+const (
+	eventMessage  = "message"
+	eventEdit     = "edit"
+	eventDelete   = "delete"
+	eventReaction = "reaction"
+	eventJoin     = "join"
+	eventPart     = "part"
+)
+
+// serverEvent is one entry of a conversation's log.
+type serverEvent struct {
+	Seq  uint64    `json:"seq"`
+	Kind string    `json:"kind"`
+	Nick string    `json:"nick"` // who did it
+	Time time.Time `json:"time"`
+
+	Message *wireMessage `json:"message,omitempty"` // the new message
+	// ID is the message edited, deleted or reacted to
+	ID    string `json:"id,omitempty"`
+	Text  string `json:"text,omitempty"`  // the edited text
+	Sig   string `json:"sig,omitempty"`   // and its signature
+	Emoji string `json:"emoji,omitempty"` // the reaction, added or taken back
+	Added bool   `json:"added,omitempty"`
+	// Member joined or left with Role, Nick is who invited or removed them
+	Member string `json:"member,omitempty"`
+	Role   role   `json:"role,omitempty"`
+}
+
+// messageID is the message ev is about, if any.
+func (ev serverEvent) messageID() string {
+	if ev.Message != nil {
+		return ev.Message.ID
+	}
+	return ev.ID
+}
+
+// conversation is the log of a channel or DM and the history it adds up
+// to, oldest message first.
+type conversation struct {
+	seq     uint64 // of the newest event
+	events  []serverEvent
+	history []wireMessage
+}
+
+func (c *conversation) messageIndex(id string) int {
+	return slices.IndexFunc(c.history, func(w wireMessage) bool { return w.ID == id })
+}
+
+// apply updates the history, and the members and pins of ch for a
+// channel, for ev.
+func (c *conversation) apply(ch *serverChannel, ev serverEvent) {
+	switch ev.Kind {
+	case eventMessage:
+		w := *ev.Message
+		w.Seq = ev.Seq
+		// Imports add older messages, keep the history in time order
+		i := sort.Search(len(c.history), func(i int) bool { return c.history[i].Time.After(w.Time) })
+		c.history = slices.Insert(c.history, i, w)
+	case eventEdit:
+		if i := c.messageIndex(ev.ID); i >= 0 {
+			c.history[i].Text, c.history[i].Edited, c.history[i].Sig = ev.Text, ev.Time, ev.Sig
+		}
+		if ch != nil {
+			for i := range ch.pins {
+				if ch.pins[i].ID == ev.ID {
+					ch.pins[i].Text, ch.pins[i].Edited, ch.pins[i].Sig = ev.Text, ev.Time, ev.Sig
+				}
+			}
+		}
+	case eventDelete:
+		byID := func(w wireMessage) bool { return w.ID == ev.ID }
+		c.history = slices.DeleteFunc(c.history, byID)
+		if ch != nil {
+			ch.pins = slices.DeleteFunc(ch.pins, byID)
+		}
+	case eventReaction:
+		if i := c.messageIndex(ev.ID); i >= 0 {
+			c.history[i].setReaction(ev.Emoji, ev.Nick, ev.Added)
+		}
+	case eventJoin:
+		if ch != nil {
+			ch.members[ev.Member] = ev.Role
+		}
+	case eventPart:
+		if ch != nil {
+			delete(ch.members, ev.Member)
+		}
+	}
+}
+
+// forget drops messages from the history and the events about them from
+// the log, for retention. Membership events are kept.
+func (c *conversation) forget(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	gone := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		gone[id] = true
+	}
+	c.history = slices.DeleteFunc(c.history, func(w wireMessage) bool { return gone[w.ID] })
+	c.events = slices.DeleteFunc(c.events, func(ev serverEvent) bool { return gone[ev.messageID()] })
+}
+
+// conversationLocked returns the log of a channel, or of a DM by its
+// dmKey, and the channel if it is one.
+func (srv *server) conversationLocked(conv string) (*conversation, *serverChannel) {
+	if ch, ok := srv.channels[conv]; ok {
+		return &ch.conversation, ch
+	}
+	c, ok := srv.dms[conv]
+	if !ok {
+		c = &conversation{}
+		srv.dms[conv] = c
+	}
+	return c, nil
+}
+
+// appendLocked numbers evs as the next events of a conversation, stores
+// them and applies them. It returns them with their sequence numbers.
+func (srv *server) appendLocked(s *session, conv string, evs ...serverEvent) ([]serverEvent, error) {
+	c, ch := srv.conversationLocked(conv)
+	for i := range evs {
+		evs[i].Seq = c.seq + uint64(i) + 1
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.appendEvents(conv, evs) }); err != nil {
+		return nil, err
+	}
+	for _, ev := range evs {
+		c.seq = ev.Seq
+		c.events = append(c.events, ev)
+		c.apply(ch, ev)
+	}
+	return evs, nil
+}
+
+// replay rebuilds a conversation from its stored log.
+func (c *conversation) replay(ch *serverChannel, evs []serverEvent) {
+	for _, ev := range evs {
+		c.seq = max(c.seq, ev.Seq)
+		c.events = append(c.events, ev)
+		c.apply(ch, ev)
+	}
+}
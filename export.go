@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+)
+
+// Export formats, picked with --format or the /export argument.
+const (
+	exportMarkdown = "markdown"
+	exportHTML     = "html"
+	exportJSON     = "json"
+)
+
+// exportPage is how many messages are read from the store at a time while
+// collecting a transcript.
+const exportPage = 1000
+
+// transcript is a buffer's history ready to be written out.
+type transcript struct {
+	Network  string
+	Channel  string
+	Topic    string
+	Since    time.Time // zero for the whole history
+	Exported time.Time
+	Messages []message // oldest first
+}
+
+// exportedMessage is a message as written to a JSON export.
+type exportedMessage struct {
+	ID          string       `json:"id"`
+	Nick        string       `json:"nick"`
+	Text        string       `json:"text"`
+	Time        time.Time    `json:"time"`
+	ReplyTo     string       `json:"reply_to,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+// attachment describes a file linked from a message.
+type attachment struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+func attachments(text string) []attachment {
+	var list []attachment
+	for _, l := range messageLinks(text) {
+		if l.file {
+			list = append(list, attachment{Name: path.Base(l.url.Path), URL: l.url.String()})
+		}
+	}
+	return list
+}
+
+// collectTranscript reads a buffer's stored history from since on.
+func collectTranscript(s store, network, name string, since time.Time) ([]message, error) {
+	var msgs []message
+	// A little past now, in case of clock skew between us and the server
+	before := time.Now().AddDate(0, 0, 1)
+	for {
+		page, err := s.loadMessagesBefore(network, name, before, exportPage)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for i < len(page) && page[i].time.Before(since) {
+			i++
+		}
+		msgs = append(page[i:], msgs...)
+		if i > 0 || len(page) < exportPage {
+			return msgs, nil
+		}
+		before = page[0].time
+	}
+}
+
+// writeTranscript writes t to w in the given format.
+func writeTranscript(w io.Writer, t transcript, format string) error {
+	switch format {
+	case exportMarkdown, "md":
+		return writeMarkdown(w, t)
+	case exportHTML:
+		return exportTemplate.Execute(w, t)
+	case exportJSON:
+		out := struct {
+			Network  string            `json:"network"`
+			Channel  string            `json:"channel"`
+			Topic    string            `json:"topic,omitempty"`
+			Since    *time.Time        `json:"since,omitempty"`
+			Exported time.Time         `json:"exported"`
+			Messages []exportedMessage `json:"messages"`
+		}{Network: t.Network, Channel: t.Channel, Topic: t.Topic, Exported: t.Exported, Messages: []exportedMessage{}}
+		if !t.Since.IsZero() {
+			out.Since = &t.Since
+		}
+		for _, msg := range t.Messages {
+			out.Messages = append(out.Messages, exportedMessage{
+				ID:          msg.id,
+				Nick:        msg.nick,
+				Text:        msg.text,
+				Time:        msg.time,
+				ReplyTo:     msg.replyTo,
+				Attachments: attachments(msg.text),
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	default:
+		return fmt.Errorf("unknown export format %q, want %s, %s or %s", format, exportMarkdown, exportHTML, exportJSON)
+	}
+}
+
+func writeMarkdown(w io.Writer, t transcript) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", t.Channel)
+	if t.Topic != "" {
+		fmt.Fprintf(&b, "> %s\n\n", t.Topic)
+	}
+	fmt.Fprintf(&b, "_%s_\n", t.Summary())
+	day := ""
+	for _, msg := range t.Messages {
+		if d := msg.time.Local().Format("Monday, January 2, 2006"); d != day {
+			day = d
+			fmt.Fprintf(&b, "\n## %s\n\n", day)
+		}
+		if ctx := t.ReplyContext(msg); ctx != "" {
+			fmt.Fprintf(&b, "> ↪ %s\n\n", ctx)
+		}
+		fmt.Fprintf(&b, "**%s** %s  \n", msg.nick, msg.time.Local().Format("15:04"))
+		b.WriteString(strings.ReplaceAll(msg.text, "\n", "  \n"))
+		b.WriteString("\n\n")
+		for _, a := range attachments(msg.text) {
+			fmt.Fprintf(&b, "- 📎 [%s](%s)\n", a.Name, a.URL)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Summary is the line under a transcript's title.
+func (t transcript) Summary() string {
+	s := fmt.Sprintf("%d messages from %s, exported %s", len(t.Messages), t.Network, t.Exported.Local().Format("2006-01-02 15:04"))
+	if !t.Since.IsZero() {
+		s += ", since " + t.Since.Format(searchDateLayout)
+	}
+	return s
+}
+
+// ReplyContext describes the message msg answers, if it is a reply.
+func (t transcript) ReplyContext(msg message) string {
+	if msg.replyTo == "" {
+		return ""
+	}
+	for _, orig := range t.Messages {
+		if orig.id == msg.replyTo {
+			return orig.nick + ": " + truncate(orig.text, 80)
+		}
+	}
+	return "an earlier message"
+}
+
+// Days groups the messages by local calendar day, for the HTML template.
+func (t transcript) Days() []transcriptDay {
+	var days []transcriptDay
+	for _, msg := range t.Messages {
+		title := msg.time.Local().Format("Monday, January 2, 2006")
+		if len(days) == 0 || days[len(days)-1].Title != title {
+			days = append(days, transcriptDay{Title: title})
+		}
+		d := &days[len(days)-1]
+		d.Messages = append(d.Messages, transcriptLine{
+			Nick:        msg.nick,
+			Time:        msg.time.Local().Format("15:04"),
+			Text:        msg.text,
+			Reply:       t.ReplyContext(msg),
+			Attachments: attachments(msg.text),
+		})
+	}
+	return days
+}
+
+type transcriptDay struct {
+	Title    string
+	Messages []transcriptLine
+}
+
+type transcriptLine struct {
+	Nick, Time, Text, Reply string
+	Attachments             []attachment
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Channel}}</title>
+<style>
+body { font: 15px/1.5 system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+h1 { margin-bottom: 0; }
+.topic { color: #666; margin-top: .25rem; }
+.summary { color: #888; font-size: 13px; }
+h2 { font-size: 14px; color: #888; border-bottom: 1px solid #eee; padding-bottom: .25rem; margin-top: 2rem; }
+.msg { margin: .5rem 0; }
+.nick { font-weight: 600; color: #c2185b; }
+.time { color: #999; font-size: 12px; margin-left: .5rem; }
+.text { white-space: pre-wrap; }
+.reply { color: #888; font-size: 13px; border-left: 2px solid #ddd; padding-left: .5rem; }
+.files { margin: .25rem 0 0; padding-left: 1.25rem; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>{{.Channel}}</h1>
+{{with .Topic}}<p class="topic">{{.}}</p>{{end}}
+<p class="summary">{{.Summary}}</p>
+{{range .Days}}<h2>{{.Title}}</h2>
+{{range .Messages}}<div class="msg">
+{{with .Reply}}<div class="reply">↪ {{.}}</div>
+{{end}}<span class="nick">{{.Nick}}</span><span class="time">{{.Time}}</span>
+<div class="text">{{.Text}}</div>
+{{with .Attachments}}<ul class="files">{{range .}}<li>📎 <a href="{{.URL}}">{{.Name}}</a></li>{{end}}</ul>
+{{end}}</div>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// --- CLI ---
+
+// exportCommand is "gochat export", writing a stored buffer's history to
+// stdout or a file.
+func exportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a stored buffer's history out as Markdown, HTML or JSON",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	name := fs.String("channel", "", "buffer to export, e.g. `#general` or @nick")
+	since := fs.String("since", "", "only export messages from `date` (2006-01-02) on")
+	format := fs.String("format", exportMarkdown, "markdown, html or json")
+	network := fs.String("network", "", "`server` the buffer is on, by name or address (default the first configured)")
+	out := fs.String("out", "", "write to `file` instead of stdout")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if *name == "" {
+			return errors.New("--channel is required")
+		}
+		var from time.Time
+		if *since != "" {
+			var err error
+			if from, err = time.ParseInLocation(searchDateLayout, *since, time.Local); err != nil {
+				return fmt.Errorf("--since wants a date like 2024-01-01")
+			}
+		}
+
+		st, _ := loadSettings()
+		s, err := openStore(st)
+		if err != nil {
+			return err
+		}
+		defer s.close()
+		t := transcript{Network: exportNetwork(*network, st.Servers), Channel: *name, Since: from, Exported: time.Now()}
+		if t.Messages, err = collectTranscript(s, t.Network, t.Channel, from); err != nil {
+			return err
+		}
+		if len(t.Messages) == 0 {
+			return fmt.Errorf("no stored messages in %s on %s (pick the server with --network)", t.Channel, t.Network)
+		}
+		if stored, err := s.loadChannels(t.Network); err == nil {
+			for _, ch := range stored {
+				if ch.name == t.Channel {
+					t.Topic = ch.topic
+				}
+			}
+		}
+
+		w := io.Writer(os.Stdout)
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return writeTranscript(w, t, *format)
+	}
+	return cmd
+}
+
+// exportNetwork resolves --network to the address buffers are stored under.
+func exportNetwork(name string, servers []serverSettings) string {
+	for _, srv := range servers {
+		if name == "" || name == srv.Name || name == srv.Addr {
+			return srv.Addr
+		}
+	}
+	if name == "" {
+		return "local"
+	}
+	return name
+}
+
+// --- Command ---
+
+// cmdExport saves the active buffer's history to a file in the current
+// directory.
+func cmdExport(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil
+	}
+	format, since, _ := strings.Cut(args, " ")
+	if format == "" {
+		format = exportMarkdown
+	}
+	t := transcript{Network: m.storeNetwork(), Channel: ch.name, Topic: ch.topic, Exported: time.Now()}
+	if since = strings.TrimSpace(since); since != "" {
+		var err error
+		if t.Since, err = time.ParseInLocation(searchDateLayout, since, time.Local); err != nil {
+			m.notice("Usage: /export [markdown|html|json] [since, like 2024-01-01]")
+			return nil
+		}
+	}
+
+	if m.store != nil {
+		msgs, err := collectTranscript(m.store, t.Network, ch.name, t.Since)
+		if err != nil {
+			m.notice("Export failed: " + err.Error())
+			return nil
+		}
+		t.Messages = msgs
+	} else {
+		for _, msg := range ch.messages {
+			if !msg.system && !msg.time.Before(t.Since) {
+				t.Messages = append(t.Messages, msg)
+			}
+		}
+	}
+
+	if len(t.Messages) == 0 {
+		m.notice("No messages to export")
+		return nil
+	}
+	ext := map[string]string{exportMarkdown: "md", "md": "md", exportHTML: "html", exportJSON: "json"}[format]
+	if ext == "" {
+		m.notice("Usage: /export [markdown|html|json] [since, like 2024-01-01]")
+		return nil
+	}
+	file := strings.NewReplacer("#", "", "@", "dm-", "/", "-").Replace(ch.name) + "-" + t.Exported.Format("20060102-150405") + "." + ext
+	if dir, err := os.Getwd(); err == nil {
+		file = filepath.Join(dir, file)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		m.notice("Export failed: " + err.Error())
+		return nil
+	}
+	err = writeTranscript(f, t, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		m.notice("Export failed: " + err.Error())
+		return nil
+	}
+	m.notice(fmt.Sprintf("Exported %d messages to %s", len(t.Messages), file))
+	return nil
+}
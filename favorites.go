@@ -0,0 +1,161 @@
+package main
+
+import (
+	"slices"
+	"sort"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// accountSettings is per-account UI state, such as the user's own buffer
+// ordering.
+type accountSettings struct {
+	Favorites  []string   `json:"favorites,omitempty"`  // starred buffers, in display order
+	Order      []string   `json:"order,omitempty"`      // manual ordering of the remaining buffers
+	Muted      []string   `json:"muted,omitempty"`      // buffers that don't badge or notify
+	Categories []category `json:"categories,omitempty"` // sidebar sections, in display order
+
+	Notify map[string]notifyLevel `json:"notify,omitempty"` // per-buffer level, absent means all
+
+	Drafts  map[string]string `json:"drafts,omitempty"`  // unsent composer text per buffer
+	Starred []starredMessage  `json:"starred,omitempty"` // oldest first
+}
+
+// accountKey identifies the current account in the settings file.
+func (m *model) accountKey() string {
+	return m.nick
+}
+
+func (m *model) account() *accountSettings {
+	if m.settings.Accounts == nil {
+		m.settings.Accounts = make(map[string]*accountSettings)
+	}
+	acct, ok := m.settings.Accounts[m.accountKey()]
+	if !ok {
+		acct = &accountSettings{}
+		m.settings.Accounts[m.accountKey()] = acct
+	}
+	return acct
+}
+
+func (m *model) isFavorite(name string) bool {
+	return slices.Contains(m.account().Favorites, name)
+}
+
+func (m *model) isMuted(name string) bool {
+	return slices.Contains(m.account().Muted, name)
+}
+
+// setMuted mutes or unmutes a buffer and persists the change.
+func (m *model) setMuted(name string, muted bool) tea.Cmd {
+	acct := m.account()
+	i := slices.Index(acct.Muted, name)
+	switch {
+	case muted && i < 0:
+		acct.Muted = append(acct.Muted, name)
+	case !muted && i >= 0:
+		acct.Muted = slices.Delete(acct.Muted, i, i+1)
+	default:
+		return nil
+	}
+	if ch := m.channelByName(name); ch != nil && muted {
+		ch.unread, ch.mentions = 0, 0
+	}
+	return saveSettingsCmd(m.settings)
+}
+
+// applyChannelOrder sorts the buffer list: favorites first in the order they
+// were starred or arranged, then the manual order, then anything new in the
+// order it was opened.
+func (m *model) applyChannelOrder() {
+	acct := m.account()
+	rank := func(name string) (int, int) {
+		if i := slices.Index(acct.Favorites, name); i >= 0 {
+			return 0, i
+		}
+		if i := slices.Index(acct.Order, name); i >= 0 {
+			return 1, i
+		}
+		return 2, 0
+	}
+
+	var activeName string
+	if ch := m.activeChannel(); ch != nil {
+		activeName = ch.name
+	}
+	sort.SliceStable(m.channels, func(i, j int) bool {
+		gi, ri := rank(m.channels[i].name)
+		gj, rj := rank(m.channels[j].name)
+		if gi != gj {
+			return gi < gj
+		}
+		return ri < rj
+	})
+	for i, ch := range m.channels {
+		if ch.name == activeName {
+			m.active = i
+		}
+	}
+}
+
+// saveChannelOrder records the current buffer order for the account.
+func (m *model) saveChannelOrder() tea.Cmd {
+	acct := m.account()
+	acct.Order = acct.Order[:0]
+	for _, ch := range m.channels {
+		if !m.isFavorite(ch.name) {
+			acct.Order = append(acct.Order, ch.name)
+		}
+	}
+	return saveSettingsCmd(m.settings)
+}
+
+// toggleFavorite stars or unstars the active buffer.
+func (m *model) toggleFavorite() tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil
+	}
+	acct := m.account()
+	if i := slices.Index(acct.Favorites, ch.name); i >= 0 {
+		acct.Favorites = slices.Delete(acct.Favorites, i, i+1)
+	} else {
+		acct.Favorites = append(acct.Favorites, ch.name)
+	}
+	m.applyChannelOrder()
+	return m.saveChannelOrder()
+}
+
+// moveChannel moves the active buffer past its neighbor in the sidebar,
+// staying within its section (starred, channels or DMs).
+func (m *model) moveChannel(delta int) tea.Cmd {
+	from := m.active
+	if from < 0 || from >= len(m.channels) {
+		return nil
+	}
+	a := m.channels[from]
+	sameSection := func(b *channel) bool {
+		if m.isFavorite(a.name) || m.isFavorite(b.name) {
+			return m.isFavorite(a.name) == m.isFavorite(b.name)
+		}
+		return a.isDM() == b.isDM()
+	}
+
+	to := from + delta
+	for to >= 0 && to < len(m.channels) && !sameSection(m.channels[to]) {
+		to += delta
+	}
+	if to < 0 || to >= len(m.channels) {
+		return nil
+	}
+	b := m.channels[to]
+
+	acct := m.account()
+	if m.isFavorite(a.name) {
+		i, j := slices.Index(acct.Favorites, a.name), slices.Index(acct.Favorites, b.name)
+		acct.Favorites[i], acct.Favorites[j] = acct.Favorites[j], acct.Favorites[i]
+	}
+	m.channels[from], m.channels[to] = b, a
+	m.active = to
+	return m.saveChannelOrder()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sahilm/fuzzy"
+)
+
+// searchMode is how the search box query matches messages, in both history
+// and in-buffer search.
+type searchMode int
+
+const (
+	searchPlain searchMode = iota
+	searchRegex            // the query text is a regular expression
+	searchFuzzy            // fzf-style, tolerating typos and partial words
+)
+
+// prompt is the search box's prompt, marking the mode the query is read in.
+func (mode searchMode) prompt() string {
+	switch mode {
+	case searchRegex:
+		return icons.Search + " .* "
+	case searchFuzzy:
+		return icons.Search + " ~ "
+	}
+	return icons.Search + " "
+}
+
+// toggleSearchMode switches the search box to mode, or back to plain
+// queries if it is already in it.
+func (m *model) toggleSearchMode(mode searchMode) {
+	if m.searchMode == mode {
+		mode = searchPlain
+	}
+	m.searchMode = mode
+	m.textInput.Prompt = mode.prompt()
+}
+
+// searchBuffer looks for the search box query in the messages loaded in
+// the active buffer, newest first (best first when fuzzy). Plain queries
+// match case-insensitively.
+func (m *model) searchBuffer() {
+	query := strings.TrimSpace(m.textInput.Value())
+	ch := m.activeChannel()
+	if query == "" || ch == nil {
+		return
+	}
+	var msgs []message
+	for i := len(ch.messages) - 1; i >= 0; i-- {
+		if msg := ch.messages[i]; !msg.system && msg.id != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	hitOf := func(msg message) searchHit {
+		return searchHit{network: m.storeNetwork(), channel: ch.name, id: msg.id, nick: msg.nick, text: msg.text, time: msg.time}
+	}
+
+	var hits []searchHit
+	if m.searchMode == searchFuzzy {
+		texts := make([]string, len(msgs))
+		for i, msg := range msgs {
+			texts[i] = msg.text
+		}
+		for _, match := range fuzzy.Find(query, texts) {
+			h := hitOf(msgs[match.Index])
+			h.matches = runeRanges(h.text, match.MatchedIndexes)
+			if hits = append(hits, h); len(hits) == searchLimit {
+				break
+			}
+		}
+		m.overlay = newSearchResults(m, fmt.Sprintf("Find ~%q in %s", query, ch.name), hits)
+		return
+	}
+
+	pattern, title := "(?i)"+regexp.QuoteMeta(query), fmt.Sprintf("Find %q in %s", query, ch.name)
+	if m.searchMode == searchRegex {
+		pattern, title = query, fmt.Sprintf("Find /%s/ in %s", query, ch.name)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		m.notice("Search: " + err.Error())
+		return
+	}
+	for _, msg := range msgs {
+		locs := re.FindAllStringIndex(msg.text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		h := hitOf(msg)
+		for _, loc := range locs {
+			h.matches = append(h.matches, [2]int{loc[0], loc[1]})
+		}
+		if hits = append(hits, h); len(hits) == searchLimit {
+			break
+		}
+	}
+	m.overlay = newSearchResults(m, title, hits)
+}
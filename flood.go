@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server keeps out addresses it is told to and ones that hammer it.
+// --ban-ip takes addresses and CIDR ranges (10.0.0.0/8, 2001:db8::/32),
+// whose connections are closed straight away. Each address may open
+// --max-conn-rate connections a minute and each connection send
+// --max-frame-rate frames a second, both with some room for bursts (a
+// client sends a batch as it connects). One that goes over is cut off and
+// its address banned for --flood-ban, so a misbehaving client or a
+// reconnect loop doesn't take the server down with it.
+
+const (
+	defaultConnRate  = 30               // connections a minute per address
+	defaultFrameRate = 20               // frames a second per connection
+	defaultFloodBan  = 10 * time.Minute // how long flooding bans an address
+	frameBurst       = 10               // seconds of frames a connection may send at once
+	// maxTrackedAddrs is when idle addresses stop being remembered
+	maxTrackedAddrs = 10000
+)
+
+var errAddrBanned = errors.New("connections from your address are banned")
+
+// floodBanError is an address banned for a while for flooding.
+type floodBanError struct {
+	until time.Time
+}
+
+func (e *floodBanError) Error() string {
+	return fmt.Sprintf("too many connections or requests, try again in %s",
+		time.Until(e.until).Round(time.Second))
+}
+
+// tokenBucket lets through rate a second, up to burst at once.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token at now if there is one left.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// floodGuard keeps the bans and the counts they go by.
+type floodGuard struct {
+	bans      []netip.Prefix
+	connRate  float64       // connections a minute per address, 0 for any
+	frameRate float64       // frames a second per connection, 0 for any
+	banFor    time.Duration // 0 only cuts a flooder off
+
+	mu       sync.Mutex
+	conns    map[netip.Addr]*tokenBucket
+	tempBans map[netip.Addr]time.Time // until when
+}
+
+func newFloodGuard() *floodGuard {
+	return &floodGuard{
+		connRate:  defaultConnRate,
+		frameRate: defaultFrameRate,
+		banFor:    defaultFloodBan,
+		conns:     make(map[netip.Addr]*tokenBucket),
+		tempBans:  make(map[netip.Addr]time.Time),
+	}
+}
+
+// banAddrs parses a --ban-ip value, comma-separated addresses and CIDR
+// ranges.
+func (g *floodGuard) banAddrs(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return fmt.Errorf("%q is neither an address nor a CIDR range", s)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		g.bans = append(g.bans, p.Masked())
+	}
+	return nil
+}
+
+// remoteAddr is the address c comes from.
+func remoteAddr(c net.Conn) netip.Addr {
+	ap, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// admit is why a connection from addr at now is refused, if it is.
+func (g *floodGuard) admit(addr netip.Addr, now time.Time) error {
+	for _, p := range g.bans {
+		if p.Contains(addr) {
+			return errAddrBanned
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until, ok := g.tempBans[addr]; ok {
+		if now.Before(until) {
+			return &floodBanError{until: until}
+		}
+		delete(g.tempBans, addr)
+	}
+	if g.connRate <= 0 {
+		return nil
+	}
+	b, ok := g.conns[addr]
+	if !ok {
+		if len(g.conns) >= maxTrackedAddrs {
+			g.forgetIdleLocked(now)
+		}
+		b = &tokenBucket{}
+		g.conns[addr] = b
+	}
+	if !b.take(now, g.connRate/60, g.connRate) {
+		return g.banLocked(addr, now, "connecting too often")
+	}
+	return nil
+}
+
+// forgetIdleLocked drops the addresses whose allowance is back to full.
+func (g *floodGuard) forgetIdleLocked(now time.Time) {
+	for addr, b := range g.conns {
+		if now.Sub(b.last).Seconds()*g.connRate/60 >= g.connRate {
+			delete(g.conns, addr)
+		}
+	}
+}
+
+// banLocked bans addr for g.banFor, if bans are on, and is what to tell it.
+func (g *floodGuard) banLocked(addr netip.Addr, now time.Time, why string) error {
+	until := now.Add(g.banFor)
+	if g.banFor > 0 {
+		g.tempBans[addr] = until
+		log.Printf("flood: banning %s until %s for %s", addr, until.Format(time.TimeOnly), why)
+	} else {
+		log.Printf("flood: cutting %s off for %s", addr, why)
+	}
+	return &floodBanError{until: until}
+}
+
+// frame is why a connection from addr may not send another frame at now,
+// if it may not, b counting its frames.
+func (g *floodGuard) frame(b *tokenBucket, addr netip.Addr, now time.Time) error {
+	if g.frameRate <= 0 || b.take(now, g.frameRate, g.frameRate*frameBurst) {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.banLocked(addr, now, "sending too many requests")
+}
+
+// refuse tells a client it isn't let in and hangs up, without holding up
+// the accept loop.
+func refuse(c net.Conn, err error) {
+	go func() {
+		c.SetDeadline(time.Now().Add(dialTimeout))
+		fc := newFrameConn(c)
+		fc.write(handshakeFailure(err))
+		fc.close()
+	}()
+}
@@ -0,0 +1,57 @@
+package main
+
+import tea "github.com/charmbracelet/bubbletea"
+
+// focusArea is the part of the UI receiving key input.
+type focusArea int
+
+const (
+	focusComposer focusArea = iota
+	focusBuffer
+	focusSearch
+)
+
+func (m *model) setFocus(f focusArea) {
+	m.focus = f
+	m.textInput.Blur()
+	m.messageInput.Blur()
+	switch f {
+	case focusComposer:
+		m.messageInput.Focus()
+	case focusSearch:
+		m.textInput.Focus()
+	}
+}
+
+// cycleFocus moves focus composer -> each pane -> search -> composer.
+func (m *model) cycleFocus() {
+	switch m.focus {
+	case focusComposer:
+		m.setFocus(focusBuffer)
+		m.focusPane(0)
+	case focusBuffer:
+		if m.focusedPane < len(m.panes)-1 {
+			m.focusPane(m.focusedPane + 1)
+			return
+		}
+		m.setFocus(focusSearch)
+	default:
+		m.setFocus(focusComposer)
+	}
+}
+
+// handleFocusMouse focuses the composer or the search box when clicked.
+func (m *model) handleFocusMouse(msg tea.MouseMsg) bool {
+	if msg.Action != tea.MouseActionRelease {
+		return false
+	}
+	switch {
+	case m.composerArea.contains(msg.X, msg.Y):
+		m.setFocus(focusComposer)
+	case m.searchArea.contains(msg.X, msg.Y):
+		m.setFocus(focusSearch)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A server can let people in without an account, as guests, to some of its
+// public channels:
+//
+//	gochat serve :6667 --guest-channels '#lobby,#help' [--guest-post]
+//
+// A guest picks a nick like anyone and gets it with guestPrefix in front,
+// "~bob", which no account can have, so everyone can tell; they have the
+// guest role everywhere and are listed under Guests. They are in the guest
+// channels for as long as they are connected, and only read them unless
+// --guest-post lets them post; DMs, invites and everything else that needs an
+// account are refused. Nothing is kept about them but what they posted.
+// /register turns a guest into a full account: the login screen opens
+// with the nick, ticked to register, and the client reconnects as it.
+
+// guestPrefix starts every guest's nick.
+const guestPrefix = "~"
+
+var (
+	errNoGuests       = errors.New("this server doesn't take guests, log in or register")
+	errGuestNickInUse = errors.New("a guest with that nick is already here, pick another")
+	errGuestsCant     = errors.New("guests can't do that, /register for an account")
+)
+
+// guestFrames are the requests guests may make. The handlers still check
+// each, posting going by --guest-post.
+var guestFrames = map[string]bool{
+	frameJoin:    true,
+	framePart:    true,
+	frameList:    true,
+	frameHistory: true,
+	frameSend:    true,
+	frameReact:   true,
+	frameEdit:    true,
+	frameDelete:  true,
+	frameEdits:   true,
+	frameReport:  true,
+	framePing:    true,
+	frameAway:    true,
+	frameTyping:  true,
+}
+
+// isGuestNick is whether nick is a guest's rather than an account's.
+func isGuestNick(nick string) bool {
+	return strings.HasPrefix(nick, guestPrefix)
+}
+
+// --- Server side ---
+
+// authenticateGuest lets s in as a guest, if the server takes them. There
+// is no session to resume, and the handshake checks that no one else has
+// the nick.
+func (srv *server) authenticateGuest(s *session) (*sessionTokens, error) {
+	if len(srv.guestChannels) == 0 {
+		return nil, errNoGuests
+	}
+	s.nick, s.guest = guestPrefix+strings.TrimLeft(s.nick, guestPrefix), true
+	return nil, nil
+}
+
+// guestPermissionsLocked is the permission table a guest is welcomed
+// with: at most posting, and that only with --guest-post.
+func (srv *server) guestPermissionsLocked() map[role]permission {
+	perms := maps.Clone(srv.permissions)
+	perms[roleGuest] = 0
+	if srv.guestPost {
+		perms[roleGuest] = srv.permissions[roleGuest] & permPost
+	}
+	return perms
+}
+
+// guestMayLocked is whether a guest may do perm in ch: post, in a
+// channel, with --guest-post. canLocked goes by it for guests.
+func (srv *server) guestMayLocked(ch *serverChannel, perm permission) bool {
+	return perm == permPost && srv.guestPost && ch != nil
+}
+
+// joinGuestChannels puts a guest who just connected in the guest
+// channels they aren't in yet. Ones that are gone or private are skipped.
+func (srv *server) joinGuestChannels(s *session, joined []string) {
+	for _, name := range srv.guestChannels {
+		if slices.Contains(joined, name) {
+			continue
+		}
+		if err := srv.join(s, name); err != nil {
+			log.Printf("guests: putting %s in %s: %v", s.nick, name, err)
+			continue
+		}
+		srv.replicate(s, newFrame(frameJoin, channelRef{Channel: name}))
+	}
+}
+
+// partGuest takes a guest whose last session ended out of their channels,
+// so the member lists only show the ones still here.
+func (srv *server) partGuest(s *session) {
+	for _, name := range srv.channelsOf(s.nick) {
+		s.stamp = newEventStamp()
+		f := newFrame(framePart, channelRef{Channel: name})
+		if err := srv.handlePart(s, f); err != nil {
+			log.Printf("guests: taking %s out of %s: %v", s.nick, name, err)
+			continue
+		}
+		srv.replicate(s, f)
+	}
+}
+
+// --- Client side ---
+
+// cmdRegister makes a guest's nick an account, opening the login screen
+// to pick a password. The guest connection ends meanwhile.
+func cmdRegister(m *model, args string) tea.Cmd {
+	n := m.currentNetwork()
+	if m.client == nil || !m.client.guest || n == nil {
+		m.notice("/register is for guests, you are logged in to an account")
+		return nil
+	}
+	nick := strings.TrimPrefix(m.nick, guestPrefix)
+	n.creds = credentials{nick: nick, register: true, invite: strings.TrimSpace(args)}
+	m.client.close()
+	m.client = nil
+	m.login = newLoginScreen(n, "Pick a password to register "+nick+" as your account")
+	return nil
+}
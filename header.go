@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	headerTopicMaxWidth   = 30
+	headerNetworkMaxWidth = 12
+)
+
+// renderHeaderLeft renders the logo, channel and topic segments of the
+// header for the active buffer.
+func (m *model) renderHeaderLeft() string {
+	name, topic := "", ""
+	if ch := m.activeChannel(); ch != nil {
+		name, topic = ch.name, ch.topic
+		if ch.private || m.encrypted(ch) {
+			name = icons.Lock + " " + name
+		}
+		switch verified, changed := m.verifiedState(ch); {
+		case verified:
+			name += " ✓"
+		case changed:
+			name = "⚠ " + ch.name + " key changed"
+		}
+	}
+	if topic == "" {
+		topic = "—"
+	}
+
+	logo := logoStyle.String()
+	network := m.renderNetworkSegment()
+	m.networkArea = m.headerRect(lipgloss.Width(logo), lipgloss.Width(network))
+
+	return lipgloss.JoinHorizontal(lipgloss.Center,
+		logo,
+		network,
+		channelStyle.Render(name),
+		dividerStyle.String(),
+		topicStyle.Render("TOPIC: "+truncate(topic, max(headerTopicMaxWidth-lipgloss.Width(network), 8))),
+		dividerStyle.String(),
+	)
+}
+
+// headerRect is the screen region of something drawn x cells into the
+// header and w wide, the header box's whole height to make it easier to
+// click.
+func (m *model) headerRect(x, w int) rect {
+	s := headerContainerStyle
+	return rect{
+		x: m.leftSidebarRenderedWidth() + s.GetBorderLeftSize() + s.GetPaddingLeft() + x,
+		y: appStyle.GetPaddingTop() + s.GetMarginTop(),
+		w: w,
+		h: 1 + s.GetVerticalBorderSize(),
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// highlight in the config file lists what else counts as mentioning us,
+// besides our nick: words, matched like the nick as whole words in any
+// case, and regular expressions between slashes.
+//
+//	highlight = ["gochat", "deploy", "/\\bOPS-[0-9]+\\b/"]
+//
+// A message matching one is a mention all the way through: the mention
+// badge, the activity center, jumping between mentions, the mention sound
+// and buffers notifying only of mentions.
+
+// highlighter matches the highlight words.
+type highlighter struct {
+	words []string // lowercased
+	res   []*regexp.Regexp
+}
+
+// parseHighlights parses the highlight list.
+func parseHighlights(list []string) (highlighter, error) {
+	var h highlighter
+	for _, entry := range list {
+		if pattern, ok := strings.CutPrefix(entry, "/"); ok && len(pattern) > 0 && strings.HasSuffix(pattern, "/") {
+			re, err := regexp.Compile(strings.TrimSuffix(pattern, "/"))
+			if err != nil {
+				return h, fmt.Errorf("highlight %s: %w", entry, err)
+			}
+			h.res = append(h.res, re)
+			continue
+		}
+		if word := strings.ToLower(strings.TrimSpace(entry)); word != "" {
+			h.words = append(h.words, word)
+		}
+	}
+	return h, nil
+}
+
+// matches reports whether text has a highlight word in it.
+func (h highlighter) matches(text string) bool {
+	lower := strings.ToLower(text)
+	for _, word := range h.words {
+		if containsWord(lower, word) {
+			return true
+		}
+	}
+	for _, re := range h.res {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
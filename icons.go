@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The header, the composer and the search prompts use icons from Nerd
+// Fonts, which show as boxes without one. [ui] icons = "ascii" swaps them
+// for plain text, and auto, the default, does too where a Nerd Font can't
+// be showing: the Linux console, dumb terminals, and locales other than
+// UTF-8. "nerd" keeps them whatever the terminal looks like.
+
+// iconSet is the icons the UI is drawn with.
+type iconSet struct {
+	Logo     string // ahead of the header
+	Search   string // the search boxes' prompt
+	Bell     string // activity, in the header
+	Info     string // the buffer's details, in the header
+	Lock     string // private and encrypted buffers
+	Composer string // after the composer's text
+}
+
+var nerdIcons = iconSet{
+	Logo:     "\uf489",
+	Search:   "\uf002",
+	Bell:     "\uf0f3",
+	Info:     "\uf05a",
+	Lock:     "\uf023",
+	Composer: " \uee49 \U000F0066",
+}
+
+var asciiIcons = iconSet{
+	Logo:   "#",
+	Search: "?",
+	Bell:   "[!]",
+	Info:   "(i)",
+	Lock:   "*",
+}
+
+// icons is the current icon set, see iconsFor.
+var icons = nerdIcons
+
+// iconsFor is the icon set [ui] icons picks.
+func iconsFor(mode string) (iconSet, error) {
+	switch mode {
+	case "", "auto":
+		if !nerdFontLikely() {
+			return asciiIcons, nil
+		}
+		return nerdIcons, nil
+	case "nerd":
+		return nerdIcons, nil
+	case "ascii":
+		return asciiIcons, nil
+	}
+	return nerdIcons, fmt.Errorf("ui: icons is auto, nerd or ascii, not %q", mode)
+}
+
+// nerdFontLikely is whether the terminal could be showing a Nerd Font, as
+// far as its environment tells.
+func nerdFontLikely() bool {
+	if term := os.Getenv("TERM"); term == "linux" || term == "dumb" {
+		return false
+	}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := strings.ToLower(os.Getenv(name)); locale != "" {
+			return strings.Contains(locale, "utf-8") || strings.Contains(locale, "utf8")
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// By itself auto-away goes by the keys pressed in gochat. "/autoaway
+// system" makes it go by input anywhere on the desktop instead, so someone
+// working in another window stays online, and typing there brings them
+// back as well. That is read with xprintidle on X11, GNOME's idle monitor
+// on Wayland and ioreg on macOS; where none of them works, and over SSH,
+// where the desktop is another machine's, it is gochat's keys again.
+
+// idleQueryTimeout bounds reading the system idle time.
+const idleQueryTimeout = 2 * time.Second
+
+// systemIdleMsg is how long the desktop has gone without input, ok being
+// false if that couldn't be read.
+type systemIdleMsg struct {
+	idle time.Duration
+	ok   bool
+}
+
+// idleTool is what reads the system idle time here, or empty.
+func idleTool() string {
+	if overSSH() {
+		return ""
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return "ioreg"
+	case "windows":
+		return ""
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" && strings.Contains(os.Getenv("XDG_CURRENT_DESKTOP"), "GNOME") {
+		if _, err := exec.LookPath("dbus-send"); err == nil {
+			return "dbus-send"
+		}
+	}
+	if os.Getenv("DISPLAY") != "" {
+		if _, err := exec.LookPath("xprintidle"); err == nil {
+			return "xprintidle"
+		}
+	}
+	return ""
+}
+
+// querySystemIdle reads the system idle time.
+func querySystemIdle() tea.Msg {
+	idle, ok := systemIdle(idleTool())
+	return systemIdleMsg{idle: idle, ok: ok}
+}
+
+// systemIdle reads the system idle time with tool.
+func systemIdle(tool string) (time.Duration, bool) {
+	if tool == "" {
+		return 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), idleQueryTimeout)
+	defer cancel()
+	var args []string
+	switch tool {
+	case "ioreg":
+		args = []string{"-c", "IOHIDSystem", "-d", "4"}
+	case "dbus-send":
+		args = []string{"--print-reply", "--dest=org.gnome.Mutter.IdleMonitor",
+			"/org/gnome/Mutter/IdleMonitor/Core", "org.gnome.Mutter.IdleMonitor.GetIdletime"}
+	}
+	out, err := exec.CommandContext(ctx, tool, args...).Output()
+	if err != nil {
+		return 0, false
+	}
+	return parseIdle(tool, string(out))
+}
+
+// parseIdle reads the idle time out of what tool printed: nanoseconds in
+// ioreg's HIDIdleTime, milliseconds from the others.
+func parseIdle(tool, out string) (time.Duration, bool) {
+	var field string
+	unit := time.Millisecond
+	switch tool {
+	case "ioreg":
+		_, rest, _ := strings.Cut(out, `"HIDIdleTime" = `)
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		field, unit = fields[0], time.Nanosecond
+	default:
+		fields := strings.Fields(out)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		field = fields[len(fields)-1]
+	}
+	n, err := strconv.ParseUint(field, 10, 63)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
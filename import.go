@@ -0,0 +1,570 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Import formats, picked with --format. Left empty it is guessed from the
+// file: a zip is a Slack export, anything else a Discord one.
+const (
+	importSlack   = "slack"
+	importDiscord = "discord"
+)
+
+// importBatchSize bounds the encoded messages sent to a server in a single
+// import frame, well under maxFrameSize.
+const importBatchSize = maxFrameSize / 2
+
+// importedChannel is a channel read from an export archive.
+type importedChannel struct {
+	name     string
+	topic    string
+	private  bool
+	created  time.Time
+	messages []message // oldest first
+}
+
+// --- Slack ---
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Profile struct {
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Created int64  `json:"created"`
+	Topic   struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+}
+
+type slackMessage struct {
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	Username string `json:"username"` // bots and integrations
+	Text     string `json:"text"`
+	Ts       string `json:"ts"`
+	ThreadTs string `json:"thread_ts"`
+	Files    []struct {
+		Name       string `json:"name"`
+		URLPrivate string `json:"url_private"`
+	} `json:"files"`
+}
+
+// slackMarkupRE matches Slack's <...> escapes: user and channel mentions
+// and links.
+var slackMarkupRE = regexp.MustCompile(`<([^<>]+)>`)
+
+// readSlackExport reads the public (channels.json) and private
+// (groups.json) channels of a Slack export zip. DMs are left out, there is
+// no telling whose side of the conversation they'd belong to.
+func readSlackExport(file string, nicks map[string]string) ([]importedChannel, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var users []slackUser
+	if err := readZipJSON(files["users.json"], &users); err != nil {
+		return nil, fmt.Errorf("users.json: %w", err)
+	}
+	names := make(map[string]string, len(users))
+	for _, u := range users {
+		name := u.Profile.DisplayName
+		if name == "" {
+			name = u.Name
+		}
+		names[u.ID] = mapNick(importNick(name), nicks)
+	}
+
+	var out []importedChannel
+	for _, list := range []struct {
+		file    string
+		private bool
+	}{{"channels.json", false}, {"groups.json", true}} {
+		if files[list.file] == nil {
+			continue
+		}
+		var channels []slackChannel
+		if err := readZipJSON(files[list.file], &channels); err != nil {
+			return nil, fmt.Errorf("%s: %w", list.file, err)
+		}
+		for _, sc := range channels {
+			ch := importedChannel{
+				name:    importChannelName(sc.Name),
+				topic:   sc.Topic.Value,
+				private: list.private,
+				created: time.Unix(sc.Created, 0).UTC(),
+			}
+			if ch.topic == "" {
+				ch.topic = sc.Purpose.Value
+			}
+			// One file per day, named by date so they sort in order
+			var days []string
+			for name := range files {
+				if path.Dir(name) == sc.Name && path.Ext(name) == ".json" {
+					days = append(days, name)
+				}
+			}
+			sort.Strings(days)
+			for _, day := range days {
+				var msgs []slackMessage
+				if err := readZipJSON(files[day], &msgs); err != nil {
+					return nil, fmt.Errorf("%s: %w", day, err)
+				}
+				for _, sm := range msgs {
+					if msg, ok := slackToMessage(sc, sm, names, nicks); ok {
+						msg.channel = ch.name
+						ch.messages = append(ch.messages, msg)
+					}
+				}
+			}
+			out = append(out, ch)
+		}
+	}
+	return out, nil
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	if f == nil {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
+
+func slackToMessage(sc slackChannel, sm slackMessage, names, nicks map[string]string) (message, bool) {
+	// Joins, leaves, topic changes and the like
+	if strings.HasPrefix(sm.Subtype, "channel_") || strings.HasPrefix(sm.Subtype, "group_") {
+		return message{}, false
+	}
+	t, ok := slackTime(sm.Ts)
+	if !ok {
+		return message{}, false
+	}
+	nick := names[sm.User]
+	if nick == "" {
+		nick = mapNick(importNick(sm.Username), nicks)
+	}
+	if nick == "" {
+		nick = "unknown"
+	}
+	text := slackText(sm.Text, names)
+	for _, f := range sm.Files {
+		if f.URLPrivate != "" {
+			text = strings.TrimSpace(text + "\n" + f.URLPrivate)
+		}
+	}
+	if text == "" {
+		return message{}, false
+	}
+	msg := message{id: slackID(sc.ID, sm.Ts), nick: nick, text: text, time: t}
+	if sm.ThreadTs != "" && sm.ThreadTs != sm.Ts {
+		msg.replyTo = slackID(sc.ID, sm.ThreadTs)
+	}
+	return msg, true
+}
+
+// slackID makes a stable message ID, so importing the same export twice
+// doesn't duplicate anything.
+func slackID(channelID, ts string) string {
+	return "slack-" + channelID + "-" + ts
+}
+
+// slackTime parses a Slack timestamp, seconds and microseconds since the
+// epoch written as "1577836800.000200".
+func slackTime(ts string) (time.Time, bool) {
+	secs, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	us, _ := strconv.ParseInt((frac + "000000")[:6], 10, 64)
+	return time.Unix(s, us*1000).UTC(), true
+}
+
+// slackText turns Slack's message markup into plain text: <@U123> becomes
+// @nick, <#C123|general> #general and <https://x|label> "label (https://x)".
+func slackText(text string, names map[string]string) string {
+	text = slackMarkupRE.ReplaceAllStringFunc(text, func(s string) string {
+		inner := s[1 : len(s)-1]
+		target, label, _ := strings.Cut(inner, "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if nick, ok := names[target[1:]]; ok {
+				return "@" + nick
+			}
+			if label != "" {
+				return "@" + label
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if label != "" {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			// @here, @channel and friends
+			return "@" + strings.TrimPrefix(target, "!")
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		default:
+			return target
+		}
+	})
+	return html.UnescapeString(text)
+}
+
+// --- Discord ---
+
+// discordExport is the JSON written by DiscordChatExporter, one channel per
+// file.
+type discordExport struct {
+	Channel struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Topic string `json:"topic"`
+	} `json:"channel"`
+	Messages []struct {
+		ID        string    `json:"id"`
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Content   string    `json:"content"`
+		Author    struct {
+			Name     string `json:"name"`
+			Nickname string `json:"nickname"`
+		} `json:"author"`
+		Attachments []struct {
+			URL string `json:"url"`
+		} `json:"attachments"`
+		Reference *struct {
+			MessageID string `json:"messageId"`
+		} `json:"reference"`
+	} `json:"messages"`
+}
+
+func readDiscordExport(file string, nicks map[string]string) ([]importedChannel, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var export discordExport
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		return nil, err
+	}
+	ch := importedChannel{name: importChannelName(export.Channel.Name), topic: export.Channel.Topic}
+	for _, dm := range export.Messages {
+		// Only plain messages and replies, not pins, joins, calls etc
+		if dm.Type != "" && dm.Type != "Default" && dm.Type != "Reply" {
+			continue
+		}
+		name := dm.Author.Nickname
+		if name == "" {
+			name = dm.Author.Name
+		}
+		text := dm.Content
+		for _, a := range dm.Attachments {
+			text = strings.TrimSpace(text + "\n" + a.URL)
+		}
+		if text == "" {
+			continue
+		}
+		msg := message{
+			id:      "discord-" + dm.ID,
+			channel: ch.name,
+			nick:    mapNick(importNick(name), nicks),
+			text:    text,
+			time:    dm.Timestamp.UTC(),
+		}
+		if dm.Reference != nil && dm.Reference.MessageID != "" {
+			msg.replyTo = "discord-" + dm.Reference.MessageID
+		}
+		ch.messages = append(ch.messages, msg)
+	}
+	if len(ch.messages) > 0 {
+		ch.created = ch.messages[0].time
+	}
+	sort.SliceStable(ch.messages, func(i, j int) bool { return ch.messages[i].time.Before(ch.messages[j].time) })
+	return []importedChannel{ch}, nil
+}
+
+// --- Mapping ---
+
+var importNameRE = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// importChannelName turns a Slack or Discord channel name into one the
+// server accepts.
+func importChannelName(name string) string {
+	name = strings.Trim(importNameRE.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		name = "imported"
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return "#" + name
+}
+
+// importNick makes a display name usable as a nick, which can't contain
+// spaces.
+func importNick(name string) string {
+	return strings.Join(strings.Fields(name), "_")
+}
+
+func mapNick(nick string, nicks map[string]string) string {
+	if to, ok := nicks[nick]; ok {
+		return to
+	}
+	return nick
+}
+
+// parseNickMap parses --map, "slackname=nick,other=nick2".
+func parseNickMap(v string) (map[string]string, error) {
+	nicks := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("bad --map entry %q, want from=to", pair)
+		}
+		nicks[importNick(from)] = to
+	}
+	return nicks, nil
+}
+
+// --- Destinations ---
+
+// importToStore adds the channels and their messages to the local store.
+// Messages already there are left alone.
+func importToStore(s store, network string, channels []importedChannel) error {
+	for _, ic := range channels {
+		ch := newChannel(ic.name, ic.topic)
+		ch.private, ch.created = ic.private, ic.created
+		if err := s.saveChannel(network, ch); err != nil {
+			return err
+		}
+		if err := s.saveMessages(network, ch.name, ic.messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importToSearch adds the channels' messages to the search index.
+func importToSearch(network string, channels []importedChannel) error {
+	idx, _, err := openSearchIndex(false)
+	if err != nil {
+		return err
+	}
+	for _, ch := range channels {
+		if err = idx.add(network, ch.name, ch.messages); err != nil {
+			break
+		}
+	}
+	if cerr := idx.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// importToServer uploads the channels to a gochat server logged in with
+// creds, whose nick needs to be an admin of any that exist there already.
+// It returns how many messages the server took.
+func importToServer(srv serverSettings, creds credentials, channels []importedChannel) (int, error) {
+	nc, err := dialServer(srv)
+	if err != nil {
+		return 0, err
+	}
+	conn := newFrameConn(nc)
+	defer conn.close()
+	if err := conn.write(newFrame(frameHello, helloData{Nick: creds.nick, Password: creds.password, Client: clientName()})); err != nil {
+		return 0, err
+	}
+	if f, err := conn.read(); err != nil {
+		return 0, err
+	} else if f.Type != frameWelcome {
+		return 0, fmt.Errorf("handshake failed: %s", f.Error)
+	}
+
+	added, seq := 0, 0
+	for _, ch := range channels {
+		for _, batch := range importBatches(ch) {
+			seq++
+			f := newFrame(frameImport, batch)
+			f.ID = strconv.Itoa(seq)
+			if err := conn.write(f); err != nil {
+				return added, err
+			}
+			// Skip whatever else the server sends until our answer
+			for {
+				r, err := conn.read()
+				if err != nil {
+					return added, err
+				}
+				if r.ID != f.ID {
+					continue
+				}
+				if r.Type == frameError {
+					return added, fmt.Errorf("%s: %s", ch.name, r.Error)
+				}
+				var res importResult
+				if err := r.decode(&res); err != nil {
+					return added, err
+				}
+				added += res.Added
+				break
+			}
+		}
+	}
+	return added, nil
+}
+
+// importBatches splits a channel into import frames small enough to send.
+func importBatches(ch importedChannel) []importData {
+	batch := importData{Channel: ch.name, Topic: ch.topic, Private: ch.private, Created: ch.created}
+	batches := []importData{}
+	size := 0
+	for _, msg := range ch.messages {
+		w := wireMessage{ID: msg.id, Channel: ch.name, Nick: msg.nick, Text: msg.text, Time: msg.time, ReplyTo: msg.replyTo}
+		b, _ := json.Marshal(w)
+		if size+len(b) > importBatchSize && len(batch.Messages) > 0 {
+			batches = append(batches, batch)
+			batch = importData{Channel: ch.name, Topic: ch.topic, Private: ch.private, Created: ch.created}
+			size = 0
+		}
+		batch.Messages = append(batch.Messages, w)
+		size += len(b)
+	}
+	return append(batches, batch)
+}
+
+// --- CLI ---
+
+// importCommand is "gochat import", reading a Slack export zip or a
+// Discord export into the local store and, with --server, a server's
+// history.
+func importCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <export.zip|export.json>",
+		Short: "Bring history over from a Slack or Discord export",
+		Args:  cobra.ExactArgs(1),
+	}
+	fs := cmd.Flags()
+	format := fs.String("format", "", "`kind` of export: slack or discord (default guessed from the file)")
+	network := fs.String("network", "", "`server` to file the history under locally, by name or address (default --server, or the first configured)")
+	server := fs.String("server", "", "also upload the history to the server at `addr`")
+	nick := fs.String("nick", "", "nick to upload as (default $USER)")
+	local := fs.Bool("store", true, "add the history to the local store")
+	nickMap := fs.String("map", "", "rename people, `from=to,...`")
+	cmd.RunE = func(_ *cobra.Command, args []string) error {
+		file := args[0]
+		nicks, err := parseNickMap(*nickMap)
+		if err != nil {
+			return err
+		}
+
+		kind := *format
+		if kind == "" {
+			kind = importDiscord
+			if strings.EqualFold(path.Ext(file), ".zip") {
+				kind = importSlack
+			}
+		}
+		var channels []importedChannel
+		switch kind {
+		case importSlack:
+			channels, err = readSlackExport(file, nicks)
+		case importDiscord:
+			channels, err = readDiscordExport(file, nicks)
+		default:
+			return fmt.Errorf("unknown import format %q, want %s or %s", kind, importSlack, importDiscord)
+		}
+		if err != nil {
+			return err
+		}
+		total := 0
+		for _, ch := range channels {
+			total += len(ch.messages)
+		}
+		fmt.Printf("Read %d messages in %d channels\n", total, len(channels))
+
+		if *local {
+			st, _ := loadSettings()
+			name := *network
+			if name == "" {
+				name = *server
+			}
+			key := exportNetwork(name, st.Servers)
+			s, err := openStore(st)
+			if err != nil {
+				return err
+			}
+			err = importToStore(s, key, channels)
+			if cerr := s.close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Added them to the local store under %s\n", key)
+			// An encrypted store's index is rebuilt in memory on every start
+			if !st.EncryptStore {
+				if err := importToSearch(key, channels); err != nil {
+					fmt.Println("Couldn't add them to the search index:", err)
+				}
+			}
+		}
+		if *server != "" {
+			who := *nick
+			if who == "" {
+				who = os.Getenv("USER")
+			}
+			password := envPassword()
+			if password == "" {
+				if password, err = askPassword("Password for " + who + " on " + *server + ": "); err != nil {
+					return err
+				}
+			}
+			st, _ := loadSettings()
+			added, err := importToServer(findServer(*server, st.Servers), credentials{nick: who, password: password}, channels)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Uploaded %d new messages to %s\n", added, *server)
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+// Server admins hand out invite codes, which someone registering gives
+// along with their new nick and password:
+//
+//	gochat invite create --max-uses 5 --ttl 24h [--channel #dev]
+//	gochat --server chat.example.com --invite <code>
+//
+// A code can be used a number of times, or any, and runs out after a
+// while, or never. One made for a channel also puts whoever registers
+// with it there, invited as if by whoever made it, who has to be able to
+// invite people to it. Servers started with --invite-only take new
+// accounts only with a code. "gochat invite list" and "gochat invite
+// revoke <id>" look after them, as does /invites in the client. The server
+// keeps only a hash of each code, like refresh tokens.
+
+const (
+	// inviteIDLength is how much of an invite's hash identifies it.
+	inviteIDLength = 8
+	// maxInvites is how many invites a server keeps, used up or not.
+	maxInvites = 1000
+)
+
+var (
+	errInviteRequired = errors.New("this server takes new accounts by invite only, register with an invite code")
+	errBadInvite      = errors.New("that invite code is unknown, used up or expired")
+	errNoSuchInvite   = errors.New("no such invite")
+)
+
+// invite is what the server keeps of an invite code.
+type invite struct {
+	Hash    string    `json:"hash,omitempty"` // SHA-256 of the code, hex
+	ID      string    `json:"id"`
+	Channel string    `json:"channel,omitempty"`  // joined on registering
+	MaxUses int       `json:"max_uses,omitempty"` // 0 for any number
+	Uses    int       `json:"uses"`
+	By      string    `json:"by"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"` // zero for never
+}
+
+// usableAt reports whether the invite can still be used at t.
+func (inv *invite) usableAt(t time.Time) bool {
+	return (inv.MaxUses == 0 || inv.Uses < inv.MaxUses) && (inv.Expires.IsZero() || t.Before(inv.Expires))
+}
+
+// state says whether the invite can be used, for listing it.
+func (inv *invite) state() string {
+	uses := fmt.Sprintf("%d uses", inv.Uses)
+	if inv.MaxUses > 0 {
+		uses = fmt.Sprintf("%d/%d uses", inv.Uses, inv.MaxUses)
+	}
+	switch {
+	case inv.MaxUses > 0 && inv.Uses >= inv.MaxUses:
+		return uses + ", used up"
+	case !inv.Expires.IsZero() && !time.Now().Before(inv.Expires):
+		return uses + ", expired"
+	case !inv.Expires.IsZero():
+		return uses + ", expires " + inv.Expires.Local().Format("2 Jan 15:04")
+	}
+	return uses + ", doesn't expire"
+}
+
+// newInviteCode makes a code to hand out.
+func newInviteCode() string {
+	var b [18]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// --- Server side ---
+
+// inviteForLocked is the invite a registration gives the code of, nil
+// for none, checking the server lets it register.
+func (srv *server) inviteForLocked(code string, now time.Time) (*invite, error) {
+	if code == "" {
+		if srv.inviteOnly {
+			return nil, errInviteRequired
+		}
+		return nil, nil
+	}
+	inv, ok := srv.invites[tokenHash(strings.TrimSpace(code))]
+	if !ok || !inv.usableAt(now) {
+		return nil, errBadInvite
+	}
+	return &inv, nil
+}
+
+// redeemLocked counts a use of inv by s registering, and remembers it for
+// the handshake to join its channel. The account stands even if the count
+// can't be saved.
+func (srv *server) redeemLocked(s *session, inv invite) {
+	inv.Uses++
+	srv.invites[inv.Hash] = inv
+	s.persistLocked(func(st serverStore) error { return st.saveInvite(inv) })
+	srv.publish(clusterEvent{Kind: clusterInvite, Invite: &inv})
+	s.invite = &inv
+}
+
+// joinInvited puts s, just registered with an invite for a channel, in
+// it, invited by whoever made the invite.
+func (srv *server) joinInvited(s *session, inv *invite) {
+	inviter := &session{srv: srv, conn: discardFrameConn(), nick: inv.By, stamp: s.stamp}
+	f := newFrame(frameInvite, memberRequest{Channel: inv.Channel, Nick: s.nick})
+	if err := srv.handleInvite(inviter, f); err != nil {
+		log.Printf("invites: putting %s in %s for %s: %v", s.nick, inv.Channel, inv.ID, err)
+		s.conn.write(frame{Type: frameError, Error: "The invite couldn't put you in " + inv.Channel + ": " + err.Error()})
+		return
+	}
+	srv.replicate(inviter, f)
+}
+
+// handleCreateInvite makes an invite code for a server admin.
+func (srv *server) handleCreateInvite(s *session, f frame) error {
+	var req inviteRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	switch {
+	case req.MaxUses < 0:
+		return errors.New("invites can't have fewer than no uses")
+	case req.TTL < 0:
+		return errors.New("invites can't expire in the past")
+	}
+	if !srv.isAdmin(s.nick) {
+		return errNotServerAdmin
+	}
+
+	code := newInviteCode()
+	hash := tokenHash(code)
+	inv := invite{Hash: hash, ID: hash[:inviteIDLength], Channel: req.Channel, MaxUses: req.MaxUses,
+		By: s.nick, Created: s.stamp.Time}
+	if req.TTL > 0 {
+		inv.Expires = s.stamp.Time.Add(req.TTL)
+	}
+
+	srv.mu.Lock()
+	if inv.Channel != "" {
+		ch, ok := srv.channels[inv.Channel]
+		if !ok {
+			srv.mu.Unlock()
+			return errNoSuchChannel
+		}
+		// Whoever registers is invited by s, so s must be able to
+		if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permInvite) ||
+			ch.private && srv.roleInLocked(ch, s.nick) < roleModerator {
+			srv.mu.Unlock()
+			return fmt.Errorf("you can only make invites to channels you can invite people to")
+		}
+	}
+	if len(srv.invites) >= maxInvites {
+		srv.mu.Unlock()
+		return fmt.Errorf("the server has %d invites already, revoke some first", maxInvites)
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveInvite(inv) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	srv.invites[hash] = inv
+	srv.mu.Unlock()
+	srv.publish(clusterEvent{Kind: clusterInvite, Invite: &inv})
+
+	inv.Hash = ""
+	s.reply(f, newFrame(frameInviteCreated, inviteCreated{Code: code, Invite: inv}))
+	return nil
+}
+
+// invitesLocked lists the invites, newest first, without their hashes.
+func (srv *server) invitesLocked() inviteList {
+	list := inviteList{Invites: []invite{}}
+	for _, inv := range srv.invites {
+		inv.Hash = ""
+		list.Invites = append(list.Invites, inv)
+	}
+	slices.SortFunc(list.Invites, func(a, b invite) int { return b.Created.Compare(a.Created) })
+	return list
+}
+
+func (srv *server) handleInvites(s *session, f frame) error {
+	if !srv.isAdmin(s.nick) {
+		return errNotServerAdmin
+	}
+	srv.mu.Lock()
+	list := srv.invitesLocked()
+	srv.mu.Unlock()
+	s.reply(f, newFrame(frameInviteList, list))
+	return nil
+}
+
+// handleRevokeInvite deletes an invite, by its ID, and replies with the
+// ones left.
+func (srv *server) handleRevokeInvite(s *session, f frame) error {
+	var req inviteRef
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if !srv.isAdmin(s.nick) {
+		return errNotServerAdmin
+	}
+
+	srv.mu.Lock()
+	var found *invite
+	for _, inv := range srv.invites {
+		if inv.ID == req.ID {
+			found = &inv
+			break
+		}
+	}
+	if found == nil {
+		srv.mu.Unlock()
+		return errNoSuchInvite
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.deleteInvite(found.Hash) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	delete(srv.invites, found.Hash)
+	list := srv.invitesLocked()
+	srv.mu.Unlock()
+	srv.publish(clusterEvent{Kind: clusterInviteRevoked, Invite: found})
+
+	s.reply(f, newFrame(frameInviteList, list))
+	return nil
+}
+
+// --- Client side ---
+
+// revokeInviteMsg asks to revoke an invite.
+type revokeInviteMsg struct{ id string }
+
+// cmdInvites opens the invite list, or with "create" makes an invite, the
+// rest of the arguments being its number of uses, how long it lasts and
+// its channel in any order.
+func cmdInvites(m *model, args string) tea.Cmd {
+	if m.serverRole() < roleAdmin {
+		m.notice("Only server admins manage invites")
+		return nil
+	}
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return m.request(frameInvites, nil)
+	}
+	if fields[0] != "create" {
+		m.notice("Usage: /invites [create [uses] [duration like 24h or 7d] [#channel]]")
+		return nil
+	}
+	var req inviteRequest
+	for _, arg := range fields[1:] {
+		if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+			req.MaxUses = n
+		} else if d, ok := parseModDuration(arg); ok {
+			req.TTL = d
+		} else if strings.HasPrefix(arg, "#") {
+			req.Channel = arg
+		} else {
+			m.notice("Usage: /invites create [uses] [duration like 24h or 7d] [#channel]")
+			return nil
+		}
+	}
+	return m.request(frameCreateInvite, req)
+}
+
+// showInviteCreated tells the admin the code of a new invite, the only
+// time it is shown.
+func (m *model) showInviteCreated(d inviteCreated) {
+	where := ""
+	if d.Invite.Channel != "" {
+		where = " to " + d.Invite.Channel
+	}
+	m.notice(fmt.Sprintf("Invite %s%s (%s): %s", d.Invite.ID, where, d.Invite.state(), d.Code))
+	m.notice("New users register with it by running: gochat --server " + m.storeNetwork() + " --invite " + d.Code)
+}
+
+// showInvites opens the invite list, or refreshes it if it is open.
+func (m *model) showInvites(list inviteList) {
+	if v, ok := m.overlay.(*inviteManager); ok {
+		v.invites = list.Invites
+		v.cursor = min(v.cursor, max(len(v.invites)-1, 0))
+		return
+	}
+	m.overlay = &inviteManager{invites: list.Invites}
+}
+
+// inviteManager is the overlay listing the server's invites, for admins
+// to revoke.
+type inviteManager struct {
+	invites []invite
+	cursor  int
+}
+
+func (v *inviteManager) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return v, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		v.cursor = max(v.cursor-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		v.cursor = min(v.cursor+1, max(len(v.invites)-1, 0))
+	case key.Matches(keyMsg, keys.Remove):
+		if len(v.invites) == 0 {
+			return v, nil
+		}
+		revoke := revokeInviteMsg{id: v.invites[v.cursor].ID}
+		return v, func() tea.Msg { return revoke }
+	}
+	return v, nil
+}
+
+func (v *inviteManager) View(width, height int) string {
+	w := min(64, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Invites (%d)", len(v.invites))), ""}
+	if len(v.invites) == 0 {
+		lines = append(lines, overlayHintStyle.Render("None, /invites create makes one"))
+	}
+	for i, inv := range v.invites {
+		name := inv.ID
+		if inv.Channel != "" {
+			name += " to " + inv.Channel
+		}
+		by := "by " + inv.By
+		row := lipgloss.NewStyle().Width(inner-lipgloss.Width(by)).Render(truncate(name, inner-lipgloss.Width(by)-1)) + by
+		if i == v.cursor {
+			row = overlaySelectedStyle.Width(inner).Render(row)
+		}
+		lines = append(lines, row, overlayHintStyle.Render("  "+truncate(inv.state(), inner-2)))
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ invite • x revoke • esc close"))
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// --- CLI ---
+
+// inviteCommand is "gochat invite create|list|revoke", logging in to the
+// server as an admin.
+func inviteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Create, list and revoke invite codes on a server, as an admin",
+	}
+	var req inviteRequest
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Make an invite code, shown only now",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, _ []string) error {
+			return runInvite(c, newFrame(frameCreateInvite, req))
+		},
+	}
+	create.Flags().IntVar(&req.MaxUses, "max-uses", 0, "let the code be used `n` times, 0 for any")
+	create.Flags().DurationVar(&req.TTL, "ttl", 0, "let the code be used for `duration`, 0 for ever")
+	create.Flags().StringVar(&req.Channel, "channel", "", "also put whoever registers with it in `#channel`")
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List invites with their uses and expiry",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, _ []string) error {
+			return runInvite(c, newFrame(frameInvites, nil))
+		},
+	}
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke an invite",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runInvite(c, newFrame(frameRevokeInvite, inviteRef{ID: args[0]}))
+		},
+	}
+	for _, sub := range []*cobra.Command{create, list, revoke} {
+		sub.Flags().String("server", "", "the server at `addr` (default the first configured)")
+		sub.Flags().String("nick", "", "admin nick to log in as (default $USER)")
+		cmd.AddCommand(sub)
+	}
+	return cmd
+}
+
+// runInvite logs in to the server of cmd's flags and makes the invite
+// request f.
+func runInvite(cmd *cobra.Command, f frame) error {
+	server, _ := cmd.Flags().GetString("server")
+	nick, _ := cmd.Flags().GetString("nick")
+
+	st, _ := loadSettings()
+	target := findServer(server, st.Servers)
+	if server == "" && len(st.Servers) > 0 {
+		target = st.Servers[0]
+	}
+	if target.Addr == "" {
+		return errors.New("--server is required")
+	}
+	who := nick
+	if who == "" {
+		who = os.Getenv("USER")
+	}
+	password := envPassword()
+	if password == "" {
+		var err error
+		if password, err = askPassword("Password for " + who + " on " + target.Addr + ": "); err != nil {
+			return err
+		}
+	}
+	r, err := adminRequest(target, credentials{nick: who, password: password}, f)
+	if err != nil {
+		return err
+	}
+
+	if r.Type == frameInviteCreated {
+		var d inviteCreated
+		if err := r.decode(&d); err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", d.Invite.ID, d.Invite.state())
+		fmt.Println(d.Code)
+		return nil
+	}
+	var list inviteList
+	if err := r.decode(&list); err != nil {
+		return err
+	}
+	for _, inv := range list.Invites {
+		fmt.Printf("%s\t%s\tby %s\t%s\n", inv.ID, inv.Channel, inv.By, inv.state())
+	}
+	return nil
+}
+
+// adminRequest logs in to srv and makes one request, returning the reply.
+func adminRequest(srv serverSettings, creds credentials, f frame) (frame, error) {
+	nc, err := dialServer(srv)
+	if err != nil {
+		return frame{}, err
+	}
+	conn := newFrameConn(nc)
+	defer conn.close()
+	if err := conn.write(newFrame(frameHello, helloData{Nick: creds.nick, Password: creds.password, Client: clientName()})); err != nil {
+		return frame{}, err
+	}
+	if r, err := conn.read(); err != nil {
+		return frame{}, err
+	} else if r.Type != frameWelcome {
+		return frame{}, fmt.Errorf("handshake failed: %s", r.Error)
+	}
+
+	f.ID = "1"
+	if err := conn.write(f); err != nil {
+		return frame{}, err
+	}
+	// Skip whatever else the server sends until our answer
+	for {
+		r, err := conn.read()
+		if err != nil {
+			return frame{}, err
+		}
+		if r.ID != f.ID {
+			continue
+		}
+		if r.Type == frameError {
+			return frame{}, errors.New(r.Error)
+		}
+		return r, nil
+	}
+}
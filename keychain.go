@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// With "keychain" in settings.json the client keeps its secrets in the OS
+// keychain (the macOS Keychain, the Secret Service of GNOME Keyring or
+// KWallet, or the Windows Credential Manager) rather than in the store in
+// the config directory: session tokens, the private halves of our
+// encryption and signing keys, and with encrypt_store the store's
+// passphrase, so it isn't asked for. The store only keeps keychainRef in
+// their place. Secrets stored before it was turned on move over the first
+// time they are loaded. Login passwords are only kept there if the setup
+// wizard was told to (see setup.go), never anywhere else.
+
+const (
+	// keychainService is what gochat's items are filed under.
+	keychainService = "gochat"
+	// keychainRef stands in the store for a secret kept in the keychain.
+	keychainRef = "keychain:"
+)
+
+// keychainStore is a store whose secrets are read from and written to the
+// keychain.
+type keychainStore struct {
+	store
+}
+
+// newKeychainStore wraps s, if the keychain can be used.
+func newKeychainStore(s store) (*keychainStore, error) {
+	if _, err := keyring.Get(keychainService, "probe"); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return nil, fmt.Errorf(`"keychain" is set in settings.json, but the OS keychain can't be used: %w`, err)
+	}
+	return &keychainStore{store: s}, nil
+}
+
+// keychainGet is the secret of item, "" if there is none.
+func keychainGet(item string) (string, error) {
+	secret, err := keyring.Get(keychainService, item)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	return secret, err
+}
+
+// keychainDelete forgets the secret of item, if there is one.
+func keychainDelete(item string) error {
+	if err := keyring.Delete(keychainService, item); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func sessionItem(network string) string { return "session " + network }
+
+func (s *keychainStore) saveSession(network, nick string, tokens sessionTokens) error {
+	if tokens.Refresh == "" {
+		if err := keychainDelete(sessionItem(network)); err != nil {
+			return err
+		}
+		return s.store.saveSession(network, nick, tokens)
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(keychainService, sessionItem(network), string(data)); err != nil {
+		return err
+	}
+	return s.store.saveSession(network, nick, sessionTokens{Refresh: keychainRef})
+}
+
+func (s *keychainStore) loadSession(network string) (string, sessionTokens, error) {
+	nick, tokens, err := s.store.loadSession(network)
+	if err != nil || tokens.Refresh == "" {
+		return nick, tokens, err
+	}
+	if tokens.Refresh != keychainRef {
+		return nick, tokens, s.saveSession(network, nick, tokens)
+	}
+	data, err := keychainGet(sessionItem(network))
+	if err != nil || data == "" {
+		// Gone from the keychain, so log in again
+		return nick, sessionTokens{}, err
+	}
+	var t sessionTokens
+	err = json.Unmarshal([]byte(data), &t)
+	return nick, t, err
+}
+
+// keyItem names the keychain item of the private half of a key, kind
+// being "e2e" or "signing".
+func keyItem(kind, network, public string) string {
+	return kind + " " + network + " " + public
+}
+
+// saveKeyWith stores k with save, its private half in the keychain.
+func saveKeyWith(kind, network string, k storedKey, save func(string, storedKey) error) error {
+	if k.private != "" && k.private != keychainRef {
+		if err := keyring.Set(keychainService, keyItem(kind, network, k.public), k.private); err != nil {
+			return err
+		}
+		k.private = keychainRef
+	}
+	return save(network, k)
+}
+
+// loadKeysWith fills in the private halves of keys from the keychain,
+// moving ones still in the store there first. Own keys whose private
+// half is gone are left out.
+func loadKeysWith(kind, network string, keys []storedKey, save func(string, storedKey) error) ([]storedKey, error) {
+	var loaded []storedKey
+	for _, k := range keys {
+		switch k.private {
+		case "":
+		case keychainRef:
+			private, err := keychainGet(keyItem(kind, network, k.public))
+			if err != nil {
+				return nil, err
+			}
+			if private == "" {
+				continue
+			}
+			k.private = private
+		default:
+			if err := saveKeyWith(kind, network, k, save); err != nil {
+				return nil, err
+			}
+		}
+		loaded = append(loaded, k)
+	}
+	return loaded, nil
+}
+
+func (s *keychainStore) saveKey(network string, k storedKey) error {
+	return saveKeyWith("e2e", network, k, s.store.saveKey)
+}
+
+func (s *keychainStore) loadKeys(network string) ([]storedKey, error) {
+	keys, err := s.store.loadKeys(network)
+	if err != nil {
+		return nil, err
+	}
+	return loadKeysWith("e2e", network, keys, s.store.saveKey)
+}
+
+func (s *keychainStore) saveSigningKey(network string, k storedKey) error {
+	return saveKeyWith("signing", network, k, s.store.saveSigningKey)
+}
+
+func (s *keychainStore) loadSigningKeys(network string) ([]storedKey, error) {
+	keys, err := s.store.loadSigningKeys(network)
+	if err != nil {
+		return nil, err
+	}
+	return loadKeysWith("signing", network, keys, s.store.saveSigningKey)
+}
+
+// storePassphraseItem holds the passphrase of an encrypted store.
+const storePassphraseItem = "store passphrase"
+
+// keychainPassphrase asks for the store passphrase with ask unless the
+// keychain has it. remember keeps the one that unlocked the store.
+func keychainPassphrase(ask passphraseFunc) (p passphraseFunc, remember func() error) {
+	var given string
+	fromKeychain, tried := false, 0
+	p = func(confirm bool, attempt int) (string, error) {
+		if !confirm && attempt == 0 {
+			if saved, err := keychainGet(storePassphraseItem); err == nil && saved != "" {
+				given, fromKeychain, tried = saved, true, 1
+				return saved, nil
+			}
+		}
+		var err error
+		// A wrong one from the keychain doesn't count as a try
+		given, err = ask(confirm, attempt-tried)
+		fromKeychain = false
+		return given, err
+	}
+	remember = func() error {
+		if fromKeychain || given == "" {
+			return nil
+		}
+		return keyring.Set(keychainService, storePassphraseItem, given)
+	}
+	return p, remember
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ? opens the key help, as does f1 in the composer and the search box,
+// where ? is typed: every binding, as [keys] in the config file left it,
+// grouped by where it works. Bindings turned off don't show, and those
+// remapped show their new keys.
+
+// keyHelpScroll is how far page up and down move the key help.
+const keyHelpScroll = 10
+
+// keyHelpEntry is a binding in the key help, with desc saying what it does
+// there if not its own.
+type keyHelpEntry struct {
+	b    key.Binding
+	desc string
+}
+
+// keyHelpGroup is the bindings that work in one part of the UI.
+type keyHelpGroup struct {
+	title   string
+	entries []keyHelpEntry
+}
+
+// keyHelpGroups are the key help's groups, from the current bindings.
+func keyHelpGroups() []keyHelpGroup {
+	return []keyHelpGroup{
+		{"Anywhere", []keyHelpEntry{
+			{b: keys.Help}, {b: keys.Quit}, {b: keys.SwitchFocus}, {b: keys.QuickSwitch},
+			{b: keys.SelectTab}, {b: keys.RecentBack}, {b: keys.RecentForward},
+			{b: keys.Networks}, {b: keys.Activity}, {b: keys.PrevMention}, {b: keys.NextMention},
+			{b: keys.PageUp}, {b: keys.PageDown},
+		}},
+		{"Message pane", []keyHelpEntry{
+			{keys.Up, "select an earlier message"}, {keys.Down, "select a later message"},
+			{keys.Reply, "reply to the selected message"}, {keys.Cancel, "back to the composer"},
+			{b: keys.SplitVertical}, {b: keys.SplitHorizontal}, {b: keys.ClosePane},
+			{b: keys.SplitLess}, {b: keys.SplitMore},
+		}},
+		{"Composer", []keyHelpEntry{
+			{b: keys.Send}, {keys.Cancel, "stop replying"},
+		}},
+		{"Search box", []keyHelpEntry{
+			{keys.Send, "search all history"}, {b: keys.SearchBuffer},
+			{b: keys.SearchRegex}, {b: keys.SearchFuzzy},
+		}},
+		{"Sidebars, from anywhere", []keyHelpEntry{
+			{b: keys.Favorite}, {b: keys.MoveUp}, {b: keys.MoveDown}, {b: keys.Collapse},
+			{b: keys.NotifyPrefs}, {b: keys.NotifyMode}, {b: keys.ChannelInfo}, {b: keys.ManageMembers},
+			{b: keys.ToggleMembers}, {b: keys.ShrinkLeft}, {b: keys.GrowLeft},
+			{b: keys.ShrinkRight}, {b: keys.GrowRight},
+		}},
+		{"Lists and forms", []keyHelpEntry{
+			{b: keys.Up}, {b: keys.Down}, {b: keys.Select}, {b: keys.Cancel},
+			{b: keys.Left}, {b: keys.Right}, {b: keys.NextField}, {b: keys.PrevField},
+			{b: keys.Toggle}, {b: keys.Invite}, {b: keys.Reply}, {b: keys.Remove},
+			{b: keys.Dismiss}, {b: keys.Mute}, {b: keys.Ban},
+		}},
+	}
+}
+
+// keyHelp is the overlay listing the key bindings.
+type keyHelp struct {
+	groups []keyHelpGroup
+	offset int // first line shown
+}
+
+func newKeyHelp() *keyHelp {
+	return &keyHelp{groups: keyHelpGroups()}
+}
+
+func (h *keyHelp) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return h, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel), key.Matches(keyMsg, keys.Help):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		h.offset--
+	case key.Matches(keyMsg, keys.Down):
+		h.offset++
+	case key.Matches(keyMsg, keys.PageUp):
+		h.offset -= keyHelpScroll
+	case key.Matches(keyMsg, keys.PageDown):
+		h.offset += keyHelpScroll
+	}
+	h.offset = max(h.offset, 0)
+	return h, nil
+}
+
+// lines are the key help's lines, the keys in a column inner wide.
+func (h *keyHelp) lines(inner int) []string {
+	keyWidth := 0
+	for _, g := range h.groups {
+		for _, e := range g.entries {
+			keyWidth = max(keyWidth, lipgloss.Width(e.b.Help().Key))
+		}
+	}
+	keyWidth = min(keyWidth+2, inner/2)
+	keyStyle := overlayPromptStyle.Width(keyWidth)
+
+	var lines []string
+	for _, g := range h.groups {
+		var rows []string
+		for _, e := range g.entries {
+			if !e.b.Enabled() {
+				continue
+			}
+			desc := e.desc
+			if desc == "" {
+				desc = e.b.Help().Desc
+			}
+			rows = append(rows, keyStyle.Render(truncate(e.b.Help().Key, keyWidth-1))+truncate(desc, inner-keyWidth))
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, overlayPromptStyle.Bold(true).Render(g.title))
+		lines = append(lines, rows...)
+	}
+	return lines
+}
+
+func (h *keyHelp) View(width, height int) string {
+	w := min(64, width-4)
+	inner := w - 2
+
+	lines := h.lines(inner)
+	// The title, the hint and the box take six rows, and two are left over
+	rows := max(height-8, 3)
+	h.offset = min(h.offset, max(len(lines)-rows, 0))
+	shown := lines[h.offset:min(h.offset+rows, len(lines))]
+
+	hint := firstKey(keys.Up) + "/" + firstKey(keys.Down) + " scroll • " + firstKey(keys.Cancel) + " close"
+	if h.offset+len(shown) < len(lines) {
+		hint += " • more below"
+	}
+	out := []string{overlayTitleStyle.Render("Keys"), ""}
+	out = append(out, shown...)
+	out = append(out, "", overlayHintStyle.Render(truncate(hint, inner)))
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, out...))
+}
+
+// firstKey is the first of the keys b's help names.
+func firstKey(b key.Binding) string {
+	k, _, _ := strings.Cut(b.Help().Key, "/")
+	return cmp.Or(k, b.Help().Key)
+}
@@ -0,0 +1,267 @@
+package main
+
+import "github.com/charmbracelet/bubbles/key"
+
+// keyMap holds every global keybinding. Update matches against these instead
+// of raw key strings so bindings can be listed and remapped in one place.
+type keyMap struct {
+	Quit          key.Binding
+	Help          key.Binding
+	SwitchFocus   key.Binding
+	ToggleMembers key.Binding
+	QuickSwitch   key.Binding
+	SelectTab     key.Binding
+	Send          key.Binding
+	Favorite      key.Binding
+	MoveUp        key.Binding
+	MoveDown      key.Binding
+	Collapse      key.Binding
+	NotifyPrefs   key.Binding
+	NotifyMode    key.Binding
+	ManageMembers key.Binding
+	PrevMention   key.Binding
+	Activity      key.Binding
+	ChannelInfo   key.Binding
+	Networks      key.Binding
+	RecentBack    key.Binding
+	RecentForward key.Binding
+	NextMention   key.Binding
+	SearchBuffer  key.Binding
+	SearchRegex   key.Binding
+	SearchFuzzy   key.Binding
+
+	// Panes
+	SplitVertical   key.Binding
+	SplitHorizontal key.Binding
+	ClosePane       key.Binding
+	PageUp          key.Binding
+	PageDown        key.Binding
+
+	// Resizing
+	ShrinkLeft  key.Binding
+	GrowLeft    key.Binding
+	ShrinkRight key.Binding
+	GrowRight   key.Binding
+	SplitLess   key.Binding
+	SplitMore   key.Binding
+
+	// Overlay / list navigation
+	Up        key.Binding
+	Down      key.Binding
+	Select    key.Binding
+	Cancel    key.Binding
+	Left      key.Binding
+	Right     key.Binding
+	NextField key.Binding
+	PrevField key.Binding
+	Toggle    key.Binding
+	Invite    key.Binding
+	Remove    key.Binding
+	Reply     key.Binding
+	Dismiss   key.Binding
+	Mute      key.Binding
+	Ban       key.Binding
+}
+
+var keys = keyMap{
+	Quit: key.NewBinding(
+		key.WithKeys("ctrl+c"),
+		key.WithHelp("ctrl+c", "quit"),
+	),
+	Help: key.NewBinding(
+		key.WithKeys("?", "f1"),
+		key.WithHelp("?/f1", "show these keys"),
+	),
+	SwitchFocus: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "cycle focus"),
+	),
+	ToggleMembers: key.NewBinding(
+		key.WithKeys("alt+m"),
+		key.WithHelp("alt+m", "toggle member list"),
+	),
+	QuickSwitch: key.NewBinding(
+		key.WithKeys("ctrl+k"),
+		key.WithHelp("ctrl+k", "quick switcher"),
+	),
+	SelectTab: key.NewBinding(
+		key.WithKeys("alt+1", "alt+2", "alt+3", "alt+4", "alt+5", "alt+6", "alt+7", "alt+8", "alt+9"),
+		key.WithHelp("alt+1..9", "go to buffer"),
+	),
+	Favorite: key.NewBinding(
+		key.WithKeys("alt+f"),
+		key.WithHelp("alt+f", "star/unstar buffer"),
+	),
+	MoveUp: key.NewBinding(
+		key.WithKeys("alt+up"),
+		key.WithHelp("alt+↑", "move buffer up"),
+	),
+	MoveDown: key.NewBinding(
+		key.WithKeys("alt+down"),
+		key.WithHelp("alt+↓", "move buffer down"),
+	),
+	Collapse: key.NewBinding(
+		key.WithKeys("alt+c"),
+		key.WithHelp("alt+c", "collapse/expand category"),
+	),
+	NotifyPrefs: key.NewBinding(
+		key.WithKeys("alt+n"),
+		key.WithHelp("alt+n", "notification preferences"),
+	),
+	NotifyMode: key.NewBinding(
+		key.WithKeys("alt+N"),
+		key.WithHelp("alt+N", "cycle notifications: all, mentions, DMs, nothing"),
+	),
+	ManageMembers: key.NewBinding(
+		key.WithKeys("alt+M"),
+		key.WithHelp("alt+M", "manage channel members"),
+	),
+	PrevMention: key.NewBinding(
+		key.WithKeys("alt+,"),
+		key.WithHelp("alt+,", "previous mention"),
+	),
+	NextMention: key.NewBinding(
+		key.WithKeys("alt+."),
+		key.WithHelp("alt+.", "next mention"),
+	),
+	Activity: key.NewBinding(
+		key.WithKeys("alt+a"),
+		key.WithHelp("alt+a", "activity center"),
+	),
+	ChannelInfo: key.NewBinding(
+		key.WithKeys("alt+i"),
+		key.WithHelp("alt+i", "channel info"),
+	),
+	Networks: key.NewBinding(
+		key.WithKeys("alt+w"),
+		key.WithHelp("alt+w", "switch network"),
+	),
+	RecentBack: key.NewBinding(
+		key.WithKeys("alt+b"),
+		key.WithHelp("alt+b", "previous buffer (repeat to go further back)"),
+	),
+	RecentForward: key.NewBinding(
+		key.WithKeys("alt+B"),
+		key.WithHelp("alt+B", "step forward through recent buffers"),
+	),
+	Send: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "send message"),
+	),
+	SearchBuffer: key.NewBinding(
+		key.WithKeys("alt+enter"),
+		key.WithHelp("alt+enter", "search this buffer"),
+	),
+	SearchRegex: key.NewBinding(
+		key.WithKeys("ctrl+r"),
+		key.WithHelp("ctrl+r", "toggle regex search"),
+	),
+	SearchFuzzy: key.NewBinding(
+		key.WithKeys("ctrl+t"),
+		key.WithHelp("ctrl+t", "toggle fuzzy search"),
+	),
+	SplitVertical: key.NewBinding(
+		key.WithKeys("alt+v"),
+		key.WithHelp("alt+v", "split side by side"),
+	),
+	SplitHorizontal: key.NewBinding(
+		key.WithKeys("alt+s"),
+		key.WithHelp("alt+s", "split stacked"),
+	),
+	ClosePane: key.NewBinding(
+		key.WithKeys("alt+o"),
+		key.WithHelp("alt+o", "close other pane"),
+	),
+	PageUp: key.NewBinding(
+		key.WithKeys("pgup"),
+		key.WithHelp("pgup", "scroll up"),
+	),
+	PageDown: key.NewBinding(
+		key.WithKeys("pgdown"),
+		key.WithHelp("pgdown", "scroll down"),
+	),
+	ShrinkLeft: key.NewBinding(
+		key.WithKeys("alt+["),
+		key.WithHelp("alt+[", "shrink left sidebar"),
+	),
+	GrowLeft: key.NewBinding(
+		key.WithKeys("alt+]"),
+		key.WithHelp("alt+]", "grow left sidebar"),
+	),
+	ShrinkRight: key.NewBinding(
+		key.WithKeys("alt+}"),
+		key.WithHelp("alt+}", "shrink member list"),
+	),
+	GrowRight: key.NewBinding(
+		key.WithKeys("alt+{"),
+		key.WithHelp("alt+{", "grow member list"),
+	),
+	SplitLess: key.NewBinding(
+		key.WithKeys("alt+-"),
+		key.WithHelp("alt+-", "move split divider back"),
+	),
+	SplitMore: key.NewBinding(
+		key.WithKeys("alt+="),
+		key.WithHelp("alt+=", "move split divider forward"),
+	),
+	Up: key.NewBinding(
+		key.WithKeys("up", "ctrl+p"),
+		key.WithHelp("↑/ctrl+p", "up"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "ctrl+n"),
+		key.WithHelp("↓/ctrl+n", "down"),
+	),
+	Select: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "select"),
+	),
+	Cancel: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "close"),
+	),
+	Left: key.NewBinding(
+		key.WithKeys("left", "h"),
+		key.WithHelp("←/h", "left"),
+	),
+	Right: key.NewBinding(
+		key.WithKeys("right", "l"),
+		key.WithHelp("→/l", "right"),
+	),
+	NextField: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "next field"),
+	),
+	PrevField: key.NewBinding(
+		key.WithKeys("shift+tab"),
+		key.WithHelp("shift+tab", "previous field"),
+	),
+	Toggle: key.NewBinding(
+		key.WithKeys(" "),
+		key.WithHelp("space", "toggle"),
+	),
+	Invite: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "invite"),
+	),
+	Reply: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "reply"),
+	),
+	Remove: key.NewBinding(
+		key.WithKeys("x", "delete"),
+		key.WithHelp("x", "remove"),
+	),
+	Dismiss: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "dismiss"),
+	),
+	Mute: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "mute"),
+	),
+	Ban: key.NewBinding(
+		key.WithKeys("b"),
+		key.WithHelp("b", "ban"),
+	),
+}
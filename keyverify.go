@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// /verify in a DM shows a fingerprint of our key and the peer's as seven
+// emoji with their names, the same on both sides. Comparing them in person
+// or on a call, and confirming they match, marks the peer's key verified:
+// the DM shows a check after its lock. If the key of a verified peer
+// changes, the DM says so with a warning in place of the lock until they
+// are verified again.
+
+// fingerprintEmoji are what a fingerprint is shown with, six bits each.
+var fingerprintEmoji = [64]struct{ emoji, name string }{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"🌈", "Rainbow"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"💜", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs up"}, {"🍩", "Doughnut"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light bulb"}, {"📕", "Book"}, {"🧦", "Socks"},
+	{"📎", "Paperclip"}, {"🧲", "Magnet"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"📞", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"🛶", "Canoe"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+const fingerprintLength = 7
+
+// fingerprint is what two keys are compared by, the same whichever side
+// computes it.
+func fingerprint(a, b *[32]byte) []int {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte("gochat e2e fingerprint\x00"))
+	h.Write(a[:])
+	h.Write(b[:])
+	bits := binary.BigEndian.Uint64(h.Sum(nil))
+	out := make([]int, fingerprintLength)
+	for i := range out {
+		out[i] = int(bits >> (64 - 6*(i+1)) & 63)
+	}
+	return out
+}
+
+// keyVerifiedMsg confirms that the fingerprint with peer's key matched.
+type keyVerifiedMsg struct {
+	peer string
+	key  [32]byte
+}
+
+// verifiedState is whether the peer of a DM is verified with the key they
+// have now, and whether instead one of their earlier keys was.
+func (m *model) verifiedState(ch *channel) (verified, changed bool) {
+	e := m.e2e()
+	if !ch.isDM() || e == nil {
+		return false, false
+	}
+	peer := ch.dmPeerNick()
+	key := e.peers[peer]
+	if key == nil {
+		return false, false
+	}
+	if e.verified[*key] {
+		return true, false
+	}
+	for k, nick := range e.trusted {
+		if nick == peer && e.verified[k] {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// keyUnverified is why nothing is sealed for ch: its peer's key changed
+// since they were verified. It is empty if it didn't.
+func (m *model) keyUnverified(ch *channel) string {
+	if _, changed := m.verifiedState(ch); changed {
+		return ch.dmPeerNick() + "'s encryption key changed since you verified them, /verify them again to send"
+	}
+	return ""
+}
+
+// markVerified marks key verified as peer's, unless it was replaced while
+// the fingerprint was up, and sends what waited on it.
+func (m *model) markVerified(msg keyVerifiedMsg) tea.Cmd {
+	e := m.e2e()
+	dm := m.channelByName("@" + msg.peer)
+	if e == nil || dm == nil {
+		return nil
+	}
+	if key := e.peers[msg.peer]; key == nil || *key != msg.key {
+		m.noticeIn(dm, msg.peer+"'s key changed meanwhile, /verify them again")
+		return nil
+	}
+	e.verified[msg.key] = true
+	m.persist(func(s store, network string) error { return s.verifyKey(network, encodeKey(&msg.key)) })
+	m.noticeIn(dm, "✓ "+msg.peer+" is verified")
+	return m.resendPendingIn(dm)
+}
+
+func cmdVerify(m *model, _ string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || !ch.isDM() {
+		m.notice("Keys are verified in a DM, open one first")
+		return nil
+	}
+	e := m.e2e()
+	peer := ch.dmPeerNick()
+	if e == nil || e.peers[peer] == nil || len(e.own[m.nick]) == 0 {
+		m.notice(peer + " has no encryption key yet, so there is nothing to verify")
+		return m.lookupKey(peer)
+	}
+	own := e.own[m.nick]
+	verified, _ := m.verifiedState(ch)
+	m.overlay = &keyVerifier{peer: peer, key: *e.peers[peer], verified: verified,
+		fingerprint: fingerprint(own[len(own)-1].public, e.peers[peer])}
+	return nil
+}
+
+// keyVerifier is the overlay comparing fingerprints with one peer.
+type keyVerifier struct {
+	peer        string
+	key         [32]byte // theirs
+	fingerprint []int
+	verified    bool // already
+}
+
+func (v *keyVerifier) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return v, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Select):
+		if v.verified {
+			return nil, nil
+		}
+		done := keyVerifiedMsg{peer: v.peer, key: v.key}
+		return nil, func() tea.Msg { return done }
+	}
+	return v, nil
+}
+
+func (v *keyVerifier) View(width, height int) string {
+	w := min(76, width-4)
+	inner := w - 2
+	col := lipgloss.NewStyle().Width(10).Align(lipgloss.Center)
+
+	var cells []string
+	for _, i := range v.fingerprint {
+		e := fingerprintEmoji[i]
+		cells = append(cells, col.Render(e.emoji+"\n"+overlayHintStyle.Render(e.name)))
+	}
+	row := lipgloss.JoinHorizontal(lipgloss.Top, cells...)
+	if lipgloss.Width(row) > inner {
+		// Too narrow for one row, one per line then
+		var lines []string
+		for _, i := range v.fingerprint {
+			lines = append(lines, "  "+fingerprintEmoji[i].emoji+"  "+fingerprintEmoji[i].name)
+		}
+		row = strings.Join(lines, "\n")
+	}
+
+	hint := "enter they match • esc cancel"
+	status := "Check that " + v.peer + " sees the same, in person or on a call. Only confirm if every one matches."
+	if v.verified {
+		hint = "esc close"
+		status = "✓ " + v.peer + " is verified with this key."
+	}
+	lines := []string{
+		overlayTitleStyle.Render("Verify " + v.peer),
+		"",
+		lipgloss.NewStyle().Width(inner).Render(status),
+		"",
+		row,
+		"",
+		overlayHintStyle.Render(hint),
+	}
+	return overlayStyle.Width(w).Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxKVKeys and maxKVValue bound what one account can keep on the
+	// server.
+	maxKVKeys  = 64
+	maxKVValue = 64 << 10
+	maxKVKey   = 64
+)
+
+var errKVTooLarge = errors.New("synced values are limited to 64 keys of 64 KiB")
+
+// --- Server side ---
+
+// handleKVSet stores one of the caller's synced values and passes it on to
+// their other sessions. The newest write wins; a stale one is answered
+// with the value the server has, so the sender catches up.
+func (srv *server) handleKVSet(s *session, f frame) error {
+	var req kvData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if req.Key == "" || len(req.Key) > maxKVKey || len(req.Value) > maxKVValue {
+		return errKVTooLarge
+	}
+
+	srv.mu.Lock()
+	store := srv.kv[s.nick]
+	if store == nil {
+		store = make(map[string]kvData)
+		srv.kv[s.nick] = store
+	}
+	cur, ok := store[req.Key]
+	if !ok && len(store) >= maxKVKeys {
+		srv.mu.Unlock()
+		return errKVTooLarge
+	}
+	if ok && cur.Updated.After(req.Updated) {
+		srv.mu.Unlock()
+		s.conn.write(newFrame(frameKV, cur))
+		return nil
+	}
+	store[req.Key] = req
+	var others []*session
+	for _, sess := range srv.sessionsOfLocked(s.nick) {
+		if sess != s {
+			others = append(others, sess)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, sess := range others {
+		sess.conn.write(newFrame(frameKV, req))
+	}
+	return nil
+}
+
+// sendKVSnapshot sends a new session all of its account's synced values.
+func (srv *server) sendKVSnapshot(s *session) {
+	srv.mu.Lock()
+	snap := kvSnapshot{Entries: []kvData{}}
+	for _, e := range srv.kv[s.nick] {
+		snap.Entries = append(snap.Entries, e)
+	}
+	srv.mu.Unlock()
+	s.conn.write(newFrame(frameKVSnapshot, snap))
+}
+
+// --- Client side ---
+
+// syncedValue is a piece of client state that follows the account across
+// devices, kept on the server under its key.
+type syncedValue struct {
+	get func(m *model) any
+	set func(m *model, data json.RawMessage) error
+}
+
+var syncedValues = map[string]syncedValue{}
+
+func registerSyncedValue(key string, v syncedValue) {
+	syncedValues[key] = v
+}
+
+func init() {
+	registerSyncedValue("account", syncedValue{
+		get: func(m *model) any {
+			acct := *m.account()
+			acct.Drafts, acct.Starred = nil, nil
+			return acct
+		},
+		set: func(m *model, data json.RawMessage) error {
+			var v accountSettings
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			acct := m.account()
+			v.Drafts, v.Starred = acct.Drafts, acct.Starred
+			*acct = v
+			m.applyChannelOrder()
+			return nil
+		},
+	})
+	registerSyncedValue("drafts", syncedValue{
+		get: func(m *model) any { return m.syncedDrafts() },
+		set: func(m *model, data json.RawMessage) error {
+			var v map[string]string
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			m.applySyncedDrafts(v)
+			return nil
+		},
+	})
+	registerSyncedValue("starred", syncedValue{
+		get: func(m *model) any { return m.account().Starred },
+		set: func(m *model, data json.RawMessage) error {
+			var v []starredMessage
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			m.account().Starred = v
+			return nil
+		},
+	})
+	registerSyncedValue("prefs", syncedValue{
+		get: func(m *model) any {
+			return syncedPrefs{Layout: m.settings.Layout, StatusFormat: m.settings.StatusFormat}
+		},
+		set: func(m *model, data json.RawMessage) error {
+			var v syncedPrefs
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			v.Layout.clamp()
+			m.settings.Layout, m.settings.StatusFormat = v.Layout, v.StatusFormat
+			m.recalcLayout()
+			return nil
+		},
+	})
+}
+
+// syncedPrefs are the UI preferences that follow the account around.
+type syncedPrefs struct {
+	Layout       layoutSettings `json:"layout"`
+	StatusFormat string         `json:"status_format,omitempty"`
+}
+
+// seeSyncedValues records the synced values as they are, so syncSettings
+// only picks up later changes.
+func (m *model) seeSyncedValues() {
+	m.kvSeen = make(map[string]string, len(syncedValues))
+	for key, v := range syncedValues {
+		if data, err := json.Marshal(v.get(m)); err == nil {
+			m.kvSeen[key] = string(data)
+		}
+	}
+}
+
+// syncSettings notices synced values that changed locally since they were
+// last seen, records when, saves the settings and sends the new values to
+// the server. It runs after every update.
+func (m *model) syncSettings() tea.Cmd {
+	// Mid-drag the layout changes on every mouse move, wait for the drop
+	if m.background || m.kvSeen == nil || m.dragging != dragNone {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for key, v := range syncedValues {
+		data, err := json.Marshal(v.get(m))
+		if err != nil || string(data) == m.kvSeen[key] {
+			continue
+		}
+		m.kvSeen[key] = string(data)
+		if m.settings.Synced == nil {
+			m.settings.Synced = make(map[string]time.Time)
+		}
+		m.settings.Synced[key] = time.Now().UTC()
+		cmds = append(cmds, m.sendKV(key, data))
+	}
+	if len(cmds) > 0 {
+		cmds = append(cmds, saveSettingsCmd(m.settings))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) sendKV(key string, data []byte) tea.Cmd {
+	if m.client == nil || m.client.guest || m.background {
+		return nil
+	}
+	_, cmd := m.client.send(frameKVSet, kvData{Key: key, Value: data, Updated: m.settings.Synced[key]})
+	return cmd
+}
+
+// applyKV takes a synced value from the server, unless ours is newer.
+func (m *model) applyKV(e kvData) {
+	v, ok := syncedValues[e.Key]
+	if !ok || !e.Updated.After(m.settings.Synced[e.Key]) {
+		return
+	}
+	if err := v.set(m, e.Value); err != nil {
+		m.lastErr = err
+		return
+	}
+	if m.settings.Synced == nil {
+		m.settings.Synced = make(map[string]time.Time)
+	}
+	m.settings.Synced[e.Key] = e.Updated
+	if data, err := json.Marshal(v.get(m)); err == nil && m.kvSeen != nil {
+		m.kvSeen[e.Key] = string(data)
+	}
+}
+
+// applyKVSnapshot merges the server's values on connecting, and sends it
+// whatever we changed more recently (or it doesn't have yet).
+func (m *model) applyKVSnapshot(snap kvSnapshot) tea.Cmd {
+	have := make(map[string]time.Time, len(snap.Entries))
+	for _, e := range snap.Entries {
+		m.applyKV(e)
+		have[e.Key] = e.Updated
+	}
+	var cmds []tea.Cmd
+	for key, v := range syncedValues {
+		updated, ok := have[key]
+		if ok && !m.settings.Synced[key].After(updated) {
+			continue
+		}
+		if !ok && m.settings.Synced[key].IsZero() {
+			// Never changed here either, let the first device to change it
+			// decide
+			continue
+		}
+		if data, err := json.Marshal(v.get(m)); err == nil {
+			cmds = append(cmds, m.sendKV(key, data))
+		}
+	}
+	return tea.Batch(append(cmds, saveSettingsCmd(m.settings))...)
+}
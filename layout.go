@@ -0,0 +1,147 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	sidebarMinWidth = 12
+	sidebarMaxWidth = 40
+	splitMinRatio   = 0.2
+	splitMaxRatio   = 0.8
+
+	resizeStep      = 2
+	splitRatioStep  = 0.05
+	dragBorderSlack = 1 // how many cells either side of a border start a drag
+)
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func (l *layoutSettings) clamp() {
+	l.LeftWidth = clampInt(l.LeftWidth, sidebarMinWidth, sidebarMaxWidth)
+	l.RightWidth = clampInt(l.RightWidth, sidebarMinWidth, sidebarMaxWidth)
+	if l.SplitRatio < splitMinRatio {
+		l.SplitRatio = splitMinRatio
+	}
+	if l.SplitRatio > splitMaxRatio {
+		l.SplitRatio = splitMaxRatio
+	}
+}
+
+// rect is a screen region recorded while rendering, used to map mouse
+// coordinates back onto the layout.
+type rect struct {
+	x, y, w, h int
+}
+
+func (r rect) contains(x, y int) bool {
+	return x >= r.x && x < r.x+r.w && y >= r.y && y < r.y+r.h
+}
+
+// dragTarget is the border currently being dragged with the mouse.
+type dragTarget int
+
+const (
+	dragNone dragTarget = iota
+	dragLeftSidebar
+	dragRightSidebar
+	dragSplit
+)
+
+// sidebarChrome is what the sidebar border adds to the content width.
+func sidebarChrome() int {
+	return leftSidebarStyle.GetHorizontalBorderSize()
+}
+
+func (m *model) leftSidebarRenderedWidth() int {
+	return m.settings.Layout.LeftWidth + sidebarChrome()
+}
+
+func (m *model) rightSidebarRenderedWidth() int {
+	if !m.membersVisible() {
+		return 0
+	}
+	return m.settings.Layout.RightWidth + sidebarChrome()
+}
+
+// resize applies a layout change and persists it.
+func (m *model) resize(apply func(l *layoutSettings)) tea.Cmd {
+	apply(&m.settings.Layout)
+	m.settings.Layout.clamp()
+	m.recalcLayout()
+	return saveSettingsCmd(m.settings)
+}
+
+func (m *model) splitDividerX() int {
+	return m.mainArea.x + int(float64(m.mainArea.w)*m.settings.Layout.SplitRatio)
+}
+
+func (m *model) splitDividerY() int {
+	return m.mainArea.y + int(float64(m.mainArea.h)*m.settings.Layout.SplitRatio)
+}
+
+func near(a, b int) bool {
+	d := a - b
+	return d >= -dragBorderSlack && d <= dragBorderSlack
+}
+
+// handleMouseResize implements dragging the sidebar and split borders. It
+// reports whether the event was consumed.
+func (m *model) handleMouseResize(msg tea.MouseMsg) (bool, tea.Cmd) {
+	switch msg.Action {
+	case tea.MouseActionPress:
+		if msg.Button != tea.MouseButtonLeft {
+			return false, nil
+		}
+		leftEdge := m.leftSidebarRenderedWidth()
+		rightEdge := m.width - m.rightSidebarRenderedWidth()
+		switch {
+		case near(msg.X, leftEdge-1):
+			m.dragging = dragLeftSidebar
+		case m.membersVisible() && near(msg.X, rightEdge):
+			m.dragging = dragRightSidebar
+		case len(m.panes) > 1 && m.split == splitVertical && m.mainArea.contains(msg.X, msg.Y) && near(msg.X, m.splitDividerX()):
+			m.dragging = dragSplit
+		case len(m.panes) > 1 && m.split == splitHorizontal && m.mainArea.contains(msg.X, msg.Y) && near(msg.Y, m.splitDividerY()):
+			m.dragging = dragSplit
+		default:
+			return false, nil
+		}
+		return true, nil
+
+	case tea.MouseActionMotion:
+		switch m.dragging {
+		case dragLeftSidebar:
+			m.settings.Layout.LeftWidth = msg.X + 1 - sidebarChrome()
+		case dragRightSidebar:
+			m.settings.Layout.RightWidth = m.width - msg.X - sidebarChrome()
+		case dragSplit:
+			if m.split == splitHorizontal && m.mainArea.h > 0 {
+				m.settings.Layout.SplitRatio = float64(msg.Y-m.mainArea.y) / float64(m.mainArea.h)
+			} else if m.mainArea.w > 0 {
+				m.settings.Layout.SplitRatio = float64(msg.X-m.mainArea.x) / float64(m.mainArea.w)
+			}
+		default:
+			return false, nil
+		}
+		m.settings.Layout.clamp()
+		m.recalcLayout()
+		return true, nil
+
+	case tea.MouseActionRelease:
+		if m.dragging == dragNone {
+			return false, nil
+		}
+		m.dragging = dragNone
+		return true, saveSettingsCmd(m.settings)
+	}
+	return false, nil
+}
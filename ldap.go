@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// A server started with --ldap-url takes its accounts from an LDAP directory,
+// such as Active Directory, instead of its own: a login binds to the
+// directory as the user with their password, and registering is off. The
+// user is found by searching --ldap-base-dn with --ldap-user-filter, bound as
+// --ldap-bind-dn if the directory doesn't allow anonymous searches.
+//
+// The groups a user is in (their memberOf) give them server roles (see
+// roles.go), with --ldap-role role=groupDN, looked up again whenever their session is
+// renewed, which also ends it once they are gone from the directory.
+// Accounts from before it was turned on keep their password.
+
+const ldapTimeout = 10 * time.Second
+
+var (
+	errNoRegistration = errors.New("accounts come from the directory on this server, log in with yours")
+	errNotInDirectory = errors.New("not in the directory")
+)
+
+// --- Server side ---
+
+// directory is where a server's accounts come from instead of its own
+// database.
+type directory interface {
+	// authenticate checks the password of nick and returns their groups,
+	// or errBadLogin
+	authenticate(nick, password string) ([]string, error)
+	// lookup returns the groups of nick, or errNotInDirectory
+	lookup(nick string) ([]string, error)
+}
+
+// ldapDirectory is a directory on an LDAP server.
+type ldapDirectory struct {
+	url          string
+	startTLS     bool
+	bindDN       string // empty to search anonymously
+	bindPassword string
+	baseDN       string
+	userFilter   string // with %s for the nick
+}
+
+// dial connects and binds with the search account.
+func (d *ldapDirectory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.url)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if d.startTLS {
+		u, _ := url.Parse(d.url) // DialURL parsed it already
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if d.bindDN != "" {
+		err = conn.Bind(d.bindDN, d.bindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("binding as %s: %w", cmp.Or(d.bindDN, "anonymous"), err)
+	}
+	return conn, nil
+}
+
+// find looks nick up, returning their DN and groups.
+func (d *ldapDirectory) find(conn *ldap.Conn, nick string) (string, []string, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(d.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout/time.Second), false, fmt.Sprintf(d.userFilter, ldap.EscapeFilter(nick)),
+		[]string{"memberOf"}, nil))
+	switch {
+	case err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		return "", nil, err
+	case err != nil || len(res.Entries) > 1:
+		return "", nil, fmt.Errorf("more than one entry in the directory matches %s", nick)
+	case len(res.Entries) == 0:
+		return "", nil, errNotInDirectory
+	}
+	entry := res.Entries[0]
+	return entry.DN, entry.GetAttributeValues("memberOf"), nil
+}
+
+func (d *ldapDirectory) authenticate(nick, password string) ([]string, error) {
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	dn, groups, err := d.find(conn, nick)
+	if errors.Is(err, errNotInDirectory) {
+		return nil, errBadLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	// An empty password would be an unauthenticated bind, which succeeds
+	if password == "" {
+		return nil, errBadLogin
+	}
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errBadLogin
+		}
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (d *ldapDirectory) lookup(nick string) ([]string, error) {
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, groups, err := d.find(conn, nick)
+	return groups, err
+}
+
+// groupRole gives the members of a directory group a server role.
+type groupRole struct {
+	role  string
+	group *ldap.DN
+}
+
+// parseGroupRole parses a --ldap-role value, role=groupDN.
+func parseGroupRole(v string) (groupRole, error) {
+	role, group, ok := strings.Cut(v, "=")
+	if !ok {
+		return groupRole{}, errors.New("want role=groupDN")
+	}
+	if _, err := parseRole(role); err != nil {
+		return groupRole{}, err
+	}
+	dn, err := ldap.ParseDN(group)
+	if err != nil {
+		return groupRole{}, fmt.Errorf("%s: %w", group, err)
+	}
+	return groupRole{role: role, group: dn}, nil
+}
+
+// rolesOf are the roles groups give, sorted.
+func (srv *server) rolesOf(groups []string) []string {
+	var roles []string
+	for _, g := range groups {
+		dn, err := ldap.ParseDN(g)
+		if err != nil {
+			continue
+		}
+		for _, gr := range srv.groupRoles {
+			if gr.group.EqualFold(dn) && !slices.Contains(roles, gr.role) {
+				roles = append(roles, gr.role)
+			}
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// authenticateDirectory logs s in with the directory and starts a session.
+func (srv *server) authenticateDirectory(s *session, password string) (*sessionTokens, error) {
+	groups, err := srv.directory.authenticate(s.nick, password)
+	if err != nil {
+		return nil, err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if err := srv.syncDirectoryAccountLocked(s, groups); err != nil {
+		return nil, err
+	}
+	return srv.issueTokensLocked(s)
+}
+
+// recheckDirectory makes sure a user from the directory is still in it
+// before their session is renewed, and picks up changes to their groups.
+func (srv *server) recheckDirectory(s *session) error {
+	srv.mu.Lock()
+	acct := srv.accounts[s.nick]
+	srv.mu.Unlock()
+	if !acct.Directory || srv.directory == nil {
+		return nil
+	}
+	groups, err := srv.directory.lookup(s.nick)
+	if errors.Is(err, errNotInDirectory) {
+		return errSessionExpired
+	}
+	if err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.syncDirectoryAccountLocked(s, groups)
+}
+
+// syncDirectoryAccountLocked records s.nick as a user of the directory in
+// groups, keeping the nick theirs and their roles known to every instance.
+func (srv *server) syncDirectoryAccountLocked(s *session, groups []string) error {
+	old, exists := srv.accounts[s.nick]
+	if exists && !old.Directory {
+		// Registered here meanwhile
+		return errBadLogin
+	}
+	acct := account{Directory: true, Roles: srv.rolesOf(groups), Created: old.Created}
+	if !exists {
+		acct.Created = s.stamp.Time
+	}
+	if exists && slices.Equal(acct.Roles, old.Roles) {
+		return nil
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveAccount(s.nick, acct) }); err != nil {
+		return err
+	}
+	srv.accounts[s.nick] = acct
+	srv.publish(clusterEvent{Kind: clusterAccount, Nick: s.nick, Account: &acct})
+	return nil
+}
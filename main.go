@@ -1,16 +1,321 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// gochat is one binary: "gochat connect" (or just "gochat") runs the
+// client, "gochat serve" a server, and the rest are tools around them.
+// Every flag can also come from a GOCHAT_ variable named after it,
+// GOCHAT_RETAIN_DAYS for --retain-days, and flags and variables both win
+// over the config file (see config.go). The old single-dash flags, and
+// "gochat -serve addr", still work.
+
 func main() {
-	m := initialModel()
-	if _, err := tea.NewProgram(&m, tea.WithAltScreen()).Run(); err != nil {
-		fmt.Println("Error running program:", err)
+	if err := rootCommand().Execute(); err != nil {
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 }
+
+// rootCommand is gochat's command line, taking its arguments from os.Args.
+func rootCommand() *cobra.Command {
+	root := connectCommand()
+	root.Use = "gochat"
+	root.Short = "A terminal chat client and server"
+	root.Long = "A terminal chat client and server. Without a command, gochat connects\n" +
+		"like \"gochat connect\". Every flag can also be set with a GOCHAT_ variable\n" +
+		"named after it, GOCHAT_NICK for --nick, and both win over the config file."
+	root.Args = cobra.NoArgs
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		return flagsFromEnv(cmd)
+	}
+	root.AddCommand(connectCommand(), serveCommand(), exportCommand(), importCommand(), inviteCommand(), doctorCommand())
+	root.SetArgs(legacyArgs(os.Args[1:]))
+	return root
+}
+
+// flagsFromEnv sets the flags of cmd not given on the command line from
+// their GOCHAT_ variables.
+func flagsFromEnv(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "help" || err != nil {
+			return
+		}
+		name := flagEnv(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if serr := cmd.Flags().Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("$%s: %w", name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// flagEnv is the variable that sets the flag name.
+func flagEnv(name string) string {
+	return "GOCHAT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// legacyArgs turns the single-dash flags gochat used to take into double-
+// dash ones, and a -serve flag into the serve command.
+func legacyArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && !(arg[1] >= '0' && arg[1] <= '9') {
+			arg = "-" + arg
+		}
+		out = append(out, arg)
+	}
+	if len(out) == 0 || !strings.HasPrefix(out[0], "-") {
+		return out
+	}
+	for i, arg := range out {
+		if addr, ok := strings.CutPrefix(arg, "--serve="); ok {
+			return append([]string{"serve", addr}, append(out[:i:i], out[i+1:]...)...)
+		}
+		if arg == "--serve" && i+1 < len(out) {
+			return append([]string{"serve", out[i+1]}, append(out[:i:i], out[i+2:]...)...)
+		}
+	}
+	return out
+}
+
+// connectCommand is "gochat connect", the client.
+func connectCommand() *cobra.Command {
+	var opts options
+	cmd := &cobra.Command{
+		Use:   "connect [profile | addr...]",
+		Short: "Connect to chat servers, those in the config file by default",
+		Long: "Connect to chat servers: the addresses given, each optionally named as\n" +
+			"name=addr and tls:// for TLS, else the config file's, else settings.json's.\n" +
+			"A name alone, with no port, picks that profile from the config file.",
+	}
+	fs := cmd.Flags()
+	configFile := fs.String("config", "", "read the client's config from the TOML or YAML `file` (default config.toml in gochat's config directory)")
+	server := fs.String("server", "", "connect to the chat servers at `addrs` (comma-separated, like the arguments)")
+	fs.StringVar(&opts.nick, "nick", "", "nick to use (default $USER)")
+	fs.StringVar(&opts.invite, "invite", "", "register a new account with the invite `code`")
+	fs.BoolVar(&opts.guest, "guest", false, "come in as a guest, without an account, where servers allow it")
+	fs.StringVar(&opts.profile, "profile", "", "use the config file's profile `name`, its servers, nick and theme")
+	fs.BoolVar(&opts.plain, "plain", false, "write a plain transcript and read lines to post, for screen readers and braille displays")
+	cmd.RunE = func(_ *cobra.Command, args []string) error {
+		if len(args) == 1 && *server == "" && !strings.ContainsAny(args[0], ":=") {
+			// No port, so not an address
+			opts.profile = args[0]
+			args = nil
+		}
+		opts.servers = parseServers(strings.Join(append([]string{*server}, args...), ","))
+		return runClient(opts, *configFile)
+	}
+	return cmd
+}
+
+// runClient runs the client until it is quit.
+func runClient(opts options, configFile string) error {
+	opts.config = configFile
+	cfg, err := loadConfig(configFile)
+	if err == nil {
+		cfg, err = cfg.withProfile(opts.profile)
+	}
+	if err == nil {
+		err = cfg.apply(&opts)
+	}
+	if err != nil {
+		return fmt.Errorf("in the config file: %w", err)
+	}
+	m := initialModel(opts)
+	if opts.plain {
+		err = runPlain(&m)
+	} else {
+		programOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithReportFocus()}
+		if opts.ui.Mouse == nil || *opts.ui.Mouse {
+			programOpts = append(programOpts, tea.WithMouseCellMotion())
+		}
+		_, err = tea.NewProgram(&m, programOpts...).Run()
+	}
+	if m.store != nil {
+		m.store.close()
+	}
+	if m.search != nil {
+		m.search.close()
+	}
+	m.logs.close()
+	m.configWatch.close()
+	if err != nil {
+		return fmt.Errorf("running program: %w", err)
+	}
+	return nil
+}
+
+// serveCommand is "gochat serve", a chat server.
+func serveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve [addr]",
+		Short: "Run a chat server, on :6667 unless given another address",
+		Args:  cobra.MaximumNArgs(1),
+	}
+	fs := cmd.Flags()
+	var keep retention
+	fs.IntVar(&keep.Days, "retain-days", 0, "drop messages older than `n` days")
+	fs.IntVar(&keep.Messages, "retain-messages", 0, "keep only the newest `n` messages per channel")
+	guestChannels := fs.String("guest-channels", "", "let people in without an account, as guests, to the comma-separated public `channels`")
+	guestPost := fs.Bool("guest-post", false, "with --guest-channels, let guests post there, not only read")
+	inviteOnly := fs.Bool("invite-only", false, "only let people register with an invite code (see gochat invite)")
+	privateEdits := fs.Bool("private-edits", false, "show earlier versions of edited messages only to their authors and moderators")
+	cost := defaultArgonParams
+	fs.Func("argon2-memory", fmt.Sprintf("hash passwords using `KiB` of memory (default %d)", cost.Memory), func(v string) error {
+		_, err := fmt.Sscan(v, &cost.Memory)
+		return err
+	})
+	fs.Func("argon2-time", fmt.Sprintf("hash passwords in `n` passes (default %d)", cost.Time), func(v string) error {
+		_, err := fmt.Sscan(v, &cost.Time)
+		return err
+	})
+	fs.Func("argon2-threads", fmt.Sprintf("hash passwords on `n` threads (default %d)", cost.Threads), func(v string) error {
+		_, err := fmt.Sscan(v, &cost.Threads)
+		return err
+	})
+	tlsCert := fs.String("tls-cert", "", "take clients over TLS with the PEM certificate (chain) in `file`")
+	tlsKey := fs.String("tls-key", "", "with --tls-cert, the PEM private key in `file`")
+	oidcIssuer := fs.String("oidc-issuer", "", "let users sign in with the OpenID Connect provider at `url`")
+	oidcClient := fs.String("oidc-client-id", "", "with --oidc-issuer, the `id` this server is registered with at the provider")
+	oidcSecret := fs.String("oidc-client-secret", "", "with --oidc-issuer, the client `secret`, if the provider gave one")
+	ldapDir := &ldapDirectory{}
+	var groupRoles []groupRole
+	fs.StringVar(&ldapDir.url, "ldap-url", "", "take accounts from the LDAP directory at `url` (ldap:// or ldaps://)")
+	fs.BoolVar(&ldapDir.startTLS, "ldap-starttls", false, "with --ldap-url, upgrade ldap:// connections with StartTLS")
+	fs.StringVar(&ldapDir.bindDN, "ldap-bind-dn", "", "with --ldap-url, search the directory as `dn` rather than anonymously")
+	fs.StringVar(&ldapDir.bindPassword, "ldap-bind-password", "", "with --ldap-bind-dn, its `password`")
+	fs.StringVar(&ldapDir.baseDN, "ldap-base-dn", "", "with --ldap-url, look users up under `dn`")
+	fs.StringVar(&ldapDir.userFilter, "ldap-user-filter", "(uid=%s)", "with --ldap-url, the `filter` that finds a user, %s being the nick")
+	fs.Func("ldap-role", "with --ldap-url, give members of a group a server role, as `role=groupDN` (repeatable)", func(v string) error {
+		gr, err := parseGroupRole(v)
+		groupRoles = append(groupRoles, gr)
+		return err
+	})
+	admins := fs.String("admins", "", "make the comma-separated `nicks` server admins, who can also export and erase users' data")
+	var roleFlags, permissionFlags []string
+	fs.Func("role", "give nicks a server role, as `role=nick,...` (repeatable)", func(v string) error {
+		roleFlags = append(roleFlags, v)
+		return nil
+	})
+	defaultRole := fs.String("default-role", "member", "the server `role` of everyone not given one, member or guest")
+	fs.Func("permissions", "set what a role may do, as `role=perm,...` of post, invite, pin, delete-others, manage-channels and moderate (repeatable)", func(v string) error {
+		permissionFlags = append(permissionFlags, v)
+		return nil
+	})
+	flood := newFloodGuard()
+	fs.Func("ban-ip", "refuse connections from the comma-separated `addrs` and CIDR ranges (repeatable)", flood.banAddrs)
+	fs.Float64Var(&flood.connRate, "max-conn-rate", defaultConnRate, "let each address open `n` connections a minute, 0 for any")
+	fs.Float64Var(&flood.frameRate, "max-frame-rate", defaultFrameRate, "let each connection send `n` requests a second, 0 for any")
+	fs.DurationVar(&flood.banFor, "flood-ban", defaultFloodBan, "ban addresses that go over those limits for `duration`, 0 to only disconnect them")
+	var filterFlags []string
+	fs.Func("filter", "check channel messages against a regexp, as `action=regexp` with action block, redact or flag (repeatable)", func(v string) error {
+		filterFlags = append(filterFlags, v)
+		return nil
+	})
+	db := fs.String("db", "", "keep channels and history in the PostgreSQL database at `url`")
+	redisURL := fs.String("redis", "", "share fan-out and presence with other servers through the Redis server at `url`")
+
+	cmd.RunE = func(_ *cobra.Command, args []string) error {
+		addr := ":6667"
+		if len(args) == 1 {
+			addr = args[0]
+		}
+		srv := newServer()
+		srv.retention = keep
+		srv.privateEdits = *privateEdits
+		srv.inviteOnly = *inviteOnly
+		for _, name := range strings.Split(*guestChannels, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !channelNameRE.MatchString(name) {
+				return fmt.Errorf("--guest-channels: %w", errBadChannel)
+			}
+			srv.guestChannels = append(srv.guestChannels, name)
+		}
+		srv.guestPost = *guestPost
+		if err := cost.validate(); err != nil {
+			return err
+		}
+		srv.setPasswordCost(cost)
+		srv.flood = flood
+		roleFlags = append([]string{"admin=" + *admins}, roleFlags...)
+		for _, v := range roleFlags {
+			if err := srv.giveRole(v); err != nil {
+				return fmt.Errorf("--role: %w", err)
+			}
+		}
+		for _, v := range permissionFlags {
+			if err := srv.setRolePermissions(v); err != nil {
+				return fmt.Errorf("--permissions: %w", err)
+			}
+		}
+		for _, v := range filterFlags {
+			if err := srv.addFilter(v); err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+		}
+		r, err := parseRole(*defaultRole)
+		if err != nil || r > roleMember {
+			return errors.New("--default-role is member or guest")
+		}
+		srv.defaultRole = r
+		if *tlsCert != "" {
+			conf, err := loadServerTLS(*tlsCert, *tlsKey)
+			if err != nil {
+				return fmt.Errorf("loading the TLS certificate: %w", err)
+			}
+			srv.tls = conf
+		}
+		if *oidcIssuer != "" {
+			p, err := discoverOIDC(*oidcIssuer, *oidcClient, *oidcSecret)
+			if err != nil {
+				return fmt.Errorf("setting up single sign-on: %w", err)
+			}
+			srv.oidc = p
+		}
+		if ldapDir.url != "" {
+			if strings.Count(ldapDir.userFilter, "%s") != 1 {
+				return errors.New("--ldap-user-filter needs one %s for the nick")
+			}
+			srv.directory = ldapDir
+			srv.groupRoles = groupRoles
+		}
+		if *db != "" {
+			st, err := openPostgresStore(*db)
+			if err != nil {
+				return fmt.Errorf("opening database: %w", err)
+			}
+			defer st.close()
+			srv.store = st
+			if err := srv.load(); err != nil {
+				return fmt.Errorf("loading from the database: %w", err)
+			}
+		}
+		if *redisURL != "" {
+			bus, err := openRedisBus(*redisURL)
+			if err != nil {
+				return fmt.Errorf("connecting to Redis: %w", err)
+			}
+			defer bus.close()
+			if err := srv.joinCluster(bus); err != nil {
+				return fmt.Errorf("joining the cluster: %w", err)
+			}
+		}
+		return srv.listenAndServe(addr)
+	}
+	return cmd
+}
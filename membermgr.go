@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const memberManagerVisibleRows = 12
+
+// memberActionMsg asks the model to send an invite or remove request.
+type memberActionMsg struct {
+	typ string // frameInvite or frameRemove
+	memberRequest
+}
+
+// memberManager is the overlay listing a channel's members, with invite and
+// remove for those allowed to use them.
+type memberManager struct {
+	channel   string
+	members   []*member
+	myRole    role
+	canInvite bool
+	cursor    int
+	offset    int
+
+	inviting bool // typing a nick to invite
+	input    textinput.Model
+}
+
+func newMemberManager(m *model, ch *channel) *memberManager {
+	ti := newTextInput()
+	ti.Placeholder = "Nick to invite"
+	ti.Prompt = " "
+	ti.PromptStyle = overlayPromptStyle
+
+	mm := &memberManager{channel: ch.name, members: ch.sortedMembers(), input: ti}
+	if me, ok := ch.members[m.nick]; ok {
+		mm.myRole = me.role
+	}
+	mm.canInvite = m.can(ch, permInvite) && (!ch.private || mm.myRole >= roleModerator)
+	return mm
+}
+
+// canRemove reports whether we outrank mem enough to remove them.
+func (mm *memberManager) canRemove(mem *member) bool {
+	return mm.myRole >= roleModerator && mem.role < mm.myRole
+}
+
+func (mm *memberManager) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return mm, nil
+	}
+
+	if mm.inviting {
+		switch {
+		case key.Matches(keyMsg, keys.Cancel):
+			mm.inviting = false
+			mm.input.Blur()
+			mm.input.SetValue("")
+			return mm, nil
+		case key.Matches(keyMsg, keys.Select):
+			nick := mm.input.Value()
+			if nick == "" {
+				return mm, nil
+			}
+			action := memberActionMsg{typ: frameInvite, memberRequest: memberRequest{Channel: mm.channel, Nick: nick}}
+			return nil, func() tea.Msg { return action }
+		}
+		var cmd tea.Cmd
+		mm.input, cmd = mm.input.Update(msg)
+		return mm, cmd
+	}
+
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if mm.cursor > 0 {
+			mm.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if mm.cursor < len(mm.members)-1 {
+			mm.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		if len(mm.members) == 0 {
+			return mm, nil
+		}
+		nick := mm.members[mm.cursor].nick
+		return nil, func() tea.Msg { return openDMMsg{nick: nick} }
+	case key.Matches(keyMsg, keys.Invite):
+		if mm.canInvite {
+			mm.inviting = true
+			return mm, mm.input.Focus()
+		}
+	case key.Matches(keyMsg, keys.Remove):
+		if len(mm.members) == 0 || !mm.canRemove(mm.members[mm.cursor]) {
+			return mm, nil
+		}
+		action := memberActionMsg{typ: frameRemove, memberRequest: memberRequest{Channel: mm.channel, Nick: mm.members[mm.cursor].nick}}
+		return nil, func() tea.Msg { return action }
+	}
+
+	if mm.cursor < mm.offset {
+		mm.offset = mm.cursor
+	}
+	if mm.cursor >= mm.offset+memberManagerVisibleRows {
+		mm.offset = mm.cursor - memberManagerVisibleRows + 1
+	}
+	return mm, nil
+}
+
+func (mm *memberManager) View(width, height int) string {
+	w := 50
+	if width-4 < w {
+		w = width - 4
+	}
+	roleW := 12
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Members of %s (%d)", mm.channel, len(mm.members))), ""}
+	end := mm.offset + memberManagerVisibleRows
+	if end > len(mm.members) {
+		end = len(mm.members)
+	}
+	for i := mm.offset; i < end; i++ {
+		mem := mm.members[i]
+		label := ""
+		if mem.role != roleMember {
+			label = roleLabel(mem.role)
+		}
+		row := presenceDot(mem.presence) + " " +
+			lipgloss.NewStyle().Width(w-2-roleW-2).Render(truncate(mem.nick, w-2-roleW-2)) +
+			overlayHintStyle.Width(roleW).Align(lipgloss.Right).Render(label)
+		if i == mm.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+
+	hint := "enter message"
+	if mm.canInvite {
+		hint += " • i invite"
+	}
+	if mm.myRole >= roleModerator {
+		hint += " • x remove"
+	}
+	hint += " • esc close"
+	if mm.inviting {
+		lines = append(lines, "", mm.input.View())
+		hint = "enter invite • esc back"
+	}
+	lines = append(lines, "", overlayHintStyle.Render(hint))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// roleLabel is the singular name of a role, e.g. "Moderator".
+func roleLabel(r role) string {
+	s := r.String()
+	return s[:len(s)-1]
+}
+
+// openMemberManager shows the member manager for the active channel.
+func (m *model) openMemberManager() {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Member management is only for channels")
+		return
+	}
+	m.overlay = newMemberManager(m, ch)
+}
+
+// memberSidebarAt reports whether a screen position is inside the member
+// sidebar.
+func (m *model) memberSidebarAt(x, y int) bool {
+	return m.membersVisible() && x >= m.leftSidebarRenderedWidth()+m.centerRenderedWidth && y >= appStyle.GetPaddingTop()+rightSidebarStyle.GetMarginTop()
+}
+
+func cmdMembers(m *model, _ string) tea.Cmd {
+	m.openMemberManager()
+	return nil
+}
+
+func cmdInvite(m *model, args string) tea.Cmd {
+	return m.memberCommand(frameInvite, "invite", args)
+}
+
+func cmdRemove(m *model, args string) tea.Cmd {
+	return m.memberCommand(frameRemove, "remove", args)
+}
+
+// memberCommand parses "<nick> [#channel]" for /invite and /remove.
+func (m *model) memberCommand(typ, name, args string) tea.Cmd {
+	nick, rest, _ := strings.Cut(args, " ")
+	nick = strings.TrimPrefix(nick, "@")
+	ch := m.bufferArg(strings.TrimSpace(rest))
+	if nick == "" || ch == nil || ch.isDM() {
+		m.notice("Usage: /" + name + " <nick> [#channel]")
+		return nil
+	}
+	return m.request(typ, memberRequest{Channel: ch.name, Nick: nick})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Below this terminal width the member list is hidden regardless of the
+// toggle, since the center column would otherwise drop under its minimum.
+const membersMinTermWidth = 100
+
+func (m *model) membersVisible() bool {
+	return m.showMembers && m.width >= membersMinTermWidth
+}
+
+func presenceDot(p presence) string {
+	switch p {
+	case presenceOnline:
+		return presenceOnlineStyle.Render("●")
+	case presenceAway:
+		return presenceAwayStyle.Render("●")
+	default:
+		return presenceOfflineStyle.Render("○")
+	}
+}
+
+// renderMemberList renders the members of ch grouped by role, with plain
+// members further grouped by presence. Output is clipped to height lines.
+func renderMemberList(ch *channel, width, height int) string {
+	if ch == nil {
+		return ""
+	}
+
+	var lines []string
+	group := ""
+	for _, mem := range ch.sortedMembers() {
+		g := mem.role.String()
+		if mem.role == roleMember {
+			g = mem.presence.String()
+		}
+		if g != group {
+			if group != "" {
+				lines = append(lines, "")
+			}
+			group = g
+			lines = append(lines, memberGroupStyle.Render(strings.ToUpper(g)))
+		}
+
+		nick := truncate(mem.nick, width-2)
+		nickStyle := memberNickStyle
+		if mem.presence == presenceOffline {
+			nickStyle = memberOfflineNickStyle
+		}
+		lines = append(lines, presenceDot(mem.presence)+" "+nickStyle.Render(nick)+renderStatus(mem, width-2-lipgloss.Width(nick)))
+	}
+
+	if height > 0 && len(lines) > height {
+		hidden := len(lines) - height + 1
+		lines = append(lines[:height-1], memberGroupStyle.Render(fmt.Sprintf("+%d more", hidden)))
+	}
+	return lipgloss.NewStyle().Width(width).Render(strings.Join(lines, "\n"))
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
+const composerPlaceholder = "Type a Message or command (use / for actions)"
+
 type model struct {
 	width               int
 	height              int
@@ -14,28 +25,118 @@ type model struct {
 	inputWidth          int             // textarea character width, for visual line wrapping
 	textInput           textinput.Model // Search bar
 	messageInput        textarea.Model  // Message input
+
+	nick        string
+	channels    []*channel
+	active      int  // index into channels
+	showMembers bool // right sidebar toggle
+
+	panes       []*pane
+	focusedPane int
+	split       splitMode
+	focus       focusArea
+
+	overlay overlay      // modal panel, nil when closed
+	login   *loginScreen // shown instead of the chat while a network needs a password
+	setup   *setupWizard // shown instead of the chat on first run, see setup.go
+
+	settings settings
+	store    store             // nil if the message store couldn't be opened
+	search   *searchIndex      // nil if the search index couldn't be opened
+	logs     *chatLogger       // plain-text logs, see logSettings
+	kvSeen   map[string]string // synced values as last sent or received, by key
+	// configWatch says when the config file changes, see reload.go
+	configWatch *configWatcher
+
+	searchMode searchMode // how search box queries match
+	mainArea   rect       // screen region of the main content, recorded by View
+	dragging   dragTarget
+
+	sidebarRows []sidebarRow  // what each sidebar line shows, recorded by View
+	bellArea    rect          // header bell icon, recorded by View
+	infoArea    rect          // header info icon, recorded by View
+	sidebarDrag string        // buffer being dragged in the sidebar
+	lastErr     error         // most recent background failure, shown in the status line
+	spinner     spinner.Model // loading row animation
+	spinning    bool          // a spinner tick is scheduled
+
+	searchArea   rect   // header search box, recorded by View
+	composerArea rect   // the composer's box, recorded by View
+	tabAreas     []rect // each buffer's tab, recorded by View
+
+	// title and windowNamed are what the terminal's title and the tmux or
+	// screen window name were last set to, see title.go
+	title, windowNamed string
+
+	sound     string    // what to play once the message in hand is handled, see sounds.go
+	lastSound time.Time // when a sound last played
+
+	unfocused       bool         // the terminal reported losing focus
+	desktopNote     *desktopNote // to show once the message in hand is handled, see desktop.go
+	lastDesktopNote time.Time
+
+	// away is whether we told the servers we are away, autoAway whether
+	// that was for lastInput being long ago, see presence.go
+	away, autoAway bool
+	lastInput      time.Time
+
+	typing *typingState // what we last said we are typing in, see typing.go
+
+	summaryDue bool // the unread summary waits for catching up, see summary.go
+
+	replyTo        *message   // message the composer is answering, if any
+	activity       []activity // mentions, replies and reactions, oldest first
+	activityUnseen int        // entries added since the activity center was last opened
+
+	recent     []string // buffer names, most recently used first
+	recentWalk int      // position in recent while stepping through it, 0 when not
+
+	opts          options
+	networks      []*network // configured servers, the shown one at net
+	net           int
+	background    bool          // state swapped in is a network that isn't shown
+	networkArea   rect          // header network switcher, recorded by View
+	client        *client       // nil while offline
+	lag           time.Duration // round trip of the last ping
+	pendingJoin   string        // channel to switch to once its state arrives
+	pendingCreate string        // same, for a channel we asked to create
+	archive       *userArchive  // user data export still coming in, see userdata.go
 }
 
-func initialModel() model {
+// options are the startup settings taken from the command line.
+type options struct {
+	servers []serverSettings // servers to connect to, empty to use the settings file
+	nick    string
+	invite  string // code to register with, see invites.go
+	guest   bool   // come in as a guest, see guests.go
+	config  string // the --config file, empty to look for one
+	profile string // the config file's profile in use, see profiles.go
+	plain   bool   // a transcript instead of the UI, see plain.go
+	ui      uiConfig
+	sounds  soundConfig
+	// highlight is the config file's highlight words, see highlight.go
+	highlight  highlighter
+	quietHours []quietRange // see dnd.go
+	rules      []rule       // see rules.go
+}
+
+func initialModel(opts options) model {
 	// Search Input
-	ti := textinput.New()
+	ti := newTextInput()
 	ti.Placeholder = "Search"
-	ti.Prompt = "\uf002 " //
+	ti.Prompt = searchPlain.prompt()
 	ti.CharLimit = 156
 	ti.Width = 20
-	// Style for search input
-	color240 := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-	ti.PromptStyle = color240
-	ti.PlaceholderStyle = color240
-	ti.TextStyle = color240
-	ti.Cursor.Style = color240
 
 	// Message Input (Textarea)
 	ta := textarea.New()
-	ta.Placeholder = "Type a Message or command (use / for actions)"
+	ta.Cursor.SetMode(cursorMode())
+	ta.Placeholder = composerPlaceholder
 	ta.ShowLineNumbers = false
 	ta.SetHeight(1)
 	ta.Prompt = ""
+	// Enter sends, so newlines need a modifier
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter"))
 	ta.Focus() // Focus message input by default
 
 	// Clear default styles to remove "light white-grey focus"
@@ -43,16 +144,102 @@ func initialModel() model {
 	ta.FocusedStyle.Base = lipgloss.NewStyle()
 	ta.BlurredStyle.CursorLine = lipgloss.NewStyle()
 	ta.BlurredStyle.Base = lipgloss.NewStyle()
+	styleInputs(&ti, &ta)
 
-	return model{
+	nick := opts.nick
+	if nick == "" {
+		nick = os.Getenv("USER")
+	}
+	if nick == "" {
+		nick = "me"
+	}
+
+	// A broken settings file shouldn't keep the client from starting
+	st, _ := loadSettings()
+
+	m := model{
+		opts:         opts,
+		settings:     st,
 		textInput:    ti,
 		messageInput: ta,
+		showMembers:  opts.ui.ShowMembers == nil || *opts.ui.ShowMembers,
+		spinner:      spinner.New(spinner.WithSpinner(spinner.MiniDot)),
+		logs:         newChatLogger(),
+		configWatch:  watchConfig(opts.config),
+		summaryDue:   st.StartupSummary,
+	}
+	var err error
+	if m.store, err = openStore(st); err != nil {
+		m.store = nil
+	}
+	search, fresh, searchErr := openSearchIndex(st.EncryptStore)
+	if searchErr == nil {
+		m.search = search
+	} else if err == nil {
+		err = searchErr
+	}
+
+	m.useServers(opts.servers, nick, fresh)
+	if len(m.networks) == 0 && findConfig(opts.config) == "" && !opts.plain {
+		m.setup = newSetupWizard(nick)
+	}
+	if err != nil {
+		m.lastErr = err
+	}
+	m.seeSyncedValues()
+	return m
+}
+
+// useServers makes the networks those of servers, or of the settings file
+// if there are none, logging in as nick unless a server says otherwise,
+// and loads what the store has for each. fresh says the search index is
+// new and needs what is restored.
+func (m *model) useServers(servers []serverSettings, nick string, fresh bool) {
+	if len(servers) == 0 {
+		servers = m.settings.Servers
+	}
+	m.networks, m.net = nil, 0
+	for _, srv := range servers {
+		name := srv.Name
+		if name == "" {
+			name = srv.Addr
+		}
+		nick, password := cmp.Or(srv.nick, nick), cmp.Or(srv.password, envPassword())
+		m.networks = append(m.networks, &network{
+			name:   name,
+			addr:   srv.Addr,
+			server: srv,
+			err:    checkPins(srv.Pins),
+			creds:  credentials{nick: nick, password: password, register: m.opts.invite != "", invite: m.opts.invite, guest: m.opts.guest},
+			stash:  newNetworkState(nick),
+		})
+	}
+
+	m.loadSessions()
+	m.loadKeys()
+	m.loadSigningKeys()
+	m.loadState(newNetworkState(nick))
+	if len(m.networks) == 0 {
+		m.restoreFromStore()
+		if fresh {
+			m.indexRestored()
+		}
+	}
+	// Fill in every network's stash, leaving the first one shown
+	for i := len(m.networks) - 1; i >= 0; i-- {
+		m.net = i
+		m.loadState(m.networks[i].stash)
+		m.restoreFromStore()
+		if fresh {
+			m.indexRestored()
+		}
+		m.networks[i].stash = m.saveState()
 	}
 }
 
 func (m *model) recalcLayout() {
-	leftSidebarRenderedWidth := 22  // 20 content + 2 border
-	rightSidebarRenderedWidth := 22 // 20 content + 2 border
+	leftSidebarRenderedWidth := m.leftSidebarRenderedWidth()   // content + 2 border
+	rightSidebarRenderedWidth := m.rightSidebarRenderedWidth() // 0 when hidden
 
 	m.centerRenderedWidth = m.width - leftSidebarRenderedWidth - rightSidebarRenderedWidth
 	if m.centerRenderedWidth < 40 {
@@ -60,12 +247,11 @@ func (m *model) recalcLayout() {
 	}
 
 	// Textarea width: same formula as View's messageBox layout
-	// messageBoxStyle has border(2) + padding(2) = 4 extra
-	messageBoxContentWidth := m.centerRenderedWidth - 4
+	// messageBoxStyle has border(2) + padding(2) = 4 extra, by default
+	messageBoxContentWidth := m.centerRenderedWidth - messageBoxStyle.GetHorizontalFrameSize()
 	prompt := "> "
-	icons := " \uee49 \U000F0066"
 	promptW := lipgloss.Width(prompt)
-	iconsW := lipgloss.Width(icons)
+	iconsW := lipgloss.Width(icons.Composer)
 	inputWidth := messageBoxContentWidth - promptW - iconsW - 2
 	if inputWidth < 1 {
 		inputWidth = 1
@@ -74,18 +260,12 @@ func (m *model) recalcLayout() {
 	m.messageInput.SetWidth(inputWidth)
 
 	// Textinput width: same formula as View's header layout
-	headerContentWidth := m.centerRenderedWidth - 4
-	leftSide := lipgloss.JoinHorizontal(lipgloss.Center,
-		logoStyle.String(),
-		channelStyle.String(),
-		dividerStyle.String(),
-		topicStyle.String(),
-		dividerStyle.String(),
-	)
+	headerContentWidth := m.centerRenderedWidth - headerContainerStyle.GetHorizontalFrameSize()
+	leftSide := m.renderHeaderLeft()
 	leftWidth := lipgloss.Width(leftSide)
 
-	bellIcon := iconBoxStyle.Render("\uf0f3")
-	infoIcon := iconBoxStyle.Render("\uf05a")
+	bellIcon := iconBoxStyle.Render(icons.Bell)
+	infoIcon := iconBoxStyle.Render(icons.Info)
 	rightSide := lipgloss.JoinHorizontal(lipgloss.Center, bellIcon, infoIcon)
 	rightWidth := lipgloss.Width(rightSide)
 
@@ -97,32 +277,395 @@ func (m *model) recalcLayout() {
 	m.textInput.Width = searchContentWidth - 2
 }
 
+// styleInputs colors the search bar and the composer in the theme.
+func styleInputs(ti *textinput.Model, ta *textarea.Model) {
+	color240 := lipgloss.NewStyle().Foreground(palette.Dim)
+	ti.PromptStyle = color240
+	ti.PlaceholderStyle = color240
+	ti.TextStyle = color240
+	ti.Cursor.Style = color240
+	ta.FocusedStyle.Placeholder = color240
+	ta.BlurredStyle.Placeholder = color240
+}
+
 func (m *model) Init() tea.Cmd {
-	return tea.Batch(
-		tea.SetWindowTitle("Bubble Tea TUI"),
+	cmds := []tea.Cmd{
+		m.updateTitle(),
 		textinput.Blink,
 		textarea.Blink,
-	)
+	}
+	cmds = append(cmds, m.connectAll(), m.pruneCmd(), m.schedulePrune(), m.waitForConfig())
+	return tea.Batch(cmds...)
+}
+
+// updateBuffer handles keys while a pane has focus.
+func (m *model) updateBuffer(msg tea.KeyMsg) tea.Cmd {
+	p := m.currentPane()
+	if p == nil {
+		return nil
+	}
+	switch {
+	case key.Matches(msg, keys.Up):
+		p.moveSelection(m.channelByName(p.buffer), -1)
+		return m.backfill(p)
+	case key.Matches(msg, keys.Down):
+		p.moveSelection(m.channelByName(p.buffer), 1)
+	case key.Matches(msg, keys.Reply):
+		ch := m.channelByName(p.buffer)
+		if ch != nil && p.selected >= 0 && p.selected < len(ch.messages) {
+			m.startReply(ch, &ch.messages[p.selected])
+		}
+	case key.Matches(msg, keys.Cancel):
+		p.selected = -1
+		p.follow = true
+		m.setFocus(focusComposer)
+	}
+	return nil
+}
+
+// sendComposer runs the composer contents as a command, or posts them to
+// the active buffer. Messages show as pending until the server has them, and
+// without any server are only echoed locally.
+func (m *model) sendComposer() tea.Cmd {
+	text := strings.TrimSpace(m.messageInput.Value())
+	ch := m.activeChannel()
+	if text == "" {
+		return nil
+	}
+	if wait := m.slowWait(ch); wait > 0 && !strings.HasPrefix(text, "/") {
+		// Keep the text for when it may go
+		m.notice(fmt.Sprintf("Slow mode: you can post again in %s", wait.Round(time.Second)))
+		return nil
+	}
+	m.messageInput.Reset()
+	if strings.HasPrefix(text, "/") {
+		return m.runCommand(text)
+	}
+	if ch == nil {
+		return nil
+	}
+	if why := m.postBlocked(ch); why != "" {
+		m.notice(why)
+		return nil
+	}
+	replyTo := ""
+	if m.replyTo != nil && m.replyTo.channel == ch.name {
+		replyTo = m.replyTo.id
+	}
+	m.cancelReply()
+	if m.currentNetwork() != nil {
+		return tea.Batch(m.postMessage(ch, text, replyTo), m.postedIn(ch))
+	}
+	m.handleChatEvent(chatMessageMsg{msg: message{
+		id:      newMessageID(),
+		channel: ch.name,
+		nick:    m.nick,
+		text:    text,
+		time:    time.Now(),
+		replyTo: replyTo,
+	}})
+	return nil
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	back := m.noteInput(msg)
+	_, cmd := m.update(msg)
+	// Whatever happened, buffers scrolled to the bottom are now read,
+	// synced settings that changed go to the server, the title follows and
+	// a message calling for a sound or a notification gets it
+	return m, tea.Batch(back, cmd, m.markVisibleRead(), m.syncSettings(), m.updateTitle(), m.playSound(), m.sendDesktopNote())
+}
+
+func (m *model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
 
+	if m.handleChatEvent(msg) {
+		return m, nil
+	}
+
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && m.setup != nil && !key.Matches(keyMsg, keys.Quit) {
+		m.setup, cmd = m.setup.Update(msg)
+		return m, cmd
+	}
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && m.login != nil && !key.Matches(keyMsg, keys.Quit) {
+		m.login, cmd = m.login.Update(msg)
+		return m, cmd
+	}
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && m.overlay != nil && !key.Matches(keyMsg, keys.Quit) {
+		m.overlay, cmd = m.overlay.Update(msg)
+		return m, cmd
+	}
+	if mouseMsg, ok := msg.(tea.MouseMsg); ok && (m.overlay != nil || m.login != nil || m.setup != nil) {
+		return m, m.overlayMouse(mouseMsg)
+	}
+
 	switch msg := msg.(type) {
+	case netMsg:
+		return m, m.updateNetwork(msg)
+	case tea.FocusMsg:
+		m.unfocused = false
+		return m, nil
+	case tea.BlurMsg:
+		m.unfocused = true
+		return m, nil
+	case desktopClickMsg:
+		m.openDesktopNote(msg)
+		return m, nil
+	case dndEndMsg:
+		return m, m.endDND()
+	case switchNetworkMsg:
+		m.switchNetwork(msg.index)
+		return m, nil
+	case reconnectNetworkMsg:
+		m.switchNetwork(msg.index)
+		if n := m.networks[msg.index]; !n.creds.usable() {
+			m.login = newLoginScreen(n, "")
+			return m, nil
+		}
+		return m, m.connectNetwork(m.networks[msg.index])
+	case loginMsg:
+		return m, m.applyLogin(msg)
+	case setupDoneMsg:
+		return m, m.finishSetup(msg.path)
+	case configChangedMsg:
+		return m, tea.Batch(m.reloadConfig(), m.waitForConfig())
+	case reconnectMsg:
+		if n := msg.n; !n.connecting && n.err != nil && n.creds.usable() && slices.Contains(m.networks, n) {
+			return m, m.connectNetwork(n)
+		}
+		return m, nil
+	case connectedMsg:
+		m.client = msg.c
+		m.nick = msg.nick
+		m.lastErr = nil
+		var refresh tea.Cmd
+		if msg.tokens != nil {
+			refresh = m.applyTokens(msg.c, *msg.tokens)
+		} else if n := msg.c.network; n != nil {
+			refresh = scheduleRefresh(msg.c, n.creds.tokens)
+		}
+		// Keys first, so what is pending for a DM waits for the peer's,
+		// unless the server still has them from before the drop
+		var publish, join tea.Cmd
+		if !msg.resumed {
+			publish, join = m.publishKey(msg.c), m.autojoin(msg.c)
+		}
+		return m, tea.Batch(m.client.listen(), m.ping(), publish, m.resendPending(), refresh, join)
+	case deviceCodeMsg:
+		return m, msg.c.awaitWelcome
+	case refreshTickMsg:
+		return m, m.refreshSession(msg.c)
+	case disconnectedMsg:
+		if m.client != nil {
+			m.client.close()
+			m.client = nil
+		}
+		m.lastErr = msg.err
+		return m, nil
+	case frameMsg:
+		cmd = m.handleFrame(msg.f)
+		if m.client == nil {
+			return m, cmd
+		}
+		return m, tea.Batch(cmd, m.client.listen())
+	case olderMessagesMsg:
+		return m, m.handleOlderMessages(msg)
+	case spinner.TickMsg:
+		return m, m.updateSpinner(msg)
+	case pingTickMsg:
+		if msg.c != m.client {
+			return m, nil
+		}
+		return m, tea.Batch(m.ping(), m.checkIdle())
+	case systemIdleMsg:
+		return m, m.handleSystemIdle(msg)
+	case typingIdleMsg:
+		return m, m.handleTypingIdle(msg)
+	case typingExpiredMsg:
+		return m, nil
+	case keyVerifiedMsg:
+		return m, m.markVerified(msg)
+	case setNotifyLevelMsg:
+		return m, m.setNotifyLevel(msg.buffer, msg.level)
+	case joinChannelMsg:
+		return m, m.joinChannel(msg.name)
+	case createChannelMsg:
+		m.pendingCreate = msg.Name
+		return m, m.request(frameCreate, msg.createData)
+	case switchBufferMsg:
+		m.switchToBuffer(msg.name)
+		return m, nil
+	case openDMMsg:
+		m.openDM(msg.nick)
+		return m, nil
+	case searchJumpMsg:
+		m.jumpToHit(msg.hit)
+		return m, nil
+	case jumpToMessageMsg:
+		if !m.jumpToMessage(msg.channel, msg.id) {
+			m.notice("That message is no longer loaded")
+		}
+		return m, nil
+	case memberActionMsg:
+		return m, m.request(msg.typ, msg.memberRequest)
+	case reportActionMsg:
+		return m, m.request(frameResolve, msg.resolveData)
+	case revokeDeviceMsg:
+		return m, m.request(frameRevokeDevice, deviceRef{ID: msg.id})
+	case revokeInviteMsg:
+		return m, m.request(frameRevokeInvite, inviteRef{ID: msg.id})
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c":
+		if m.handleRecentKey(msg) {
+			return m, nil
+		}
+		switch {
+		case key.Matches(msg, keys.Quit):
 			return m, tea.Quit
-		case "tab":
-			if m.textInput.Focused() {
-				m.textInput.Blur()
-				m.messageInput.Focus()
-			} else {
-				m.messageInput.Blur()
-				m.textInput.Focus()
+		case key.Matches(msg, keys.Help) && !(m.focus != focusBuffer && msg.Type == tea.KeyRunes):
+			// ? is only text in the composer and the search box
+			m.overlay = newKeyHelp()
+			return m, nil
+		case key.Matches(msg, keys.QuickSwitch):
+			m.overlay = newSwitcher(m)
+			return m, nil
+		case key.Matches(msg, keys.Favorite):
+			return m, m.toggleFavorite()
+		case key.Matches(msg, keys.MoveUp):
+			return m, m.moveChannel(-1)
+		case key.Matches(msg, keys.MoveDown):
+			return m, m.moveChannel(1)
+		case key.Matches(msg, keys.PrevMention), key.Matches(msg, keys.NextMention):
+			dir, none := -1, "No earlier mentions"
+			if key.Matches(msg, keys.NextMention) {
+				dir, none = 1, "No later mentions"
 			}
+			if !m.jumpToMention(dir) {
+				m.notice(none)
+				return m, nil
+			}
+			m.setFocus(focusBuffer)
+			return m, nil
+		case key.Matches(msg, keys.Activity):
+			m.overlay = newActivityCenter(m)
+			return m, nil
+		case key.Matches(msg, keys.Networks):
+			m.openNetworkSwitcher()
+			return m, nil
+		case key.Matches(msg, keys.ChannelInfo):
+			m.openInfoPanel()
+			return m, nil
+		case key.Matches(msg, keys.ManageMembers):
+			m.openMemberManager()
+			return m, nil
+		case key.Matches(msg, keys.NotifyPrefs):
+			m.overlay = newNotifyPrefs(m)
+			return m, nil
+		case key.Matches(msg, keys.NotifyMode):
+			return m, m.cycleNotifyMode()
+		case key.Matches(msg, keys.Collapse):
+			if ch := m.activeChannel(); ch != nil {
+				if cat := m.categoryOf(ch.name); cat != nil {
+					return m, m.toggleCategory(cat.Name)
+				}
+			}
+			return m, nil
+		case key.Matches(msg, keys.SelectTab):
+			m.setActive(tabIndex(msg.String()))
+			return m, nil
+		case key.Matches(msg, keys.SwitchFocus):
+			m.cycleFocus()
+			return m, nil
+		case key.Matches(msg, keys.SplitVertical):
+			m.splitPanes(splitVertical)
+			return m, nil
+		case key.Matches(msg, keys.SplitHorizontal):
+			m.splitPanes(splitHorizontal)
+			return m, nil
+		case key.Matches(msg, keys.ClosePane):
+			m.closeOtherPane()
+			return m, nil
+		case key.Matches(msg, keys.ShrinkLeft):
+			return m, m.resize(func(l *layoutSettings) { l.LeftWidth -= resizeStep })
+		case key.Matches(msg, keys.GrowLeft):
+			return m, m.resize(func(l *layoutSettings) { l.LeftWidth += resizeStep })
+		case key.Matches(msg, keys.ShrinkRight):
+			return m, m.resize(func(l *layoutSettings) { l.RightWidth -= resizeStep })
+		case key.Matches(msg, keys.GrowRight):
+			return m, m.resize(func(l *layoutSettings) { l.RightWidth += resizeStep })
+		case key.Matches(msg, keys.SplitLess):
+			return m, m.resize(func(l *layoutSettings) { l.SplitRatio -= splitRatioStep })
+		case key.Matches(msg, keys.SplitMore):
+			return m, m.resize(func(l *layoutSettings) { l.SplitRatio += splitRatioStep })
+		case key.Matches(msg, keys.PageUp):
+			if p := m.currentPane(); p != nil {
+				p.viewport.PageUp()
+				p.follow = false
+				return m, m.backfill(p)
+			}
+			return m, nil
+		case key.Matches(msg, keys.PageDown):
+			if p := m.currentPane(); p != nil {
+				p.viewport.PageDown()
+				p.follow = p.viewport.AtBottom()
+			}
+			return m, nil
+		case m.focus == focusBuffer:
+			return m, m.updateBuffer(msg)
+		case m.focus == focusComposer && m.replyTo != nil && key.Matches(msg, keys.Cancel):
+			m.cancelReply()
+			return m, nil
+		case m.focus == focusComposer && key.Matches(msg, keys.Send):
+			return m, tea.Batch(m.stopTyping(), m.sendComposer())
+		case m.focus == focusSearch && key.Matches(msg, keys.Send):
+			m.searchHistory()
+			return m, nil
+		case m.focus == focusSearch && key.Matches(msg, keys.SearchBuffer):
+			m.searchBuffer()
+			return m, nil
+		case m.focus == focusSearch && key.Matches(msg, keys.SearchRegex):
+			m.toggleSearchMode(searchRegex)
+			return m, nil
+		case m.focus == focusSearch && key.Matches(msg, keys.SearchFuzzy):
+			m.toggleSearchMode(searchFuzzy)
+			return m, nil
+		case key.Matches(msg, keys.ToggleMembers):
+			m.showMembers = !m.showMembers
+			m.recalcLayout()
+			return m, nil
+		case m.focus == focusComposer && m.messageInput.Value() == "" &&
+			(msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace) && !strings.HasPrefix(string(msg.Runes), "/") &&
+			m.postBlocked(m.activeChannel()) != "":
+			// Where we can't post the composer only takes commands
+			return m, nil
+		}
+	case tea.MouseMsg:
+		if ok, cmd := m.handleMouseResize(msg); ok {
+			return m, cmd
+		}
+		if ok, cmd := m.handleSidebarMouse(msg); ok {
+			return m, cmd
 		}
+		if m.handleHeaderMouse(msg) || m.handleNetworkMouse(msg) || m.handleTabMouse(msg) || m.handleFocusMouse(msg) {
+			return m, nil
+		}
+		if ok, cmd := m.handlePaneMouse(msg); ok {
+			return m, cmd
+		}
+		if msg.Action == tea.MouseActionRelease && m.memberSidebarAt(msg.X, msg.Y) {
+			m.openMemberManager()
+			return m, nil
+		}
+	case errMsg:
+		m.lastErr = msg.err
+		return m, nil
+	case slowModeTickMsg:
+		if wait := m.slowWait(m.activeChannel()); wait > 0 {
+			return m, slowModeTick(wait)
+		}
+		return m, nil
+	case pruneTickMsg:
+		return m, tea.Batch(m.pruneCmd(), m.schedulePrune())
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
@@ -131,8 +674,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Update inputs
 	m.textInput, cmd = m.textInput.Update(msg)
 	cmds = append(cmds, cmd)
+	before := m.messageInput.Value()
 	m.messageInput, cmd = m.messageInput.Update(msg)
-	cmds = append(cmds, cmd)
+	cmds = append(cmds, cmd, m.noteTyping(before))
 
 	// Recalculate layout (width must be set before dynamic height check)
 	if m.width > 0 {
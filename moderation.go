@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Moderators keep a channel in order with
+//
+//	/kick <nick> [reason]              remove someone, who may join again
+//	/ban <nick> [duration] [reason]    remove them and keep them out
+//	/mute <nick> [duration] [reason]   let them read but not post
+//	/timeout <nick> <duration> [reason]
+//	/shadowban <nick> [duration] [reason]
+//	/unban <nick>, /unmute <nick>, /unshadowban <nick>
+//
+// which take a role with the moderate permission (see roles.go) that
+// outranks the one dealt with. A duration is like 10m, 2h or 7d; without
+// one a ban or mute lasts until it is lifted, and a timeout is a mute that
+// always has one. Bans and mutes are kept with the channel in the server's
+// store and simply stop counting once they run out. Muted users can't do
+// what posting covers, and their composer says so until the mute ends. The
+// channel is told what happened and why, and so is whoever it happened to.
+//
+// A shadow ban is for a troll who would only come back as someone else:
+// the server takes their messages and echoes them back as usual, but
+// nobody else gets them, and nobody but the channel's moderators is told.
+// Every action goes into the channel's moderation log, which /modlog
+// shows its moderators, so shadow bans don't go unseen among them.
+
+// Moderation actions.
+const (
+	modKick   = "kick"
+	modBan    = "ban"
+	modUnban  = "unban"
+	modMute   = "mute"
+	modUnmute = "unmute"
+	// modShadowBan hides someone's messages from everyone else
+	modShadowBan   = "shadowban"
+	modUnshadowBan = "unshadowban"
+)
+
+// maxModLog is how many actions the moderation log keeps.
+const maxModLog = 1000
+
+// modLifts is the kind of sanction each lifting action lifts, and what
+// the nick isn't if there is none.
+var modLifts = map[string]struct{ kind, was string }{
+	modUnban:       {modBan, "banned"},
+	modUnmute:      {modMute, "muted"},
+	modUnshadowBan: {modShadowBan, "shadow-banned"},
+}
+
+// shadow reports whether an action is about a shadow ban, which only
+// moderators hear of.
+func shadow(action string) bool {
+	return action == modShadowBan || action == modUnshadowBan
+}
+
+// sanction is a ban or mute of a nick in a channel.
+type sanction struct {
+	Nick   string    `json:"nick"`
+	By     string    `json:"by"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitzero"` // zero until lifted
+}
+
+// activeAt reports whether the sanction still holds at t.
+func (sn *sanction) activeAt(t time.Time) bool {
+	return sn != nil && (sn.Until.IsZero() || t.Before(sn.Until))
+}
+
+// until describes how long the sanction lasts, for notices.
+func (sn *sanction) until() string {
+	if sn.Until.IsZero() {
+		return ""
+	}
+	t := sn.Until.Local()
+	if now := time.Now(); t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return " until " + t.Format("15:04")
+	}
+	return " until " + t.Format("2 Jan 15:04")
+}
+
+// parseModDuration parses a duration like 90s, 10m, 2h or 7d.
+func parseModDuration(v string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// --- Server side ---
+
+// bannedError is a banned nick trying to join, or being invited back.
+type bannedError struct {
+	sanction
+	channel string
+}
+
+func (e *bannedError) Error() string {
+	return e.Nick + " is banned from " + e.channel + e.until()
+}
+
+// mutedError is a muted nick trying to post.
+type mutedError struct {
+	sanction
+	channel string
+}
+
+func (e *mutedError) Error() string {
+	return "you are muted in " + e.channel + e.until()
+}
+
+// maxModReason is the longest reason a moderation action can give.
+const maxModReason = 200
+
+// handleModerate kicks, bans, mutes or lifts a ban or mute.
+func (srv *server) handleModerate(s *session, f frame) error {
+	var req moderateData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case len(req.Reason) > maxModReason:
+		return fmt.Errorf("reasons can be at most %d bytes", maxModReason)
+	case req.Duration < 0:
+		return errors.New("durations can't be negative")
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if !srv.knownNickLocked(req.Nick) {
+		srv.mu.Unlock()
+		return errNoSuchNick
+	}
+	if !srv.canLocked(ch, s.nick, permModerate) || srv.roleInLocked(ch, req.Nick) >= srv.roleInLocked(ch, s.nick) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	_, member := ch.members[req.Nick]
+	sn := sanction{Nick: req.Nick, By: s.nick, Reason: req.Reason, Since: s.stamp.Time}
+	if req.Duration > 0 {
+		sn.Until = s.stamp.Time.Add(req.Duration)
+	}
+	var err error
+	switch req.Action {
+	case modKick:
+		if !member {
+			err = fmt.Errorf("%s is not in %s", req.Nick, req.Channel)
+		}
+	case modBan, modMute, modShadowBan:
+		kind := req.Action
+		if err = s.persistLocked(func(st serverStore) error { return st.saveSanction(ch.name, kind, sn) }); err == nil {
+			ch.setSanction(kind, sn)
+		}
+	case modUnban, modUnmute, modUnshadowBan:
+		lift := modLifts[req.Action]
+		if cur, ok := ch.sanctions[lift.kind][req.Nick]; !ok || !cur.activeAt(s.stamp.Time) {
+			err = fmt.Errorf("%s isn't %s in %s", req.Nick, lift.was, req.Channel)
+		} else if err = s.persistLocked(func(st serverStore) error { return st.deleteSanction(ch.name, lift.kind, req.Nick) }); err == nil {
+			delete(ch.sanctions[lift.kind], req.Nick)
+		}
+	default:
+		err = fmt.Errorf("unknown moderation action %q", req.Action)
+	}
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ev := moderationEvent{Channel: req.Channel, Action: req.Action, sanction: sn}
+	srv.logModerationLocked(s, ev)
+	// Whoever it happened to hears of it even once they are out
+	targets := srv.sessionsOfLocked(req.Nick)
+	if shadow(req.Action) {
+		targets = srv.moderatorSessionsLocked(ch)
+	}
+	srv.mu.Unlock()
+
+	if shadow(req.Action) {
+		for _, sess := range targets {
+			sess.conn.write(newFrame(frameModerated, ev))
+		}
+		return nil
+	}
+
+	if member && (req.Action == modKick || req.Action == modBan) {
+		if err := srv.removeMember(s, req.Channel, req.Nick, ""); err != nil {
+			return err
+		}
+	}
+	moderated := newFrame(frameModerated, ev)
+	srv.broadcast(req.Channel, moderated)
+	if !member || req.Action == modKick || req.Action == modBan {
+		for _, sess := range targets {
+			sess.conn.write(moderated)
+		}
+	}
+	return nil
+}
+
+// setSanction bans, mutes or shadow-bans (kind modBan, modMute or
+// modShadowBan) someone in ch.
+func (ch *serverChannel) setSanction(kind string, sn sanction) {
+	if ch.sanctions == nil {
+		ch.sanctions = make(map[string]map[string]sanction)
+	}
+	if ch.sanctions[kind] == nil {
+		ch.sanctions[kind] = make(map[string]sanction)
+	}
+	ch.sanctions[kind][sn.Nick] = sn
+}
+
+// bannedLocked is the ban keeping nick out of ch, if there is one.
+func (srv *server) bannedLocked(ch *serverChannel, nick string, now time.Time) error {
+	if sn, ok := ch.sanctions[modBan][nick]; ok && sn.activeAt(now) {
+		return &bannedError{sanction: sn, channel: ch.name}
+	}
+	return nil
+}
+
+// muteOfLocked is nick's mute in ch at now, or nil.
+func (ch *serverChannel) muteOfLocked(nick string, now time.Time) *sanction {
+	if sn, ok := ch.sanctions[modMute][nick]; ok && sn.activeAt(now) {
+		return &sn
+	}
+	return nil
+}
+
+// shadowBannedLocked reports whether nick's messages in ch are kept from
+// everyone else at now.
+func (ch *serverChannel) shadowBannedLocked(nick string, now time.Time) bool {
+	sn, ok := ch.sanctions[modShadowBan][nick]
+	return ok && sn.activeAt(now)
+}
+
+// mayPostLocked is why s can't post in ch, or a DM (nil), if they can't:
+// their role there doesn't let them, or they are muted.
+func (srv *server) mayPostLocked(s *session, ch *serverChannel) error {
+	if !srv.canLocked(ch, s.nick, permPost) {
+		return errNotPermitted
+	}
+	if ch == nil {
+		return nil
+	}
+	if sn := ch.muteOfLocked(s.nick, s.stamp.Time); sn != nil {
+		return &mutedError{sanction: *sn, channel: ch.name}
+	}
+	return nil
+}
+
+// --- Client side ---
+
+// cmdModerate runs /kick, /ban, /mute, /timeout, /unban and /unmute.
+func cmdModerate(action string) func(m *model, args string) tea.Cmd {
+	return func(m *model, args string) tea.Cmd {
+		ch := m.activeChannel()
+		if ch == nil || ch.isDM() {
+			m.notice("Moderation is only for channels")
+			return nil
+		}
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			m.notice("Usage: /" + action + " <nick>" + modUsage[action])
+			return nil
+		}
+		req := moderateData{Channel: ch.name, Nick: fields[0], Action: action}
+		rest := fields[1:]
+		switch action {
+		case "timeout", modBan, modMute, modShadowBan:
+			if len(rest) > 0 {
+				if d, ok := parseModDuration(rest[0]); ok {
+					req.Duration, rest = d, rest[1:]
+				}
+			}
+			if action == "timeout" {
+				if req.Duration == 0 {
+					m.notice("Usage: /timeout <nick>" + modUsage[action])
+					return nil
+				}
+				req.Action = modMute
+			}
+		}
+		if _, lift := modLifts[req.Action]; !lift {
+			req.Reason = strings.Join(rest, " ")
+		}
+		if !m.allowed(ch, permModerate, action+" people") {
+			return nil
+		}
+		return m.request(frameModerate, req)
+	}
+}
+
+// modUsage is what follows the nick for each command.
+var modUsage = map[string]string{
+	modKick:        " [reason]",
+	modBan:         " [duration like 2h or 7d] [reason]",
+	modMute:        " [duration like 10m or 1d] [reason]",
+	"timeout":      " <duration like 10m or 1d> [reason]",
+	modShadowBan:   " [duration like 2h or 7d] [reason]",
+	modUnban:       "",
+	modUnmute:      "",
+	modUnshadowBan: "",
+}
+
+// applyModeration shows a moderation action in its channel, or to
+// whoever it was done to once they are out of it, and keeps track of our
+// own mute.
+func (m *model) applyModeration(ev moderationEvent) {
+	ch := m.channelByName(ev.Channel)
+	if ch != nil && ev.Nick == m.nick {
+		switch ev.Action {
+		case modMute:
+			sn := ev.sanction
+			ch.mute = &sn
+		case modUnmute:
+			ch.mute = nil
+		}
+	}
+	text := ev.describe()
+	if text == "" {
+		return
+	}
+	if ev.Nick == m.nick {
+		text = strings.Replace(text, " "+ev.Nick, " you", 1)
+	}
+	if ch == nil && (ev.Action == modKick || ev.Action == modBan) {
+		text += " from " + ev.Channel
+	} else if ch == nil {
+		text += " in " + ev.Channel
+	}
+	if ev.Reason != "" {
+		text += ": " + ev.Reason
+	}
+	if ch == nil {
+		m.notice(text)
+		return
+	}
+	m.noticeIn(ch, text)
+}
+
+// describe says what happened, like "carol muted bob until 15:04", or is
+// empty for an action we don't know.
+func (ev moderationEvent) describe() string {
+	switch ev.Action {
+	case modKick:
+		return ev.By + " kicked " + ev.Nick
+	case modBan:
+		return ev.By + " banned " + ev.Nick + ev.until()
+	case modUnban:
+		return ev.By + " lifted the ban on " + ev.Nick
+	case modMute:
+		return ev.By + " muted " + ev.Nick + ev.until()
+	case modUnmute:
+		return ev.By + " unmuted " + ev.Nick
+	case modShadowBan:
+		return ev.By + " shadow-banned " + ev.Nick + ev.until()
+	case modUnshadowBan:
+		return ev.By + " lifted the shadow ban on " + ev.Nick
+	}
+	return ""
+}
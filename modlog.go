@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The moderation log records every kick, ban, mute and shadow ban, and
+// each lifting of one, with who did it to whom and why. It is kept in the
+// server's store, the newest maxModLog in memory, and /modlog shows a
+// channel's moderators its part. Other actions are announced in the
+// channel anyway; shadow bans are only ever seen here and by the
+// moderators online when they happen.
+
+// --- Server side ---
+
+// logModerationLocked adds an action to the moderation log. The action
+// stands even if the log can't be saved.
+func (srv *server) logModerationLocked(s *session, ev moderationEvent) {
+	srv.modLog = append(srv.modLog, ev)
+	if n := len(srv.modLog) - maxModLog; n > 0 {
+		srv.modLog = slices.Delete(srv.modLog, 0, n)
+	}
+	s.persistLocked(func(st serverStore) error { return st.logModeration(ev) })
+}
+
+// handleModLog replies with a channel's part of the moderation log, for
+// those whose role may moderate there.
+func (srv *server) handleModLog(s *session, f frame) error {
+	var req modLogData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permModerate) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	for _, ev := range srv.modLog {
+		if ev.Channel == req.Channel {
+			req.Entries = append(req.Entries, ev)
+		}
+	}
+	srv.mu.Unlock()
+
+	s.reply(f, newFrame(frameModLogEntries, req))
+	return nil
+}
+
+// --- Client side ---
+
+func cmdModLog(m *model, _ string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("The moderation log is per channel")
+		return nil
+	}
+	if !m.allowed(ch, permModerate, "see the moderation log") {
+		return nil
+	}
+	return m.request(frameModLog, modLogData{Channel: ch.name})
+}
+
+// modLogView is the overlay listing a channel's moderation actions,
+// newest first.
+type modLogView struct {
+	channel string
+	entries []moderationEvent
+	offset  int // entries scrolled past
+}
+
+func newModLogView(d modLogData) *modLogView {
+	slices.Reverse(d.Entries)
+	return &modLogView{channel: d.Channel, entries: d.Entries}
+}
+
+func (v *modLogView) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return v, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel), key.Matches(keyMsg, keys.Select):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		v.offset = max(v.offset-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		v.offset = min(v.offset+1, max(len(v.entries)-1, 0))
+	}
+	return v, nil
+}
+
+func (v *modLogView) View(width, height int) string {
+	w := min(70, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Moderation log of %s (%d)", v.channel, len(v.entries)))}
+	if len(v.entries) == 0 {
+		lines = append(lines, "", overlayHintStyle.Render("No one has been moderated here"))
+	}
+	used := 4
+	for i, ev := range v.entries[v.offset:] {
+		text := ev.describe()
+		if ev.Reason != "" {
+			text += ": " + ev.Reason
+		}
+		style := lipgloss.NewStyle()
+		if shadow(ev.Action) {
+			style = style.Foreground(palette.Warning)
+		}
+		entry := lipgloss.JoinVertical(lipgloss.Left,
+			"",
+			overlayHintStyle.Render(ev.Since.Local().Format("2 Jan 15:04")),
+			style.Width(inner).Render(text),
+		)
+		if used += lipgloss.Height(entry); used > height-2 && i > 0 {
+			break
+		}
+		lines = append(lines, entry)
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ scroll • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
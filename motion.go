@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+)
+
+// [ui] reduce_motion = true keeps the screen still: cursors don't blink,
+// the loading row has no spinner and slow mode says when it ends rather
+// than counting down. That is easier on those bothered by motion, and
+// over a slow SSH link it saves redrawing the screen several times a
+// second for nothing.
+
+// reduceMotion is whether [ui] reduce_motion is set.
+var reduceMotion bool
+
+// cursorMode is how input cursors are drawn, blinking unless motion is
+// reduced.
+func cursorMode() cursor.Mode {
+	if reduceMotion {
+		return cursor.CursorStatic
+	}
+	return cursor.CursorBlink
+}
+
+// newTextInput is a text input with its cursor in cursorMode.
+func newTextInput() textinput.Model {
+	ti := textinput.New()
+	ti.Cursor.SetMode(cursorMode())
+	return ti
+}
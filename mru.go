@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxRecent caps the most-recently-used buffer stack.
+const maxRecent = 20
+
+// touchRecent moves name to the top of the recent buffer stack.
+func (m *model) touchRecent(name string) {
+	if m.recentWalk > 0 {
+		// Walking the stack, don't reorder it under our feet
+		return
+	}
+	m.recent = append([]string{name}, removeString(m.recent, name)...)
+	if len(m.recent) > maxRecent {
+		m.recent = m.recent[:maxRecent]
+	}
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// liveRecent returns the recent stack without buffers we've since left.
+func (m *model) liveRecent() []string {
+	var live []string
+	for _, name := range m.recent {
+		if m.channelByName(name) != nil {
+			live = append(live, name)
+		}
+	}
+	return live
+}
+
+// walkRecent steps through the recent stack like alt+tab: the first press
+// goes to the previous buffer, each further press goes one deeper, and the
+// stack is only reordered once some other key ends the walk.
+func (m *model) walkRecent(delta int) {
+	if ch := m.activeChannel(); ch != nil {
+		m.touchRecent(ch.name)
+	}
+	m.recent = m.liveRecent()
+	if len(m.recent) < 2 {
+		return
+	}
+	n := len(m.recent)
+	m.recentWalk = ((m.recentWalk+delta)%n + n) % n
+	if m.recentWalk == 0 {
+		// Wrapped back round to where we started
+		m.recentWalk = n
+	}
+	m.switchToBuffer(m.recent[m.recentWalk%n])
+}
+
+// endRecentWalk commits the buffer a walk landed on to the top of the stack.
+func (m *model) endRecentWalk() {
+	if m.recentWalk == 0 {
+		return
+	}
+	m.recentWalk = 0
+	if ch := m.activeChannel(); ch != nil {
+		m.touchRecent(ch.name)
+	}
+}
+
+// handleRecentKey handles the recent-buffer keys, ending any walk on other
+// keys. It reports whether msg was one of them.
+func (m *model) handleRecentKey(msg tea.KeyMsg) bool {
+	switch {
+	case key.Matches(msg, keys.RecentBack):
+		m.walkRecent(1)
+		return true
+	case key.Matches(msg, keys.RecentForward):
+		m.walkRecent(-1)
+		return true
+	}
+	m.endRecentWalk()
+	return false
+}
+
+// renderRecentWalk shows the stack during a walk, e.g. "#a › [#b] › @c".
+func (m *model) renderRecentWalk() string {
+	parts := make([]string, len(m.recent))
+	for i, name := range m.recent {
+		if i == m.recentWalk%len(m.recent) {
+			name = "[" + name + "]"
+		}
+		parts[i] = name
+	}
+	return "Recent: " + strings.Join(parts, " › ")
+}
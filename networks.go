@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// serverSettings is one server to connect to, from --server, the config
+// file or the settings file.
+type serverSettings struct {
+	Name string `json:"name,omitempty"` // label in the header, defaults to the address
+	Addr string `json:"addr"`
+	// TLS connects over TLS, and Pins are the certificates trusted for it
+	// (see certpin.go)
+	TLS  bool     `json:"tls,omitempty"`
+	Pins []string `json:"pins,omitempty"`
+	// nick and password log in to it, and autojoin are the channels joined
+	// on connecting, from the config file (see config.go)
+	nick, password string
+	autojoin       []string
+}
+
+// parseServers parses a --server value: comma-separated addresses, each
+// optionally named as name=addr, and tls:// for TLS.
+func parseServers(v string) []serverSettings {
+	var list []serverSettings
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "=")
+		if !ok {
+			name, addr = "", item
+		}
+		addr, tls := strings.CutPrefix(addr, "tls://")
+		list = append(list, serverSettings{Name: name, Addr: addr, TLS: tls})
+	}
+	return list
+}
+
+// network is one configured server. The model's buffer state always belongs
+// to the network being shown; the others keep theirs stashed here until
+// switched to.
+type network struct {
+	name       string
+	addr       string
+	server     serverSettings // how to connect to it
+	connecting bool
+	err        error       // why the last connection failed or dropped
+	creds      credentials // what it logs in with, see accounts.go
+	retries    int         // reconnects that failed in a row, see resume.go
+	e2e        *e2eState   // what its DMs are encrypted with, see e2ee.go
+	signing    *signState  // what its messages are signed with, see signing.go
+	stash      networkState
+}
+
+// networkState is the part of the model that belongs to one network.
+type networkState struct {
+	nick           string
+	client         *client
+	lag            time.Duration
+	channels       []*channel
+	active         int
+	panes          []*pane
+	focusedPane    int
+	split          splitMode
+	recent         []string
+	activity       []activity
+	activityUnseen int
+	replyTo        *message
+	pendingJoin    string
+	pendingCreate  string
+	lastErr        error
+}
+
+// newNetworkState is what a network starts with before connecting: just
+// #general.
+func newNetworkState(nick string) networkState {
+	general := newChannel("#general", "Discussion")
+	general.members[nick] = &member{nick: nick, presence: presenceOnline}
+	return networkState{
+		nick:     nick,
+		channels: []*channel{general},
+		panes:    []*pane{newPane(general.name)},
+		recent:   []string{general.name},
+	}
+}
+
+func (m *model) saveState() networkState {
+	return networkState{
+		nick:           m.nick,
+		client:         m.client,
+		lag:            m.lag,
+		channels:       m.channels,
+		active:         m.active,
+		panes:          m.panes,
+		focusedPane:    m.focusedPane,
+		split:          m.split,
+		recent:         m.recent,
+		activity:       m.activity,
+		activityUnseen: m.activityUnseen,
+		replyTo:        m.replyTo,
+		pendingJoin:    m.pendingJoin,
+		pendingCreate:  m.pendingCreate,
+		lastErr:        m.lastErr,
+	}
+}
+
+func (m *model) loadState(st networkState) {
+	m.nick = st.nick
+	m.client = st.client
+	m.lag = st.lag
+	m.channels = st.channels
+	m.active = st.active
+	m.panes = st.panes
+	m.focusedPane = st.focusedPane
+	m.split = st.split
+	m.recent = st.recent
+	m.recentWalk = 0
+	m.activity = st.activity
+	m.activityUnseen = st.activityUnseen
+	m.replyTo = st.replyTo
+	m.pendingJoin = st.pendingJoin
+	m.pendingCreate = st.pendingCreate
+	m.lastErr = st.lastErr
+}
+
+func (m *model) currentNetwork() *network {
+	if m.net < 0 || m.net >= len(m.networks) {
+		return nil
+	}
+	return m.networks[m.net]
+}
+
+// netMsg is a message produced on behalf of one network. Everything coming
+// from a network's connection is wrapped so it is applied to that network's
+// state even while another one is shown.
+type netMsg struct {
+	n   *network
+	msg tea.Msg
+}
+
+// tagCmd wraps cmd so its result is delivered as a netMsg for n.
+func tagCmd(n *network, cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		switch msg := cmd().(type) {
+		case nil:
+			return nil
+		case tea.BatchMsg:
+			for i, c := range msg {
+				msg[i] = tagCmd(n, c)
+			}
+			return msg
+		default:
+			return netMsg{n: n, msg: msg}
+		}
+	}
+}
+
+// connectNetwork starts connecting to n.
+func (m *model) connectNetwork(n *network) tea.Cmd {
+	n.connecting = true
+	channels := n.stash.channels
+	if n == m.currentNetwork() {
+		channels = m.channels
+	}
+	return tagCmd(n, connectCmd(n.server, n.creds, lastSeen(channels), m.away))
+}
+
+// connectAll starts connecting to every network that can log in, asking
+// for what the first one that can't needs.
+func (m *model) connectAll() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, n := range m.networks {
+		if n.creds.usable() {
+			cmds = append(cmds, m.connectNetwork(n))
+		}
+	}
+	m.nextLogin()
+	return tea.Batch(cmds...)
+}
+
+// updateNetwork applies msg to its network, swapping that network's state in
+// for the duration if it isn't the one shown.
+func (m *model) updateNetwork(msg netMsg) tea.Cmd {
+	n := msg.n
+	if !slices.Contains(m.networks, n) {
+		// Left behind by switching profiles
+		if connected, ok := msg.msg.(connectedMsg); ok {
+			connected.c.close()
+		}
+		return nil
+	}
+	if click, ok := msg.msg.(desktopClickMsg); ok {
+		// Shown whichever network it is on
+		m.openDesktopNote(click)
+		return nil
+	}
+	var retry tea.Cmd
+	switch inner := msg.msg.(type) {
+	case connectedMsg:
+		n.connecting, n.err = false, nil
+		// Guests stay guests, coming back under the same nick
+		n.creds.nick, n.creds.register, n.creds.invite, n.creds.sso = inner.nick, false, "", false
+		n.creds.resume, n.retries = inner.resume, 0
+		inner.c.network = n
+		// Done signing in, once the session is taken
+		if m.login != nil && m.login.n == n {
+			defer m.nextLogin()
+		}
+	case deviceCodeMsg:
+		if m.login == nil || m.login.n != n {
+			m.login = newLoginScreen(n, "")
+		}
+		m.login.waitForDevice(inner.c, inner.code)
+	case disconnectedMsg:
+		n.connecting, n.err = false, inner.err
+		var denied *loginError
+		if errors.As(inner.err, &denied) {
+			n.creds.password, n.creds.tokens, n.creds.resume = "", sessionTokens{}, ""
+			m.saveSession(n)
+			m.login = newLoginScreen(n, denied.reason)
+			n.creds.sso = false
+		} else {
+			retry = reconnectLater(n)
+		}
+	}
+	if n != m.currentNetwork() {
+		shown := m.saveState()
+		m.loadState(n.stash)
+		m.background = true
+		defer func() {
+			n.stash = m.saveState()
+			m.loadState(shown)
+			m.background = false
+		}()
+	}
+	_, cmd := m.Update(msg.msg)
+	return tea.Batch(tagCmd(n, cmd), retry)
+}
+
+// switchNetwork shows network i.
+func (m *model) switchNetwork(i int) {
+	if i == m.net || i < 0 || i >= len(m.networks) {
+		return
+	}
+	m.endRecentWalk()
+	m.currentNetwork().stash = m.saveState()
+	m.net = i
+	m.loadState(m.networks[i].stash)
+	m.setFocus(focusComposer)
+}
+
+// networkConnected reports whether network i has a live connection.
+func (m *model) networkConnected(i int) bool {
+	if i == m.net {
+		return m.client != nil
+	}
+	return m.networks[i].stash.client != nil
+}
+
+// networkDot is the connection state dot shown next to a network.
+func (m *model) networkDot(i int) string {
+	switch {
+	case m.networkConnected(i):
+		return presenceOnlineStyle.Render("●")
+	case m.networks[i].connecting:
+		return presenceAwayStyle.Render("◌")
+	default:
+		return errorTextStyle.Render("○")
+	}
+}
+
+func (m *model) networkStateLabel(i int) string {
+	switch {
+	case m.networkConnected(i):
+		return "connected"
+	case m.networks[i].connecting:
+		return "connecting…"
+	case m.networks[i].err != nil:
+		return "disconnected: " + m.networks[i].err.Error()
+	default:
+		return "disconnected"
+	}
+}
+
+// networkUnread sums the badges of network i's buffers.
+func (m *model) networkUnread(i int) (unread, mentions int) {
+	channels := m.networks[i].stash.channels
+	if i == m.net {
+		channels = m.channels
+	}
+	for _, ch := range channels {
+		unread += ch.unread
+		mentions += ch.mentions
+	}
+	return unread, mentions
+}
+
+// renderNetworkSegment renders the header's network switcher, or nothing
+// with a single server.
+func (m *model) renderNetworkSegment() string {
+	n := m.currentNetwork()
+	if len(m.networks) < 2 || n == nil {
+		return ""
+	}
+	badge := ""
+	for i := range m.networks {
+		if i == m.net {
+			continue
+		}
+		if _, mentions := m.networkUnread(i); mentions > 0 {
+			badge = " " + mentionBadgeStyle.Render("@")
+			break
+		}
+	}
+	return networkSegmentStyle.Render(m.networkDot(m.net) + " " + truncate(n.name, headerNetworkMaxWidth) + " ▾" + badge)
+}
+
+type switchNetworkMsg struct{ index int }
+
+type reconnectNetworkMsg struct{ index int }
+
+// networkSwitcher is the overlay listing configured networks.
+type networkSwitcher struct {
+	m      *model
+	cursor int
+}
+
+func newNetworkSwitcher(m *model) *networkSwitcher {
+	return &networkSwitcher{m: m, cursor: m.net}
+}
+
+func (s *networkSwitcher) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return s, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if s.cursor > 0 {
+			s.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if s.cursor < len(s.m.networks)-1 {
+			s.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		i := s.cursor
+		if !s.m.networkConnected(i) && !s.m.networks[i].connecting {
+			return nil, func() tea.Msg { return reconnectNetworkMsg{index: i} }
+		}
+		return nil, func() tea.Msg { return switchNetworkMsg{index: i} }
+	}
+	return s, nil
+}
+
+func (s *networkSwitcher) View(width, height int) string {
+	w := 60
+	if width-4 < w {
+		w = width - 4
+	}
+	nameW := 16
+
+	lines := []string{overlayTitleStyle.Render("Networks"), ""}
+	for i, n := range s.m.networks {
+		state := s.m.networkStateLabel(i)
+		if unread, mentions := s.m.networkUnread(i); unread > 0 {
+			state = fmt.Sprintf("%s · %d unread", state, unread)
+			if mentions > 0 {
+				state += " · " + plural(mentions, "mention")
+			}
+		}
+		row := s.m.networkDot(i) + " " +
+			lipgloss.NewStyle().Width(nameW).Render(truncate(n.name, nameW)) + " " +
+			overlayHintStyle.Render(truncate(state, w-2-nameW-3))
+		if i == s.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("enter switch (or reconnect) • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func (m *model) openNetworkSwitcher() {
+	if len(m.networks) < 2 {
+		m.notice("Only one server is configured")
+		return
+	}
+	m.overlay = newNetworkSwitcher(m)
+}
+
+// handleNetworkMouse opens the network switcher when the header segment is
+// clicked.
+func (m *model) handleNetworkMouse(msg tea.MouseMsg) bool {
+	if msg.Action != tea.MouseActionRelease || !m.networkArea.contains(msg.X, msg.Y) {
+		return false
+	}
+	m.openNetworkSwitcher()
+	return true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// How nicks show in messages is up to [ui] in the config file:
+//
+//	nick_width = 12        # padded or cut to 12 cells, 0 as they are
+//	nick_align = "right"   # against the text, a column down each buffer
+//	hide_dm_nicks = true   # a DM is two people, the text says enough
+//	nick_roles = true      # alice@mod, for those above plain members
+//
+// Right-aligned nicks with no width line up on the buffer's longest nick,
+// up to maxNickColumn. System lines keep their "--" in the same column.
+
+// maxNickColumn is the widest the nick column grows to by itself.
+const maxNickColumn = 16
+
+// nickLook is the nick settings of [ui].
+type nickLook struct {
+	width     int
+	right     bool
+	hideInDMs bool
+	roles     bool
+}
+
+// nickLookFor checks and reads the nick settings of ui.
+func nickLookFor(ui uiConfig) (nickLook, error) {
+	l := nickLook{width: ui.NickWidth, hideInDMs: ui.HideDMNicks, roles: ui.NickRoles}
+	if l.width < 0 {
+		return l, fmt.Errorf("ui: nick_width is %d, want 0 or more", l.width)
+	}
+	switch ui.NickAlign {
+	case "", "left":
+	case "right":
+		l.right = true
+	default:
+		return l, fmt.Errorf("ui: unknown nick_align %q, want left or right", ui.NickAlign)
+	}
+	return l, nil
+}
+
+// nickColumn is how the nicks of one buffer are drawn: in ch, for their
+// roles, padded to width cells, or as they are at 0.
+type nickColumn struct {
+	ch    *channel
+	width int
+}
+
+// columnFor is the nick column of ch as l draws it.
+func (l nickLook) columnFor(ch *channel) nickColumn {
+	col := nickColumn{ch: ch, width: l.width}
+	if col.width > 0 || !l.right || ch == nil {
+		return col
+	}
+	for _, msg := range ch.messages {
+		if !msg.system {
+			col.width = max(col.width, lipgloss.Width(msg.nick+l.roleSuffix(ch, msg.nick)))
+		}
+	}
+	col.width = min(col.width, maxNickColumn)
+	return col
+}
+
+// roleSuffix is what follows nick in ch to say its role, if l shows them.
+func (l nickLook) roleSuffix(ch *channel, nick string) string {
+	if !l.roles || ch == nil {
+		return ""
+	}
+	mem, ok := ch.members[nick]
+	if !ok {
+		return ""
+	}
+	switch mem.role {
+	case roleOwner:
+		return "@owner"
+	case roleAdmin:
+		return "@admin"
+	case roleModerator:
+		return "@mod"
+	case roleGuest:
+		return "@guest"
+	}
+	return ""
+}
+
+// renderNick is msg's nick as l draws it in col.
+func (l nickLook) renderNick(msg message, col nickColumn) string {
+	if msg.system {
+		return l.pad(systemMessageStyle.Render("--"), col)
+	}
+	if l.hideInDMs && col.ch != nil && col.ch.isDM() {
+		return ""
+	}
+	label := msg.nick + l.roleSuffix(col.ch, msg.nick)
+	if col.width > 0 {
+		label = truncate(label, col.width)
+	}
+	// The nick in its color, what is left of the suffix dimmed
+	nick, suffix := label, ""
+	if len(label) > len(msg.nick) && strings.HasPrefix(label, msg.nick) {
+		nick, suffix = msg.nick, label[len(msg.nick):]
+	}
+	return l.pad(nickStyle(msg.nick).Render(nick)+timestampStyle.Render(suffix), col)
+}
+
+// pad fills out a rendered nick to col's width, on the side l says.
+func (l nickLook) pad(s string, col nickColumn) string {
+	fill := col.width - lipgloss.Width(s)
+	if fill <= 0 {
+		return s
+	}
+	if l.right {
+		return strings.Repeat(" ", fill) + s
+	}
+	return s + strings.Repeat(" ", fill)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// notifyLevel is how much attention a buffer asks for.
+type notifyLevel string
+
+const (
+	notifyAll      notifyLevel = "all"      // every message badges and notifies
+	notifyMentions notifyLevel = "mentions" // only mentions do
+	notifyNothing  notifyLevel = "nothing"  // nothing does
+)
+
+var notifyLevels = []notifyLevel{notifyAll, notifyMentions, notifyNothing}
+
+func (l notifyLevel) label() string {
+	switch l {
+	case notifyMentions:
+		return "Mentions only"
+	case notifyNothing:
+		return "Nothing"
+	default:
+		return "All messages"
+	}
+}
+
+func parseNotifyLevel(s string) (notifyLevel, error) {
+	switch strings.ToLower(s) {
+	case "all":
+		return notifyAll, nil
+	case "mentions", "mention":
+		return notifyMentions, nil
+	case "nothing", "none", "off":
+		return notifyNothing, nil
+	}
+	return "", fmt.Errorf("unknown notification level %q (all, mentions, nothing)", s)
+}
+
+// notifyLevelFor returns the effective level for a buffer. Muting wins over
+// the stored preference.
+func (m *model) notifyLevelFor(name string) notifyLevel {
+	if m.isMuted(name) {
+		return notifyNothing
+	}
+	if l, ok := m.account().Notify[name]; ok {
+		return l
+	}
+	return notifyAll
+}
+
+func (m *model) setNotifyLevel(name string, l notifyLevel) tea.Cmd {
+	acct := m.account()
+	if acct.Notify == nil {
+		acct.Notify = make(map[string]notifyLevel)
+	}
+	if l == notifyAll {
+		delete(acct.Notify, name)
+	} else {
+		acct.Notify[name] = l
+	}
+	return saveSettingsCmd(m.settings)
+}
+
+// notifyMode is how far sounds and desktop notifications go, over every
+// buffer's level: alt+N cycles through them, or /notifications picks one,
+// and {dnd} in the status line shows it unless all. Badges and the activity
+// center don't go by it.
+type notifyMode string
+
+const (
+	modeAll      notifyMode = ""         // what each buffer's level lets through
+	modeMentions notifyMode = "mentions" // mentions and DMs
+	modeDMs      notifyMode = "dms"      // DMs alone
+	modeNothing  notifyMode = "nothing"  // nothing
+)
+
+var notifyModes = []notifyMode{modeAll, modeMentions, modeDMs, modeNothing}
+
+func (n notifyMode) label() string {
+	switch n {
+	case modeMentions:
+		return "Mentions only"
+	case modeDMs:
+		return "DMs only"
+	case modeNothing:
+		return "Nothing"
+	default:
+		return "All"
+	}
+}
+
+func parseNotifyMode(s string) (notifyMode, error) {
+	switch strings.ToLower(s) {
+	case "all":
+		return modeAll, nil
+	case "mentions", "mention":
+		return modeMentions, nil
+	case "dms", "dm":
+		return modeDMs, nil
+	case "nothing", "none", "off":
+		return modeNothing, nil
+	}
+	return "", fmt.Errorf("unknown notification mode %q (all, mentions, dms, nothing)", s)
+}
+
+// notifies reports whether a message in ch that a calls for gets a sound
+// or a notification, as the notification mode, /dnd and quiet hours let
+// it.
+func (m *model) notifies(ch *channel, a alert) bool {
+	if m.quiet() {
+		return false
+	}
+	switch m.settings.NotifyMode {
+	case modeMentions:
+		return a.mention || a.notify || a.sound != ""
+	case modeDMs:
+		return ch.isDM() && a.unread
+	case modeNothing:
+		return false
+	}
+	return a.unread
+}
+
+// setNotifyMode sets the notification mode.
+func (m *model) setNotifyMode(n notifyMode) tea.Cmd {
+	m.settings.NotifyMode = n
+	m.notice("Notifications: " + n.label())
+	return saveSettingsCmd(m.settings)
+}
+
+// cycleNotifyMode moves on to the next notification mode.
+func (m *model) cycleNotifyMode() tea.Cmd {
+	i := slices.Index(notifyModes, m.settings.NotifyMode)
+	return m.setNotifyMode(notifyModes[(i+1)%len(notifyModes)])
+}
+
+func cmdNotifications(m *model, args string) tea.Cmd {
+	if args = strings.TrimSpace(args); args == "" {
+		m.notice("Notifications: " + m.settings.NotifyMode.label())
+		return nil
+	}
+	n, err := parseNotifyMode(args)
+	if err != nil {
+		m.notice(err.Error())
+		return nil
+	}
+	return m.setNotifyMode(n)
+}
+
+// alert is what an incoming message should trigger.
+type alert struct {
+	unread  bool   // counts towards the unread badge
+	mention bool   // counts towards the mention badge
+	notify  bool   // pops up as a mention would, for a rule (see rules.go)
+	sound   string // to play instead of [sounds], for a rule
+}
+
+// classifyMessage decides how loudly msg in ch should be surfaced. It is the
+// single place incoming messages are weighed, so badges and notifications
+// stay consistent.
+func (m *model) classifyMessage(ch *channel, msg message) alert {
+	if msg.nick == m.nick || msg.system {
+		return alert{}
+	}
+	r := m.ruleFor(msg)
+	if r != nil && r.action == ruleIgnore {
+		return alert{}
+	}
+	// DMs are addressed to us, so they always count as mentions
+	mention := m.isMention(msg) || ch.isDM()
+
+	switch {
+	case r != nil && r.action == ruleNotify:
+		return alert{unread: true, mention: mention, notify: true}
+	case r != nil && r.action == ruleSound:
+		return alert{unread: true, mention: mention, sound: cmp.Or(r.sound, m.opts.sounds.Mention, "bell")}
+	}
+	switch m.notifyLevelFor(ch.name) {
+	case notifyNothing:
+		return alert{}
+	case notifyMentions:
+		return alert{unread: mention, mention: mention}
+	}
+	return alert{unread: true, mention: mention}
+}
+
+func cmdNotify(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.overlay = newNotifyPrefs(m)
+		return nil
+	}
+	l, err := parseNotifyLevel(args)
+	if err != nil {
+		m.notice(err.Error())
+		return nil
+	}
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil
+	}
+	m.notice("Notifications for " + ch.name + ": " + l.label())
+	return m.setNotifyLevel(ch.name, l)
+}
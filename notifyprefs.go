@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type setNotifyLevelMsg struct {
+	buffer string
+	level  notifyLevel
+}
+
+// notifyPrefs is the overlay for picking each buffer's notification level.
+type notifyPrefs struct {
+	buffers []string
+	levels  []notifyLevel
+	muted   []bool
+	cursor  int
+}
+
+func newNotifyPrefs(m *model) *notifyPrefs {
+	p := &notifyPrefs{}
+	for i, ch := range m.channels {
+		p.buffers = append(p.buffers, ch.name)
+		p.levels = append(p.levels, m.notifyLevelFor(ch.name))
+		p.muted = append(p.muted, m.isMuted(ch.name))
+		if i == m.active {
+			p.cursor = i
+		}
+	}
+	return p
+}
+
+// cycle moves the selected buffer's level by delta and tells the model.
+func (p *notifyPrefs) cycle(delta int) tea.Cmd {
+	if len(p.buffers) == 0 || p.muted[p.cursor] {
+		return nil
+	}
+	cur := 0
+	for i, l := range notifyLevels {
+		if l == p.levels[p.cursor] {
+			cur = i
+		}
+	}
+	next := notifyLevels[(cur+delta+len(notifyLevels))%len(notifyLevels)]
+	p.levels[p.cursor] = next
+	msg := setNotifyLevelMsg{buffer: p.buffers[p.cursor], level: next}
+	return func() tea.Msg { return msg }
+}
+
+func (p *notifyPrefs) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return p, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if p.cursor < len(p.buffers)-1 {
+			p.cursor++
+		}
+	case key.Matches(keyMsg, keys.Left):
+		return p, p.cycle(-1)
+	case key.Matches(keyMsg, keys.Right), key.Matches(keyMsg, keys.Select), key.Matches(keyMsg, keys.Toggle):
+		return p, p.cycle(1)
+	}
+	return p, nil
+}
+
+func (p *notifyPrefs) View(width, height int) string {
+	w := 50
+	if width-4 < w {
+		w = width - 4
+	}
+	nameW := w - 2 - 18
+
+	lines := []string{overlayTitleStyle.Render("Notification preferences"), ""}
+	for i, name := range p.buffers {
+		level := p.levels[i].label()
+		if p.muted[i] {
+			level = "Muted"
+		}
+		row := lipgloss.NewStyle().Width(nameW).Render(truncate(name, nameW-2)) +
+			overlayHintStyle.Render("‹ ") + level + overlayHintStyle.Render(" ›")
+		if i == p.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ buffer • ←/→ level • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
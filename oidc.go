@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A server started with --oidc-issuer also lets users sign in with an
+// OpenID Connect identity provider, using the device authorization flow
+// (RFC 8628) so nothing has to run a browser on the client's side: the
+// server asks the provider for a code, the client shows it with the URL to
+// enter it at, and the server polls the provider until the user has
+// approved it there. The ID token it gets back comes straight from the
+// provider over TLS, so its issuer, audience and subject are taken as they
+// are without checking its signature.
+//
+// An identity is linked to a nick the first time it signs in, to the nick
+// the client asked for, which has to be free. After that it always signs
+// in to that nick. SSO accounts have no password, only the session they
+// get like any other login, see tokens.go.
+
+const (
+	oidcRequestTimeout = 10 * time.Second
+	// maxDeviceWait caps how long a handshake waits for the user to
+	// approve its code, whatever the provider allows
+	maxDeviceWait = 15 * time.Minute
+)
+
+var (
+	errNoSSO      = errors.New("this server doesn't offer single sign-on")
+	errSSODenied  = errors.New("sign-in was denied")
+	errSSOExpired = errors.New("the sign-in code expired, try again")
+)
+
+// --- Server side ---
+
+// oidcProvider is the identity provider of a server.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string // empty for a public client
+	deviceURL    string // device authorization endpoint
+	tokenURL     string
+	http         *http.Client
+}
+
+// discoverOIDC looks up the endpoints of the provider at issuer.
+func discoverOIDC(issuer, clientID, clientSecret string) (*oidcProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	p := &oidcProvider{issuer: issuer, clientID: clientID, clientSecret: clientSecret,
+		http: &http.Client{Timeout: oidcRequestTimeout}}
+	if clientID == "" {
+		return nil, errors.New("--oidc-issuer needs --oidc-client-id")
+	}
+	resp, err := p.http.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: discovery failed: %s", issuer, resp.Status)
+	}
+	var doc struct {
+		Issuer    string `json:"issuer"`
+		DeviceURL string `json:"device_authorization_endpoint"`
+		TokenURL  string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: discovery: %w", issuer, err)
+	}
+	switch {
+	case strings.TrimSuffix(doc.Issuer, "/") != issuer:
+		return nil, fmt.Errorf("%s: discovery names another issuer, %s", issuer, doc.Issuer)
+	case doc.DeviceURL == "" || doc.TokenURL == "":
+		return nil, fmt.Errorf("%s: the provider doesn't support the device authorization flow", issuer)
+	}
+	p.deviceURL, p.tokenURL = doc.DeviceURL, doc.TokenURL
+	return p, nil
+}
+
+// deviceAuth is a code the provider handed out for one sign-in.
+type deviceAuth struct {
+	DeviceCode  string `json:"device_code"`
+	UserCode    string `json:"user_code"`
+	VerifyURL   string `json:"verification_uri"`
+	CompleteURL string `json:"verification_uri_complete"`
+	ExpiresIn   int    `json:"expires_in"` // seconds
+	Interval    int    `json:"interval"`   // seconds between polls
+}
+
+// oidcError is an error response of the provider.
+type oidcError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oidcError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// post sends a form to one of the provider's endpoints and decodes the
+// JSON answer into v, or returns the provider's error.
+func (p *oidcProvider) post(ctx context.Context, endpoint string, form url.Values, v any) error {
+	form.Set("client_id", p.clientID)
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		oe := &oidcError{}
+		if json.NewDecoder(resp.Body).Decode(oe) != nil || oe.Code == "" {
+			return fmt.Errorf("%s: %s", endpoint, resp.Status)
+		}
+		return oe
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// startDeviceAuth gets a code for the user to approve.
+func (p *oidcProvider) startDeviceAuth(ctx context.Context) (deviceAuth, error) {
+	var auth deviceAuth
+	err := p.post(ctx, p.deviceURL, url.Values{"scope": {"openid profile email"}}, &auth)
+	if err == nil && (auth.DeviceCode == "" || auth.UserCode == "" || auth.VerifyURL == "") {
+		err = errors.New("the provider sent an incomplete device code")
+	}
+	return auth, err
+}
+
+// awaitIdentity polls the provider until the user approved or denied auth,
+// or it expired, and returns the identity that signed in: issuer and
+// subject, which never change for a user.
+func (p *oidcProvider) awaitIdentity(ctx context.Context, auth deviceAuth) (string, error) {
+	interval := 5 * time.Second // unless the provider says otherwise
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	if auth.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(auth.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {auth.DeviceCode},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return "", errSSOExpired
+		case <-time.After(interval):
+		}
+		var tok struct {
+			IDToken string `json:"id_token"`
+		}
+		err := p.post(ctx, p.tokenURL, form, &tok)
+		var oe *oidcError
+		switch {
+		case errors.As(err, &oe) && oe.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oe) && oe.Code == "slow_down":
+			interval += 5 * time.Second
+			continue
+		case errors.As(err, &oe) && oe.Code == "access_denied":
+			return "", errSSODenied
+		case errors.As(err, &oe) && oe.Code == "expired_token", ctx.Err() != nil:
+			return "", errSSOExpired
+		case err != nil:
+			return "", err
+		}
+		return p.identity(tok.IDToken)
+	}
+}
+
+// identity checks that an ID token is meant for this server and returns
+// who it names.
+func (p *oidcProvider) identity(idToken string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, &claims); err != nil {
+		return "", fmt.Errorf("the provider sent a bad ID token: %w", err)
+	}
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.issuer:
+		return "", fmt.Errorf("the ID token is from another issuer, %s", claims.Issuer)
+	case !slices.Contains(claims.Audience, p.clientID):
+		return "", errors.New("the ID token is meant for another client")
+	case claims.Subject == "":
+		return "", errors.New("the ID token names no one")
+	}
+	return p.issuer + " " + claims.Subject, nil
+}
+
+// nickOfIdentityLocked is the nick an identity is linked to.
+func (srv *server) nickOfIdentityLocked(identity string) (string, bool) {
+	for nick, acct := range srv.accounts {
+		if acct.Identity == identity {
+			return nick, true
+		}
+	}
+	return "", false
+}
+
+// authenticateSSO signs s in through the identity provider, linking the
+// identity to s.nick the first time, and starts a session. s.nick becomes
+// the nick linked to it.
+func (srv *server) authenticateSSO(s *session) (*sessionTokens, error) {
+	if srv.oidc == nil {
+		return nil, errNoSSO
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxDeviceWait)
+	defer cancel()
+	auth, err := srv.oidc.startDeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("single sign-on: %w", err)
+	}
+	code := deviceCode{URL: auth.VerifyURL, CompleteURL: auth.CompleteURL, Code: auth.UserCode}
+	if auth.ExpiresIn > 0 {
+		code.Expires = time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	}
+	if err := s.conn.write(newFrame(frameDeviceCode, code)); err != nil {
+		return nil, err
+	}
+	// The client doesn't say anything until it is welcomed, so if it goes
+	// away meanwhile this only notices once the code expires
+	identity, err := srv.oidc.awaitIdentity(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stamp = newEventStamp()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if nick, linked := srv.nickOfIdentityLocked(identity); linked {
+		s.nick = nick
+		return srv.issueTokensLocked(s)
+	}
+	if _, taken := srv.accounts[s.nick]; taken {
+		return nil, errNickTaken
+	}
+	acct := account{Identity: identity, Created: s.stamp.Time}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveAccount(s.nick, acct) }); err != nil {
+		return nil, err
+	}
+	srv.accounts[s.nick] = acct
+	srv.publish(clusterEvent{Kind: clusterAccount, Nick: s.nick, Account: &acct})
+	return srv.issueTokensLocked(s)
+}
+
+// --- Client side ---
+
+// deviceCodeMsg is the code a handshake waits on the user to approve.
+type deviceCodeMsg struct {
+	c    *client
+	code deviceCode
+}
+
+// waitForDevice turns the login screen into the code to approve, until
+// the welcome comes or it is cancelled.
+func (l *loginScreen) waitForDevice(c *client, code deviceCode) {
+	l.pending, l.device, l.err = c, &code, ""
+}
+
+// updateDevice handles keys while waiting on the code: cancelling drops
+// the connection and goes back to the form.
+func (l *loginScreen) updateDevice(msg tea.KeyMsg) {
+	if key.Matches(msg, keys.Cancel) {
+		l.pending.close()
+		l.pending, l.device = nil, nil
+		l.err = "Sign-in cancelled"
+	}
+}
+
+func (l *loginScreen) deviceLines() []string {
+	url := l.device.URL
+	if l.device.CompleteURL != "" {
+		url = l.device.CompleteURL
+	}
+	// With the code in the URL there is nothing to type, only to check
+	then := "then enter the code"
+	if l.device.CompleteURL != "" {
+		then = "and check that it shows the code"
+	}
+	lines := []string{
+		overlayTitleStyle.Render("Sign in to " + l.n.name),
+		"",
+		"Open this page to sign in",
+		"",
+		"  " + overlayPromptStyle.Render(url),
+		"",
+		then,
+		"",
+		"  " + overlayTitleStyle.Render(l.device.Code),
+	}
+	if !l.device.Expires.IsZero() {
+		lines = append(lines, "", overlayHintStyle.Render("The code works until "+l.device.Expires.Local().Format("15:04")))
+	}
+	return append(lines, "", overlayHintStyle.Render("waiting for approval… • esc cancel"))
+}
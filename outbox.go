@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The composer gives every message a UUID before sending it and shows it
+// right away, marked pending. Messages still pending when the connection
+// comes back are sent again with the same ID, and the server posts each ID
+// once, so a retry of one that did get through isn't a duplicate. The
+// server's copy then replaces the pending one.
+
+var (
+	messageIDRE       = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	errBadMessageID   = errors.New("message IDs must be UUIDs")
+	errDuplicateMsgID = errors.New("a message with that ID was already sent by someone else")
+)
+
+// newMessageID returns a random (version 4) UUID.
+func newMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// --- Server side ---
+
+// sentLocked looks for a message already sent with the same ID in the
+// history of conv. A retry gets the stored message back.
+func (srv *server) sentLocked(s *session, conv string, id string) (wireMessage, bool, error) {
+	c, _ := srv.conversationLocked(conv)
+	i := c.messageIndex(id)
+	if i < 0 {
+		return wireMessage{}, false, nil
+	}
+	if c.history[i].Nick != s.nick {
+		return wireMessage{}, false, errDuplicateMsgID
+	}
+	return c.history[i], true, nil
+}
+
+// --- Client side ---
+
+// postMessage shows text in ch as pending and sends it. Offline it waits
+// for the connection to come back.
+func (m *model) postMessage(ch *channel, text, replyTo string) tea.Cmd {
+	msg := message{
+		id:      newMessageID(),
+		channel: ch.name,
+		nick:    m.nick,
+		text:    text,
+		time:    time.Now(),
+		replyTo: replyTo,
+		pending: true,
+	}
+	ch.messages = append(ch.messages, msg)
+	if m.client == nil {
+		return nil
+	}
+	return m.sendMessage(ch, msg)
+}
+
+// sendMessage sends msg to ch, sealed if ch is an encrypted DM. Until the
+// server said whether the peer has a key it stays pending and the key is
+// looked up, see e2ee.go.
+func (m *model) sendMessage(ch *channel, msg message) tea.Cmd {
+	text, ready := m.sealFor(ch, msg.text)
+	if !ready {
+		return m.lookupKey(ch.dmPeerNick())
+	}
+	sig := m.sign(ch.name, msg.id, text)
+	_, cmd := m.client.send(frameSend, sendData{ID: msg.id, Channel: ch.name, Text: text, ReplyTo: msg.replyTo, Sig: sig})
+	return cmd
+}
+
+// resendPending sends again every message that is still pending, after
+// reconnecting.
+func (m *model) resendPending() tea.Cmd {
+	if m.client == nil {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, ch := range m.channels {
+		cmds = append(cmds, m.resendPendingIn(ch))
+	}
+	return tea.Batch(cmds...)
+}
+
+// resendPendingIn sends again the messages of ch that are still pending.
+func (m *model) resendPendingIn(ch *channel) tea.Cmd {
+	var cmds []tea.Cmd
+	for _, msg := range ch.messages {
+		if msg.pending {
+			cmds = append(cmds, m.sendMessage(ch, msg))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// confirmMessage takes msg from the server in place of our pending copy
+// of it. It reports whether msg was already shown, pending or not.
+func (m *model) confirmMessage(ch *channel, msg message) bool {
+	i := ch.messageIndex(msg.id)
+	if msg.id == "" || i < 0 {
+		return false
+	}
+	if ch.messages[i].pending {
+		ch.messages[i] = msg
+		m.saveMessages(ch, []message{msg})
+		m.logMessage(msg)
+	}
+	return true
+}
+
+// pendingMessages copies the messages of msgs still to be sent.
+func pendingMessages(msgs []message) []message {
+	return slices.DeleteFunc(slices.Clone(msgs), func(msg message) bool { return !msg.pending })
+}
+
+// keepPending adds pending messages back to ch once its history was
+// replaced by the server's, unless the server has them already.
+func keepPending(ch *channel, pending []message) {
+	for _, msg := range pending {
+		if ch.messageIndex(msg.id) < 0 {
+			ch.messages = append(ch.messages, msg)
+		}
+	}
+}
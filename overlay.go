@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// overlay is a modal panel drawn on top of the main layout. While one is
+// open it receives all key input. Update returns the overlay to keep showing,
+// or nil to close it; actions on the model are requested via returned Cmds.
+type overlay interface {
+	Update(msg tea.Msg) (overlay, tea.Cmd)
+	View(width, height int) string
+}
+
+// placeOverlay draws fg on top of bg with its top-left corner at x, y.
+func placeOverlay(x, y int, fg, bg string) string {
+	bgLines := strings.Split(bg, "\n")
+	fgLines := strings.Split(fg, "\n")
+
+	for i, fgLine := range fgLines {
+		row := y + i
+		if row < 0 || row >= len(bgLines) {
+			continue
+		}
+		bgLine := bgLines[row]
+
+		left := ansi.Truncate(bgLine, x, "")
+		if w := ansi.StringWidth(left); w < x {
+			left += strings.Repeat(" ", x-w)
+		}
+		right := ansi.TruncateLeft(bgLine, x+ansi.StringWidth(fgLine), "")
+
+		bgLines[row] = left + "\x1b[0m" + fgLine + "\x1b[0m" + right
+	}
+	return strings.Join(bgLines, "\n")
+}
+
+// renderOverlay centers the open overlay over the rendered view.
+func (m *model) renderOverlay(view string) string {
+	if m.overlay == nil {
+		return view
+	}
+	box := m.overlay.View(m.width, m.height)
+	x := (m.width - lipgloss.Width(box)) / 2
+	y := (m.height - lipgloss.Height(box)) / 3
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	return placeOverlay(x, y, box, view)
+}
+
+// overlayMouse turns the wheel into up and down in the open overlay. The
+// rest of the mouse waits for it to close, as it does for the login and
+// setup screens.
+func (m *model) overlayMouse(msg tea.MouseMsg) tea.Cmd {
+	if m.overlay == nil || m.login != nil || m.setup != nil || msg.Action != tea.MouseActionPress {
+		return nil
+	}
+	var k tea.KeyMsg
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		k = tea.KeyMsg{Type: tea.KeyUp}
+	case tea.MouseButtonWheelDown:
+		k = tea.KeyMsg{Type: tea.KeyDown}
+	default:
+		return nil
+	}
+	var cmd tea.Cmd
+	m.overlay, cmd = m.overlay.Update(k)
+	return cmd
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A channel can change what a role may do in it, say to make #announcements
+// read-only for members:
+//
+//	/permissions member pin
+//
+// takes posting away from members there and leaves them pinning, and
+// "/permissions member default" gives them the server's set back. An
+// override replaces the role's whole set in that channel, it doesn't add to
+// it. Members whose role lets them manage the channel set them, for roles
+// below their own so nobody locks out themselves or those above. Overrides
+// are part of the channel's state, kept with it in the server's store, and
+// the client goes by them too: where we can't post the composer says why
+// and takes only commands.
+
+// --- Server side ---
+
+// handleOverride sets or clears what a role may do in a channel.
+func (srv *server) handleOverride(s *session, f frame) error {
+	var req overrideData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if req.Role < roleGuest || req.Role > roleOwner {
+		return errBadRole
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permManageChannels) ||
+		srv.roleInLocked(ch, s.nick) <= req.Role {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	overrides := maps.Clone(ch.overrides)
+	if req.Permissions == nil {
+		delete(overrides, req.Role)
+	} else {
+		if overrides == nil {
+			overrides = make(map[role]permission)
+		}
+		overrides[req.Role] = *req.Permissions & permAll
+	}
+	next := *ch
+	next.overrides = overrides
+	if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(&next) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ch.overrides = overrides
+	srv.mu.Unlock()
+
+	req.Nick = s.nick
+	srv.broadcast(req.Channel, newFrame(frameOverridden, req))
+	return nil
+}
+
+// --- Client side ---
+
+// cmdPermissions lists what each role may do in the current channel, or
+// overrides it for one: "/permissions member post,pin", "none" or
+// "default".
+func cmdPermissions(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Permissions are per channel")
+		return nil
+	}
+	name, list, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if name == "" {
+		for _, r := range []role{roleGuest, roleMember, roleModerator, roleAdmin, roleOwner} {
+			line := strings.ToLower(roleLabel(r)) + ": " + m.permissionsIn(ch, r).String()
+			if _, ok := ch.overrides[r]; ok {
+				line += " (overridden here)"
+			}
+			m.notice(line)
+		}
+		return nil
+	}
+	r, err := parseRole(name)
+	if err != nil {
+		m.notice(err.Error())
+		return nil
+	}
+	req := overrideData{Channel: ch.name, Role: r}
+	if list = strings.TrimSpace(list); list != "default" {
+		if list == "" {
+			m.notice("Usage: /permissions <role> <" + strings.Join(permissionNames, ",") + "|none|default>")
+			return nil
+		}
+		p, err := parsePermissions(list)
+		if err != nil {
+			m.notice(err.Error())
+			return nil
+		}
+		req.Permissions = &p
+	}
+	if !m.allowed(ch, permManageChannels, "change permissions") {
+		return nil
+	}
+	return m.request(frameOverride, req)
+}
+
+// applyOverride records a role's permissions in ch changing.
+func (m *model) applyOverride(ch *channel, o overrideData) {
+	who := strings.ToLower(o.Role.String())
+	if o.Permissions == nil {
+		delete(ch.overrides, o.Role)
+		m.noticeIn(ch, o.Nick+" put "+who+" back to the server's permissions here")
+		return
+	}
+	if ch.overrides == nil {
+		ch.overrides = make(map[role]permission)
+	}
+	ch.overrides[o.Role] = *o.Permissions
+	m.noticeIn(ch, o.Nick+" changed what "+who+" may do here to: "+o.Permissions.String())
+}
+
+// postBlocked is why we can't post in ch, or "" if we can.
+func (m *model) postBlocked(ch *channel) string {
+	switch {
+	case ch == nil:
+		return ""
+	case ch.archived:
+		return "This channel is archived and read-only"
+	case !m.can(ch, permPost) && m.client != nil && m.client.guest:
+		return "Guests can only read " + ch.name + ", /register for an account"
+	case !m.can(ch, permPost):
+		r, _ := m.roleIn(ch)
+		return r.String() + " can't post in " + ch.name
+	case ch.mute.activeAt(time.Now()):
+		return "You are muted in " + ch.name + ch.mute.until()
+	}
+	return m.keyUnverified(ch)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type splitMode int
+
+const (
+	splitNone splitMode = iota
+	splitVertical
+	splitHorizontal
+)
+
+// pane is one view onto a buffer in the main content area. Each pane keeps
+// its own scroll position and message selection.
+type pane struct {
+	buffer   string // channel name
+	viewport viewport.Model
+	selected int  // selected message index, -1 for none
+	follow   bool // stick to the bottom as messages arrive
+	reveal   bool // scroll the selection into view on next render
+	offsets  []int
+	area     rect // screen region of its messages, recorded by renderMain
+
+	// The first message and its line as of the last render, so messages
+	// stitched on above can be scrolled past without moving the view
+	firstID  string
+	firstTop int
+}
+
+func newPane(buffer string) *pane {
+	return &pane{
+		buffer:   buffer,
+		viewport: viewport.New(0, 0),
+		selected: -1,
+		follow:   true,
+	}
+}
+
+func (m *model) currentPane() *pane {
+	if m.focusedPane < 0 || m.focusedPane >= len(m.panes) {
+		return nil
+	}
+	return m.panes[m.focusedPane]
+}
+
+// splitPanes opens a second pane in the given direction, showing the next
+// buffer so there is something different to look at.
+func (m *model) splitPanes(mode splitMode) {
+	m.split = mode
+	if len(m.panes) < 2 {
+		next := m.active
+		if len(m.channels) > 1 {
+			next = (m.active + 1) % len(m.channels)
+		}
+		m.panes = append(m.panes, newPane(m.channels[next].name))
+	}
+}
+
+// closeOtherPane goes back to a single pane, keeping the focused one.
+func (m *model) closeOtherPane() {
+	if p := m.currentPane(); p != nil {
+		m.panes = []*pane{p}
+	}
+	m.focusedPane = 0
+	m.split = splitNone
+}
+
+// focusPane moves focus to pane i and makes its buffer the active one.
+func (m *model) focusPane(i int) {
+	if i < 0 || i >= len(m.panes) {
+		return
+	}
+	m.focusedPane = i
+	for idx, ch := range m.channels {
+		if ch.name == m.panes[i].buffer {
+			m.setActive(idx)
+			break
+		}
+	}
+}
+
+// moveSelection moves the pane's message selection by delta, starting from
+// the newest message when nothing is selected.
+func (p *pane) moveSelection(ch *channel, delta int) {
+	if ch == nil || len(ch.messages) == 0 {
+		return
+	}
+	if p.selected < 0 {
+		p.selected = len(ch.messages)
+	}
+	p.selected += delta
+	if p.selected < 0 {
+		p.selected = 0
+	}
+	if p.selected >= len(ch.messages) {
+		p.selected = len(ch.messages) - 1
+	}
+	p.follow = p.selected == len(ch.messages)-1
+	p.reveal = true
+}
+
+// jumpToMention selects the previous (dir < 0) or next (dir > 0) message
+// that mentions us in the focused pane's buffer. It reports whether one was
+// found.
+func (m *model) jumpToMention(dir int) bool {
+	p := m.currentPane()
+	if p == nil {
+		return false
+	}
+	ch := m.channelByName(p.buffer)
+	if ch == nil {
+		return false
+	}
+	i := p.selected
+	if i < 0 {
+		i = len(ch.messages)
+	}
+	for i += dir; i >= 0 && i < len(ch.messages); i += dir {
+		msg := ch.messages[i]
+		if !msg.system && msg.nick != m.nick && m.isMention(msg) {
+			p.selected = i
+			p.follow = false
+			p.reveal = true
+			return true
+		}
+	}
+	return false
+}
+
+// jumpToMessage shows buffer name in the focused pane with message id
+// selected. It reports false if the message isn't loaded.
+func (m *model) jumpToMessage(name, id string) bool {
+	ch := m.channelByName(name)
+	if ch == nil {
+		return false
+	}
+	i := ch.messageIndex(id)
+	if i < 0 {
+		return false
+	}
+	m.switchToBuffer(name)
+	p := m.currentPane()
+	if p == nil {
+		return false
+	}
+	p.selected = i
+	p.follow = false
+	p.reveal = true
+	m.setFocus(focusBuffer)
+	return true
+}
+
+// render lays out the pane's buffer into a width x height block, with
+// loading (if not empty) as a row above the messages.
+func (p *pane) render(ch *channel, loading string, width, height int) string {
+	p.viewport.Width = width
+	p.viewport.Height = height
+
+	content, offsets := renderBuffer(ch, width, p.selected)
+	if loading != "" {
+		content = loading + "\n" + content
+		for i := range offsets {
+			offsets[i]++
+		}
+	}
+	p.offsets = offsets
+	p.viewport.SetContent(content)
+	p.stitch(ch)
+
+	switch {
+	case p.follow:
+		p.viewport.GotoBottom()
+	case p.reveal && p.selected >= 0 && p.selected < len(offsets):
+		top := offsets[p.selected]
+		if top < p.viewport.YOffset || top >= p.viewport.YOffset+height {
+			p.viewport.SetYOffset(top - height/2)
+		}
+	}
+	p.reveal = false
+
+	return lipgloss.NewStyle().Width(width).Height(height).Render(p.viewport.View())
+}
+
+// stitch keeps the view on the same messages after older ones were put in
+// front of them.
+func (p *pane) stitch(ch *channel) {
+	if ch == nil || len(ch.messages) == 0 {
+		p.firstID, p.firstTop = "", 0
+		return
+	}
+	if p.firstID != "" {
+		if k := ch.messageIndex(p.firstID); k >= 0 {
+			p.viewport.SetYOffset(p.viewport.YOffset + p.offsets[k] - p.firstTop)
+		}
+	}
+	p.firstID, p.firstTop = ch.messages[0].id, p.offsets[0]
+}
+
+// renderMain renders the main content area, one bordered box per pane.
+// width and height are the outer size of the whole area, drawn at
+// m.mainArea.
+func (m *model) renderMain(width, height int) string {
+	boxes := make([]string, len(m.panes))
+	x, y := m.mainArea.x, m.mainArea.y
+	for i, p := range m.panes {
+		w, h := width, height
+		if len(m.panes) > 1 {
+			switch m.split {
+			case splitVertical:
+				first := int(float64(width) * m.settings.Layout.SplitRatio)
+				w = first
+				if i == len(m.panes)-1 {
+					w = width - first
+				}
+			case splitHorizontal:
+				first := int(float64(height) * m.settings.Layout.SplitRatio)
+				h = first
+				if i == len(m.panes)-1 {
+					h = height - first
+				}
+			}
+		}
+
+		style := mainContentStyle
+		if m.focus == focusBuffer && i == m.focusedPane {
+			style = focusedBorder(style)
+		}
+		// Border(2) + padding(2) around the content, by default
+		contentW, contentH := w-style.GetHorizontalFrameSize(), h-style.GetVerticalFrameSize()
+		if contentW < 1 {
+			contentW = 1
+		}
+		if contentH < 0 {
+			contentH = 0
+		}
+		ch := m.channelByName(p.buffer)
+		body := p.render(ch, m.loadingRow(ch), contentW, contentH)
+		boxes[i] = style.Width(w - style.GetHorizontalBorderSize()).Height(contentH).Render(body)
+		p.area = rect{
+			x: x + style.GetBorderLeftSize() + style.GetPaddingLeft(),
+			y: y + style.GetBorderTopSize() + style.GetPaddingTop(),
+			w: contentW,
+			h: contentH,
+		}
+		if m.split == splitHorizontal {
+			y += h
+		} else {
+			x += w
+		}
+	}
+
+	if m.split == splitHorizontal {
+		return lipgloss.JoinVertical(lipgloss.Left, boxes...)
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, boxes...)
+}
+
+// messageAt is the index of the message the pane shows at screen row y,
+// or -1 for none.
+func (p *pane) messageAt(y int) int {
+	line := p.viewport.YOffset + y - p.area.y
+	if line < 0 || line >= p.viewport.TotalLineCount() {
+		return -1
+	}
+	i, found := slices.BinarySearch(p.offsets, line)
+	if !found {
+		i--
+	}
+	return i
+}
+
+// handlePaneMouse scrolls the pane under the wheel and, on a click, focuses
+// the pane, selecting the message clicked on. It reports whether the event
+// was consumed.
+func (m *model) handlePaneMouse(msg tea.MouseMsg) (bool, tea.Cmd) {
+	i := slices.IndexFunc(m.panes, func(p *pane) bool { return p.area.contains(msg.X, msg.Y) })
+	if i < 0 {
+		return false, nil
+	}
+	p := m.panes[i]
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp:
+		p.viewport.ScrollUp(p.viewport.MouseWheelDelta)
+		p.follow = false
+		return true, m.backfill(p)
+	case msg.Button == tea.MouseButtonWheelDown:
+		p.viewport.ScrollDown(p.viewport.MouseWheelDelta)
+		p.follow = p.viewport.AtBottom()
+		return true, nil
+	case msg.Action == tea.MouseActionRelease:
+		m.focusPane(i)
+		m.setFocus(focusBuffer)
+		ch := m.channelByName(p.buffer)
+		if sel := p.messageAt(msg.Y); ch != nil && sel >= 0 && sel < len(ch.messages) {
+			p.selected = sel
+			p.follow = p.follow && sel == len(ch.messages)-1
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account passwords are hashed with argon2id, each with its own random
+// salt, and kept in the PHC string format, which records the cost it was
+// hashed with:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// So the cost can be raised with the -argon2-* flags at any time. A hash
+// made with less (or with bcrypt, as before argon2id) still verifies, and
+// is replaced with one at the current cost the next time its owner logs in.
+
+const (
+	argonSaltLength = 16
+	argonKeyLength  = 32
+	// Bounds on the cost, so a hash can't make a login take all the
+	// memory or all day
+	maxArgonMemory = 4 << 20 // KiB, 4 GiB
+	maxArgonTime   = 100
+)
+
+var errBadPasswordHash = errors.New("unrecognised password hash")
+
+// argonParams is the cost of an argon2id hash.
+type argonParams struct {
+	Memory  uint32 // KiB
+	Time    uint32 // passes
+	Threads uint8
+}
+
+// defaultArgonParams follow the OWASP recommendation of the time, with
+// room to spare.
+var defaultArgonParams = argonParams{Memory: 64 * 1024, Time: 3, Threads: 2}
+
+func (p argonParams) validate() error {
+	switch {
+	case p.Time < 1 || p.Threads < 1:
+		return errors.New("argon2 needs at least one pass and one thread")
+	case p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("argon2 needs at least %d KiB of memory for %d threads", 8*uint32(p.Threads), p.Threads)
+	case p.Memory > maxArgonMemory || p.Time > maxArgonTime:
+		return fmt.Errorf("argon2 takes at most %d KiB of memory and %d passes", maxArgonMemory, maxArgonTime)
+	}
+	return nil
+}
+
+// hashPassword hashes password with a new salt at cost p.
+func hashPassword(password string, p argonParams) (string, error) {
+	salt := make([]byte, argonSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argonKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// setPasswordCost sets the cost of new hashes, and makes the dummy hash
+// checked for nicks with no account cost the same.
+func (srv *server) setPasswordCost(p argonParams) {
+	srv.passwordCost = p
+	srv.dummyHash, _ = hashPassword(newID(), p)
+}
+
+// checkPassword reports whether password matches hash, and whether hash
+// should be redone at cost p because it was made with another one.
+func checkPassword(hash, password string, p argonParams) (ok, stale bool, err error) {
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return err == nil, true, err
+	}
+
+	var version int
+	var used argonParams
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return false, false, errBadPasswordHash
+	}
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errBadPasswordHash
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &used.Memory, &used.Time, &used.Threads); err != nil || used.validate() != nil {
+		// argon2 panics on no passes or threads
+		return false, false, errBadPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false, false, errBadPasswordHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(want) == 0 {
+		return false, false, errBadPasswordHash
+	}
+	got := argon2.IDKey([]byte(password), salt, used.Time, used.Memory, used.Threads, uint32(len(want)))
+	ok = subtle.ConstantTimeCompare(got, want) == 1
+	return ok, used.Memory < p.Memory || used.Time < p.Time || used.Threads < p.Threads, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgonParams keep hashing fast in tests.
+var testArgonParams = argonParams{Memory: 1024, Time: 1, Threads: 1}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, err := hashPassword("correct horse", testArgonParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash %q isn't in the PHC format", hash)
+	}
+	ok, stale, err := checkPassword(hash, "correct horse", testArgonParams)
+	if !ok || stale || err != nil {
+		t.Errorf("checkPassword(right password) = %v, %v, %v, want true, false, nil", ok, stale, err)
+	}
+	ok, _, err = checkPassword(hash, "correct horse!", testArgonParams)
+	if ok || err != nil {
+		t.Errorf("checkPassword(wrong password) = %v, %v, want false, nil", ok, err)
+	}
+
+	again, _ := hashPassword("correct horse", testArgonParams)
+	if again == hash {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestCheckPasswordStale(t *testing.T) {
+	cheap, _ := hashPassword("correct horse", testArgonParams)
+	bcrypted, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	higher := argonParams{Memory: 2048, Time: 2, Threads: 1}
+
+	for _, tc := range []struct {
+		name, hash, password string
+		ok, stale            bool
+	}{
+		{"argon2id at less cost", cheap, "correct horse", true, true},
+		{"argon2id at less cost, wrong password", cheap, "wrong", false, false},
+		{"bcrypt", string(bcrypted), "correct horse", true, true},
+		{"bcrypt, wrong password", string(bcrypted), "wrong", false, false},
+	} {
+		ok, stale, err := checkPassword(tc.hash, tc.password, higher)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if ok != tc.ok || ok && stale != tc.stale {
+			t.Errorf("%s: got ok %v, stale %v, want %v, %v", tc.name, ok, stale, tc.ok, tc.stale)
+		}
+	}
+}
+
+func TestCheckPasswordMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=lots,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=4,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64!$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	} {
+		if ok, _, err := checkPassword(hash, "password1", testArgonParams); ok || !errors.Is(err, errBadPasswordHash) {
+			t.Errorf("checkPassword(%q) = %v, %v, want false, errBadPasswordHash", hash, ok, err)
+		}
+	}
+}
+
+func TestLoginUpgradesHash(t *testing.T) {
+	bcrypted, _ := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.MinCost)
+	cheap, _ := hashPassword("password1", argonParams{Memory: 512, Time: 1, Threads: 1})
+	current, _ := hashPassword("password1", testArgonParams)
+
+	srv := newServer()
+	srv.setPasswordCost(testArgonParams)
+	srv.accounts["alice"] = account{Hash: string(bcrypted)}
+	srv.accounts["bob"] = account{Hash: cheap}
+	srv.accounts["carol"] = account{Hash: current}
+
+	login := func(nick, password string) error {
+		s := &session{srv: srv, nick: nick, conn: discardFrameConn(), stamp: newEventStamp()}
+		_, err := srv.authenticate(s, helloData{Nick: nick, Password: password})
+		return err
+	}
+
+	if err := login("alice", "wrong password"); !errors.Is(err, errBadLogin) {
+		t.Fatalf("wrong password: got %v, want errBadLogin", err)
+	}
+	if srv.accounts["alice"].Hash != string(bcrypted) {
+		t.Error("a failed login replaced the hash")
+	}
+
+	for _, nick := range []string{"alice", "bob", "carol"} {
+		if err := login(nick, "password1"); err != nil {
+			t.Fatalf("%s: %v", nick, err)
+		}
+		hash := srv.accounts[nick].Hash
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("%s's hash after logging in is %q, want one at the current cost", nick, hash)
+		}
+		if ok, stale, _ := checkPassword(hash, "password1", testArgonParams); !ok || stale {
+			t.Errorf("%s's new hash doesn't check out: ok %v, stale %v", nick, ok, stale)
+		}
+	}
+	if srv.accounts["carol"].Hash != current {
+		t.Error("a hash at the current cost was redone")
+	}
+}
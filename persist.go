@@ -0,0 +1,113 @@
+package main
+
+import tea "github.com/charmbracelet/bubbletea"
+
+// storeNetwork is the key the shown network's data is stored under.
+func (m *model) storeNetwork() string {
+	if n := m.currentNetwork(); n != nil {
+		return n.addr
+	}
+	return "local"
+}
+
+// persist runs fn against the store, if there is one. Failures show in the
+// status line rather than interrupting the chat.
+func (m *model) persist(fn func(s store, network string) error) {
+	if m.store == nil {
+		return
+	}
+	if err := fn(m.store, m.storeNetwork()); err != nil {
+		m.lastErr = err
+	}
+}
+
+// restoreFromStore loads the stored buffers of the shown network, so there
+// is scrollback to browse, and the topics and members last seen, before (or
+// without) connecting.
+func (m *model) restoreFromStore() {
+	if m.store == nil {
+		return
+	}
+	stored, err := m.store.loadChannels(m.storeNetwork())
+	if err != nil {
+		m.lastErr = err
+		return
+	}
+	for _, ch := range stored {
+		ch.unread = m.unreadSince(ch, ch.readID)
+		ch.markerID = ch.readID
+		if ch.isDM() {
+			peer := ch.name[1:]
+			ch.members = map[string]*member{m.nick: {nick: m.nick}, peer: {nick: peer}}
+		}
+		// We are here, who else is can't be known before connecting
+		if me, ok := ch.members[m.nick]; ok {
+			me.presence = presenceOnline
+		}
+		if cur := m.channelByName(ch.name); cur != nil {
+			cur.topic, cur.private, cur.archived, cur.created = ch.topic, ch.private, ch.archived, ch.created
+			cur.messages, cur.readID, cur.markerID, cur.unread = ch.messages, ch.readID, ch.markerID, ch.unread
+			if len(ch.members) > 0 {
+				cur.members = ch.members
+			}
+			continue
+		}
+		m.channels = append(m.channels, ch)
+	}
+	m.applyChannelOrder()
+}
+
+// unreadSince counts other people's messages after the one with id readID.
+// With no known read position nothing counts as unread.
+func (m *model) unreadSince(ch *channel, readID string) int {
+	i := ch.messageIndex(readID)
+	if readID == "" || i < 0 {
+		return 0
+	}
+	n := 0
+	for _, msg := range ch.messages[i+1:] {
+		if !msg.system && msg.nick != m.nick {
+			n++
+		}
+	}
+	return n
+}
+
+// mergeStoredHistory puts stored messages older than the server's replay in
+// front of it, so joining doesn't cut scrollback down to the replay window.
+func (m *model) mergeStoredHistory(ch *channel) {
+	if m.store == nil {
+		return
+	}
+	stored, err := m.store.loadMessages(m.storeNetwork(), ch.name, storeScrollback)
+	if err != nil {
+		m.lastErr = err
+		return
+	}
+	if len(ch.messages) == 0 {
+		ch.messages = stored
+		return
+	}
+	first := ch.messages[0].time
+	var older []message
+	for _, msg := range stored {
+		if msg.time.Before(first) && ch.messageIndex(msg.id) < 0 {
+			older = append(older, msg)
+		}
+	}
+	ch.messages = append(older, ch.messages...)
+}
+
+// markRead remembers that the newest message in ch has been seen, locally
+// and on the server.
+func (m *model) markRead(ch *channel) tea.Cmd {
+	last, ok := ch.lastMessage()
+	if !ok || last.id == "" || last.id == ch.readID {
+		return nil
+	}
+	ch.readID = last.id
+	m.persist(func(s store, network string) error {
+		return s.saveReadPosition(network, ch.name, last)
+	})
+	return m.syncReadPosition(ch, last)
+}
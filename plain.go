@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// With --plain the client draws nothing: no boxes, borders or cursor
+// movement, just a transcript written line by line, the way screen readers
+// and braille displays can follow. It shows the active buffer, and the
+// mentions and DMs from elsewhere prefixed with where they came from, plus
+// connections coming and going. Each line typed is what the composer would
+// have taken, a message to the buffer or a command: /switch and /join move
+// to another one. Passwords are asked for up front, and ctrl+d or /quit
+// leaves. What only the full UI has, its panels and overlays, says so.
+
+// plainBacklog is how many messages are shown on coming to a buffer.
+const plainBacklog = 5
+
+// plainInputMsg is a line typed in plain mode.
+type plainInputMsg struct{ line string }
+
+// plainModel runs the model for --plain, printing what it would have drawn.
+type plainModel struct {
+	*model
+	out      io.Writer
+	shown    *channel // the buffer followed
+	last     message  // its newest message printed
+	mentions map[*channel]int
+	states   map[*network]string
+}
+
+// runPlain runs m in plain mode until stdin ends.
+func runPlain(m *model) error {
+	for _, n := range m.networks {
+		if n.creds.usable() {
+			continue
+		}
+		password, err := askPassword("Password for " + n.creds.nick + " on " + n.name + ": ")
+		if err != nil {
+			return err
+		}
+		n.creds.password = password
+	}
+	p := &plainModel{model: m, out: os.Stdout, mentions: map[*channel]int{}, states: map[*network]string{}}
+	prog := tea.NewProgram(p, tea.WithoutRenderer(), tea.WithInput(nil))
+	go func() {
+		lines := bufio.NewScanner(os.Stdin)
+		for lines.Scan() {
+			prog.Send(plainInputMsg{line: lines.Text()})
+		}
+		prog.Quit()
+	}()
+	fmt.Fprintln(p.out, "gochat: type a message to post it, /help for commands, ctrl+d to leave")
+	if len(m.networks) == 0 {
+		fmt.Fprintln(p.out, "No servers to connect to: give one, as gochat connect host:port --plain, or write a config file")
+	}
+	_, err := prog.Run()
+	return err
+}
+
+func (p *plainModel) Init() tea.Cmd {
+	// A size to lay the buffers out in, there being no terminal to go by
+	resize := func() tea.Msg { return tea.WindowSizeMsg{Width: 80, Height: 24} }
+	return tea.Batch(p.model.Init(), resize)
+}
+
+func (p *plainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if line, ok := msg.(plainInputMsg); ok {
+		p.messageInput.SetValue(line.line)
+		cmd = p.sendComposer()
+	} else {
+		_, cmd = p.model.Update(msg)
+	}
+	if p.overlay != nil {
+		p.overlay = nil
+		fmt.Fprintln(p.out, "That needs the full UI, without --plain")
+	}
+	if p.login != nil {
+		// The password asked for didn't do
+		reason := "a password is needed"
+		if n := p.login.n.err; n != nil {
+			reason = n.Error()
+		}
+		fmt.Fprintf(p.out, "Can't sign in to %s: %s\n", p.login.n.name, reason)
+		return p, tea.Quit
+	}
+	p.printStates()
+	p.printBuffer()
+	p.printElsewhere()
+	return p, cmd
+}
+
+func (p *plainModel) View() string { return "" }
+
+// printStates prints the networks whose connection changed.
+func (p *plainModel) printStates() {
+	for i, n := range p.networks {
+		state := p.networkStateLabel(i)
+		if state != p.states[n] {
+			p.states[n] = state
+			fmt.Fprintf(p.out, "%s: %s\n", n.name, state)
+		}
+	}
+}
+
+// printBuffer prints what is new in the active buffer, or the last few
+// messages on coming to another one.
+func (p *plainModel) printBuffer() {
+	ch := p.activeChannel()
+	if ch == nil {
+		return
+	}
+	var unseen []message
+	if ch != p.shown {
+		p.shown = ch
+		header := "Now in " + ch.name
+		if ch.topic != "" {
+			header += ": " + ch.topic
+		}
+		fmt.Fprintln(p.out, header)
+		unseen = ch.messages[max(0, len(ch.messages)-plainBacklog):]
+	} else {
+		unseen = messagesAfter(ch.messages, p.last)
+	}
+	for _, msg := range unseen {
+		fmt.Fprintln(p.out, plainLine(msg))
+	}
+	if len(ch.messages) > 0 {
+		p.last = ch.messages[len(ch.messages)-1]
+	}
+}
+
+// printElsewhere prints the mentions, DMs among them, that came in to
+// buffers other than the active one, on any network.
+func (p *plainModel) printElsewhere() {
+	for i, n := range p.networks {
+		channels := n.stash.channels
+		if i == p.net {
+			channels = p.channels
+		}
+		for _, ch := range channels {
+			seen := p.mentions[ch]
+			p.mentions[ch] = ch.mentions
+			if ch == p.shown || ch.mentions <= seen {
+				continue
+			}
+			msg, ok := ch.lastMessage()
+			if !ok {
+				continue
+			}
+			where := ch.name
+			if len(p.networks) > 1 {
+				where = n.name + " " + where
+			}
+			fmt.Fprintf(p.out, "[%s] %s\n", where, plainLine(msg))
+		}
+	}
+}
+
+// messagesAfter is what follows last in msgs, or if it isn't there any
+// more, what is newer.
+func messagesAfter(msgs []message, last message) []message {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if sameMessage(msgs[i], last) {
+			return msgs[i+1:]
+		}
+	}
+	for i, msg := range msgs {
+		if msg.time.After(last.time) {
+			return msgs[i:]
+		}
+	}
+	return nil
+}
+
+func sameMessage(a, b message) bool {
+	if a.id != "" || b.id != "" {
+		return a.id == b.id
+	}
+	return a.time.Equal(b.time) && a.text == b.text
+}
+
+// plainLine is msg as a transcript line, in words rather than symbols.
+func plainLine(msg message) string {
+	stamp := msg.time.Format(messageFormat.time)
+	if msg.system {
+		return stamp + " " + msg.text
+	}
+	text := strings.ReplaceAll(msg.text, "\n", "\n  ")
+	line := stamp + " " + msg.nick + ": " + text
+	if !msg.edited.IsZero() {
+		line += " (edited)"
+	}
+	if msg.unverified {
+		line += " (unverified)"
+	} else if msg.imported {
+		line += " (imported)"
+	}
+	return line
+}
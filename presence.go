@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Everyone is online, away or offline, shown by the dot next to them in
+// member lists and DMs. A nick is online while any of its sessions, on any
+// instance, isn't away, away while they all are and offline without one.
+// /away marks this machine's sessions away and /back undoes it, and after
+// a while without a key pressed (defaultAutoAway, or /autoaway 20m) that
+// happens by itself, until the next key, unless /autoaway off. Those sharing a channel or a DM hear of a
+// change only when it changes the nick's presence as a whole, so a second
+// device logging in or a busy one going away doesn't tell anyone anything.
+
+// defaultAutoAway is how long without input marks us away, unless
+// /autoaway says otherwise.
+const defaultAutoAway = 10 * time.Minute
+
+// --- Server side ---
+
+// handleAway marks the caller's session away or back.
+func (srv *server) handleAway(s *session, f frame) error {
+	var req awayData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	srv.setAway(s, req.Away)
+	return nil
+}
+
+// setAway marks s away or back, telling the other instances and whoever
+// the change of presence concerns.
+func (srv *server) setAway(s *session, away bool) {
+	srv.mu.Lock()
+	if s.away == away {
+		srv.mu.Unlock()
+		return
+	}
+	was := srv.presenceOfLocked(s.nick)
+	s.away = away
+	now := srv.presenceOfLocked(s.nick)
+	srv.mu.Unlock()
+	srv.publish(clusterEvent{Kind: clusterAway, Nick: s.nick, Away: away})
+	srv.presenceChanged(s.nick, was, now)
+}
+
+// presenceOfLocked is nick's presence across the cluster.
+func (srv *server) presenceOfLocked(nick string) presence {
+	sessions, away := 0, 0
+	for s := range srv.sessions {
+		if s.nick == nick {
+			sessions++
+			if s.away {
+				away++
+			}
+		}
+	}
+	for _, p := range srv.peers {
+		sessions += p.online[nick]
+		away += p.away[nick]
+	}
+	switch {
+	case sessions == 0:
+		return presenceOffline
+	case away >= sessions:
+		return presenceAway
+	}
+	return presenceOnline
+}
+
+// sendDMPresence tells a new session the presence and status (see
+// status.go) of those it has DMs with. Channel members come with the
+// channel state.
+func (srv *server) sendDMPresence(s *session) {
+	srv.mu.Lock()
+	var frames []frame
+	for key := range srv.dms {
+		a, b, _ := strings.Cut(key, "\x00")
+		peer := b
+		switch s.nick {
+		case a:
+		case b:
+			peer = a
+		default:
+			continue
+		}
+		frames = append(frames, newFrame(framePresence, presenceData{Nick: peer, Presence: srv.presenceOfLocked(peer)}))
+		if st := srv.statusOfLocked(peer); st != (userStatus{}) {
+			frames = append(frames, newFrame(frameStatus, statusData{Nick: peer, userStatus: st}))
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, f := range frames {
+		s.conn.write(f)
+	}
+}
+
+// --- Client side ---
+
+// noteInput records a key or a click as input, bringing us back if we
+// went away for the lack of it.
+func (m *model) noteInput(msg tea.Msg) tea.Cmd {
+	switch msg.(type) {
+	case tea.KeyMsg, tea.MouseMsg:
+	default:
+		return nil
+	}
+	m.lastInput = time.Now()
+	if m.away && m.autoAway {
+		return m.setAway(false)
+	}
+	return nil
+}
+
+// autoAwayAfter is how long without input marks us away.
+func (m *model) autoAwayAfter() time.Duration {
+	if m.settings.AutoAwayMinutes > 0 {
+		return time.Duration(m.settings.AutoAwayMinutes) * time.Minute
+	}
+	return defaultAutoAway
+}
+
+// checkIdle sees whether we have been idle long enough to go away, asking
+// the system how long that is first if /autoaway system says to.
+func (m *model) checkIdle() tea.Cmd {
+	if m.lastInput.IsZero() {
+		m.lastInput = time.Now()
+	}
+	switch {
+	case !m.settings.AutoAway:
+		return nil
+	case m.settings.AutoAwaySystem && idleTool() != "":
+		return querySystemIdle
+	}
+	return m.idleFor(time.Since(m.lastInput))
+}
+
+// handleSystemIdle goes by the system idle time, or by our own input if
+// it couldn't be read.
+func (m *model) handleSystemIdle(msg systemIdleMsg) tea.Cmd {
+	idle := time.Since(m.lastInput)
+	if msg.ok {
+		idle = min(idle, msg.idle)
+	}
+	return m.idleFor(idle)
+}
+
+// idleFor marks us away after idle without input, and back once there was
+// some, if that is what made us away. Only the network shown does, as
+// setAway reaches the others from there.
+func (m *model) idleFor(idle time.Duration) tea.Cmd {
+	switch after := m.autoAwayAfter(); {
+	case m.background:
+	case !m.away && idle >= after:
+		cmd := m.setAway(true)
+		m.autoAway = true
+		return cmd
+	case m.away && m.autoAway && idle < after:
+		return m.setAway(false)
+	}
+	return nil
+}
+
+// setAway tells every server we are connected to that we are away, or
+// back.
+func (m *model) setAway(away bool) tea.Cmd {
+	m.away, m.autoAway = away, false
+	clients := []*client{m.client}
+	for i, n := range m.networks {
+		if i != m.net {
+			clients = append(clients, n.stash.client)
+		}
+	}
+	var cmds []tea.Cmd
+	for _, c := range clients {
+		if c != nil {
+			_, cmd := c.send(frameAway, awayData{Away: away})
+			cmds = append(cmds, cmd)
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+func cmdAway(m *model, args string) tea.Cmd {
+	if m.away && !m.autoAway {
+		m.notice("You are already away, /back when you are back")
+		return nil
+	}
+	m.notice("You are away, until /back")
+	return m.setAway(true)
+}
+
+func cmdBack(m *model, args string) tea.Cmd {
+	if !m.away {
+		m.notice("You aren't away")
+		return nil
+	}
+	m.notice("Welcome back")
+	return m.setAway(false)
+}
+
+func cmdAutoAway(m *model, args string) tea.Cmd {
+	args = strings.TrimSpace(args)
+	switch args {
+	case "":
+	case "on":
+		m.settings.AutoAway = true
+	case "off":
+		m.settings.AutoAway = false
+	case "system":
+		m.settings.AutoAway, m.settings.AutoAwaySystem = true, true
+	case "tui":
+		m.settings.AutoAway, m.settings.AutoAwaySystem = true, false
+	default:
+		d, ok := parseModDuration(args)
+		if !ok || d < time.Minute {
+			m.notice("Usage: /autoaway [on|off|system|tui|minutes], the minutes like 5m or 1h")
+			return nil
+		}
+		m.settings.AutoAway, m.settings.AutoAwayMinutes = true, int(d/time.Minute)
+	}
+	if !m.settings.AutoAway {
+		m.notice("Not going away by itself, until /autoaway on")
+	} else {
+		where := "in gochat"
+		switch {
+		case !m.settings.AutoAwaySystem:
+		case idleTool() == "":
+			where += ", as the system's idle time can't be read here"
+		default:
+			where = "anywhere on the desktop"
+		}
+		m.notice(fmt.Sprintf("Going away after %d minutes without input %s", int(m.autoAwayAfter()/time.Minute), where))
+	}
+	if args == "" {
+		return nil
+	}
+	return saveSettingsCmd(m.settings)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A profile in the config file is another set of servers to connect to,
+// with the nick, [theme] and channels to join that go with them: work and
+// home, say, each with its own servers. "gochat connect work" (or
+// --profile work) starts with one, and /profile work switches to it while
+// running, leaving the servers of the one before. What a profile doesn't
+// set comes from the top of the file, and /profile - goes back to that.
+// A server's autojoin channels are joined each time it is logged in to
+// afresh, profile or not.
+
+// withProfile is c with the nick, servers and theme of its profile name,
+// those it sets, in place of its own. No name is c as it is.
+func (c config) withProfile(name string) (config, error) {
+	if name == "" {
+		return c, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return c, fmt.Errorf("no profile %q", name)
+	}
+	c.Nick = cmp.Or(p.Nick, c.Nick)
+	if len(p.Servers) > 0 {
+		c.Servers = p.Servers
+	}
+	if !reflect.ValueOf(p.Theme).IsZero() {
+		c.Theme = p.Theme
+	}
+	return c, nil
+}
+
+// switchProfile disconnects from the servers in use and connects to those
+// of profile name, in its theme.
+func (m *model) switchProfile(name string) tea.Cmd {
+	c, err := loadConfig(m.opts.config)
+	if err == nil {
+		c, err = c.withProfile(name)
+	}
+	var cmd tea.Cmd
+	if err == nil {
+		cmd, err = m.useConfig(c, cmp.Or(c.Profiles[name].Nick, m.opts.nick))
+	}
+	if err != nil {
+		m.notice("Profile not switched: " + err.Error())
+		return nil
+	}
+	m.opts.profile = name
+	if name == "" {
+		m.notice("Switched to the servers outside any profile")
+	} else {
+		m.notice("Switched to profile " + name)
+	}
+	return cmd
+}
+
+// useConfig disconnects from the servers in use and connects to those of
+// c as nick, in its looks.
+func (m *model) useConfig(c config, nick string) (tea.Cmd, error) {
+	servers, err := c.serverList()
+	if err == nil {
+		err = c.applyLooks()
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.restyle()
+	m.closeNetworks()
+	m.useServers(servers, nick, false)
+	m.recalcLayout()
+	return m.connectAll(), nil
+}
+
+// closeNetworks closes every network's connection.
+func (m *model) closeNetworks() {
+	for i, n := range m.networks {
+		c := n.stash.client
+		if i == m.net {
+			c = m.client
+		}
+		if c != nil {
+			c.close()
+		}
+	}
+	m.client = nil
+}
+
+// autojoin joins the channels c's server is set to join on connecting.
+func (m *model) autojoin(c *client) tea.Cmd {
+	if c.network == nil {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, name := range c.network.server.autojoin {
+		if !strings.HasPrefix(name, "#") {
+			name = "#" + name
+		}
+		_, cmd := c.send(frameJoin, channelRef{Channel: name})
+		cmds = append(cmds, cmd)
+	}
+	return tea.Batch(cmds...)
+}
+
+func cmdProfile(m *model, args string) tea.Cmd {
+	name := strings.TrimSpace(args)
+	if name != "" {
+		if name == "-" {
+			name = ""
+		}
+		return m.switchProfile(name)
+	}
+	c, err := loadConfig(m.opts.config)
+	if err != nil {
+		m.notice("Can't read the config file: " + err.Error())
+		return nil
+	}
+	if len(c.Profiles) == 0 {
+		m.notice("The config file has no profiles")
+		return nil
+	}
+	names := slices.Sorted(maps.Keys(c.Profiles))
+	for i, name := range names {
+		if name == m.opts.profile {
+			names[i] += " (in use)"
+		}
+	}
+	m.notice("Profiles: " + strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The wire protocol is newline-delimited JSON frames over TCP. Every frame
+// has a type and an optional typed payload in data. A frame sent in reply to
+// a request carries the request's id.
+
+const (
+	// client -> server
+	frameHello        = "hello"
+	frameJoin         = "join"
+	framePart         = "part"
+	frameSend         = "send"
+	frameList         = "list"
+	frameCreate       = "create"
+	frameTopic        = "topic"
+	frameArchive      = "archive"
+	frameOverride     = "override"
+	frameModerate     = "moderate"
+	frameSlowMode     = "slow_mode"
+	frameFilter       = "filter"
+	frameFilterLog    = "filter_log"
+	frameReport       = "report"
+	frameReports      = "reports"
+	frameResolve      = "resolve_report"
+	frameModLog       = "mod_log"
+	frameInvite       = "invite"
+	frameRemove       = "remove"
+	frameReact        = "react"
+	framePin          = "pin"
+	framePing         = "ping"
+	frameHistory      = "history"
+	frameImport       = "import"
+	framePurge        = "purge"
+	frameRead         = "read"
+	frameKVSet        = "kv_set"
+	frameEdit         = "edit"
+	frameDelete       = "delete"
+	frameEdits        = "edits"
+	frameRefresh      = "refresh"
+	frameUserData     = "user_data"
+	frameEraseUser    = "erase_user"
+	frameSetKey       = "set_key"
+	frameGetKey       = "get_key"
+	frameDevices      = "devices"
+	frameRevokeDevice = "revoke_device"
+	frameCreateInvite = "create_invite"
+	frameInvites      = "invites"
+	frameRevokeInvite = "revoke_invite"
+	frameAway         = "away"
+	frameSetStatus    = "set_status"
+	frameTyping       = "typing"
+
+	// server -> client
+	frameWelcome       = "welcome"
+	frameChannelState  = "channel_state"
+	frameChannelList   = "channel_list"
+	frameMemberJoin    = "member_join"
+	frameMemberPart    = "member_part"
+	frameTopicChanged  = "topic_changed"
+	frameArchived      = "archived"
+	frameOverridden    = "overridden"
+	frameModerated     = "moderated"
+	frameSlowModeSet   = "slow_mode_set"
+	frameFilters       = "filters"
+	frameFilterHits    = "filter_hits"
+	frameReportFiled   = "report_filed"
+	frameReportQueue   = "report_queue"
+	frameResolved      = "report_resolved"
+	frameModLogEntries = "mod_log_entries"
+	frameMessage       = "message"
+	frameReaction      = "reaction"
+	framePinned        = "pinned"
+	framePresence      = "presence"
+	framePong          = "pong"
+	frameHistoryPage   = "history_page"
+	frameImported      = "imported"
+	framePurged        = "purged"
+	frameReadMarker    = "read_marker"
+	frameKV            = "kv"
+	frameKVSnapshot    = "kv_snapshot"
+	frameEdited        = "edited"
+	frameDeleted       = "deleted"
+	frameEditHistory   = "edit_history"
+	frameUserArchive   = "user_archive"
+	frameUserErased    = "user_erased"
+	frameLoginFailed   = "login_failed" // instead of welcome, see accounts.go
+	frameTokens        = "tokens"
+	frameDeviceCode    = "device_code" // before welcome, see oidc.go
+	frameKey           = "key"
+	frameDeviceList    = "device_list"
+	frameInviteCreated = "invite_created"
+	frameInviteList    = "invite_list"
+	frameStatus        = "status"
+	frameTypingState   = "typing_state"
+	frameError         = "error"
+)
+
+type frame struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+func newFrame(typ string, v any) frame {
+	f := frame{Type: typ}
+	if v != nil {
+		// Payloads are plain structs, marshalling them can't fail
+		f.Data, _ = json.Marshal(v)
+	}
+	return f
+}
+
+func (f frame) decode(v any) error {
+	return json.Unmarshal(f.Data, v)
+}
+
+// --- Payloads ---
+
+type helloData struct {
+	Nick     string `json:"nick"`
+	Password string `json:"password,omitempty"`
+	// Register creates the account rather than logging in to it, with
+	// an invite code if the server wants one (see invites.go)
+	Register bool   `json:"register,omitempty"`
+	Invite   string `json:"invite,omitempty"`
+	// Token and Refresh resume a session instead of a password
+	Token   string `json:"token,omitempty"`
+	Refresh string `json:"refresh,omitempty"`
+	// SSO signs in with the server's identity provider instead, and
+	// Guest comes in without an account (see guests.go)
+	SSO   bool `json:"sso,omitempty"`
+	Guest bool `json:"guest,omitempty"`
+	// Resume takes over the session the token came with, if it is still
+	// there (see resume.go)
+	Resume string `json:"resume,omitempty"`
+	// Client is what the client runs on, for the device list
+	Client string `json:"client,omitempty"`
+	// Seen is the newest event seen per buffer, when reconnecting
+	Seen map[string]uint64 `json:"seen,omitempty"`
+	// Away starts the session away (see presence.go)
+	Away bool `json:"away,omitempty"`
+}
+
+type welcomeData struct {
+	Nick string `json:"nick"`
+	// Tokens are a new session, after a password or expired access token
+	Tokens *sessionTokens `json:"tokens,omitempty"`
+	// Role is the server role of Nick, and Permissions what each role
+	// allows, see roles.go
+	Role        role                `json:"role,omitempty"`
+	Permissions map[role]permission `json:"permissions,omitempty"`
+	// Guest is whether Nick is a guest's, without an account
+	Guest bool `json:"guest,omitempty"`
+	// Resume is the token to take the session over with if the
+	// connection drops, and Resumed whether this took one over
+	Resume  string `json:"resume,omitempty"`
+	Resumed bool   `json:"resumed,omitempty"`
+}
+
+// sessionTokens log a client in again without its password, see tokens.go.
+// A refresh request has only Refresh.
+type sessionTokens struct {
+	Access  string    `json:"access,omitempty"`
+	Refresh string    `json:"refresh"`
+	Expires time.Time `json:"expires,omitzero"` // of Access
+}
+
+// deviceCode is what the user approves a single sign-on with, at URL or
+// CompleteURL, which has Code in it already.
+type deviceCode struct {
+	URL         string    `json:"url"`
+	CompleteURL string    `json:"complete_url,omitempty"`
+	Code        string    `json:"code"`
+	Expires     time.Time `json:"expires,omitzero"`
+}
+
+// e2eKeyData is the public key DMs with Nick are encrypted to, see
+// e2ee.go, and the one their messages are signed with, see signing.go.
+// Setting them has no Nick, and either is empty if they have none.
+type e2eKeyData struct {
+	Nick    string `json:"nick,omitempty"`
+	Key     string `json:"key"`
+	Signing string `json:"signing,omitempty"`
+}
+
+// deviceInfo is one device an account is logged in on, see devices.go.
+type deviceInfo struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client,omitempty"`
+	Addr    string    `json:"addr,omitempty"`
+	Started time.Time `json:"started"`
+	Active  time.Time `json:"active"`
+	// Current is the one asking, Online ones are connected to the instance
+	// answering
+	Current bool `json:"current,omitempty"`
+	Online  bool `json:"online,omitempty"`
+}
+
+type deviceList struct {
+	Devices []deviceInfo `json:"devices"`
+}
+
+// deviceRef names a device to revoke.
+type deviceRef struct {
+	ID string `json:"id"`
+}
+
+// inviteRequest makes an invite code, see invites.go. TTL is zero for an
+// invite that doesn't expire, MaxUses for one that can be used any number
+// of times.
+type inviteRequest struct {
+	Channel string        `json:"channel,omitempty"`
+	MaxUses int           `json:"max_uses,omitempty"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+}
+
+// inviteCreated is the code of a new invite, the only time it is sent.
+type inviteCreated struct {
+	Code   string `json:"code"`
+	Invite invite `json:"invite"`
+}
+
+// inviteList is the server's invites, newest first.
+type inviteList struct {
+	Invites []invite `json:"invites"`
+}
+
+// inviteRef names an invite to revoke.
+type inviteRef struct {
+	ID string `json:"id"`
+}
+
+type channelRef struct {
+	Channel string `json:"channel"`
+}
+
+type sendData struct {
+	ID      string `json:"id,omitempty"` // a UUID from the client, see outbox.go
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+	ReplyTo string `json:"reply_to,omitempty"` // ID of the message answered
+	Sig     string `json:"sig,omitempty"`      // see signing.go
+}
+
+type createData struct {
+	Name    string `json:"name"`
+	Topic   string `json:"topic"`
+	Private bool   `json:"private"`
+}
+
+type topicData struct {
+	Channel string `json:"channel"`
+	Topic   string `json:"topic"`
+	Nick    string `json:"nick,omitempty"` // who changed it, set by the server
+}
+
+type listData struct {
+	Archived bool `json:"archived,omitempty"` // list archived channels instead
+}
+
+type archiveData struct {
+	Channel  string `json:"channel"`
+	Archived bool   `json:"archived"`
+	Nick     string `json:"nick,omitempty"` // who changed it, set by the server
+}
+
+// slowModeData sets a channel's slow mode, the seconds between each
+// member's messages, 0 for off.
+type slowModeData struct {
+	Channel string `json:"channel"`
+	Seconds int    `json:"seconds"`
+	Nick    string `json:"nick,omitempty"` // who changed it, set by the server
+}
+
+// filterRequest changes a channel's word filter rules (see wordfilter.go)
+// with one of Add, Remove (a rule's number, from 1) and Server, or with
+// none of them just asks for them.
+type filterRequest struct {
+	Channel string      `json:"channel"`
+	Add     *filterRule `json:"add,omitempty"`
+	Remove  int         `json:"remove,omitempty"`
+	Server  *bool       `json:"server,omitempty"` // whether the server's rules apply
+}
+
+// filtersData is a channel's filter rules, the reply to a filterRequest.
+type filtersData struct {
+	Channel     string       `json:"channel"`
+	Rules       []filterRule `json:"rules,omitempty"`
+	NoServer    bool         `json:"no_server,omitempty"`
+	ServerRules int          `json:"server_rules"` // how many the server has
+}
+
+// filterLogData asks for a channel's part of the filter's review log, and
+// with Hits is the reply.
+type filterLogData struct {
+	Channel string      `json:"channel"`
+	Hits    []filterHit `json:"hits,omitempty"`
+}
+
+// reportRequest reports a channel message to its moderators, see
+// reports.go.
+type reportRequest struct {
+	Channel string `json:"channel"`
+	ID      string `json:"id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// reportQueueData asks for the open reports in the channels we moderate,
+// and with Reports is the reply.
+type reportQueueData struct {
+	Reports []report `json:"reports,omitempty"`
+}
+
+// resolveData deals with a report in one of the ways in reports.go. The
+// server tells the channel's moderators with the IDs of every report it
+// resolved, those of the same message.
+type resolveData struct {
+	ID     string   `json:"id,omitempty"`
+	Action string   `json:"action"`
+	IDs    []string `json:"ids,omitempty"`  // set by the server
+	Nick   string   `json:"nick,omitempty"` // who resolved them, set by the server
+}
+
+// overrideData sets what a role may do in a channel, or with no
+// Permissions puts it back to the server's.
+type overrideData struct {
+	Channel     string      `json:"channel"`
+	Role        role        `json:"role"`
+	Permissions *permission `json:"permissions,omitempty"`
+	Nick        string      `json:"nick,omitempty"` // who changed it, set by the server
+}
+
+// moderateData kicks, bans, mutes or lifts a ban or mute, see
+// moderation.go. Duration, for a ban or mute, is zero for until lifted.
+type moderateData struct {
+	Channel  string        `json:"channel"`
+	Nick     string        `json:"nick"`
+	Action   string        `json:"action"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+}
+
+// moderationEvent tells a channel, and whoever it happened to, about a
+// moderation action. For a kick or lift only Nick, By and Reason count.
+type moderationEvent struct {
+	Channel string `json:"channel"`
+	Action  string `json:"action"`
+	sanction
+}
+
+// modLogData asks for a channel's part of the moderation log, and with
+// Entries is the reply.
+type modLogData struct {
+	Channel string            `json:"channel"`
+	Entries []moderationEvent `json:"entries,omitempty"`
+}
+
+type wireMessage struct {
+	ID        string              `json:"id"`
+	Channel   string              `json:"channel"`
+	Nick      string              `json:"nick"`
+	Text      string              `json:"text"`
+	Time      time.Time           `json:"time"`
+	ReplyTo   string              `json:"reply_to,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"` // emoji -> nicks
+	Edited    time.Time           `json:"edited,omitzero"`     // last edit, zero if never
+	Seq       uint64              `json:"seq,omitempty"`       // of the event that posted it
+	Sig       string              `json:"sig,omitempty"`       // of the text, see signing.go
+	Imported  bool                `json:"imported,omitempty"`  // brought in with gochat import
+}
+
+// historyRequest asks for messages older than Before in a channel or DM.
+type historyRequest struct {
+	Channel string    `json:"channel"`
+	Before  time.Time `json:"before"`
+	Limit   int       `json:"limit,omitempty"`
+}
+
+// historyPage answers a historyRequest, oldest message first.
+type historyPage struct {
+	Channel  string        `json:"channel"`
+	Messages []wireMessage `json:"messages"`
+	More     bool          `json:"more"` // there are older messages still
+}
+
+// importData adds history from another chat system to a channel, creating
+// it if needed. Messages the channel already has are skipped.
+type importData struct {
+	Channel  string        `json:"channel"`
+	Topic    string        `json:"topic,omitempty"`
+	Private  bool          `json:"private,omitempty"`
+	Created  time.Time     `json:"created"`
+	Messages []wireMessage `json:"messages"`
+}
+
+// importResult answers an importData with how many messages were new.
+type importResult struct {
+	Channel string `json:"channel"`
+	Added   int    `json:"added"`
+}
+
+// purgeData asks for (and then announces) the deletion of a channel's
+// messages from before Before.
+type purgeData struct {
+	Channel string    `json:"channel"`
+	Before  time.Time `json:"before"`
+	Nick    string    `json:"nick,omitempty"`    // who purged, set by the server
+	Removed int       `json:"removed,omitempty"` // set by the server
+}
+
+// kvData is one of an account's synced values, drafts or preferences for
+// example. The newest Updated wins.
+type kvData struct {
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Updated time.Time       `json:"updated"`
+}
+
+// kvSnapshot is every synced value of the account, sent after welcome.
+type kvSnapshot struct {
+	Entries []kvData `json:"entries"`
+}
+
+// pingData is echoed back in a pong so the client can measure lag.
+type pingData struct {
+	Sent time.Time `json:"sent"`
+}
+
+// reactionData toggles a reaction, and tells the channel it changed.
+type reactionData struct {
+	Channel string `json:"channel"`
+	ID      string `json:"id"`
+	Emoji   string `json:"emoji"`
+	Nick    string `json:"nick,omitempty"`  // who reacted, set by the server
+	Added   bool   `json:"added,omitempty"` // false when the reaction was taken back
+	Seq     uint64 `json:"seq,omitempty"`   // set by the server
+}
+
+// editData changes the text of one of the sender's messages, and tells
+// the channel or DM it happened.
+type editData struct {
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Text    string    `json:"text"`
+	Nick    string    `json:"nick,omitempty"` // set by the server
+	Time    time.Time `json:"time,omitzero"`  // set by the server
+	Seq     uint64    `json:"seq,omitempty"`  // set by the server
+	Sig     string    `json:"sig,omitempty"`  // see signing.go
+}
+
+// deleteData removes a message, the sender's own or, for moderators, any
+// in the channel.
+type deleteData struct {
+	Channel string `json:"channel"`
+	ID      string `json:"id"`
+	Nick    string `json:"nick,omitempty"` // who deleted it, set by the server
+	Seq     uint64 `json:"seq,omitempty"`  // set by the server
+}
+
+// editHistoryData asks for, and then lists, the versions of a message.
+type editHistoryData struct {
+	Channel   string     `json:"channel"`
+	ID        string     `json:"id"`
+	Revisions []revision `json:"revisions,omitempty"` // the original first, set by the server
+}
+
+// revision is one version of a message's text.
+type revision struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// userRef names the user a server admin exports or erases.
+type userRef struct {
+	Nick string `json:"nick"`
+}
+
+// userArchive is everything a user has on the server, as exported by
+// /userdata. A large one comes in several frames, each with more Messages
+// and the last with Done set.
+type userArchive struct {
+	Nick      string               `json:"nick"`
+	Exported  time.Time            `json:"exported"`
+	Channels  []archivedMembership `json:"channels,omitempty"` // the ones they are in now
+	Messages  []archivedMessage    `json:"messages,omitempty"`
+	Reactions []archivedReaction   `json:"reactions,omitempty"` // that they still have on messages
+	Reads     []readData           `json:"reads,omitempty"`
+	Synced    []kvData             `json:"synced,omitempty"`
+	Done      bool                 `json:"done,omitempty"`
+}
+
+type archivedMembership struct {
+	Channel string `json:"channel"`
+	Role    role   `json:"role"`
+}
+
+// archivedMessage is a message as last edited, with the versions before.
+type archivedMessage struct {
+	wireMessage
+	Revisions []revision `json:"revisions,omitempty"` // oldest first
+	Deleted   time.Time  `json:"deleted,omitzero"`
+}
+
+type archivedReaction struct {
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Emoji   string    `json:"emoji"`
+	Time    time.Time `json:"time"`
+}
+
+// eraseResult answers an erase with how many messages went.
+type eraseResult struct {
+	Nick     string `json:"nick"`
+	Messages int    `json:"messages"`
+}
+
+type wireMember struct {
+	Nick     string     `json:"nick"`
+	Role     role       `json:"role"`
+	Presence presence   `json:"presence"`
+	Status   userStatus `json:"status,omitzero"`
+}
+
+type memberEvent struct {
+	Channel string `json:"channel"`
+	wireMember
+	By  string `json:"by,omitempty"` // who invited or removed them, if not themselves
+	Seq uint64 `json:"seq"`
+}
+
+// memberRequest asks to invite or remove someone.
+type memberRequest struct {
+	Channel string `json:"channel"`
+	Nick    string `json:"nick"`
+}
+
+type presenceData struct {
+	Nick     string   `json:"nick"`
+	Presence presence `json:"presence"`
+}
+
+// awayData marks the session that sends it away, or back.
+type awayData struct {
+	Away bool `json:"away"`
+}
+
+// statusData is someone's status changing, none clearing it.
+type statusData struct {
+	Nick string `json:"nick"`
+	userStatus
+}
+
+// typingData is someone starting or stopping typing in a channel or DM, as
+// a DM is seen by whoever it goes to.
+type typingData struct {
+	Channel string `json:"channel"`
+	Nick    string `json:"nick,omitempty"`
+	Typing  bool   `json:"typing,omitempty"`
+}
+
+type channelInfo struct {
+	Name     string `json:"name"`
+	Topic    string `json:"topic"`
+	Members  int    `json:"members"`
+	Private  bool   `json:"private,omitempty"`
+	Archived bool   `json:"archived,omitempty"`
+}
+
+type channelListData struct {
+	Channels []channelInfo `json:"channels"`
+	Archived bool          `json:"archived,omitempty"` // reply to an archived listing
+}
+
+type channelStateData struct {
+	Name     string        `json:"name"`
+	Topic    string        `json:"topic"`
+	Private  bool          `json:"private,omitempty"`
+	Archived bool          `json:"archived,omitempty"`
+	Created  time.Time     `json:"created"`
+	Members  []wireMember  `json:"members"`
+	History  []wireMessage `json:"history"`
+	Pins     []wireMessage `json:"pins,omitempty"`
+	// Overrides are what some roles may do here instead, see overrides.go
+	Overrides map[role]permission `json:"overrides,omitempty"`
+	// Mute is the receiving user's, if they are muted here
+	Mute *sanction `json:"mute,omitempty"`
+	// SlowMode is the seconds between each member's messages, see
+	// slowmode.go
+	SlowMode int       `json:"slow_mode,omitempty"`
+	Read     *readData `json:"read,omitempty"` // how far the receiving user has read
+	Seq      uint64    `json:"seq"`            // of the channel's newest event
+	// Since is set instead of History for a client catching up from it,
+	// which gets the frames for the events after it in Gap
+	Since uint64  `json:"since,omitempty"`
+	Gap   []frame `json:"gap,omitempty"`
+}
+
+// readData is a read position: the newest message seen in a channel or DM.
+// Clients send it as they read and the server passes it on to the user's
+// other sessions.
+type readData struct {
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+}
+
+// pinData pins or unpins a message, and tells the channel it changed.
+type pinData struct {
+	Channel string       `json:"channel"`
+	ID      string       `json:"id"`
+	Pinned  bool         `json:"pinned"`
+	Nick    string       `json:"nick,omitempty"`    // who changed it, set by the server
+	Message *wireMessage `json:"message,omitempty"` // the pinned message, set by the server
+}
+
+func (w wireMessage) toMessage() message {
+	return message{
+		id:        w.ID,
+		channel:   w.Channel,
+		nick:      w.Nick,
+		text:      w.Text,
+		time:      w.Time,
+		replyTo:   w.ReplyTo,
+		reactions: w.Reactions,
+		edited:    w.Edited,
+		imported:  w.Imported,
+	}
+}
+
+// maxFrameSize bounds a single frame so a peer can't make us buffer an
+// endless line.
+const maxFrameSize = 1 << 20
+
+// frameConn reads and writes frames on a connection. Writes are safe for
+// concurrent use, reads are not.
+type frameConn struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+
+	// With these set, a read waiting longer than readTimeout fails, and a
+	// write taking longer than writeTimeout hangs up, so a peer that went
+	// quiet or stopped reading doesn't hold anyone up
+	readTimeout, writeTimeout time.Duration
+
+	wmu sync.Mutex
+	enc *json.Encoder
+	// Once the client is gone, away holds what is written in held, up to
+	// maxHeldFrames, for it to resume, and next is where writes go once
+	// it did (see resume.go)
+	away       bool
+	held       []frame
+	overflowed bool
+	next       *frameConn
+}
+
+func newFrameConn(c net.Conn) *frameConn {
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 0, 4096), maxFrameSize)
+	return &frameConn{conn: c, scanner: sc, enc: json.NewEncoder(c)}
+}
+
+// discardFrameConn is a frameConn whose writes go nowhere, for sessions
+// with no client behind them.
+func discardFrameConn() *frameConn {
+	return &frameConn{enc: json.NewEncoder(io.Discard)}
+}
+
+func (c *frameConn) write(f frame) error {
+	c.wmu.Lock()
+	if next := c.next; next != nil {
+		c.wmu.Unlock()
+		return next.write(f)
+	}
+	defer c.wmu.Unlock()
+	if c.away {
+		if len(c.held) < maxHeldFrames {
+			c.held = append(c.held, f)
+		} else {
+			c.overflowed = true
+		}
+		return nil
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	err := c.enc.Encode(f)
+	if err != nil && c.writeTimeout > 0 {
+		// Part of f may have gone out, so nothing after it can; and a
+		// resumed session would miss it, so it catches up instead
+		c.overflowed = true
+		c.conn.Close()
+	}
+	return err
+}
+
+func (c *frameConn) read() (frame, error) {
+	var f frame
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return f, err
+		}
+		return f, io.EOF
+	}
+	err := json.Unmarshal(c.scanner.Bytes(), &f)
+	return f, err
+}
+
+func (c *frameConn) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// applyReaction records that nick added or took back an emoji reaction.
+func (msg *message) applyReaction(emoji, nick string, added bool) {
+	if msg.reactions == nil {
+		msg.reactions = make(map[string][]string)
+	}
+	nicks := slices.DeleteFunc(msg.reactions[emoji], func(n string) bool { return n == nick })
+	if added {
+		nicks = append(nicks, nick)
+	}
+	if len(nicks) == 0 {
+		delete(msg.reactions, emoji)
+		return
+	}
+	msg.reactions[emoji] = nicks
+}
+
+// reactionSummary renders reactions as "👍 2  🎉 1", in a stable order.
+func reactionSummary(reactions map[string][]string) string {
+	emojis := make([]string, 0, len(reactions))
+	for e := range reactions {
+		emojis = append(emojis, e)
+	}
+	sort.Strings(emojis)
+	parts := make([]string, len(emojis))
+	for i, e := range emojis {
+		parts[i] = e + " " + strconv.Itoa(len(reactions[e]))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// targetMessage is the message /react and replies act on: the selection in
+// the focused pane, or else the newest message in the active buffer.
+func (m *model) targetMessage() (*channel, *message) {
+	if p := m.currentPane(); p != nil {
+		if ch := m.channelByName(p.buffer); ch != nil && p.selected >= 0 && p.selected < len(ch.messages) {
+			if msg := &ch.messages[p.selected]; !msg.system {
+				return ch, msg
+			}
+		}
+	}
+	ch := m.activeChannel()
+	if ch == nil {
+		return nil, nil
+	}
+	for i := len(ch.messages) - 1; i >= 0; i-- {
+		if !ch.messages[i].system {
+			return ch, &ch.messages[i]
+		}
+	}
+	return ch, nil
+}
+
+func cmdReact(m *model, args string) tea.Cmd {
+	if args == "" || strings.Contains(args, " ") {
+		m.notice("Usage: /react <emoji>")
+		return nil
+	}
+	ch, msg := m.targetMessage()
+	if msg == nil || msg.id == "" {
+		m.notice("Nothing to react to")
+		return nil
+	}
+	if !m.allowed(ch, permPost, "react") {
+		return nil
+	}
+	return m.request(frameReact, reactionData{Channel: ch.name, ID: msg.id, Emoji: args})
+}
+
+// startReply makes the next message sent from the composer a reply to msg.
+func (m *model) startReply(ch *channel, msg *message) {
+	if msg == nil || msg.system || msg.id == "" {
+		return
+	}
+	m.switchToBuffer(ch.name)
+	m.replyTo = &message{id: msg.id, channel: ch.name, nick: msg.nick, text: msg.text}
+	m.setFocus(focusComposer)
+}
+
+func (m *model) cancelReply() {
+	m.replyTo = nil
+}
+
+func cmdReply(m *model, _ string) tea.Cmd {
+	ch, msg := m.targetMessage()
+	if msg == nil || msg.id == "" {
+		m.notice("Nothing to reply to")
+		return nil
+	}
+	m.startReply(ch, msg)
+	return nil
+}
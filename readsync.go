@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// --- Server side ---
+
+// handleRead records how far the caller has read a channel or DM and tells
+// their other sessions, so every device shows the same unread state. Older
+// positions than the one known are ignored.
+func (srv *server) handleRead(s *session, f frame) error {
+	var req readData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if req.ID == "" {
+		return errNoSuchMessage
+	}
+
+	srv.mu.Lock()
+	if !strings.HasPrefix(req.Channel, "@") {
+		ch, ok := srv.channels[req.Channel]
+		if !ok {
+			srv.mu.Unlock()
+			return errNoSuchChannel
+		}
+		if _, member := ch.members[s.nick]; !member {
+			srv.mu.Unlock()
+			return errNotJoined
+		}
+	}
+	reads := srv.reads[s.nick]
+	if reads == nil {
+		reads = make(map[string]readData)
+		srv.reads[s.nick] = reads
+	}
+	if cur, ok := reads[req.Channel]; ok && !req.Time.After(cur.Time) {
+		srv.mu.Unlock()
+		return nil
+	}
+	reads[req.Channel] = req
+	var others []*session
+	for _, sess := range srv.sessionsOfLocked(s.nick) {
+		if sess != s {
+			others = append(others, sess)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, sess := range others {
+		sess.conn.write(newFrame(frameReadMarker, req))
+	}
+	return nil
+}
+
+// sendDMReads sends a new session its DM read positions. Channel ones go
+// with the channel state.
+func (srv *server) sendDMReads(s *session) {
+	srv.mu.Lock()
+	var markers []readData
+	for name, r := range srv.reads[s.nick] {
+		if strings.HasPrefix(name, "@") {
+			markers = append(markers, r)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, r := range markers {
+		s.conn.write(newFrame(frameReadMarker, r))
+	}
+}
+
+// --- Client side ---
+
+// syncReadPosition tells the server how far ch has been read.
+func (m *model) syncReadPosition(ch *channel, last message) tea.Cmd {
+	if m.client == nil || m.client.guest || m.background {
+		return nil
+	}
+	if !ch.isDM() && ch.members[m.nick] == nil {
+		return nil
+	}
+	_, cmd := m.client.send(frameRead, readData{Channel: ch.name, ID: last.id, Time: last.time})
+	return cmd
+}
+
+// applyReadMarker takes a read position from another device, if it is
+// further along than ours, and recounts the badges from it.
+func (m *model) applyReadMarker(ch *channel, r readData) {
+	if i := ch.messageIndex(ch.readID); i >= 0 && !r.Time.After(ch.messages[i].time) {
+		return
+	}
+	ch.readID = r.ID
+	m.persist(func(s store, network string) error {
+		return s.saveReadPosition(network, ch.name, message{id: r.ID, time: r.Time})
+	})
+	if m.isReadingBottom(ch) {
+		return
+	}
+	ch.markerID = r.ID
+	ch.unread, ch.mentions = 0, 0
+	for _, msg := range ch.messages {
+		if !msg.time.After(r.Time) {
+			continue
+		}
+		a := m.classifyMessage(ch, msg)
+		if a.unread {
+			ch.unread++
+		}
+		if a.mention {
+			ch.mentions++
+		}
+	}
+}
+
+// unreadAfter counts the badges for what came after the message with id in
+// ch, nothing if it isn't there.
+func (m *model) unreadAfter(ch *channel, id string) (unread, mentions int) {
+	i := ch.messageIndex(id)
+	if id == "" || i < 0 {
+		return 0, 0
+	}
+	for _, msg := range ch.messages[i+1:] {
+		a := m.classifyMessage(ch, msg)
+		if a.unread {
+			unread++
+		}
+		if a.mention {
+			mentions++
+		}
+	}
+	return unread, mentions
+}
+
+// renderUnreadLine is the divider drawn under the last message read.
+func renderUnreadLine(width int) string {
+	label := "── new messages "
+	if fill := width - lipgloss.Width(label); fill > 0 {
+		label += strings.Repeat("─", fill)
+	}
+	return unreadLineStyle.Render(truncate(label, width))
+}
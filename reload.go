@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// The client watches the config file while it runs and, when it is saved,
+// reads it again: the colors, theme, icons and keys change on the spot, as
+// do the status line, how messages are laid out, whether the member list
+// shows and whether the mouse is taken. Servers and nicks only count when
+// connecting, so they wait for the next start. A file that doesn't parse
+// leaves everything as it was and says why. /reload does the same by hand,
+// for where watching doesn't work (some network filesystems) or when there
+// was no file to watch at start.
+
+// configSettle is how long the file has to stay unchanged before it is read,
+// editors often saving in several steps.
+const configSettle = 100 * time.Millisecond
+
+// configChangedMsg says the config file was saved.
+type configChangedMsg struct{}
+
+// configWatcher watches the config file.
+type configWatcher struct {
+	w       *fsnotify.Watcher
+	changed chan struct{}
+}
+
+// watchConfig watches the config file at path, or the one found, returning
+// nil if there is none or it can't be watched.
+func watchConfig(path string) *configWatcher {
+	if path = findConfig(path); path == "" {
+		return nil
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil
+	}
+	// The directory, as editors saving by renaming replace the file itself
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil
+	}
+	cw := &configWatcher{w: w, changed: make(chan struct{}, 1)}
+	go cw.run(path)
+	return cw
+}
+
+func (cw *configWatcher) run(path string) {
+	settle := time.AfterFunc(time.Hour, func() {
+		select {
+		case cw.changed <- struct{}{}:
+		default:
+		}
+	})
+	settle.Stop()
+	errs := cw.w.Errors
+	for {
+		select {
+		case ev, ok := <-cw.w.Events:
+			if !ok {
+				settle.Stop()
+				return
+			}
+			if filepath.Clean(ev.Name) == path && ev.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				settle.Reset(configSettle)
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		}
+	}
+}
+
+func (cw *configWatcher) close() {
+	if cw != nil {
+		cw.w.Close()
+	}
+}
+
+// waitForConfig delivers a configChangedMsg once the config file changes.
+func (m *model) waitForConfig() tea.Cmd {
+	if m.configWatch == nil {
+		return nil
+	}
+	changed := m.configWatch.changed
+	return func() tea.Msg {
+		<-changed
+		return configChangedMsg{}
+	}
+}
+
+// reloadConfig reads the config file again and applies what can change
+// while running.
+func (m *model) reloadConfig() tea.Cmd {
+	c, err := loadConfig(m.opts.config)
+	if err == nil {
+		c, err = c.withProfile(m.opts.profile)
+	}
+	if err == nil {
+		err = c.applyLooks()
+	}
+	if err == nil {
+		err = c.Sounds.check()
+	}
+	var h highlighter
+	if err == nil {
+		h, err = parseHighlights(c.Highlight)
+	}
+	var quiet []quietRange
+	if err == nil {
+		quiet, err = parseQuietHours(c.QuietHours)
+	}
+	var rules []rule
+	if err == nil {
+		rules, err = parseRules(c.Rules, c.Sounds)
+	}
+	if err != nil {
+		m.notice("Config file not reloaded: " + err.Error())
+		return nil
+	}
+	m.restyle()
+	old := m.opts.ui
+	m.opts.ui, m.opts.sounds, m.opts.highlight, m.opts.quietHours, m.opts.rules = c.UI, c.Sounds, h, quiet, rules
+	if shown := c.UI.ShowMembers == nil || *c.UI.ShowMembers; shown != (old.ShowMembers == nil || *old.ShowMembers) {
+		m.showMembers = shown
+	}
+	m.recalcLayout()
+	m.notice("Reloaded the config file")
+	mouse := c.UI.Mouse == nil || *c.UI.Mouse
+	switch {
+	case mouse == (old.Mouse == nil || *old.Mouse):
+		return nil
+	case mouse:
+		return tea.EnableMouseCellMotion
+	default:
+		return tea.DisableMouse
+	}
+}
+
+// restyle redraws the inputs after the looks changed.
+func (m *model) restyle() {
+	styleInputs(&m.textInput, &m.messageInput)
+	m.textInput.Cursor.SetMode(cursorMode())
+	m.messageInput.Cursor.SetMode(cursorMode())
+	m.textInput.Prompt = m.searchMode.prompt()
+}
+
+func cmdReload(m *model, _ string) tea.Cmd {
+	return m.reloadConfig()
+}
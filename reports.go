@@ -0,0 +1,452 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Anyone can report a channel message to its moderators:
+//
+//	/report [reason]     the selected or latest message
+//
+// Reports wait in the server's moderation queue, kept in its store, until
+// a moderator of the channel deals with them. Those online hear of each
+// one as it comes in, and /reports opens the queue of every channel they
+// moderate, oldest first, where a report can be
+//
+//	d  dismissed          x  acted on by deleting the message
+//	m  acted on by muting its author for an hour
+//	b  acted on by banning its author until lifted
+//
+// Acting on a report is the delete or moderation request it stands for,
+// made by the moderator and needing what that needs, and resolves every
+// open report of the message. Resolved reports stay in the store, with
+// who resolved them how.
+
+// Ways of resolving a report.
+const (
+	reportDismiss = "dismiss"
+	reportDelete  = "delete"
+	reportMute    = "mute"
+	reportBan     = "ban"
+)
+
+const (
+	// maxOpenReports is how many reports one person can have waiting.
+	maxOpenReports = 20
+	// reportMuteFor is how long muting a reported author lasts.
+	reportMuteFor = time.Hour
+)
+
+var errNoSuchReport = errors.New("no such report, someone may have dealt with it already")
+
+// reportDone describes each way of resolving, for notices.
+var reportDone = map[string]string{
+	reportDismiss: "dismissed",
+	reportDelete:  "deleted the message",
+	reportMute:    "muted its author for an hour",
+	reportBan:     "banned its author",
+}
+
+// report is a message someone reported, with what became of it.
+type report struct {
+	ID         string    `json:"id"`
+	Channel    string    `json:"channel"`
+	MessageID  string    `json:"message_id"`
+	Author     string    `json:"author"`
+	Text       string    `json:"text"` // as it was reported
+	Reporter   string    `json:"reporter"`
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+	Resolution string    `json:"resolution,omitempty"` // empty while open
+	ResolvedBy string    `json:"resolved_by,omitempty"`
+	Resolved   time.Time `json:"resolved,omitzero"`
+}
+
+// --- Server side ---
+
+// handleReport files a report of a channel message and tells the
+// channel's moderators.
+func (srv *server) handleReport(s *session, f frame) error {
+	var req reportRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case len(req.Reason) > maxModReason:
+		return fmt.Errorf("reasons can be at most %d bytes", maxModReason)
+	case strings.HasPrefix(req.Channel, "@"):
+		return errors.New("only channel messages can be reported")
+	}
+
+	srv.mu.Lock()
+	r, err := srv.fileReportLocked(s, req)
+	var mods []*session
+	if err == nil {
+		mods = srv.moderatorSessionsLocked(srv.channels[r.Channel])
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	filed := newFrame(frameReportFiled, r)
+	s.reply(f, filed)
+	for _, sess := range mods {
+		if sess != s {
+			sess.conn.write(filed)
+		}
+	}
+	return nil
+}
+
+// fileReportLocked is handleReport's work under srv.mu.
+func (srv *server) fileReportLocked(s *session, req reportRequest) (report, error) {
+	_, _, msg, err := srv.messageLocked(s, req.Channel, req.ID)
+	if err != nil {
+		return report{}, err
+	}
+	if msg.Nick == s.nick {
+		return report{}, errors.New("you can't report your own message")
+	}
+	open := 0
+	for _, r := range srv.reports {
+		if r.Reporter != s.nick {
+			continue
+		}
+		if r.Channel == req.Channel && r.MessageID == req.ID {
+			return report{}, errors.New("you have reported that message already")
+		}
+		open++
+	}
+	if open >= maxOpenReports {
+		return report{}, fmt.Errorf("you have %d reports waiting for a moderator already", open)
+	}
+	r := report{
+		ID: s.stamp.ID, Channel: req.Channel, MessageID: msg.ID, Author: msg.Nick, Text: msg.Text,
+		Reporter: s.nick, Reason: req.Reason, Time: s.stamp.Time,
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveReport(r) }); err != nil {
+		return report{}, err
+	}
+	srv.reports = append(srv.reports, r)
+	return r, nil
+}
+
+// moderatorSessionsLocked lists the sessions of ch's members whose role
+// may moderate there.
+func (srv *server) moderatorSessionsLocked(ch *serverChannel) []*session {
+	var list []*session
+	for s := range srv.sessions {
+		if _, member := ch.members[s.nick]; member && srv.canLocked(ch, s.nick, permModerate) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// moderatesLocked reports whether s is in the channel a report is about,
+// with a role that may moderate there.
+func (srv *server) moderatesLocked(s *session, r report) bool {
+	ch, ok := srv.channels[r.Channel]
+	if !ok {
+		return false
+	}
+	_, member := ch.members[s.nick]
+	return member && srv.canLocked(ch, s.nick, permModerate)
+}
+
+// handleReports replies with the open reports of the channels s
+// moderates, oldest first.
+func (srv *server) handleReports(s *session, f frame) error {
+	var d reportQueueData
+	srv.mu.Lock()
+	for _, r := range srv.reports {
+		if srv.moderatesLocked(s, r) {
+			d.Reports = append(d.Reports, r)
+		}
+	}
+	srv.mu.Unlock()
+
+	s.reply(f, newFrame(frameReportQueue, d))
+	return nil
+}
+
+// handleResolve dismisses or acts on a report, resolving every open one
+// of the same message.
+func (srv *server) handleResolve(s *session, f frame) error {
+	var req resolveData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if _, ok := reportDone[req.Action]; !ok {
+		return fmt.Errorf("unknown way of resolving a report %q", req.Action)
+	}
+
+	srv.mu.Lock()
+	i := slices.IndexFunc(srv.reports, func(r report) bool { return r.ID == req.ID })
+	if i < 0 {
+		srv.mu.Unlock()
+		return errNoSuchReport
+	}
+	r := srv.reports[i]
+	if !srv.moderatesLocked(s, r) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	srv.mu.Unlock()
+
+	if act, ok := r.action(req.Action); ok {
+		act.ID = f.ID
+		// A message deleted meanwhile needs no deleting
+		if err := srv.handlers[act.Type](s, act); err != nil && !(req.Action == reportDelete && errors.Is(err, errNoSuchMessage)) {
+			return err
+		}
+	}
+
+	srv.mu.Lock()
+	ids, err := srv.resolveLocked(s, r, req.Action)
+	var mods []*session
+	if ch, ok := srv.channels[r.Channel]; ok && err == nil {
+		mods = srv.moderatorSessionsLocked(ch)
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	resolved := newFrame(frameResolved, resolveData{Action: req.Action, IDs: ids, Nick: s.nick})
+	for _, sess := range mods {
+		sess.conn.write(resolved)
+	}
+	return nil
+}
+
+// action is the request acting on r stands for, if it stands for one.
+func (r report) action(how string) (frame, bool) {
+	reason := cmp.Or(r.Reason, "reported")
+	switch how {
+	case reportDelete:
+		return newFrame(frameDelete, deleteData{Channel: r.Channel, ID: r.MessageID}), true
+	case reportMute:
+		return newFrame(frameModerate, moderateData{Channel: r.Channel, Nick: r.Author, Action: modMute, Duration: reportMuteFor, Reason: reason}), true
+	case reportBan:
+		return newFrame(frameModerate, moderateData{Channel: r.Channel, Nick: r.Author, Action: modBan, Reason: reason}), true
+	}
+	return frame{}, false
+}
+
+// resolveLocked resolves the open reports of r's message, and is their
+// IDs.
+func (srv *server) resolveLocked(s *session, r report, how string) ([]string, error) {
+	var done []report
+	for _, open := range srv.reports {
+		if open.Channel == r.Channel && open.MessageID == r.MessageID {
+			open.Resolution, open.ResolvedBy, open.Resolved = how, s.nick, s.stamp.Time
+			done = append(done, open)
+		}
+	}
+	if len(done) == 0 {
+		return nil, errNoSuchReport
+	}
+	if err := s.persistLocked(func(st serverStore) error {
+		for _, r := range done {
+			if err := st.saveReport(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	srv.reports = slices.DeleteFunc(srv.reports, func(open report) bool {
+		return open.Channel == r.Channel && open.MessageID == r.MessageID
+	})
+	ids := make([]string, len(done))
+	for i, r := range done {
+		ids[i] = r.ID
+	}
+	return ids, nil
+}
+
+// --- Client side ---
+
+// reportActionMsg asks the model to resolve a report from the queue.
+type reportActionMsg struct {
+	resolveData
+}
+
+// cmdReport reports the selected or latest message to the moderators.
+func cmdReport(m *model, args string) tea.Cmd {
+	ch, msg := m.targetMessage()
+	switch {
+	case ch == nil || msg == nil || msg.id == "":
+		m.notice("Nothing to report")
+		return nil
+	case ch.isDM():
+		m.notice("Only channel messages can be reported")
+		return nil
+	case msg.nick == m.nick:
+		m.notice("That's your own message")
+		return nil
+	}
+	return m.request(frameReport, reportRequest{Channel: ch.name, ID: msg.id, Reason: strings.TrimSpace(args)})
+}
+
+// cmdReports opens the moderation queue.
+func cmdReports(m *model, _ string) tea.Cmd {
+	if !slices.ContainsFunc(m.channels, func(ch *channel) bool { return !ch.isDM() && m.can(ch, permModerate) }) {
+		m.notice("You don't moderate any channel here")
+		return nil
+	}
+	return m.request(frameReports, reportQueueData{})
+}
+
+// applyReportFiled tells the reporter their report went in, and a
+// moderator that one did, adding it to the queue if that is open.
+func (m *model) applyReportFiled(r report) {
+	if r.Reporter == m.nick {
+		m.notice("Reported " + r.Author + "'s message to the moderators of " + r.Channel)
+		return
+	}
+	if q, ok := m.overlay.(*reportQueue); ok {
+		q.reports = append(q.reports, r)
+	}
+	text := r.Reporter + " reported a message by " + r.Author + ", /reports to review"
+	if ch := m.channelByName(r.Channel); ch != nil {
+		m.noticeIn(ch, text)
+		return
+	}
+	m.notice(text)
+}
+
+// applyResolved drops resolved reports from the queue, if it is open.
+func (m *model) applyResolved(d resolveData) {
+	if q, ok := m.overlay.(*reportQueue); ok {
+		q.resolved(d.IDs)
+	}
+	if d.Nick != m.nick {
+		return
+	}
+	what := "report"
+	if len(d.IDs) != 1 {
+		what = fmt.Sprintf("%d reports", len(d.IDs))
+	}
+	if d.Action == reportDismiss {
+		m.notice("Dismissed the " + what)
+		return
+	}
+	m.notice("Resolved the " + what + ": " + reportDone[d.Action])
+}
+
+// reportQueue is the overlay with the open reports, for moderators to
+// deal with.
+type reportQueue struct {
+	reports []report
+	cursor  int
+	offset  int // reports scrolled past
+}
+
+func newReportQueue(d reportQueueData) *reportQueue {
+	return &reportQueue{reports: d.Reports}
+}
+
+// resolved drops the reports with ids.
+func (q *reportQueue) resolved(ids []string) {
+	q.reports = slices.DeleteFunc(q.reports, func(r report) bool { return slices.Contains(ids, r.ID) })
+	q.cursor = min(q.cursor, max(len(q.reports)-1, 0))
+	q.offset = min(q.offset, q.cursor)
+}
+
+func (q *reportQueue) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return q, nil
+	}
+	how := ""
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		q.cursor = max(q.cursor-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		q.cursor = min(q.cursor+1, max(len(q.reports)-1, 0))
+	case key.Matches(keyMsg, keys.Select):
+		if len(q.reports) == 0 {
+			return q, nil
+		}
+		r := q.reports[q.cursor]
+		return nil, func() tea.Msg { return jumpToMessageMsg{channel: r.Channel, id: r.MessageID} }
+	case key.Matches(keyMsg, keys.Dismiss):
+		how = reportDismiss
+	case key.Matches(keyMsg, keys.Remove):
+		how = reportDelete
+	case key.Matches(keyMsg, keys.Mute):
+		how = reportMute
+	case key.Matches(keyMsg, keys.Ban):
+		how = reportBan
+	}
+	if how == "" || len(q.reports) == 0 {
+		return q, nil
+	}
+	action := reportActionMsg{resolveData{ID: q.reports[q.cursor].ID, Action: how}}
+	return q, func() tea.Msg { return action }
+}
+
+func (q *reportQueue) View(width, height int) string {
+	w := min(70, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Reports (%d)", len(q.reports)))}
+	if len(q.reports) == 0 {
+		lines = append(lines, "", overlayHintStyle.Render("Nothing is waiting for a moderator"))
+	}
+	// Scroll just far enough to show the selected report
+	q.offset = min(q.offset, q.cursor)
+	for q.offset < q.cursor && q.lastShown(inner, height) < q.cursor {
+		q.offset++
+	}
+	for i := q.offset; i <= q.lastShown(inner, height); i++ {
+		lines = append(lines, q.entry(i, inner))
+	}
+	lines = append(lines, "", overlayHintStyle.Render("enter go to • d dismiss • x delete • m mute 1h • b ban • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// lastShown is the last report that fits below the one scrolled to.
+func (q *reportQueue) lastShown(inner, height int) int {
+	used := 4
+	for i := q.offset; i < len(q.reports); i++ {
+		if used += lipgloss.Height(q.entry(i, inner)); used > height-2 && i > q.offset {
+			return i - 1
+		}
+	}
+	return len(q.reports) - 1
+}
+
+// entry renders report i, inner wide.
+func (q *reportQueue) entry(i, inner int) string {
+	r := q.reports[i]
+	head := r.Time.Local().Format("2 Jan 15:04") + " " + r.Channel + " · " + r.Reporter + " reported"
+	if r.Reason != "" {
+		head += ": " + r.Reason
+	}
+	head = truncate(head, inner)
+	if i == q.cursor {
+		head = overlaySelectedStyle.Width(inner).Render(head)
+	} else {
+		head = overlayHintStyle.Render(head)
+	}
+	text := nickStyle(r.Author).Render(r.Author) + " " + lipgloss.NewStyle().Width(inner-lipgloss.Width(r.Author)-1).Render(r.Text)
+	return lipgloss.JoinVertical(lipgloss.Left, "", head, text)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A client whose connection drops gets a while to come back to the same
+// session. The welcome gives it a resume token; when the connection goes,
+// the server parks the session instead of ending it, still online and
+// still a member everywhere, holding on to what it would have been sent.
+// A hello with the token within resumeWindow takes the session over: no
+// password or token check, no presence going offline and online again,
+// and instead of every channel's state just the frames held meanwhile,
+// the replies to requests in flight among them. Past the window, or once
+// too much was held, it is a login and catch-up like any other. The client
+// reconnects by itself, waiting longer after each failed try.
+
+const (
+	// resumeWindow is how long a dropped session waits to be resumed.
+	resumeWindow = 2 * time.Minute
+	// maxHeldFrames is how many frames a parked session holds before it
+	// gives up on them and resuming catches up from scratch.
+	maxHeldFrames = 500
+	// maxReconnectDelay is the longest the client waits between tries.
+	maxReconnectDelay = 30 * time.Second
+)
+
+// --- Server side ---
+
+// hold makes c keep what is written to it, its client being gone.
+func (c *frameConn) hold() {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.away = true
+}
+
+// forward writes what c held to next and sends it all that comes later,
+// returning false, after writing nothing, if c had to drop some.
+func (c *frameConn) forward(next *frameConn) bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	complete := !c.overflowed
+	if complete {
+		for _, f := range c.held {
+			next.write(f)
+		}
+	}
+	c.held, c.next = nil, next
+	return complete
+}
+
+// newResumeToken gives s a resume token, returning it for the welcome.
+func (s *session) newResumeToken() string {
+	token := rand.Text()
+	s.resume = tokenHash(token)
+	return token
+}
+
+// park keeps s, whose connection just dropped, for it to be resumed,
+// returning false if it can't be.
+func (srv *server) park(s *session) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if s.resume == "" || !srv.resumableLocked(s) {
+		return false
+	}
+	s.conn.hold()
+	srv.parked[s.resume] = s
+	time.AfterFunc(resumeWindow, func() { srv.unpark(s) })
+	return true
+}
+
+// resumableLocked is whether s may still be resumed: a guest's, or one
+// whose device wasn't logged out meanwhile.
+func (srv *server) resumableLocked(s *session) bool {
+	if s.guest {
+		return true
+	}
+	_, ok := srv.deviceLocked(s.nick, s.device)
+	return ok
+}
+
+// takeParkedLocked takes the parked sessions of nick, for a guest taking
+// the nick afresh, so they can't be resumed any more, and returns them to
+// be ended. If nick is also in use by a session that isn't parked, here or
+// on another instance, it takes none and returns false.
+func (srv *server) takeParkedLocked(nick string) ([]*session, bool) {
+	var parked []*session
+	for s := range srv.sessions {
+		if s.nick != nick {
+			continue
+		}
+		if srv.parked[s.resume] != s {
+			return nil, false
+		}
+		parked = append(parked, s)
+	}
+	for _, p := range srv.peers {
+		if p.online[nick] > 0 {
+			return nil, false
+		}
+	}
+	for _, s := range parked {
+		delete(srv.parked, s.resume)
+	}
+	return parked, true
+}
+
+// unpark ends s if it wasn't resumed in time.
+func (srv *server) unpark(s *session) {
+	srv.mu.Lock()
+	if srv.parked[s.resume] != s {
+		srv.mu.Unlock()
+		return
+	}
+	delete(srv.parked, s.resume)
+	srv.mu.Unlock()
+	srv.leave(s)
+}
+
+// resume hands the session parked under the token of hello over to s and
+// welcomes it, returning false if there is no such session to resume, and
+// an error if s went away too.
+func (srv *server) resume(s *session, hello helloData) (bool, error) {
+	srv.mu.Lock()
+	old, ok := srv.parked[tokenHash(hello.Resume)]
+	if !ok || old.nick != s.nick || !srv.resumableLocked(old) {
+		srv.mu.Unlock()
+		return false, nil
+	}
+	// Taken out first, so it isn't ended while s is welcomed
+	delete(srv.parked, old.resume)
+	s.device, s.deviceStarted, s.guest = old.device, old.deviceStarted, old.guest
+	welcome := welcomeData{Nick: s.nick, Role: srv.serverRoleLocked(s.nick), Permissions: srv.permissions,
+		Resume: s.newResumeToken(), Resumed: true}
+	if s.guest {
+		welcome.Guest, welcome.Permissions = true, srv.guestPermissionsLocked()
+	}
+	srv.mu.Unlock()
+
+	if err := s.conn.write(newFrame(frameWelcome, welcome)); err != nil {
+		srv.mu.Lock()
+		srv.parked[old.resume] = old
+		srv.mu.Unlock()
+		return true, err
+	}
+	// Held frames first, then whatever was on its way to old
+	complete := old.conn.forward(s.conn)
+	srv.mu.Lock()
+	delete(srv.sessions, old)
+	s.away = old.away
+	srv.sessions[s] = struct{}{}
+	srv.mu.Unlock()
+	if !complete {
+		srv.catchUp(s, hello.Seen)
+	}
+	// The user may have gone away or come back while it was parked
+	srv.setAway(s, hello.Away)
+	return true, nil
+}
+
+// --- Client side ---
+
+// reconnectMsg says it is time to try connecting n again.
+type reconnectMsg struct{ n *network }
+
+// reconnectLater tries connecting n again after a while, longer the more
+// tries failed, if it had a session to come back to.
+func reconnectLater(n *network) tea.Cmd {
+	if n.creds.resume == "" || !n.creds.usable() {
+		return nil
+	}
+	delay := min(time.Second<<n.retries, maxReconnectDelay)
+	n.retries = min(n.retries+1, 5)
+	return tea.Tick(delay, func(time.Time) tea.Msg { return reconnectMsg{n: n} })
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pruneInterval is how often the client and server apply their retention.
+const pruneInterval = time.Hour
+
+// retention bounds how much history is kept. Zero fields are unlimited.
+type retention struct {
+	Days     int `json:"days,omitempty"`
+	Messages int `json:"messages,omitempty"` // per buffer
+}
+
+func (r retention) enabled() bool {
+	return r.Days > 0 || r.Messages > 0
+}
+
+// cutoff is the time before which messages are dropped, zero if age
+// doesn't matter.
+func (r retention) cutoff(now time.Time) time.Time {
+	if r.Days <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -r.Days)
+}
+
+// trim applies r to a history, oldest first.
+func (r retention) trim(history []wireMessage, now time.Time) []wireMessage {
+	i := 0
+	if cut := r.cutoff(now); !cut.IsZero() {
+		i = sort.Search(len(history), func(i int) bool { return !history[i].Time.Before(cut) })
+	}
+	if r.Messages > 0 && len(history)-i > r.Messages {
+		i = len(history) - r.Messages
+	}
+	return history[i:]
+}
+
+// --- Server side ---
+
+// pruneLoop applies the server's retention now and every pruneInterval.
+func (srv *server) pruneLoop() {
+	for {
+		if n := srv.prune(time.Now()); n > 0 {
+			log.Printf("retention: dropped %d messages", n)
+		}
+		time.Sleep(pruneInterval)
+	}
+}
+
+// prune trims every channel and DM history, returning how many messages
+// went.
+func (srv *server) prune(now time.Time) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	dropped := 0
+	for conv, c := range srv.conversationsLocked() {
+		kept := srv.retention.trim(c.history, now)
+		ids := messageIDs(c.history[:len(c.history)-len(kept)])
+		if len(ids) == 0 || srv.persistLocked(func(st serverStore) error { return st.forgetMessages(conv, ids) }) != nil {
+			continue
+		}
+		c.forget(ids)
+		dropped += len(ids)
+	}
+	return dropped
+}
+
+// handlePurge deletes a channel's history from before the given time, for
+// admins. Everyone in the channel is told so they can drop it too.
+func (srv *server) handlePurge(s *session, f frame) error {
+	var req purgeData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	before := req.Before
+	if before.IsZero() {
+		before = s.stamp.Time
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permManageChannels) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	i := sort.Search(len(ch.history), func(i int) bool { return !ch.history[i].Time.Before(before) })
+	ids := messageIDs(ch.history[:i])
+	if err := s.persistLocked(func(st serverStore) error { return st.forgetMessages(req.Channel, ids) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ch.forget(ids)
+	pins := ch.pins[:0]
+	for _, p := range ch.pins {
+		if !p.Time.Before(before) {
+			pins = append(pins, p)
+		}
+	}
+	ch.pins = pins
+	srv.mu.Unlock()
+
+	srv.broadcast(req.Channel, newFrame(framePurged, purgeData{
+		Channel: req.Channel,
+		Before:  before,
+		Nick:    s.nick,
+		Removed: i,
+	}))
+	return nil
+}
+
+// --- Client side ---
+
+type pruneTickMsg struct{}
+
+// schedulePrune applies the client's retention after pruneInterval, if it
+// has one.
+func (m *model) schedulePrune() tea.Cmd {
+	if m.store == nil || !m.settings.Retention.enabled() {
+		return nil
+	}
+	return tea.Tick(pruneInterval, func(time.Time) tea.Msg { return pruneTickMsg{} })
+}
+
+// pruneCmd trims the store and search index to the client's retention in
+// the background.
+func (m *model) pruneCmd() tea.Cmd {
+	r := m.settings.Retention
+	if m.store == nil || !r.enabled() {
+		return nil
+	}
+	s, idx := m.store, m.search
+	return func() tea.Msg {
+		refs, err := s.pruneMessages("", "", r.cutoff(time.Now()), r.Messages)
+		if err == nil && idx != nil {
+			err = idx.remove(refs)
+		}
+		if err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
+
+// applyPurge drops the messages a server purge deleted from ch, the store
+// and the search index.
+func (m *model) applyPurge(ch *channel, p purgeData) {
+	kept := ch.messages[:0]
+	for _, msg := range ch.messages {
+		if msg.system || !msg.time.Before(p.Before) {
+			kept = append(kept, msg)
+		}
+	}
+	ch.messages = kept
+	pins := ch.pins[:0]
+	for _, msg := range ch.pins {
+		if !msg.time.Before(p.Before) {
+			pins = append(pins, msg)
+		}
+	}
+	ch.pins = pins
+	// Whatever the store has before p.Before is gone from the server too
+	ch.noMoreOlder = true
+
+	m.persist(func(s store, network string) error {
+		refs, err := s.pruneMessages(network, ch.name, p.Before, 0)
+		if err == nil && m.search != nil {
+			err = m.search.remove(refs)
+		}
+		return err
+	})
+	m.noticeIn(ch, fmt.Sprintf("%s purged %s from before %s", p.Nick, plural(p.Removed, "message"), p.Before.Local().Format("2006-01-02 15:04")))
+}
+
+// cmdPurge asks the server to delete the channel's history, all of it or
+// what is older than a number of days.
+func cmdPurge(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("/purge only works in channels")
+		return nil
+	}
+	before := time.Now()
+	if args != "all" {
+		days, err := strconv.Atoi(args)
+		if err != nil || days < 1 {
+			m.notice("Usage: /purge <days>|all")
+			return nil
+		}
+		before = before.AddDate(0, 0, -days)
+	}
+	if !m.allowed(ch, permManageChannels, "purge history") {
+		return nil
+	}
+	return m.request(framePurge, purgeData{Channel: ch.name, Before: before})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Everyone has a server role: guest, member (unless --default-role says
+// otherwise), moderator, admin or owner, given with --role, --admins or
+// directory groups (see ldap.go), the highest of them counting. In a channel
+// they have the higher of it and their role there, so a channel's creator
+// owns it and a server moderator moderates every channel, except that a
+// guest is a guest everywhere. What a role lets one do is a set of
+// permissions, --permissions changing these defaults:
+//
+//	guest      post
+//	member     post, invite, pin
+//	moderator  post, invite, pin, delete-others, moderate
+//	admin      post, invite, pin, delete-others, manage-channels, moderate
+//	owner      post, invite, pin, delete-others, manage-channels, moderate
+//
+// Posting covers sending, editing one's messages, reacting and setting the
+// topic, managing channels archiving, purging and importing into them, and
+// moderating kicking, banning and muting (see moderation.go).
+// A channel can override a role's set (see overrides.go). Guests can't
+// create channels, and private channels still take a moderator to invite to
+// and one who outranks them to remove someone. The server checks every
+// request, and the welcome tells the client its role and the table so it
+// only offers what the server will allow.
+
+// permission is a set of things a role lets one do.
+type permission uint
+
+const (
+	permPost permission = 1 << iota
+	permInvite
+	permPin
+	permDeleteOthers
+	permManageChannels
+	permModerate
+
+	permAll = permModerate<<1 - 1
+)
+
+// permissionNames are the names of the permissions, by bit.
+var permissionNames = []string{"post", "invite", "pin", "delete-others", "manage-channels", "moderate"}
+
+// roleNames are the server roles by name.
+var roleNames = map[string]role{
+	"guest":     roleGuest,
+	"member":    roleMember,
+	"moderator": roleModerator,
+	"admin":     roleAdmin,
+	"owner":     roleOwner,
+}
+
+func defaultPermissions() map[role]permission {
+	staff := permPost | permInvite | permPin | permDeleteOthers | permModerate
+	return map[role]permission{
+		roleGuest:     permPost,
+		roleMember:    permPost | permInvite | permPin,
+		roleModerator: staff,
+		roleAdmin:     staff | permManageChannels,
+		roleOwner:     staff | permManageChannels,
+	}
+}
+
+func (p permission) String() string {
+	var names []string
+	for i, name := range permissionNames {
+		if p&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseRole parses a server role's name.
+func parseRole(name string) (role, error) {
+	r, ok := roleNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown role %q, want guest, member, moderator, admin or owner", name)
+	}
+	return r, nil
+}
+
+// parsePermissions parses a comma-separated list of permission names, or
+// "none".
+func parsePermissions(v string) (permission, error) {
+	var p permission
+	for _, name := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if name == "none" {
+			continue
+		}
+		i := slices.Index(permissionNames, name)
+		if i < 0 {
+			return 0, fmt.Errorf("unknown permission %q, want %s", name, strings.Join(permissionNames, ", "))
+		}
+		p |= 1 << i
+	}
+	return p, nil
+}
+
+// --- Server side ---
+
+// serverRoleLocked is nick's server role.
+func (srv *server) serverRoleLocked(nick string) role {
+	if isGuestNick(nick) {
+		return roleGuest
+	}
+	r, given := srv.roles[nick]
+	for _, name := range srv.accounts[nick].Roles {
+		if dr, ok := roleNames[name]; ok && (!given || dr > r) {
+			r, given = dr, true
+		}
+	}
+	if !given {
+		return srv.defaultRole
+	}
+	return r
+}
+
+// roleInLocked is nick's role in ch, their server role for a DM (nil).
+func (srv *server) roleInLocked(ch *serverChannel, nick string) role {
+	r := srv.serverRoleLocked(nick)
+	if ch == nil || r == roleGuest {
+		return r
+	}
+	if own, member := ch.members[nick]; member {
+		r = max(r, own)
+	}
+	return r
+}
+
+// canLocked is whether nick's role in ch, or on the server for a DM (nil),
+// gives them perm, going by ch's overrides.
+func (srv *server) canLocked(ch *serverChannel, nick string, perm permission) bool {
+	if isGuestNick(nick) && !srv.guestMayLocked(ch, perm) {
+		return false
+	}
+	r := srv.roleInLocked(ch, nick)
+	perms := srv.permissions[r]
+	if ch != nil {
+		if p, ok := ch.overrides[r]; ok {
+			perms = p
+		}
+	}
+	return perms&perm != 0
+}
+
+// setRolePermissions parses a --permissions value, role=permissions.
+func (srv *server) setRolePermissions(v string) error {
+	name, list, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want role=permissions, of %s", strings.Join(permissionNames, ", "))
+	}
+	r, err := parseRole(name)
+	if err != nil {
+		return err
+	}
+	p, err := parsePermissions(list)
+	if err != nil {
+		return err
+	}
+	srv.permissions[r] = p
+	return nil
+}
+
+// giveRole parses a --role value, role=nick,nick.
+func (srv *server) giveRole(v string) error {
+	name, nicks, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want role=nicks")
+	}
+	r, err := parseRole(name)
+	if err != nil {
+		return err
+	}
+	for _, nick := range strings.Split(nicks, ",") {
+		if nick = strings.TrimSpace(nick); nick != "" {
+			srv.roles[nick] = r
+		}
+	}
+	return nil
+}
+
+// --- Client side ---
+
+// serverRole is our role on the server shown, as its welcome said.
+func (m *model) serverRole() role {
+	if m.client == nil {
+		return roleMember
+	}
+	return m.client.role
+}
+
+// roleIn is our role in ch, or on the server for a DM, and what it lets
+// us do.
+func (m *model) roleIn(ch *channel) (role, permission) {
+	r := m.serverRole()
+	if ch != nil && !ch.isDM() {
+		if me, ok := ch.members[m.nick]; ok {
+			r = me.role
+		}
+	}
+	return r, m.permissionsIn(ch, r)
+}
+
+// permissionsIn is what r may do in ch, or on the server for a DM (nil).
+func (m *model) permissionsIn(ch *channel, r role) permission {
+	if m.client != nil && m.client.guest {
+		// Guests get what the welcome said, overrides or not
+		return m.client.permissions[r]
+	}
+	if ch != nil {
+		if p, ok := ch.overrides[r]; ok {
+			return p
+		}
+	}
+	if m.client != nil && m.client.permissions != nil {
+		return m.client.permissions[r]
+	}
+	return defaultPermissions()[r]
+}
+
+// can is whether our role in ch gives us perm. The server has the last
+// word.
+func (m *model) can(ch *channel, perm permission) bool {
+	_, perms := m.roleIn(ch)
+	return perms&perm != 0
+}
+
+// allowed is can, with a notice saying so when we can't.
+func (m *model) allowed(ch *channel, perm permission, what string) bool {
+	if m.can(ch, perm) {
+		return true
+	}
+	where := "here"
+	if ch != nil && !ch.isDM() {
+		where = "in " + ch.name
+	}
+	m.notice("Your role doesn't let you " + what + " " + where)
+	return false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// [[rules]] in the config file weigh each incoming message before anything
+// else does, the first rule that matches it deciding what it does:
+//
+//	[[rules]]
+//	channel = "#ops*"
+//	from = "deploybot"
+//	match = "(?i)failed|rolled back"
+//	action = "notify"
+//
+//	[[rules]]
+//	channel = "#random"
+//	hours = "09:00-18:00"
+//	action = "ignore"
+//
+// channel and from are patterns as in path.Match, "@*" being every DM, match
+// is a regular expression for the text and hours a time of day as in
+// quiet_hours, when the message was sent; what a rule leaves out matches
+// anything. highlight makes the message a mention, badge, activity center
+// and all, and ignore makes it nothing, no badge, sound or notification.
+// notify pops it up on the desktop as a mention would, and sound plays
+// sound, else the mention sound, else the bell; those two count it even in
+// a buffer muted or notifying of nothing, as they were asked for by name.
+// /dnd, quiet hours and the notification mode still hold back sounds and
+// notifications, and our own messages go by no rule.
+
+// ruleAction is what a rule does with the messages it matches.
+type ruleAction string
+
+const (
+	ruleNotify    ruleAction = "notify"
+	ruleSound     ruleAction = "sound"
+	ruleHighlight ruleAction = "highlight"
+	ruleIgnore    ruleAction = "ignore"
+)
+
+// ruleConfig is a rule in the config file.
+type ruleConfig struct {
+	Channel string `toml:"channel" yaml:"channel"`
+	From    string `toml:"from" yaml:"from"`
+	Match   string `toml:"match" yaml:"match"`
+	Hours   string `toml:"hours" yaml:"hours"`
+	Action  string `toml:"action" yaml:"action"`
+	Sound   string `toml:"sound" yaml:"sound"` // for action = "sound", as in [sounds]
+}
+
+// rule is a rule parsed, nil or zero fields matching anything.
+type rule struct {
+	channel string
+	from    string
+	match   *regexp.Regexp
+	hours   *quietRange
+	action  ruleAction
+	sound   string
+}
+
+// parseRules parses the rules, sounds being [sounds] to check a rule's
+// sound file can be played with.
+func parseRules(list []ruleConfig, sounds soundConfig) ([]rule, error) {
+	var rules []rule
+	for i, rc := range list {
+		r := rule{channel: rc.Channel, from: rc.From, action: ruleAction(strings.ToLower(rc.Action)), sound: rc.Sound}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("rules: rule %d: "+format, append([]any{i + 1}, args...)...)
+		}
+		for _, pattern := range []string{r.channel, r.from} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fail("%q isn't a pattern like #ops* or @*", pattern)
+			}
+		}
+		if rc.Match != "" {
+			re, err := regexp.Compile(rc.Match)
+			if err != nil {
+				return nil, fail("match: %w", err)
+			}
+			r.match = re
+		}
+		if rc.Hours != "" {
+			hours, err := parseQuietHours([]string{rc.Hours})
+			if err != nil {
+				return nil, fail("%q isn't a range of times like 09:00-18:00", rc.Hours)
+			}
+			r.hours = &hours[0]
+		}
+		switch r.action {
+		case ruleNotify, ruleHighlight, ruleIgnore:
+		case ruleSound:
+			switch r.sound {
+			case "", "none", "bell":
+			default:
+				if _, err := os.Stat(expandHome(r.sound)); err != nil {
+					return nil, fail("sound is bell, none or a sound file: %w", err)
+				}
+				if _, err := soundPlayer(sounds.Player); err != nil {
+					return nil, fail("%w", err)
+				}
+			}
+		default:
+			return nil, fail("action %q isn't notify, sound, highlight or ignore", rc.Action)
+		}
+		if r.sound != "" && r.action != ruleSound {
+			return nil, fail(`sound only goes with action = "sound"`)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matches reports whether r matches msg.
+func (r rule) matches(msg message) bool {
+	switch {
+	case r.channel != "" && !patternMatches(r.channel, msg.channel),
+		r.from != "" && !patternMatches(r.from, msg.nick),
+		r.match != nil && !r.match.MatchString(msg.text),
+		r.hours != nil && !r.hours.contains(msg.time.Local()):
+		return false
+	}
+	return true
+}
+
+// patternMatches reports whether name matches pattern, which parseRules
+// made sure is one.
+func patternMatches(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// ruleFor is the first rule matching msg, nil if none does or msg is ours.
+func (m *model) ruleFor(msg message) *rule {
+	if msg.system || msg.nick == m.nick {
+		return nil
+	}
+	for i := range m.opts.rules {
+		if m.opts.rules[i].matches(msg) {
+			return &m.opts.rules[i]
+		}
+	}
+	return nil
+}
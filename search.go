@@ -0,0 +1,494 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// searchLimit is how many ranked hits a history search returns.
+const searchLimit = 50
+
+// Regex and fuzzy searches scan the newest scanLimit messages passing their
+// filters, scanPage at a time.
+const (
+	scanLimit = 20000
+	scanPage  = 1000
+)
+
+// searchIndexVersion changes when documents gain fields, so indexes made
+// by older versions are filled again.
+const searchIndexVersion = "2"
+
+// searchIndex is a full-text index of stored messages across all networks
+// and buffers, kept next to the store in the gochat config directory.
+type searchIndex struct {
+	index bleve.Index
+	queue *indexQueue // writes to index in the background, see searchqueue.go
+}
+
+func newSearchIndex(index bleve.Index) *searchIndex {
+	return &searchIndex{index: index, queue: newIndexQueue(index)}
+}
+
+// indexedMessage is the document kept per message. The ID is the message's
+// network, buffer and ID joined, so re-indexing a message replaces it.
+type indexedMessage struct {
+	Network string    `json:"network"`
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Nick    string    `json:"nick"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	Has     []string  `json:"has,omitempty"` // see messageHas
+}
+
+// searchHit is one ranked result of a history search.
+type searchHit struct {
+	network string
+	channel string
+	id      string
+	nick    string
+	text    string
+	time    time.Time
+	matches [][2]int // byte ranges of the matched terms in text
+}
+
+// openSearchIndex opens the index, creating an empty one if there is none
+// yet. created reports whether it is new and so needs filling. With an
+// encrypted store the index is kept in memory instead, as it holds the
+// message text in the clear, and any index on disk is removed.
+func openSearchIndex(encrypted bool) (idx *searchIndex, created bool, err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, false, err
+	}
+	path := filepath.Join(dir, "gochat", "index.bleve")
+	if encrypted {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, false, err
+		}
+		index, err := bleve.NewMemOnly(searchMapping())
+		if err != nil {
+			return nil, false, err
+		}
+		return newSearchIndex(index), true, nil
+	}
+	// Don't hang if another gochat has the index open
+	index, err := bleve.OpenUsing(path, map[string]any{"bolt_timeout": "1s"})
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, searchMapping())
+		created = true
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if v, err := index.GetInternal([]byte("version")); err == nil && string(v) != searchIndexVersion {
+		created = true
+		if err := index.SetInternal([]byte("version"), []byte(searchIndexVersion)); err != nil {
+			index.Close()
+			return nil, false, err
+		}
+	}
+	return newSearchIndex(index), created, nil
+}
+
+// searchMapping analyzes message text for full-text search and keeps the
+// other fields as exact keywords, out of the catch-all field so that plain
+// words only match text.
+func searchMapping() mapping.IndexMapping {
+	keyword := bleve.NewKeywordFieldMapping()
+	keyword.IncludeInAll = false
+	when := bleve.NewDateTimeFieldMapping()
+	when.IncludeInAll = false
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("network", keyword)
+	doc.AddFieldMappingsAt("channel", keyword)
+	doc.AddFieldMappingsAt("id", keyword)
+	doc.AddFieldMappingsAt("nick", keyword)
+	doc.AddFieldMappingsAt("text", bleve.NewTextFieldMapping())
+	doc.AddFieldMappingsAt("time", when)
+	doc.AddFieldMappingsAt("has", keyword)
+
+	im := bleve.NewIndexMapping()
+	im.DefaultMapping = doc
+	return im
+}
+
+// add queues msgs of a buffer for indexing, skipping system notices and
+// messages without an ID. It returns the last background failure, if any.
+func (x *searchIndex) add(network, channel string, msgs []message) error {
+	var updates []indexUpdate
+	for _, msg := range msgs {
+		if msg.system || msg.id == "" {
+			continue
+		}
+		doc := indexedMessage{Network: network, Channel: channel, ID: msg.id, Nick: msg.nick, Text: msg.text, Time: msg.time, Has: messageHas(msg.text)}
+		updates = append(updates, indexUpdate{id: searchDocID(network, channel, msg.id), doc: &doc})
+	}
+	return x.queue.push(updates...)
+}
+
+// remove queues deleted messages to be dropped from the index.
+func (x *searchIndex) remove(refs []messageRef) error {
+	updates := make([]indexUpdate, len(refs))
+	for i, r := range refs {
+		updates[i] = indexUpdate{id: searchDocID(r.network, r.channel, r.id)}
+	}
+	return x.queue.push(updates...)
+}
+
+// query runs req once everything queued is in the index.
+func (x *searchIndex) query(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	x.queue.flush()
+	return x.index.Search(req)
+}
+
+func searchDocID(network, channel, id string) string {
+	return network + "\x00" + channel + "\x00" + id
+}
+
+// search runs a query over the index (see parseSearchQuery), best match
+// first, or newest first when there are only filters.
+func (x *searchIndex) search(q string) ([]searchHit, error) {
+	filters, text, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		filters = append(filters, bleve.NewQueryStringQuery(text))
+	}
+	req := bleve.NewSearchRequestOptions(allOf(filters), searchLimit, 0, false)
+	if text == "" {
+		req.SortBy([]string{"-time"})
+	}
+	req.Fields = searchFields
+	req.IncludeLocations = true
+	res, err := x.query(req)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]searchHit, 0, len(res.Hits))
+	for _, doc := range res.Hits {
+		h := hitOf(doc)
+		h.matches = matchRanges(doc.Locations["text"])
+		hits = append(hits, h)
+	}
+	return hits, nil
+}
+
+// searchRegex runs a query whose free text is a regular expression. The
+// filters narrow it down in the index, the pattern is then matched against
+// the text of the newest messages left.
+func (x *searchIndex) searchRegex(q string) ([]searchHit, error) {
+	filters, text, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, errors.New("regex search needs a pattern")
+	}
+	re, err := regexp.Compile(text)
+	if err != nil {
+		return nil, err
+	}
+	var hits []searchHit
+	err = x.scan(filters, func(h searchHit) bool {
+		for _, loc := range re.FindAllStringIndex(h.text, -1) {
+			h.matches = append(h.matches, [2]int{loc[0], loc[1]})
+		}
+		if len(h.matches) > 0 {
+			hits = append(hits, h)
+		}
+		return len(hits) < searchLimit
+	})
+	return hits, err
+}
+
+// scan calls fn with up to scanLimit messages matching filters, newest
+// first, until it returns false.
+func (x *searchIndex) scan(filters []query.Query, fn func(searchHit) bool) error {
+	for from := 0; from < scanLimit; from += scanPage {
+		req := bleve.NewSearchRequestOptions(allOf(filters), scanPage, from, false)
+		req.SortBy([]string{"-time"})
+		req.Fields = searchFields
+		res, err := x.query(req)
+		if err != nil {
+			return err
+		}
+		for _, doc := range res.Hits {
+			if !fn(hitOf(doc)) {
+				return nil
+			}
+		}
+		if len(res.Hits) < scanPage {
+			return nil
+		}
+	}
+	return nil
+}
+
+// searchFields are the stored fields a hit is built from.
+var searchFields = []string{"network", "channel", "id", "nick", "text", "time"}
+
+func hitOf(doc *search.DocumentMatch) searchHit {
+	h := searchHit{
+		network: fieldString(doc.Fields, "network"),
+		channel: fieldString(doc.Fields, "channel"),
+		id:      fieldString(doc.Fields, "id"),
+		nick:    fieldString(doc.Fields, "nick"),
+		text:    fieldString(doc.Fields, "text"),
+	}
+	h.time, _ = time.Parse(time.RFC3339, fieldString(doc.Fields, "time"))
+	return h
+}
+
+func (x *searchIndex) close() error {
+	x.queue.stop()
+	return x.index.Close()
+}
+
+func fieldString(fields map[string]any, name string) string {
+	s, _ := fields[name].(string)
+	return s
+}
+
+// matchRanges flattens the term locations of a hit into sorted byte ranges.
+func matchRanges(terms search.TermLocationMap) [][2]int {
+	var ranges [][2]int
+	for _, locs := range terms {
+		for _, loc := range locs {
+			ranges = append(ranges, [2]int{int(loc.Start), int(loc.End)})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	return ranges
+}
+
+// snippet cuts text down to about width cells around the first match, with
+// the matches highlighted.
+func snippet(text string, matches [][2]int, width int) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	start := 0
+	if len(matches) > 0 && matches[0][0] > width/3 {
+		start = clusterStart(text, matches[0][0]-width/3)
+	}
+	end := start + len(cutWidth(text[start:], width))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, r := range matches {
+		lo, hi := max(r[0], pos), min(r[1], end)
+		if lo >= hi {
+			continue
+		}
+		b.WriteString(text[pos:lo])
+		b.WriteString(searchMatchStyle.Render(text[lo:hi]))
+		pos = hi
+	}
+	b.WriteString(text[pos:end])
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// --- Model side ---
+
+// saveMessages keeps msgs of ch in the store and the search index.
+func (m *model) saveMessages(ch *channel, msgs []message) {
+	m.persist(func(s store, network string) error { return s.saveMessages(network, ch.name, msgs) })
+	if m.search != nil {
+		if err := m.search.add(m.storeNetwork(), ch.name, msgs); err != nil {
+			m.lastErr = err
+		}
+	}
+}
+
+// indexRestored fills a new search index with the scrollback just restored
+// from the store, so history from before the index existed is searchable.
+func (m *model) indexRestored() {
+	for _, ch := range m.channels {
+		if err := m.search.add(m.storeNetwork(), ch.name, ch.messages); err != nil {
+			m.lastErr = err
+			return
+		}
+	}
+}
+
+// searchHistory runs the search box query and shows the results.
+func (m *model) searchHistory() {
+	query := strings.TrimSpace(m.textInput.Value())
+	if query == "" {
+		return
+	}
+	if m.search == nil {
+		m.notice("History search is unavailable, the search index couldn't be opened")
+		return
+	}
+	run, title := m.search.search, fmt.Sprintf("Search %q", query)
+	switch m.searchMode {
+	case searchRegex:
+		run, title = m.search.searchRegex, fmt.Sprintf("Search /%s/", query)
+	case searchFuzzy:
+		run, title = m.search.searchFuzzy, fmt.Sprintf("Fuzzy search %q", query)
+	}
+	hits, err := run(query)
+	if err != nil {
+		m.notice("Search: " + err.Error())
+		return
+	}
+	m.overlay = newSearchResults(m, title, hits)
+}
+
+// searchJumpMsg asks to show a search hit in its buffer.
+type searchJumpMsg struct {
+	hit searchHit
+}
+
+// jumpToHit shows a search hit in context, switching network and loading
+// older scrollback from the store as needed.
+func (m *model) jumpToHit(h searchHit) {
+	for i, n := range m.networks {
+		if n.addr == h.network {
+			m.switchNetwork(i)
+		}
+	}
+	ch := m.channelByName(h.channel)
+	if ch == nil {
+		m.notice("You are no longer in " + h.channel)
+		return
+	}
+	m.loadThrough(ch, h.id, h.time)
+	if !m.jumpToMessage(ch.name, h.id) {
+		m.notice("That message is no longer stored")
+	}
+}
+
+// loadThrough stitches stored scrollback onto ch until message id, sent at
+// t, is loaded.
+func (m *model) loadThrough(ch *channel, id string, t time.Time) {
+	if m.store == nil {
+		return
+	}
+	for ch.messageIndex(id) < 0 && len(ch.messages) > 0 && !ch.messages[0].time.Before(t) {
+		older, err := m.store.loadMessagesBefore(m.storeNetwork(), ch.name, ch.messages[0].time, storeScrollback)
+		if err != nil {
+			m.lastErr = err
+			return
+		}
+		if m.prependMessages(ch, older); len(older) == 0 {
+			return
+		}
+	}
+}
+
+// --- Results overlay ---
+
+const searchVisibleRows = 12
+
+type searchResults struct {
+	title    string
+	hits     []searchHit
+	networks map[string]string // address -> display name, with several networks
+	cursor   int
+	offset   int
+}
+
+func newSearchResults(m *model, title string, hits []searchHit) *searchResults {
+	r := &searchResults{title: title, hits: hits}
+	if len(m.networks) > 1 {
+		r.networks = make(map[string]string, len(m.networks))
+		for _, n := range m.networks {
+			r.networks[n.addr] = n.name
+		}
+	}
+	return r
+}
+
+func (r *searchResults) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return r, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if r.cursor > 0 {
+			r.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if r.cursor < len(r.hits)-1 {
+			r.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		if len(r.hits) == 0 {
+			return r, nil
+		}
+		h := r.hits[r.cursor]
+		return nil, func() tea.Msg { return searchJumpMsg{hit: h} }
+	}
+
+	if r.cursor < r.offset {
+		r.offset = r.cursor
+	}
+	if r.cursor >= r.offset+searchVisibleRows {
+		r.offset = r.cursor - searchVisibleRows + 1
+	}
+	return r, nil
+}
+
+func (r *searchResults) View(width, height int) string {
+	w := 80
+	if width-4 < w {
+		w = width - 4
+	}
+	whereW := 24
+	textW := w - 2 - 2 - whereW - 2 - 12
+
+	title := fmt.Sprintf("%s (%d)", r.title, len(r.hits))
+	if len(r.hits) == searchLimit {
+		title = fmt.Sprintf("%s (best %d)", r.title, searchLimit)
+	}
+	lines := []string{overlayTitleStyle.Render(truncate(title, w-4)), ""}
+	if len(r.hits) == 0 {
+		lines = append(lines, overlayHintStyle.Render("No messages match"))
+	}
+	end := min(r.offset+searchVisibleRows, len(r.hits))
+	for i := r.offset; i < end; i++ {
+		h := r.hits[i]
+		where := h.nick + " in " + h.channel
+		if name, ok := r.networks[h.network]; ok {
+			where = h.nick + " in " + name + "/" + h.channel
+		}
+		row := lipgloss.NewStyle().Width(whereW).Render(truncate(where, whereW)) + "  " +
+			lipgloss.NewStyle().Width(textW).Render(snippet(h.text, h.matches, textW-1)) +
+			timestampStyle.Render(h.time.Local().Format("Jan 02 15:04"))
+		if i == r.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("enter jump to message • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/sahilm/fuzzy"
+)
+
+// searchFuzzy runs a query whose free text is matched loosely, for when
+// the message is only half remembered. Two kinds of match are merged, best
+// first:
+//
+//   - fzf-style: the text's characters appear in order in the message, so
+//     "dplyprd" finds "deploy to prod"
+//   - per word: every word of the text is within a typo or two of a word
+//     in the message, or starts one, so "depoly" and "prod" both count
+//
+// The filters apply to both.
+func (x *searchIndex) searchFuzzy(q string) ([]searchHit, error) {
+	filters, text, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, errors.New("fuzzy search needs some text")
+	}
+
+	var candidates []searchHit
+	var texts []string
+	err = x.scan(filters, func(h searchHit) bool {
+		candidates = append(candidates, h)
+		texts = append(texts, h.text)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var hits []searchHit
+	seen := make(map[string]bool)
+	for _, match := range fuzzy.Find(text, texts) {
+		h := candidates[match.Index]
+		h.matches = runeRanges(h.text, match.MatchedIndexes)
+		seen[h.network+"\x00"+h.channel+"\x00"+h.id] = true
+		if hits = append(hits, h); len(hits) == searchLimit {
+			return hits, nil
+		}
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		typo := bleve.NewFuzzyQuery(word)
+		typo.SetField("text")
+		typo.SetFuzziness(typoDistance(word))
+		prefix := bleve.NewPrefixQuery(word)
+		prefix.SetField("text")
+		filters = append(filters, bleve.NewDisjunctionQuery(typo, prefix))
+	}
+	req := bleve.NewSearchRequestOptions(allOf(filters), searchLimit, 0, false)
+	req.Fields = searchFields
+	req.IncludeLocations = true
+	res, err := x.query(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range res.Hits {
+		h := hitOf(doc)
+		if seen[h.network+"\x00"+h.channel+"\x00"+h.id] {
+			continue
+		}
+		h.matches = matchRanges(doc.Locations["text"])
+		if hits = append(hits, h); len(hits) == searchLimit {
+			break
+		}
+	}
+	return hits, nil
+}
+
+// typoDistance is how many edits a word may be off by, fewer for short
+// words so they don't match everything.
+func typoDistance(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// runeRanges turns the byte offsets of matched characters into ranges
+// covering each whole character.
+func runeRanges(text string, idx []int) [][2]int {
+	ranges := make([][2]int, 0, len(idx))
+	for _, i := range idx {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		ranges = append(ranges, [2]int{i, i + size})
+	}
+	return ranges
+}
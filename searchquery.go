@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// searchDateLayout is how dates are written in before:, after: and on:.
+const searchDateLayout = "2006-01-02"
+
+// Things has: can look for, indexed per message in the has field.
+const (
+	hasLink = "link"
+	hasFile = "file"
+)
+
+// parseSearchQuery splits a search box query into filters and free text.
+// Slack-style operators become filters:
+//
+//	from:alice       sent by alice
+//	in:#general      in a channel (or a DM, in:@bob)
+//	before:2024-06-01, after:2024-06-01, on:2024-06-01
+//	has:link, has:file
+//
+// Everything else is free text, in bleve's query string syntax or a regular
+// expression depending on the search mode.
+func parseSearchQuery(q string) (filters []query.Query, text string, err error) {
+	var free []string
+	for _, word := range strings.Fields(q) {
+		op, arg, ok := strings.Cut(word, ":")
+		if !ok || arg == "" {
+			free = append(free, word)
+			continue
+		}
+		switch strings.ToLower(op) {
+		case "from":
+			filters = append(filters, fieldTerm("nick", strings.TrimPrefix(arg, "@")))
+		case "in":
+			if !strings.HasPrefix(arg, "#") && !strings.HasPrefix(arg, "@") {
+				arg = "#" + arg
+			}
+			filters = append(filters, fieldTerm("channel", arg))
+		case "before", "after", "on":
+			day, err := time.ParseInLocation(searchDateLayout, arg, time.Local)
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: wants a date like 2024-06-01", op)
+			}
+			var start, end time.Time
+			switch strings.ToLower(op) {
+			case "before":
+				end = day
+			case "after":
+				start = day.AddDate(0, 0, 1)
+			default:
+				start, end = day, day.AddDate(0, 0, 1)
+			}
+			r := bleve.NewDateRangeQuery(start, end)
+			r.SetField("time")
+			filters = append(filters, r)
+		case "has":
+			arg = strings.ToLower(arg)
+			if arg != hasLink && arg != hasFile {
+				return nil, "", fmt.Errorf("has: wants %s or %s", hasLink, hasFile)
+			}
+			filters = append(filters, fieldTerm("has", arg))
+		default:
+			// Not ours, leave it to the query string syntax (text:foo etc)
+			free = append(free, word)
+		}
+	}
+
+	return filters, strings.Join(free, " "), nil
+}
+
+// allOf matches documents matching every query, or all documents if there
+// are none.
+func allOf(qs []query.Query) query.Query {
+	switch len(qs) {
+	case 0:
+		return bleve.NewMatchAllQuery()
+	case 1:
+		return qs[0]
+	default:
+		return bleve.NewConjunctionQuery(qs...)
+	}
+}
+
+func fieldTerm(field, term string) query.Query {
+	q := bleve.NewTermQuery(term)
+	q.SetField(field)
+	return q
+}
+
+// pageExts are extensions of links to web pages rather than files.
+var pageExts = map[string]bool{".html": true, ".htm": true, ".php": true, ".asp": true, ".aspx": true}
+
+// messageHas lists what has: finds in text.
+func messageHas(text string) []string {
+	var has []string
+	for _, l := range messageLinks(text) {
+		if l.file {
+			return []string{hasLink, hasFile}
+		}
+		has = []string{hasLink}
+	}
+	return has
+}
+
+// link is a URL found in a message. It is a file if its path ends in an
+// extension (other than a web page's), or it is a file: URL.
+type link struct {
+	url  *url.URL
+	file bool
+}
+
+// messageLinks returns the links in text, in order.
+func messageLinks(text string) []link {
+	var links []link
+	for _, word := range strings.Fields(text) {
+		u, err := url.Parse(strings.TrimRight(word, ".,;:!?)"))
+		if err != nil {
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if u.Host == "" {
+				continue
+			}
+			ext := path.Ext(u.Path)
+			links = append(links, link{url: u, file: ext != "" && !pageExts[strings.ToLower(ext)]})
+		case "file":
+			links = append(links, link{url: u, file: true})
+		}
+	}
+	return links
+}
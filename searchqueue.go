@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// Messages are indexed in the background as the store takes them in: add
+// and remove only queue the change, and writeLoop applies what piled up in
+// one batch, so a burst of history doesn't hold up the UI. Searches wait
+// for the queue to drain first, so they see everything added before them.
+
+const (
+	// indexBatchSize is the most changes written in one batch.
+	indexBatchSize = 500
+	// indexBatchDelay is how long a change waits for others to batch with.
+	indexBatchDelay = 200 * time.Millisecond
+)
+
+// indexUpdate is a queued change to the index: doc indexed under id, or
+// the document removed if doc is nil. With flushed set it is a marker,
+// closed once everything queued before it is written.
+type indexUpdate struct {
+	id      string
+	doc     *indexedMessage
+	flushed chan struct{}
+}
+
+// indexQueue is the background writer of a searchIndex.
+type indexQueue struct {
+	updates chan indexUpdate
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error // of the last batch that failed, until reported
+}
+
+func newIndexQueue(index bleve.Index) *indexQueue {
+	q := &indexQueue{updates: make(chan indexUpdate, indexBatchSize), done: make(chan struct{})}
+	go q.writeLoop(index)
+	return q
+}
+
+// push queues updates, returning the last background failure if there was
+// one since the last call.
+func (q *indexQueue) push(updates ...indexUpdate) error {
+	for _, u := range updates {
+		q.updates <- u
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.err
+	q.err = nil
+	return err
+}
+
+// flush waits until everything queued so far is written.
+func (q *indexQueue) flush() {
+	flushed := make(chan struct{})
+	q.updates <- indexUpdate{flushed: flushed}
+	<-flushed
+}
+
+// stop writes what is queued and ends writeLoop.
+func (q *indexQueue) stop() {
+	close(q.updates)
+	<-q.done
+}
+
+func (q *indexQueue) writeLoop(index bleve.Index) {
+	defer close(q.done)
+	for u := range q.updates {
+		b := index.NewBatch()
+		var flushed []chan struct{}
+		timeout := time.After(indexBatchDelay)
+		for more := true; more; {
+			switch {
+			case u.flushed != nil:
+				flushed = append(flushed, u.flushed)
+			case u.doc == nil:
+				b.Delete(u.id)
+			default:
+				if err := b.Index(u.id, *u.doc); err != nil {
+					q.fail(err)
+				}
+			}
+			// Someone waits on a flush, write now rather than after the delay
+			if len(flushed) > 0 || b.Size() >= indexBatchSize {
+				break
+			}
+			select {
+			case u, more = <-q.updates:
+			case <-timeout:
+				more = false
+			}
+		}
+		if b.Size() > 0 {
+			if err := index.Batch(b); err != nil {
+				q.fail(err)
+			}
+		}
+		for _, c := range flushed {
+			close(c)
+		}
+	}
+}
+
+func (q *indexQueue) fail(err error) {
+	q.mu.Lock()
+	q.err = err
+	q.mu.Unlock()
+}
@@ -0,0 +1,1420 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// historyReplay is how many recent messages a client gets on join.
+	historyReplay = 100
+	// historyPageSize is the largest page served for scrollback requests.
+	historyPageSize = 100
+
+	maxTopicLength = 250
+
+	// idleTimeout is how long a client may send nothing, not even the ping
+	// it sends every pingInterval, before it is dropped.
+	idleTimeout = 4 * pingInterval
+	// writeTimeout is how long a client may take to read what is written
+	// to it before it is dropped, so broadcasts don't wait on it.
+	writeTimeout = 10 * time.Second
+)
+
+var channelNameRE = regexp.MustCompile(`^#[A-Za-z0-9_-]{1,32}$`)
+
+var (
+	errNotJoined     = errors.New("not a member of that channel")
+	errNoSuchChannel = errors.New("no such channel")
+	errBadChannel    = errors.New("channel names must start with # and use letters, digits, - or _ (max 32)")
+	errChannelExists = errors.New("channel already exists")
+	errTopicTooLong  = errors.New("topic is too long")
+	errNoSuchNick    = errors.New("no such nick")
+	errArchived      = errors.New("channel is archived and read-only")
+	errNotPermitted  = errors.New("you don't have permission to do that")
+	errNoSuchMessage = errors.New("no such message")
+	errBadReaction   = errors.New("reactions must be a single short emoji or word")
+	errAlreadyPinned = errors.New("message is already pinned")
+	errNotPinned     = errors.New("message isn't pinned")
+	errBadRole       = errors.New("no such role")
+)
+
+type serverChannel struct {
+	name     string
+	topic    string
+	private  bool // unlisted, joinable only by existing members
+	archived bool // read-only and hidden from the default listing
+	created  time.Time
+	members  map[string]role
+	// overrides replace the server's permissions for some roles, see
+	// overrides.go
+	overrides map[role]permission
+	// sanctions are the bans, mutes and shadow bans, by modBan, modMute
+	// or modShadowBan and then nick, see moderation.go
+	sanctions map[string]map[string]sanction
+	// slowMode is how long members wait between messages, and lastPost
+	// when each last posted (see slowmode.go)
+	slowMode time.Duration
+	lastPost map[string]time.Time
+	filters  channelFilters // see wordfilter.go
+	pins     []wireMessage
+	conversation
+}
+
+// session is one connected client, or a replica of a peer's client whose
+// requests are re-run here (see cluster.go).
+type session struct {
+	srv     *server
+	conn    *frameConn
+	nick    string
+	replica bool
+	// device is what s.nick logged in on (see devices.go), once they did,
+	// and client what the client says it is
+	device        string
+	deviceStarted time.Time
+	client        string
+	// stamp is the ID and time given to whatever the current request
+	// creates, fixed by the instance the request came in on
+	stamp eventStamp
+	// invite is the one s.nick just registered with, if they did
+	invite *invite
+	// guest is whether s came in without an account (see guests.go)
+	guest bool
+	// resume is the tokenHash of what resumes s once its connection
+	// drops (see resume.go)
+	resume string
+	// away is whether the client said its user is away (see presence.go)
+	away bool
+}
+
+// eventStamp is the ID and time for what a request creates.
+type eventStamp struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+func newEventStamp() eventStamp {
+	return eventStamp{ID: newID(), Time: time.Now().UTC()}
+}
+
+type handlerFunc func(s *session, f frame) error
+
+// server is the chat server. All state is in memory and guarded by mu,
+// and written through to store when there is one.
+type server struct {
+	mu       sync.Mutex
+	channels map[string]*serverChannel
+	dms      map[string]*conversation       // keyed by dmKey
+	reads    map[string]map[string]readData // nick -> buffer -> read position
+	kv       map[string]map[string]kvData   // nick -> key -> synced value
+	accounts map[string]account             // by nick, see accounts.go
+	// refreshTokens are the sessions, by tokenHash, and tokenKey signs
+	// access tokens (see tokens.go)
+	refreshTokens map[string]refreshToken
+	tokenKey      []byte
+	// invites are the invite codes, by tokenHash, and inviteOnly whether
+	// registering takes one (see invites.go)
+	invites    map[string]invite
+	inviteOnly bool
+	// guestChannels are the channels guests are let in to, none letting
+	// no guests in, and guestPost whether they may post there (see
+	// guests.go)
+	guestChannels []string
+	guestPost     bool
+	// e2eKeys are the public keys DMs are encrypted to, by nick (see
+	// e2ee.go)
+	e2eKeys map[string]string
+	// signingKeys are the public keys messages are signed with, by nick
+	// (see signing.go)
+	signingKeys map[string]string
+	// statuses are what people say they are up to, by nick (see
+	// status.go)
+	statuses map[string]userStatus
+	// dummyHash is checked against for nicks with no account
+	dummyHash    string
+	passwordCost argonParams // for new password hashes
+	sessions     map[*session]struct{}
+	// parked are the sessions whose connection dropped, by resume token
+	// hash, still in sessions until resumed or let go (see resume.go)
+	parked map[string]*session
+
+	handlers  map[string]handlerFunc
+	retention retention // history limits, applied by pruneLoop
+	// privateEdits shows a message's earlier versions only to its author
+	// and the channel's moderators
+	privateEdits bool
+	// roles are the server roles given to nicks with --role and --admins,
+	// defaultRole everyone else's, and permissions what each allows (see
+	// roles.go)
+	roles       map[string]role
+	defaultRole role
+	permissions map[role]permission
+	// tls is what clients connect with, nil for plain TCP (see certpin.go)
+	tls *tls.Config
+	// flood keeps out banned addresses and ones that flood (see flood.go)
+	flood *floodGuard
+	// filters are the word filter's server-wide rules, and filterLog its
+	// recent matches (see wordfilter.go)
+	filters   []filterRule
+	filterLog []filterHit
+	// modLog is the newest of the moderation log (see modlog.go)
+	modLog []moderationEvent
+	// reports are the open ones of the moderation queue, oldest first
+	// (see reports.go)
+	reports []report
+	// oidc is the identity provider users may sign in with, if any
+	oidc *oidcProvider
+	// directory has the accounts instead, if set, and groupRoles are the
+	// server roles its groups give (see ldap.go)
+	directory  directory
+	groupRoles []groupRole
+	store      serverStore // nil keeps everything in memory only
+
+	// With a cluster bus, requests are re-run on every instance so they
+	// all hold the same state, see cluster.go
+	cluster  clusterBus
+	instance string                // this instance's ID on the bus
+	peers    map[string]*peerState // by instance ID
+}
+
+func newServer() *server {
+	srv := &server{
+		channels:      make(map[string]*serverChannel),
+		dms:           make(map[string]*conversation),
+		reads:         make(map[string]map[string]readData),
+		kv:            make(map[string]map[string]kvData),
+		accounts:      make(map[string]account),
+		refreshTokens: make(map[string]refreshToken),
+		tokenKey:      randomKey(),
+		invites:       make(map[string]invite),
+		e2eKeys:       make(map[string]string),
+		signingKeys:   make(map[string]string),
+		statuses:      make(map[string]userStatus),
+		roles:         make(map[string]role),
+		permissions:   defaultPermissions(),
+		flood:         newFloodGuard(),
+		sessions:      make(map[*session]struct{}),
+		parked:        make(map[string]*session),
+		instance:      newID(),
+		peers:         make(map[string]*peerState),
+	}
+	srv.setPasswordCost(defaultArgonParams)
+	srv.channels["#general"] = &serverChannel{
+		name:    "#general",
+		topic:   "Discussion",
+		created: time.Now().UTC(),
+		members: make(map[string]role),
+	}
+	srv.handlers = map[string]handlerFunc{
+		frameJoin:         srv.handleJoin,
+		framePart:         srv.handlePart,
+		frameSend:         srv.handleSend,
+		frameList:         srv.handleList,
+		frameCreate:       srv.handleCreate,
+		frameTopic:        srv.handleTopic,
+		frameArchive:      srv.handleArchive,
+		frameOverride:     srv.handleOverride,
+		frameModerate:     srv.handleModerate,
+		frameSlowMode:     srv.handleSlowMode,
+		frameFilter:       srv.handleFilter,
+		frameFilterLog:    srv.handleFilterLog,
+		frameReport:       srv.handleReport,
+		frameReports:      srv.handleReports,
+		frameResolve:      srv.handleResolve,
+		frameModLog:       srv.handleModLog,
+		frameInvite:       srv.handleInvite,
+		frameRemove:       srv.handleRemove,
+		frameReact:        srv.handleReact,
+		frameEdit:         srv.handleEdit,
+		frameDelete:       srv.handleDelete,
+		frameEdits:        srv.handleEdits,
+		frameRefresh:      srv.handleRefresh,
+		frameUserData:     srv.handleUserData,
+		frameEraseUser:    srv.handleEraseUser,
+		frameSetKey:       srv.handleSetKey,
+		frameGetKey:       srv.handleGetKey,
+		frameDevices:      srv.handleDevices,
+		frameRevokeDevice: srv.handleRevokeDevice,
+		frameCreateInvite: srv.handleCreateInvite,
+		frameInvites:      srv.handleInvites,
+		frameRevokeInvite: srv.handleRevokeInvite,
+		framePin:          srv.handlePin,
+		framePing:         srv.handlePing,
+		frameHistory:      srv.handleHistory,
+		frameImport:       srv.handleImport,
+		framePurge:        srv.handlePurge,
+		frameRead:         srv.handleRead,
+		frameKVSet:        srv.handleKVSet,
+		frameAway:         srv.handleAway,
+		frameSetStatus:    srv.handleSetStatus,
+		frameTyping:       srv.handleTyping,
+	}
+	return srv
+}
+
+// listenAndServe accepts clients on addr until the listener fails.
+func (srv *server) listenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if srv.tls != nil {
+		ln = tls.NewListener(ln, srv.tls)
+		log.Printf("gochat server listening on %s with TLS, pinned as %s", ln.Addr(), spkiPin(srv.tls.Certificates[0].Leaf))
+	} else {
+		log.Printf("gochat server listening on %s", ln.Addr())
+	}
+	if srv.retention.enabled() {
+		go srv.pruneLoop()
+	}
+	return srv.serve(ln)
+}
+
+func (srv *server) serve(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		if err := srv.flood.admit(remoteAddr(c), time.Now()); err != nil {
+			refuse(c, err)
+			continue
+		}
+		go srv.handleConn(c)
+	}
+}
+
+func (srv *server) handleConn(c net.Conn) {
+	s := &session{srv: srv, conn: newFrameConn(c)}
+	s.conn.readTimeout, s.conn.writeTimeout = idleTimeout, writeTimeout
+	defer s.conn.close()
+
+	if err := srv.handshake(s); err != nil {
+		s.conn.write(handshakeFailure(err))
+		return
+	}
+	defer srv.disconnect(s)
+
+	addr, frames := remoteAddr(c), &tokenBucket{}
+	for {
+		f, err := s.conn.read()
+		if err != nil {
+			return
+		}
+		if err := srv.flood.frame(frames, addr, time.Now()); err != nil {
+			s.reply(f, frame{Type: frameError, Error: err.Error()})
+			return
+		}
+		h, ok := srv.handlers[f.Type]
+		if !ok {
+			s.reply(f, frame{Type: frameError, Error: "unknown frame type " + f.Type})
+			continue
+		}
+		if s.guest && !guestFrames[f.Type] {
+			s.reply(f, frame{Type: frameError, Error: errGuestsCant.Error()})
+			continue
+		}
+		s.stamp = newEventStamp()
+		if err := h(s, f); err != nil {
+			s.reply(f, frame{Type: frameError, Error: err.Error()})
+			continue
+		}
+		srv.replicate(s, f)
+	}
+}
+
+// handshake reads the client's hello, logs it in, registers the session
+// and joins it to #general.
+func (srv *server) handshake(s *session) error {
+	f, err := s.conn.read()
+	if err != nil {
+		return err
+	}
+	if f.Type != frameHello {
+		return fmt.Errorf("expected %s, got %s", frameHello, f.Type)
+	}
+	var hello helloData
+	if err := f.decode(&hello); err != nil {
+		return err
+	}
+	nick := strings.TrimSpace(hello.Nick)
+	if nick == "" || strings.ContainsAny(nick, " #@") || isGuestNick(nick) && !hello.Guest {
+		return fmt.Errorf("invalid nick %q", hello.Nick)
+	}
+	s.nick, s.client = nick, truncate(strings.TrimSpace(hello.Client), maxClientLength)
+	s.away = hello.Away
+	s.stamp = newEventStamp()
+	if hello.Resume != "" {
+		if resumed, err := srv.resume(s, hello); resumed || err != nil {
+			return err
+		}
+	}
+	tokens, err := srv.authenticate(s, hello)
+	if err != nil {
+		return err
+	}
+	nick = s.nick // signing in with the identity provider may pick another
+	if s.guest {
+		// Whoever had it dropped and came back without resuming. Until
+		// they are ended the nick stays taken, so no one else ends them
+		srv.mu.Lock()
+		parked, free := srv.takeParkedLocked(nick)
+		srv.mu.Unlock()
+		if !free {
+			return errGuestNickInUse
+		}
+		for _, old := range parked {
+			srv.leave(old)
+		}
+	}
+
+	srv.mu.Lock()
+	welcome := welcomeData{Nick: nick, Tokens: tokens, Role: srv.serverRoleLocked(nick), Permissions: srv.permissions,
+		Resume: s.newResumeToken()}
+	if s.guest {
+		// Checked again, in case another guest took it meanwhile
+		if srv.isOnlineLocked(nick) {
+			srv.mu.Unlock()
+			return errGuestNickInUse
+		}
+		welcome.Guest, welcome.Permissions = true, srv.guestPermissionsLocked()
+	} else {
+		s.persistLocked(func(st serverStore) error { return st.saveUser(nick, s.stamp.Time) })
+	}
+	was := srv.presenceOfLocked(nick)
+	srv.sessions[s] = struct{}{}
+	now := srv.presenceOfLocked(nick)
+	srv.mu.Unlock()
+	srv.publish(clusterEvent{Kind: clusterConnect, Nick: nick, Away: s.away})
+
+	// From here on handleConn won't disconnect s if we fail, so we do
+	if err := s.conn.write(newFrame(frameWelcome, welcome)); err != nil {
+		srv.leave(s)
+		return err
+	}
+	srv.presenceChanged(nick, was, now)
+	joined := srv.catchUp(s, hello.Seen)
+	if s.guest {
+		srv.joinGuestChannels(s, joined)
+		return nil
+	}
+	if len(joined) == 0 {
+		// Someone banned from #general just starts out in no channel
+		var banned *bannedError
+		if err := srv.join(s, "#general"); errors.As(err, &banned) {
+			return nil
+		} else if err != nil {
+			srv.leave(s)
+			return err
+		}
+		srv.replicate(s, newFrame(frameJoin, channelRef{Channel: "#general"}))
+	}
+	if s.invite != nil && s.invite.Channel != "" {
+		srv.joinInvited(s, s.invite)
+	}
+	return nil
+}
+
+// catchUp sends a client that just logged in what it missed, given how far
+// it saw each buffer, returning the channels it is in.
+func (srv *server) catchUp(s *session, seen map[string]uint64) []string {
+	srv.sendKVSnapshot(s)
+	joined := srv.channelsOf(s.nick)
+	for _, name := range joined {
+		srv.sendChannelState(s, name, seen[name])
+	}
+	srv.sendDMGaps(s, seen)
+	srv.sendDMReads(s)
+	srv.sendDMPresence(s)
+	return joined
+}
+
+// disconnect parks s for its client to resume, or ends it.
+func (srv *server) disconnect(s *session) {
+	if !srv.park(s) {
+		srv.leave(s)
+	}
+}
+
+// leave ends s, telling those who share a channel if its nick's presence
+// changed with it.
+func (srv *server) leave(s *session) {
+	srv.mu.Lock()
+	was := srv.presenceOfLocked(s.nick)
+	delete(srv.sessions, s)
+	now := srv.presenceOfLocked(s.nick)
+	srv.mu.Unlock()
+	srv.publish(clusterEvent{Kind: clusterDisconnect, Nick: s.nick, Away: s.away})
+
+	srv.presenceChanged(s.nick, was, now)
+	if now == presenceOffline && s.guest {
+		srv.partGuest(s)
+	}
+}
+
+func (s *session) reply(req, f frame) {
+	f.ID = req.ID
+	s.conn.write(f)
+}
+
+// --- Handlers ---
+
+func (srv *server) handlePing(s *session, f frame) error {
+	s.reply(f, frame{Type: framePong, Data: f.Data})
+	return nil
+}
+
+// handleHistory serves a page of older messages for scrollback.
+func (srv *server) handleHistory(s *session, f frame) error {
+	var req historyRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > historyPageSize {
+		limit = historyPageSize
+	}
+
+	srv.mu.Lock()
+	var history []wireMessage
+	if peer, ok := strings.CutPrefix(req.Channel, "@"); ok {
+		if dm, ok := srv.dms[dmKey(s.nick, peer)]; ok {
+			history = dm.history
+		}
+	} else {
+		ch, ok := srv.channels[req.Channel]
+		if !ok {
+			srv.mu.Unlock()
+			return errNoSuchChannel
+		}
+		if _, member := ch.members[s.nick]; !member {
+			srv.mu.Unlock()
+			return errNotJoined
+		}
+		history = ch.history
+	}
+	end := sort.Search(len(history), func(i int) bool { return !history[i].Time.Before(req.Before) })
+	start := max(end-limit, 0)
+	page := historyPage{Channel: req.Channel, Messages: slices.Clone(history[start:end]), More: start > 0}
+	srv.mu.Unlock()
+
+	if peer, ok := strings.CutPrefix(req.Channel, "@"); ok {
+		// DMs are stored from the sender's side, show them from ours
+		for i := range page.Messages {
+			page.Messages[i].Channel = "@" + peer
+		}
+	}
+	s.reply(f, newFrame(frameHistoryPage, page))
+	return nil
+}
+
+func (srv *server) handleJoin(s *session, f frame) error {
+	var ref channelRef
+	if err := f.decode(&ref); err != nil {
+		return err
+	}
+	if s.guest && !slices.Contains(srv.guestChannels, ref.Channel) {
+		return errGuestsCant
+	}
+	return srv.join(s, ref.Channel)
+}
+
+func (srv *server) join(s *session, name string) error {
+	srv.mu.Lock()
+	ch, ok := srv.channels[name]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	_, already := ch.members[s.nick]
+	if ch.private && !already {
+		srv.mu.Unlock()
+		// Don't reveal that a private channel exists
+		return errNoSuchChannel
+	}
+	if err := srv.bannedLocked(ch, s.nick, s.stamp.Time); err != nil && !already {
+		srv.mu.Unlock()
+		return err
+	}
+	var seq uint64
+	if !already {
+		evs, err := srv.appendLocked(s, name, serverEvent{Kind: eventJoin, Nick: s.nick, Time: s.stamp.Time, Member: s.nick, Role: roleMember})
+		if err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		seq = evs[0].Seq
+	}
+	r := srv.roleInLocked(ch, s.nick)
+	srv.mu.Unlock()
+
+	srv.sendChannelState(s, name, 0)
+	if !already {
+		srv.broadcast(name, newFrame(frameMemberJoin, memberEvent{
+			Channel:    name,
+			wireMember: wireMember{Nick: s.nick, Role: r, Presence: presenceOnline},
+			Seq:        seq,
+		}))
+	}
+	return nil
+}
+
+func (srv *server) handlePart(s *session, f frame) error {
+	var ref channelRef
+	if err := f.decode(&ref); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[ref.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	srv.mu.Unlock()
+
+	return srv.removeMember(s, ref.Channel, s.nick, "")
+}
+
+// removeMember drops nick from a channel for s, telling the channel (nick
+// included). by is set when someone else removed them.
+func (srv *server) removeMember(s *session, name, nick, by string) error {
+	srv.mu.Lock()
+	if _, ok := srv.channels[name]; !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	// They are no longer a member once it's done, so tell them directly
+	leaving := srv.sessionsOfLocked(nick)
+	evs, err := srv.appendLocked(s, name, serverEvent{Kind: eventPart, Nick: s.nick, Time: s.stamp.Time, Member: nick})
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f := newFrame(frameMemberPart, memberEvent{
+		Channel:    name,
+		wireMember: wireMember{Nick: nick},
+		By:         by,
+		Seq:        evs[0].Seq,
+	})
+	for _, sess := range leaving {
+		sess.conn.write(f)
+	}
+	srv.broadcast(name, f)
+	return nil
+}
+
+// handleInvite adds someone to a channel, for members whose role lets them
+// invite. Private channels also need a moderator.
+func (srv *server) handleInvite(s *session, f frame) error {
+	var req memberRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if !srv.canLocked(ch, s.nick, permInvite) || ch.private && srv.roleInLocked(ch, s.nick) < roleModerator {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	if !srv.knownNickLocked(req.Nick) {
+		srv.mu.Unlock()
+		return errNoSuchNick
+	}
+	if _, already := ch.members[req.Nick]; already {
+		srv.mu.Unlock()
+		return fmt.Errorf("%s is already in %s", req.Nick, req.Channel)
+	}
+	if err := srv.bannedLocked(ch, req.Nick, s.stamp.Time); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	evs, err := srv.appendLocked(s, req.Channel, serverEvent{Kind: eventJoin, Nick: s.nick, Time: s.stamp.Time, Member: req.Nick, Role: roleMember})
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	p, st := srv.presenceOfLocked(req.Nick), srv.statusOfLocked(req.Nick)
+	invitee := srv.sessionsOfLocked(req.Nick)
+	r := srv.roleInLocked(ch, req.Nick)
+	srv.mu.Unlock()
+
+	srv.broadcast(req.Channel, newFrame(frameMemberJoin, memberEvent{
+		Channel:    req.Channel,
+		wireMember: wireMember{Nick: req.Nick, Role: r, Presence: p, Status: st},
+		By:         s.nick,
+		Seq:        evs[0].Seq,
+	}))
+	for _, sess := range invitee {
+		srv.sendChannelState(sess, req.Channel, 0)
+	}
+	return nil
+}
+
+// handleRemove kicks a member out of a channel. The remover must be a
+// moderator and outrank the target.
+func (srv *server) handleRemove(s *session, f frame) error {
+	var req memberRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if _, ok := ch.members[req.Nick]; !ok {
+		srv.mu.Unlock()
+		return fmt.Errorf("%s is not in %s", req.Nick, req.Channel)
+	}
+	if r := srv.roleInLocked(ch, s.nick); r < roleModerator || srv.roleInLocked(ch, req.Nick) >= r {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	srv.mu.Unlock()
+
+	return srv.removeMember(s, req.Channel, req.Nick, s.nick)
+}
+
+func (srv *server) handleSend(s *session, f frame) error {
+	var req sendData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return nil
+	}
+	if req.ID != "" && !messageIDRE.MatchString(req.ID) {
+		return errBadMessageID
+	}
+	if !validSignature(req.Sig) {
+		return errBadSignature
+	}
+
+	msg := wireMessage{
+		ID:      cmp.Or(req.ID, s.stamp.ID),
+		Channel: req.Channel,
+		Nick:    s.nick,
+		Text:    text,
+		Time:    s.stamp.Time,
+		ReplyTo: req.ReplyTo,
+		Sig:     req.Sig,
+	}
+	if peer, ok := strings.CutPrefix(req.Channel, "@"); ok {
+		return srv.sendDM(s, peer, msg)
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if ch.archived {
+		srv.mu.Unlock()
+		return errArchived
+	}
+	if err := srv.mayPostLocked(s, ch); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	if sent, ok, err := srv.sentLocked(s, req.Channel, msg.ID); ok || err != nil {
+		srv.mu.Unlock()
+		if ok {
+			s.conn.write(newFrame(frameMessage, sent))
+		}
+		return err
+	}
+	if err := srv.slowModeLocked(s, ch, msg.Time); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	filtered, err := srv.filterLocked(s, ch, msg.Text)
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	msg.Text = filtered
+	if ch.shadowBannedLocked(s.nick, msg.Time) {
+		// Only their own sessions get it, and it isn't kept
+		ch.postedLocked(s.nick, msg.Time)
+		own := srv.sessionsOfLocked(s.nick)
+		srv.mu.Unlock()
+		for _, sess := range own {
+			sess.conn.write(newFrame(frameMessage, msg))
+		}
+		return nil
+	}
+	evs, err := srv.appendLocked(s, req.Channel, serverEvent{Kind: eventMessage, Nick: s.nick, Time: msg.Time, Message: &msg})
+	if err == nil {
+		ch.postedLocked(s.nick, msg.Time)
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	msg.Seq = evs[0].Seq
+
+	srv.broadcast(req.Channel, newFrame(frameMessage, msg))
+	return nil
+}
+
+// sendDM delivers a direct message to every session of the peer and of the
+// sender. Each side sees the buffer named after the other.
+func (srv *server) sendDM(s *session, peer string, msg wireMessage) error {
+	if peer == s.nick {
+		return errors.New("you can't message yourself")
+	}
+
+	srv.mu.Lock()
+	if !srv.knownNickLocked(peer) {
+		srv.mu.Unlock()
+		return errNoSuchNick
+	}
+	if !srv.canLocked(nil, s.nick, permPost) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	if sent, ok, err := srv.sentLocked(s, dmKey(s.nick, peer), msg.ID); ok || err != nil {
+		srv.mu.Unlock()
+		if ok {
+			s.conn.write(newFrame(frameMessage, sent))
+		}
+		return err
+	}
+	evs, err := srv.appendLocked(s, dmKey(s.nick, peer), serverEvent{Kind: eventMessage, Nick: s.nick, Time: msg.Time, Message: &msg})
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	msg.Seq = evs[0].Seq
+	peers, own := srv.dmSessionsLocked(s.nick, peer)
+	srv.mu.Unlock()
+
+	toPeer := msg
+	toPeer.Channel = "@" + s.nick
+	for _, sess := range peers {
+		sess.conn.write(newFrame(frameMessage, toPeer))
+	}
+	for _, sess := range own {
+		sess.conn.write(newFrame(frameMessage, msg))
+	}
+	return nil
+}
+
+// dmSessionsLocked returns the sessions of both sides of a DM.
+func (srv *server) dmSessionsLocked(nick, peer string) (peers, own []*session) {
+	for sess := range srv.sessions {
+		switch sess.nick {
+		case peer:
+			peers = append(peers, sess)
+		case nick:
+			own = append(own, sess)
+		}
+	}
+	return peers, own
+}
+
+// handleReact toggles the caller's reaction on a channel or DM message.
+func (srv *server) handleReact(s *session, f frame) error {
+	var req reactionData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	emoji := strings.TrimSpace(req.Emoji)
+	if emoji == "" || strings.ContainsAny(emoji, " \t") || utf8.RuneCountInString(emoji) > 16 {
+		return errBadReaction
+	}
+
+	srv.mu.Lock()
+	peer, isDM := strings.CutPrefix(req.Channel, "@")
+	var history []wireMessage
+	conv := req.Channel
+	var ch *serverChannel
+	if isDM {
+		conv = dmKey(s.nick, peer)
+		if dm, ok := srv.dms[conv]; ok {
+			history = dm.history
+		}
+	} else {
+		var ok bool
+		if ch, ok = srv.channels[req.Channel]; !ok {
+			srv.mu.Unlock()
+			return errNoSuchChannel
+		}
+		if _, member := ch.members[s.nick]; !member {
+			srv.mu.Unlock()
+			return errNotJoined
+		}
+		if ch.archived {
+			srv.mu.Unlock()
+			return errArchived
+		}
+		history = ch.history
+	}
+	if err := srv.mayPostLocked(s, ch); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	i := slices.IndexFunc(history, func(w wireMessage) bool { return w.ID == req.ID })
+	if i < 0 {
+		srv.mu.Unlock()
+		return errNoSuchMessage
+	}
+	added := !history[i].hasReaction(emoji, s.nick)
+	evs, err := srv.appendLocked(s, conv, serverEvent{Kind: eventReaction, Nick: s.nick, Time: s.stamp.Time, ID: req.ID, Emoji: emoji, Added: added})
+	if err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	var peers, own []*session
+	if isDM {
+		peers, own = srv.dmSessionsLocked(s.nick, peer)
+	}
+	srv.mu.Unlock()
+
+	ev := reactionData{Channel: req.Channel, ID: req.ID, Emoji: emoji, Nick: s.nick, Added: added, Seq: evs[0].Seq}
+	if !isDM {
+		srv.broadcast(req.Channel, newFrame(frameReaction, ev))
+		return nil
+	}
+	for _, sess := range own {
+		sess.conn.write(newFrame(frameReaction, ev))
+	}
+	ev.Channel = "@" + s.nick
+	for _, sess := range peers {
+		sess.conn.write(newFrame(frameReaction, ev))
+	}
+	return nil
+}
+
+// handlePin pins or unpins a channel message, for roles that may pin.
+func (srv *server) handlePin(s *session, f frame) error {
+	var req pinData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if ch.archived {
+		srv.mu.Unlock()
+		return errArchived
+	}
+	if !srv.canLocked(ch, s.nick, permPin) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	byID := func(w wireMessage) bool { return w.ID == req.ID }
+	pinned := slices.IndexFunc(ch.pins, byID)
+	if req.Pinned {
+		if pinned >= 0 {
+			srv.mu.Unlock()
+			return errAlreadyPinned
+		}
+		i := slices.IndexFunc(ch.history, byID)
+		if i < 0 {
+			srv.mu.Unlock()
+			return errNoSuchMessage
+		}
+		msg := ch.history[i]
+		if err := s.persistLocked(func(st serverStore) error { return st.setPinned(req.Channel, req.ID, true) }); err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		ch.pins = append(ch.pins, msg)
+		req.Message = &msg
+	} else {
+		if pinned < 0 {
+			srv.mu.Unlock()
+			return errNotPinned
+		}
+		if err := s.persistLocked(func(st serverStore) error { return st.setPinned(req.Channel, req.ID, false) }); err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		ch.pins = slices.Delete(ch.pins, pinned, pinned+1)
+	}
+	srv.mu.Unlock()
+
+	req.Nick = s.nick
+	srv.broadcast(req.Channel, newFrame(framePinned, req))
+	return nil
+}
+
+func (w *wireMessage) hasReaction(emoji, nick string) bool {
+	return slices.Contains(w.Reactions[emoji], nick)
+}
+
+// setReaction adds or takes back nick's emoji reaction. The map is replaced
+// rather than changed in place, since copies of the message may be being
+// encoded outside the lock.
+func (w *wireMessage) setReaction(emoji, nick string, on bool) {
+	if w.hasReaction(emoji, nick) == on {
+		return
+	}
+	next := make(map[string][]string, len(w.Reactions)+1)
+	for e, nicks := range w.Reactions {
+		next[e] = nicks
+	}
+	nicks := next[emoji]
+	if on {
+		next[emoji] = append(slices.Clip(nicks), nick)
+	} else {
+		next[emoji] = slices.DeleteFunc(slices.Clone(nicks), func(n string) bool { return n == nick })
+		if len(next[emoji]) == 0 {
+			delete(next, emoji)
+		}
+	}
+	w.Reactions = next
+}
+
+// dmKey names the conversation between two nicks regardless of direction.
+func dmKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "\x00" + b
+}
+
+// knownNickLocked reports whether nick is online or a member of any channel.
+func (srv *server) knownNickLocked(nick string) bool {
+	if srv.isOnlineLocked(nick) {
+		return true
+	}
+	for _, ch := range srv.channels {
+		if _, ok := ch.members[nick]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *server) handleList(s *session, f frame) error {
+	var req listData
+	if len(f.Data) > 0 {
+		if err := f.decode(&req); err != nil {
+			return err
+		}
+	}
+
+	srv.mu.Lock()
+	list := make([]channelInfo, 0, len(srv.channels))
+	for _, ch := range srv.channels {
+		if _, member := ch.members[s.nick]; ch.private && !member {
+			continue
+		}
+		if s.guest && !slices.Contains(srv.guestChannels, ch.name) {
+			continue
+		}
+		if ch.archived != req.Archived {
+			continue
+		}
+		list = append(list, channelInfo{
+			Name:     ch.name,
+			Topic:    ch.topic,
+			Members:  len(ch.members),
+			Private:  ch.private,
+			Archived: ch.archived,
+		})
+	}
+	srv.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	s.reply(f, newFrame(frameChannelList, channelListData{Channels: list, Archived: req.Archived}))
+	return nil
+}
+
+// handleArchive archives or restores a channel, for members whose role
+// lets them manage it. #general can't be archived.
+func (srv *server) handleArchive(s *session, f frame) error {
+	var req archiveData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if req.Channel == "#general" {
+		return errNotPermitted
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permManageChannels) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	next := *ch
+	next.archived = req.Archived
+	if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(&next) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ch.archived = req.Archived
+	srv.mu.Unlock()
+
+	srv.broadcast(req.Channel, newFrame(frameArchived, archiveData{
+		Channel:  req.Channel,
+		Archived: req.Archived,
+		Nick:     s.nick,
+	}))
+	return nil
+}
+
+func (srv *server) handleCreate(s *session, f frame) error {
+	var req createData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if !channelNameRE.MatchString(req.Name) {
+		return errBadChannel
+	}
+	topic := strings.TrimSpace(req.Topic)
+	if len(topic) > maxTopicLength {
+		return errTopicTooLong
+	}
+
+	srv.mu.Lock()
+	if srv.serverRoleLocked(s.nick) == roleGuest {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	if _, exists := srv.channels[req.Name]; exists {
+		srv.mu.Unlock()
+		return errChannelExists
+	}
+	ch := &serverChannel{
+		name:    req.Name,
+		topic:   topic,
+		private: req.Private,
+		created: s.stamp.Time,
+		members: make(map[string]role),
+	}
+	err := srv.createChannelLocked(s, ch)
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	srv.sendChannelState(s, req.Name, 0)
+	return nil
+}
+
+// createChannelLocked adds a new channel with s as its owner.
+func (srv *server) createChannelLocked(s *session, ch *serverChannel) error {
+	if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(ch) }); err != nil {
+		return err
+	}
+	srv.channels[ch.name] = ch
+	if _, err := srv.appendLocked(s, ch.name, serverEvent{Kind: eventJoin, Nick: s.nick, Time: s.stamp.Time, Member: s.nick, Role: roleOwner}); err != nil {
+		delete(srv.channels, ch.name)
+		return err
+	}
+	return nil
+}
+
+// handleImport merges imported history into a channel. It takes someone
+// whose server role may manage channels to create one for it, with them as
+// its owner, and a member who may manage it to add to one that exists. The
+// messages keep their nicks and times but are marked imported, and lose
+// their signatures, which were never checked.
+func (srv *server) handleImport(s *session, f frame) error {
+	var req importData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if !channelNameRE.MatchString(req.Channel) {
+		return errBadChannel
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok && !srv.canLocked(nil, s.nick, permManageChannels) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	if !ok {
+		created := req.Created
+		if created.IsZero() {
+			created = s.stamp.Time
+		}
+		topic := strings.TrimSpace(req.Topic)
+		topic = cutBytes(topic, maxTopicLength)
+		ch = &serverChannel{
+			name:    req.Channel,
+			topic:   topic,
+			private: req.Private,
+			created: created.UTC(),
+			members: make(map[string]role),
+		}
+		if err := srv.createChannelLocked(s, ch); err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+	} else if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permManageChannels) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	have := make(map[string]bool, len(ch.history))
+	for _, w := range ch.history {
+		have[w.ID] = true
+	}
+	var added []serverEvent
+	for _, w := range req.Messages {
+		if w.ID == "" || have[w.ID] {
+			continue
+		}
+		w.Channel, w.Reactions, w.Seq, w.Sig, w.Imported = req.Channel, nil, 0, "", true
+		added = append(added, serverEvent{Kind: eventMessage, Nick: s.nick, Time: s.stamp.Time, Message: &w})
+		have[w.ID] = true
+	}
+	if len(added) > 0 {
+		if _, err := srv.appendLocked(s, req.Channel, added...); err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+	}
+	srv.mu.Unlock()
+
+	s.reply(f, newFrame(frameImported, importResult{Channel: req.Channel, Added: len(added)}))
+	return nil
+}
+
+func (srv *server) handleTopic(s *session, f frame) error {
+	var req topicData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	topic := strings.TrimSpace(req.Topic)
+	if len(topic) > maxTopicLength {
+		return errTopicTooLong
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member {
+		srv.mu.Unlock()
+		return errNotJoined
+	}
+	if err := srv.mayPostLocked(s, ch); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	next := *ch
+	next.topic = topic
+	if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(&next) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ch.topic = topic
+	srv.mu.Unlock()
+
+	srv.broadcast(req.Channel, newFrame(frameTopicChanged, topicData{Channel: req.Channel, Topic: topic, Nick: s.nick}))
+	return nil
+}
+
+// --- Fan-out ---
+
+// broadcast sends f to every connected session that is a member of the
+// channel.
+func (srv *server) broadcast(name string, f frame) {
+	srv.mu.Lock()
+	ch, ok := srv.channels[name]
+	if !ok {
+		srv.mu.Unlock()
+		return
+	}
+	var targets []*session
+	for s := range srv.sessions {
+		if _, member := ch.members[s.nick]; member {
+			targets = append(targets, s)
+		}
+	}
+	srv.mu.Unlock()
+
+	for _, s := range targets {
+		s.conn.write(f)
+	}
+}
+
+// broadcastPresence tells those watching nick about a presence change.
+func (srv *server) broadcastPresence(nick string, p presence) {
+	f := newFrame(framePresence, presenceData{Nick: nick, Presence: p})
+
+	srv.mu.Lock()
+	targets := srv.watchersLocked(nick)
+	srv.mu.Unlock()
+
+	for _, s := range targets {
+		s.conn.write(f)
+	}
+}
+
+// watchersLocked are the sessions that show nick: of everyone sharing a
+// channel or a DM with them, and their own.
+func (srv *server) watchersLocked(nick string) []*session {
+	var list []*session
+	for s := range srv.sessions {
+		if s.nick == nick || srv.sharesChannelLocked(s.nick, nick) || srv.dms[dmKey(s.nick, nick)] != nil {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// sendChannelState sends a channel's state to s. A client that has seen
+// its log up to seen, when reconnecting, gets only the events since then
+// instead of the recent history if the log still has them all.
+func (srv *server) sendChannelState(s *session, name string, seen uint64) {
+	srv.mu.Lock()
+	ch, ok := srv.channels[name]
+	if !ok {
+		srv.mu.Unlock()
+		return
+	}
+	state := channelStateData{
+		Name:      ch.name,
+		Topic:     ch.topic,
+		Private:   ch.private,
+		Archived:  ch.archived,
+		Created:   ch.created,
+		Pins:      slices.Clone(ch.pins),
+		Overrides: maps.Clone(ch.overrides),
+		Mute:      ch.muteOfLocked(s.nick, time.Now()),
+		SlowMode:  int(ch.slowMode / time.Second),
+		Seq:       ch.seq,
+	}
+	if r, ok := srv.reads[s.nick][name]; ok {
+		state.Read = &r
+	}
+	for nick := range ch.members {
+		state.Members = append(state.Members, wireMember{Nick: nick, Role: srv.roleInLocked(ch, nick),
+			Presence: srv.presenceOfLocked(nick), Status: srv.statusOfLocked(nick)})
+	}
+	if gap, complete := ch.gapSince(seen, maxGapEvents); seen > 0 && complete {
+		state.Since = seen
+		state.Gap = eventFrames(name, gap)
+	} else {
+		start := max(len(ch.history)-historyReplay, 0)
+		state.History = append(state.History, ch.history[start:]...)
+	}
+	srv.mu.Unlock()
+
+	s.conn.write(newFrame(frameChannelState, state))
+}
+
+func (srv *server) channelsOf(nick string) []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var names []string
+	for name, ch := range srv.channels {
+		if _, ok := ch.members[nick]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (srv *server) sessionsOfLocked(nick string) []*session {
+	var list []*session
+	for s := range srv.sessions {
+		if s.nick == nick {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// isOnlineLocked reports whether nick has a session here or on a peer.
+func (srv *server) isOnlineLocked(nick string) bool {
+	for s := range srv.sessions {
+		if s.nick == nick {
+			return true
+		}
+	}
+	for _, p := range srv.peers {
+		if p.online[nick] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *server) sharesChannelLocked(a, b string) bool {
+	for _, ch := range srv.channels {
+		_, okA := ch.members[a]
+		_, okB := ch.members[b]
+		if okA && okB {
+			return true
+		}
+	}
+	return false
+}
+
+// randomKey is a new 256-bit secret.
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// serverStore persists the server's state so channels and history survive
+// a restart. The server still works from memory: it loads everything on
+// start and writes each change through while holding srv.mu, so the store
+// sees changes in the order they happened. Messages and membership are
+// kept as each conversation's event log, see eventlog.go.
+type serverStore interface {
+	load() (storedState, error)
+	// saveUser records that nick connected at seen.
+	saveUser(nick string, seen time.Time) error
+	// saveAccount records the account registered as nick.
+	saveAccount(nick string, a account) error
+	// saveRefreshToken and deleteRefreshToken record sessions starting
+	// and ending.
+	saveRefreshToken(t refreshToken) error
+	deleteRefreshToken(hash string) error
+	// saveInvite and deleteInvite record an invite being made or used,
+	// and revoked.
+	saveInvite(inv invite) error
+	deleteInvite(hash string) error
+	// saveChannel records a channel's metadata.
+	saveChannel(ch *serverChannel) error
+	// appendEvents adds to the log of a channel, or of a DM by its dmKey.
+	appendEvents(conv string, evs []serverEvent) error
+	// forgetMessages deletes the events about messages of a conversation,
+	// for retention.
+	forgetMessages(conv string, ids []string) error
+	// forgetEvents deletes events of a conversation by sequence number,
+	// for erasing a user.
+	forgetEvents(conv string, seqs []uint64) error
+	// saveE2EKey records the public key nick's DMs are encrypted to.
+	saveE2EKey(nick, key string) error
+	// saveSigningKey records the public key nick's messages are signed
+	// with, empty for none.
+	saveSigningKey(nick, key string) error
+	// saveStatus records nick's status, a zero one clearing it.
+	saveStatus(nick string, st userStatus) error
+	// deleteUser drops the record of nick, with their sessions and key.
+	deleteUser(nick string) error
+	setPinned(channel, id string, pinned bool) error
+	// saveSanction and deleteSanction record a ban, mute or shadow ban
+	// (kind modBan, modMute or modShadowBan) in a channel being set and
+	// lifted.
+	saveSanction(channel, kind string, sn sanction) error
+	deleteSanction(channel, kind, nick string) error
+	// logFilterHit adds to the word filter's review log.
+	logFilterHit(hit filterHit) error
+	// logModeration adds to the moderation log.
+	logModeration(ev moderationEvent) error
+	// saveReport records a report being filed or resolved.
+	saveReport(r report) error
+	close() error
+}
+
+// storedState is what a serverStore has.
+type storedState struct {
+	channels map[string]*serverChannel // metadata, with bans and mutes
+	events   map[string][]serverEvent  // by channel or dmKey, in order
+	pins     map[string][]string       // message IDs by channel, oldest pin first
+	accounts map[string]account        // by nick
+	tokens   map[string]refreshToken   // by hash
+	invites  map[string]invite         // by hash
+	e2eKeys  map[string]string         // by nick
+	signing  map[string]string         // signing keys, by nick
+	statuses map[string]userStatus     // by nick
+	// filterHits are the newest of the word filter's review log, oldest
+	// first
+	filterHits []filterHit
+	reports    []report // the open ones, oldest first
+	// modLog is the newest of the moderation log, oldest first
+	modLog []moderationEvent
+}
+
+var errStoreFailed = errors.New("the server couldn't save that, try again later")
+
+// persistLocked runs a write against the server's store, if it has one.
+// The cause of a failure is logged, the client only gets errStoreFailed.
+// srv.mu must be held.
+func (srv *server) persistLocked(fn func(st serverStore) error) error {
+	if srv.store == nil {
+		return nil
+	}
+	if err := fn(srv.store); err != nil {
+		log.Printf("store: %v", err)
+		return errStoreFailed
+	}
+	return nil
+}
+
+// persistLocked is srv.persistLocked for a request of s. Replicas leave it
+// to the instance the request came in on, which shares the store.
+func (s *session) persistLocked(fn func(st serverStore) error) error {
+	if s.replica {
+		return nil
+	}
+	return s.srv.persistLocked(fn)
+}
+
+// load replaces the server's state with what its store has, replaying the
+// logs, and keeps #general if the store doesn't have it yet.
+func (srv *server) load() error {
+	state, err := srv.store.load()
+	if err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	channels, dms := state.channels, make(map[string]*conversation)
+	for name, ch := range srv.channels {
+		if _, ok := channels[name]; !ok {
+			if err := srv.store.saveChannel(ch); err != nil {
+				return err
+			}
+			channels[name] = ch
+		}
+	}
+	for conv, evs := range state.events {
+		if ch, ok := channels[conv]; ok {
+			ch.replay(ch, evs)
+			continue
+		}
+		dm := &conversation{}
+		dm.replay(nil, evs)
+		dms[conv] = dm
+	}
+	for name, ids := range state.pins {
+		ch, ok := channels[name]
+		if !ok {
+			continue
+		}
+		for _, id := range ids {
+			if i := ch.messageIndex(id); i >= 0 {
+				ch.pins = append(ch.pins, ch.history[i])
+			}
+		}
+	}
+	srv.channels, srv.dms = channels, dms
+	if state.accounts != nil {
+		srv.accounts = state.accounts
+	}
+	if state.tokens != nil {
+		srv.refreshTokens = state.tokens
+	}
+	if state.invites != nil {
+		srv.invites = state.invites
+	}
+	if state.e2eKeys != nil {
+		srv.e2eKeys = state.e2eKeys
+	}
+	if state.signing != nil {
+		srv.signingKeys = state.signing
+	}
+	if state.statuses != nil {
+		srv.statuses = state.statuses
+	}
+	srv.filterLog = state.filterHits
+	srv.reports = state.reports
+	srv.modLog = state.modLog
+	return nil
+}
+
+// messageIDs lists the IDs of msgs, for deleteMessages.
+func messageIDs(msgs []wireMessage) []string {
+	ids := make([]string, len(msgs))
+	for i, w := range msgs {
+		ids[i] = w.ID
+	}
+	return ids
+}
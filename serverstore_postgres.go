@@ -0,0 +1,773 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresStore keeps the server's state in PostgreSQL: channels, users,
+// each conversation's event log, pins and the files messages link to.
+type postgresStore struct {
+	db *sql.DB
+}
+
+// pgMigrations are applied in order, each once, and recorded in
+// schema_migrations. Append new ones, never change an applied one.
+var pgMigrations = []string{
+	`CREATE TABLE users (
+		nick       TEXT PRIMARY KEY,
+		first_seen TIMESTAMPTZ NOT NULL,
+		last_seen  TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE channels (
+		name     TEXT PRIMARY KEY,
+		topic    TEXT NOT NULL DEFAULT '',
+		private  BOOLEAN NOT NULL DEFAULT false,
+		archived BOOLEAN NOT NULL DEFAULT false,
+		created  TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE members (
+		channel TEXT NOT NULL REFERENCES channels ON DELETE CASCADE,
+		nick    TEXT NOT NULL,
+		role    SMALLINT NOT NULL,
+		PRIMARY KEY (channel, nick)
+	);
+	CREATE TABLE messages (
+		conversation TEXT NOT NULL,
+		id           TEXT NOT NULL,
+		nick         TEXT NOT NULL,
+		text         TEXT NOT NULL,
+		time         TIMESTAMPTZ NOT NULL,
+		reply_to     TEXT NOT NULL DEFAULT '',
+		reactions    JSONB,
+		pinned_at    TIMESTAMPTZ,
+		PRIMARY KEY (conversation, id)
+	);
+	CREATE INDEX messages_by_time ON messages (conversation, time);
+	CREATE TABLE attachments (
+		conversation TEXT NOT NULL,
+		message_id   TEXT NOT NULL,
+		name         TEXT NOT NULL,
+		url          TEXT NOT NULL,
+		FOREIGN KEY (conversation, message_id) REFERENCES messages ON DELETE CASCADE
+	);
+	CREATE INDEX attachments_by_message ON attachments (conversation, message_id);`,
+
+	// Messages and membership become each conversation's event log
+	`CREATE TABLE events (
+		conversation TEXT NOT NULL,
+		seq          BIGINT NOT NULL,
+		kind         TEXT NOT NULL,
+		message_id   TEXT NOT NULL DEFAULT '',
+		time         TIMESTAMPTZ NOT NULL,
+		data         JSONB NOT NULL,
+		PRIMARY KEY (conversation, seq)
+	);
+	CREATE INDEX events_by_message ON events (conversation, message_id);
+	CREATE TABLE pins (
+		channel    TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		pinned_at  TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (channel, message_id)
+	);
+	INSERT INTO pins (channel, message_id, pinned_at)
+		SELECT conversation, id, pinned_at FROM messages WHERE pinned_at IS NOT NULL;
+	INSERT INTO events (conversation, seq, kind, message_id, time, data)
+		SELECT conversation, ROW_NUMBER() OVER (PARTITION BY conversation ORDER BY time, id), 'message', id, time,
+			jsonb_build_object('kind', 'message', 'nick', nick, 'time', time,
+				'message', jsonb_strip_nulls(jsonb_build_object(
+					'id', id, 'channel', CASE WHEN conversation LIKE '#%' THEN conversation END,
+					'nick', nick, 'text', text, 'time', time,
+					'reply_to', NULLIF(reply_to, ''), 'reactions', reactions)))
+		FROM messages;
+	INSERT INTO events (conversation, seq, kind, time, data)
+		SELECT m.channel, COALESCE(n.count, 0) + ROW_NUMBER() OVER (PARTITION BY m.channel ORDER BY m.nick), 'join', now(),
+			jsonb_build_object('kind', 'join', 'nick', m.nick, 'time', now(), 'member', m.nick, 'role', m.role)
+		FROM members m
+		LEFT JOIN (SELECT conversation, COUNT(*) AS count FROM messages GROUP BY conversation) n ON n.conversation = m.channel;
+	ALTER TABLE attachments DROP CONSTRAINT attachments_conversation_message_id_fkey;
+	DROP TABLE members;
+	DROP TABLE messages;`,
+
+	// Nicks become accounts with a password, empty for users from before
+	`ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';`,
+
+	// Sessions, by the hash of their refresh token
+	`CREATE TABLE refresh_tokens (
+		hash    TEXT PRIMARY KEY,
+		nick    TEXT NOT NULL,
+		created TIMESTAMPTZ NOT NULL,
+		expires TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX refresh_tokens_by_nick ON refresh_tokens (nick);`,
+
+	// Accounts signed in to through the identity provider
+	`ALTER TABLE users ADD COLUMN identity TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX users_by_identity ON users (identity) WHERE identity <> '';`,
+
+	// Accounts from the directory, with the roles its groups give them,
+	// space-separated
+	`ALTER TABLE users ADD COLUMN directory BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN roles TEXT NOT NULL DEFAULT '';`,
+
+	// Public keys DMs are encrypted to
+	`ALTER TABLE users ADD COLUMN e2e_key TEXT NOT NULL DEFAULT '';`,
+
+	// The device each session is, for the device list
+	`ALTER TABLE refresh_tokens ADD COLUMN device TEXT NOT NULL DEFAULT '',
+		ADD COLUMN client TEXT NOT NULL DEFAULT '',
+		ADD COLUMN addr TEXT NOT NULL DEFAULT '',
+		ADD COLUMN started TIMESTAMPTZ;
+	UPDATE refresh_tokens SET started = created;
+	ALTER TABLE refresh_tokens ALTER COLUMN started SET NOT NULL;`,
+
+	// Public keys messages are signed with
+	`ALTER TABLE users ADD COLUMN signing_key TEXT NOT NULL DEFAULT '';`,
+
+	// What roles may do in a channel instead of the server's permissions
+	`ALTER TABLE channels ADD COLUMN overrides JSONB;`,
+
+	// Bans and mutes, until NULL lasting until lifted
+	`CREATE TABLE sanctions (
+		channel TEXT NOT NULL,
+		kind    TEXT NOT NULL,
+		nick    TEXT NOT NULL,
+		by_nick TEXT NOT NULL,
+		reason  TEXT NOT NULL DEFAULT '',
+		since   TIMESTAMPTZ NOT NULL,
+		until   TIMESTAMPTZ,
+		PRIMARY KEY (channel, kind, nick)
+	);`,
+
+	// Seconds between each member's messages, 0 for no slow mode
+	`ALTER TABLE channels ADD COLUMN slow_mode INTEGER NOT NULL DEFAULT 0;`,
+
+	// Channels' own word filter rules, and what matched the filter
+	`ALTER TABLE channels ADD COLUMN filters JSONB;
+	CREATE TABLE filter_hits (
+		id      BIGSERIAL PRIMARY KEY,
+		channel TEXT NOT NULL,
+		nick    TEXT NOT NULL,
+		action  TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		text    TEXT NOT NULL,
+		time    TIMESTAMPTZ NOT NULL
+	);`,
+
+	// Reported messages, resolution '' while waiting for a moderator
+	`CREATE TABLE reports (
+		id          TEXT PRIMARY KEY,
+		channel     TEXT NOT NULL,
+		message_id  TEXT NOT NULL,
+		author      TEXT NOT NULL,
+		text        TEXT NOT NULL,
+		reporter    TEXT NOT NULL,
+		reason      TEXT NOT NULL DEFAULT '',
+		time        TIMESTAMPTZ NOT NULL,
+		resolution  TEXT NOT NULL DEFAULT '',
+		resolved_by TEXT NOT NULL DEFAULT '',
+		resolved    TIMESTAMPTZ
+	);`,
+
+	// Every moderation action, until NULL for none
+	`CREATE TABLE mod_log (
+		id      BIGSERIAL PRIMARY KEY,
+		channel TEXT NOT NULL,
+		action  TEXT NOT NULL,
+		nick    TEXT NOT NULL,
+		by_nick TEXT NOT NULL,
+		reason  TEXT NOT NULL DEFAULT '',
+		since   TIMESTAMPTZ NOT NULL,
+		until   TIMESTAMPTZ
+	);`,
+
+	// Invite codes by hash, max_uses 0 for any number and expires NULL
+	// for never
+	`CREATE TABLE invites (
+		hash     TEXT PRIMARY KEY,
+		channel  TEXT NOT NULL DEFAULT '',
+		max_uses INTEGER NOT NULL DEFAULT 0,
+		uses     INTEGER NOT NULL DEFAULT 0,
+		by_nick  TEXT NOT NULL,
+		created  TIMESTAMPTZ NOT NULL,
+		expires  TIMESTAMPTZ
+	);`,
+
+	// What each user says they are up to, NULL for nothing
+	`ALTER TABLE users ADD COLUMN status JSONB;`,
+}
+
+// pgMigrationLock is the advisory lock key held while migrating, so
+// servers starting together don't both apply the same migration.
+const pgMigrationLock = 0x6763686174 // "gchat"
+
+// openPostgresStore connects to the database at url (a postgres:// URL or
+// key=value DSN) and brings its schema up to date.
+func openPostgresStore(url string) (*postgresStore, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &postgresStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating the database: %w", err)
+	}
+	return s, nil
+}
+
+// migrate applies the pgMigrations the database doesn't have yet.
+func (s *postgresStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, pgMigrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(pgMigrations) {
+		return fmt.Errorf("the database is at schema version %d, newer than this server (%d)", version, len(pgMigrations))
+	}
+	for i := version; i < len(pgMigrations); i++ {
+		if _, err := tx.Exec(pgMigrations[i]); err != nil {
+			return fmt.Errorf("version %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pgConversation names a channel or DM in the messages table. dmKey joins
+// the nicks with a NUL, which Postgres text can't hold, so a space (which
+// nicks can't contain) is used instead.
+func pgConversation(conv string) string {
+	return strings.ReplaceAll(conv, "\x00", " ")
+}
+
+func (s *postgresStore) close() error {
+	return s.db.Close()
+}
+
+func (s *postgresStore) load() (storedState, error) {
+	state := storedState{
+		channels: make(map[string]*serverChannel),
+		events:   make(map[string][]serverEvent),
+		pins:     make(map[string][]string),
+		accounts: make(map[string]account),
+		tokens:   make(map[string]refreshToken),
+		invites:  make(map[string]invite),
+		e2eKeys:  make(map[string]string),
+		signing:  make(map[string]string),
+		statuses: make(map[string]userStatus),
+	}
+	rows, err := s.db.Query(`SELECT name, topic, private, archived, created, overrides, slow_mode, filters FROM channels`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		ch := &serverChannel{members: make(map[string]role)}
+		var overrides, filters []byte
+		var slowMode int
+		if err := rows.Scan(&ch.name, &ch.topic, &ch.private, &ch.archived, &ch.created, &overrides, &slowMode, &filters); err != nil {
+			rows.Close()
+			return state, err
+		}
+		if filters != nil {
+			if err := json.Unmarshal(filters, &ch.filters); err != nil {
+				rows.Close()
+				return state, err
+			}
+			if err := ch.filters.compile(); err != nil {
+				rows.Close()
+				return state, fmt.Errorf("%s's word filter: %w", ch.name, err)
+			}
+		}
+		ch.slowMode = time.Duration(slowMode) * time.Second
+		if overrides != nil {
+			if err := json.Unmarshal(overrides, &ch.overrides); err != nil {
+				rows.Close()
+				return state, err
+			}
+		}
+		ch.created = ch.created.UTC()
+		state.channels[ch.name] = ch
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT conversation, seq, data FROM events ORDER BY conversation, seq`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var conv string
+		var seq uint64
+		var data []byte
+		if err := rows.Scan(&conv, &seq, &data); err != nil {
+			rows.Close()
+			return state, err
+		}
+		var ev serverEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			rows.Close()
+			return state, fmt.Errorf("event %d of %s: %w", seq, conv, err)
+		}
+		ev.Seq, ev.Time = seq, ev.Time.UTC()
+		if !strings.HasPrefix(conv, "#") {
+			conv = strings.Replace(conv, " ", "\x00", 1)
+		}
+		state.events[conv] = append(state.events[conv], ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT nick, password_hash, identity, directory, roles, first_seen FROM users
+		WHERE password_hash <> '' OR identity <> '' OR directory`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var nick, roles string
+		var a account
+		if err := rows.Scan(&nick, &a.Hash, &a.Identity, &a.Directory, &roles, &a.Created); err != nil {
+			rows.Close()
+			return state, err
+		}
+		a.Roles = strings.Fields(roles)
+		a.Created = a.Created.UTC()
+		state.accounts[nick] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT nick, e2e_key, signing_key FROM users WHERE e2e_key <> '' OR signing_key <> ''`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var nick, key, signing string
+		if err := rows.Scan(&nick, &key, &signing); err != nil {
+			rows.Close()
+			return state, err
+		}
+		if key != "" {
+			state.e2eKeys[nick] = key
+		}
+		if signing != "" {
+			state.signing[nick] = signing
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT nick, status FROM users WHERE status IS NOT NULL`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var nick string
+		var data []byte
+		if err := rows.Scan(&nick, &data); err != nil {
+			rows.Close()
+			return state, err
+		}
+		var st userStatus
+		if err := json.Unmarshal(data, &st); err != nil {
+			rows.Close()
+			return state, err
+		}
+		state.statuses[nick] = st
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT hash, nick, device, client, addr, started, created, expires FROM refresh_tokens
+		WHERE expires > now()`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var t refreshToken
+		if err := rows.Scan(&t.Hash, &t.Nick, &t.Device, &t.Client, &t.Addr, &t.Started, &t.Created, &t.Expires); err != nil {
+			rows.Close()
+			return state, err
+		}
+		t.Started, t.Created, t.Expires = t.Started.UTC(), t.Created.UTC(), t.Expires.UTC()
+		state.tokens[t.Hash] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT hash, channel, max_uses, uses, by_nick, created, expires FROM invites`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var inv invite
+		var expires sql.NullTime
+		if err := rows.Scan(&inv.Hash, &inv.Channel, &inv.MaxUses, &inv.Uses, &inv.By, &inv.Created, &expires); err != nil {
+			rows.Close()
+			return state, err
+		}
+		inv.ID, inv.Created = inv.Hash[:inviteIDLength], inv.Created.UTC()
+		if expires.Valid {
+			inv.Expires = expires.Time.UTC()
+		}
+		state.invites[inv.Hash] = inv
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT channel, kind, nick, by_nick, reason, since, until FROM sanctions`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var name, kind string
+		var sn sanction
+		var until sql.NullTime
+		if err := rows.Scan(&name, &kind, &sn.Nick, &sn.By, &sn.Reason, &sn.Since, &until); err != nil {
+			rows.Close()
+			return state, err
+		}
+		sn.Since = sn.Since.UTC()
+		if until.Valid {
+			sn.Until = until.Time.UTC()
+		}
+		if ch, ok := state.channels[name]; ok {
+			ch.setSanction(kind, sn)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT channel, nick, action, pattern, text, time FROM
+		(SELECT * FROM filter_hits ORDER BY id DESC LIMIT $1) newest ORDER BY id`, maxFilterLog)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var hit filterHit
+		if err := rows.Scan(&hit.Channel, &hit.Nick, &hit.Action, &hit.Pattern, &hit.Text, &hit.Time); err != nil {
+			rows.Close()
+			return state, err
+		}
+		hit.Time = hit.Time.UTC()
+		state.filterHits = append(state.filterHits, hit)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT channel, action, nick, by_nick, reason, since, until FROM
+		(SELECT * FROM mod_log ORDER BY id DESC LIMIT $1) newest ORDER BY id`, maxModLog)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var ev moderationEvent
+		var until sql.NullTime
+		if err := rows.Scan(&ev.Channel, &ev.Action, &ev.Nick, &ev.By, &ev.Reason, &ev.Since, &until); err != nil {
+			rows.Close()
+			return state, err
+		}
+		ev.Since = ev.Since.UTC()
+		if until.Valid {
+			ev.Until = until.Time.UTC()
+		}
+		state.modLog = append(state.modLog, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT id, channel, message_id, author, text, reporter, reason, time
+		FROM reports WHERE resolution = '' ORDER BY time`)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var r report
+		if err := rows.Scan(&r.ID, &r.Channel, &r.MessageID, &r.Author, &r.Text, &r.Reporter, &r.Reason, &r.Time); err != nil {
+			rows.Close()
+			return state, err
+		}
+		r.Time = r.Time.UTC()
+		state.reports = append(state.reports, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = s.db.Query(`SELECT channel, message_id FROM pins ORDER BY pinned_at`)
+	if err != nil {
+		return state, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, id string
+		if err := rows.Scan(&name, &id); err != nil {
+			return state, err
+		}
+		state.pins[name] = append(state.pins[name], id)
+	}
+	return state, rows.Err()
+}
+
+func (s *postgresStore) saveUser(nick string, seen time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO users (nick, first_seen, last_seen) VALUES ($1, $2, $2)
+		ON CONFLICT (nick) DO UPDATE SET last_seen = excluded.last_seen`, nick, seen)
+	return err
+}
+
+func (s *postgresStore) saveAccount(nick string, a account) error {
+	_, err := s.db.Exec(`INSERT INTO users (nick, first_seen, last_seen, password_hash, identity, directory, roles)
+		VALUES ($1, $2, $2, $3, $4, $5, $6)
+		ON CONFLICT (nick) DO UPDATE SET password_hash = excluded.password_hash, identity = excluded.identity,
+			directory = excluded.directory, roles = excluded.roles`,
+		nick, a.Created, a.Hash, a.Identity, a.Directory, strings.Join(a.Roles, " "))
+	return err
+}
+
+func (s *postgresStore) saveE2EKey(nick, key string) error {
+	_, err := s.db.Exec(`INSERT INTO users (nick, first_seen, last_seen, e2e_key) VALUES ($1, now(), now(), $2)
+		ON CONFLICT (nick) DO UPDATE SET e2e_key = excluded.e2e_key`, nick, key)
+	return err
+}
+
+func (s *postgresStore) saveStatus(nick string, st userStatus) error {
+	var data []byte
+	if st != (userStatus{}) {
+		var err error
+		if data, err = json.Marshal(st); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(`INSERT INTO users (nick, first_seen, last_seen, status) VALUES ($1, now(), now(), $2)
+		ON CONFLICT (nick) DO UPDATE SET status = excluded.status`, nick, data)
+	return err
+}
+
+func (s *postgresStore) saveSigningKey(nick, key string) error {
+	_, err := s.db.Exec(`INSERT INTO users (nick, first_seen, last_seen, signing_key) VALUES ($1, now(), now(), $2)
+		ON CONFLICT (nick) DO UPDATE SET signing_key = excluded.signing_key`, nick, key)
+	return err
+}
+
+func (s *postgresStore) saveChannel(ch *serverChannel) error {
+	var overrides, filters []byte
+	var err error
+	if len(ch.overrides) > 0 {
+		if overrides, err = json.Marshal(ch.overrides); err != nil {
+			return err
+		}
+	}
+	if len(ch.filters.Rules) > 0 || ch.filters.NoServer {
+		if filters, err = json.Marshal(ch.filters); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec(`INSERT INTO channels (name, topic, private, archived, created, overrides, slow_mode, filters)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (name) DO UPDATE SET
+			topic = excluded.topic, private = excluded.private, archived = excluded.archived,
+			overrides = excluded.overrides, slow_mode = excluded.slow_mode, filters = excluded.filters`,
+		ch.name, ch.topic, ch.private, ch.archived, ch.created, overrides, int(ch.slowMode/time.Second), filters)
+	return err
+}
+
+func (s *postgresStore) appendEvents(conv string, evs []serverEvent) error {
+	conv = pgConversation(conv)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, ev := range evs {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		id := ev.messageID()
+		if _, err := tx.Exec(`INSERT INTO events (conversation, seq, kind, message_id, time, data)
+			VALUES ($1, $2, $3, $4, $5, $6::jsonb)`, conv, ev.Seq, ev.Kind, id, ev.Time, string(data)); err != nil {
+			return err
+		}
+		// attachments lists the files messages link to as they are now
+		switch ev.Kind {
+		case eventMessage:
+			err = insertAttachments(tx, conv, id, ev.Message.Text)
+		case eventEdit, eventDelete:
+			_, err = tx.Exec(`DELETE FROM attachments WHERE conversation = $1 AND message_id = $2`, conv, id)
+			if err == nil && ev.Kind == eventEdit {
+				err = insertAttachments(tx, conv, id, ev.Text)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertAttachments(tx *sql.Tx, conv, id, text string) error {
+	for _, a := range attachments(text) {
+		if _, err := tx.Exec(`INSERT INTO attachments (conversation, message_id, name, url) VALUES ($1, $2, $3, $4)`,
+			conv, id, a.Name, a.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *postgresStore) forgetMessages(conv string, ids []string) error {
+	conv = pgConversation(conv)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"events", "attachments"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE conversation = $1 AND message_id = ANY($2)`, conv, ids); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM pins WHERE channel = $1 AND message_id = ANY($2)`, conv, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) forgetEvents(conv string, seqs []uint64) error {
+	_, err := s.db.Exec(`DELETE FROM events WHERE conversation = $1 AND seq = ANY($2)`, pgConversation(conv), seqs)
+	return err
+}
+
+func (s *postgresStore) deleteUser(nick string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"users", "refresh_tokens"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE nick = $1`, nick); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) saveRefreshToken(t refreshToken) error {
+	_, err := s.db.Exec(`INSERT INTO refresh_tokens (hash, nick, device, client, addr, started, created, expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		t.Hash, t.Nick, t.Device, t.Client, t.Addr, t.Started, t.Created, t.Expires)
+	return err
+}
+
+func (s *postgresStore) deleteRefreshToken(hash string) error {
+	_, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE hash = $1`, hash)
+	return err
+}
+
+func (s *postgresStore) saveInvite(inv invite) error {
+	expires := sql.NullTime{Time: inv.Expires, Valid: !inv.Expires.IsZero()}
+	_, err := s.db.Exec(`INSERT INTO invites (hash, channel, max_uses, uses, by_nick, created, expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (hash) DO UPDATE SET uses = excluded.uses`,
+		inv.Hash, inv.Channel, inv.MaxUses, inv.Uses, inv.By, inv.Created, expires)
+	return err
+}
+
+func (s *postgresStore) deleteInvite(hash string) error {
+	_, err := s.db.Exec(`DELETE FROM invites WHERE hash = $1`, hash)
+	return err
+}
+
+func (s *postgresStore) saveSanction(channel, kind string, sn sanction) error {
+	until := sql.NullTime{Time: sn.Until, Valid: !sn.Until.IsZero()}
+	_, err := s.db.Exec(`INSERT INTO sanctions (channel, kind, nick, by_nick, reason, since, until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (channel, kind, nick) DO UPDATE SET
+			by_nick = excluded.by_nick, reason = excluded.reason, since = excluded.since, until = excluded.until`,
+		channel, kind, sn.Nick, sn.By, sn.Reason, sn.Since, until)
+	return err
+}
+
+func (s *postgresStore) deleteSanction(channel, kind, nick string) error {
+	_, err := s.db.Exec(`DELETE FROM sanctions WHERE channel = $1 AND kind = $2 AND nick = $3`, channel, kind, nick)
+	return err
+}
+
+func (s *postgresStore) logFilterHit(hit filterHit) error {
+	_, err := s.db.Exec(`INSERT INTO filter_hits (channel, nick, action, pattern, text, time) VALUES ($1, $2, $3, $4, $5, $6)`,
+		hit.Channel, hit.Nick, hit.Action, hit.Pattern, hit.Text, hit.Time)
+	return err
+}
+
+func (s *postgresStore) logModeration(ev moderationEvent) error {
+	until := sql.NullTime{Time: ev.Until, Valid: !ev.Until.IsZero()}
+	_, err := s.db.Exec(`INSERT INTO mod_log (channel, action, nick, by_nick, reason, since, until) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		ev.Channel, ev.Action, ev.Nick, ev.By, ev.Reason, ev.Since, until)
+	return err
+}
+
+func (s *postgresStore) saveReport(r report) error {
+	resolved := sql.NullTime{Time: r.Resolved, Valid: !r.Resolved.IsZero()}
+	_, err := s.db.Exec(`INSERT INTO reports (id, channel, message_id, author, text, reporter, reason, time, resolution, resolved_by, resolved)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET
+			resolution = excluded.resolution, resolved_by = excluded.resolved_by, resolved = excluded.resolved`,
+		r.ID, r.Channel, r.MessageID, r.Author, r.Text, r.Reporter, r.Reason, r.Time, r.Resolution, r.ResolvedBy, resolved)
+	return err
+}
+
+func (s *postgresStore) setPinned(channel, id string, pinned bool) error {
+	if !pinned {
+		_, err := s.db.Exec(`DELETE FROM pins WHERE channel = $1 AND message_id = $2`, channel, id)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO pins (channel, message_id, pinned_at) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, channel, id, time.Now().UTC())
+	return err
+}
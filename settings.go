@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// settings is UI state gochat writes back on its own (layout, and so on),
+// persisted as JSON in the user's config directory between runs.
+type settings struct {
+	Layout   layoutSettings              `json:"layout"`
+	Accounts map[string]*accountSettings `json:"accounts,omitempty"`
+	Servers  []serverSettings            `json:"servers,omitempty"` // used when --server isn't given
+
+	// StatusFormat lays out the status line, see defaultStatusFormat
+	StatusFormat string `json:"status_format,omitempty"`
+	// Store picks the message store backend: "sqlite" (default) or "bolt"
+	Store string `json:"store,omitempty"`
+	// EncryptStore encrypts the store with a passphrase asked on startup
+	EncryptStore bool `json:"encrypt_store,omitempty"`
+	// Keychain keeps session tokens, private keys and the store
+	// passphrase in the OS keychain, see keychain.go
+	Keychain bool `json:"keychain,omitempty"`
+	// SignMessages signs what we send, see signing.go
+	SignMessages bool `json:"sign_messages,omitempty"`
+	// Logging writes plain-text logs of chosen buffers
+	Logging logSettings `json:"logging,omitempty"`
+	// Retention bounds the store, pruned in the background
+	Retention retention `json:"retention,omitempty"`
+	// DesktopNotify pops up mentions and DMs, see desktop.go, through
+	// DesktopVia, see termnotify.go
+	DesktopNotify bool   `json:"desktop_notify"`
+	DesktopVia    string `json:"desktop_via,omitempty"`
+	// DND holds back sounds and notifications, until DNDUntil unless
+	// zero, see dnd.go
+	DND      bool      `json:"dnd,omitempty"`
+	DNDUntil time.Time `json:"dnd_until,omitzero"`
+	// NotifyMode is how far sounds and notifications go, see notify.go
+	NotifyMode notifyMode `json:"notify_mode,omitempty"`
+	// AutoAway marks us away after AutoAwayMinutes without input, or
+	// defaultAutoAway if 0, see presence.go; AutoAwaySystem counts input
+	// anywhere on the desktop, see idle.go
+	AutoAway        bool `json:"auto_away"`
+	AutoAwayMinutes int  `json:"auto_away_minutes,omitempty"`
+	AutoAwaySystem  bool `json:"auto_away_system,omitempty"`
+	// StartupSummary shows what is unread on starting up, see summary.go
+	StartupSummary bool `json:"startup_summary"`
+	// Synced is when each synced value last changed, see syncedValues
+	Synced map[string]time.Time `json:"synced,omitempty"`
+}
+
+type layoutSettings struct {
+	LeftWidth  int     `json:"left_width"`  // left sidebar content width
+	RightWidth int     `json:"right_width"` // member list content width
+	SplitRatio float64 `json:"split_ratio"` // share of the main area given to the first pane
+}
+
+func defaultSettings() settings {
+	return settings{
+		Layout: layoutSettings{
+			LeftWidth:  20,
+			RightWidth: 20,
+			SplitRatio: 0.5,
+		},
+		DesktopNotify:  true,
+		AutoAway:       true,
+		StartupSummary: true,
+	}
+}
+
+func settingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gochat", "settings.json"), nil
+}
+
+// loadSettings reads the settings file, falling back to defaults for a
+// missing file or missing fields.
+func loadSettings() (settings, error) {
+	s := defaultSettings()
+	path, err := settingsPath()
+	if err != nil {
+		return s, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return defaultSettings(), err
+	}
+	s.Layout.clamp()
+	return s, nil
+}
+
+func saveSettings(s settings) error {
+	path, err := settingsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first so a crash can't leave a truncated file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// errMsg reports a failure from a background command.
+type errMsg struct{ err error }
+
+func saveSettingsCmd(s settings) tea.Cmd {
+	return func() tea.Msg {
+		if err := saveSettings(s); err != nil {
+			return errMsg{err}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zalando/go-keyring"
+)
+
+// With no config file and no servers to go to, the client starts in a
+// setup wizard rather than an empty UI: the server's address, the nick,
+// where the password comes from and the theme, a step at a time, which it
+// writes to config.toml and then connects with. Esc goes back a step, or
+// on the first skips setting up. The password itself never goes in the
+// file: it is asked for at login (and the session kept from then on), read
+// from a variable or a command, or filed in the OS keychain.
+
+// setupDoneMsg says the wizard wrote the config file at path, or was
+// skipped if there is none.
+type setupDoneMsg struct{ path string }
+
+const (
+	setupStepServer = iota
+	setupStepNick
+	setupStepPassword
+	setupStepTheme
+	setupStepReview
+)
+
+// passwordSources are where the password can come from, as the wizard
+// offers them.
+var passwordSources = []string{"ask", "env", "command", "keychain"}
+
+// setupWizard is the first-run setup, in place of the chat until it is
+// done or skipped.
+type setupWizard struct {
+	step   int
+	field  int // within the step
+	addr   textinput.Model
+	tls    bool
+	nick   textinput.Model
+	source int             // into passwordSources
+	detail textinput.Model // the variable, command or password for source
+	themes []string        // the built-in palettes
+	theme  int             // into themes
+	shown  theme           // what to go back to on skipping
+	err    string
+}
+
+func newSetupWizard(nick string) *setupWizard {
+	addr := newTextInput()
+	addr.Prompt = ""
+	addr.Placeholder = "chat.example.com:6697"
+
+	n := newTextInput()
+	n.Prompt = ""
+	n.Placeholder = "nick"
+	n.CharLimit = 32
+	n.SetValue(nick)
+
+	w := &setupWizard{addr: addr, nick: n, detail: newTextInput(), shown: palette}
+	w.detail.Prompt = ""
+	w.themes = slices.Sorted(maps.Keys(builtinThemes))
+	w.theme = slices.Index(w.themes, "default")
+	w.focusField(0)
+	return w
+}
+
+// fields is how many fields the step has.
+func (w *setupWizard) fields() int {
+	switch {
+	case w.step == setupStepServer:
+		return 2
+	case w.step == setupStepPassword && passwordSources[w.source] != "ask":
+		return 2
+	}
+	return 1
+}
+
+func (w *setupWizard) focusField(i int) {
+	w.field = (i + w.fields()) % w.fields()
+	w.addr.Blur()
+	w.nick.Blur()
+	w.detail.Blur()
+	switch {
+	case w.step == setupStepServer && w.field == 0:
+		w.addr.Focus()
+	case w.step == setupStepNick:
+		w.nick.Focus()
+	case w.step == setupStepPassword && w.field == 1:
+		w.detail.Focus()
+	}
+}
+
+// choosing is whether the focus is on a choice of several, changed with
+// ←/→.
+func (w *setupWizard) choosing() bool {
+	return w.step == setupStepTheme || w.step == setupStepPassword && w.field == 0
+}
+
+// cycle moves the choice the focus is on by d.
+func (w *setupWizard) cycle(d int) {
+	if w.step == setupStepTheme {
+		w.theme = (w.theme + d + len(w.themes)) % len(w.themes)
+		// Shown straight away, the wizard being drawn in it
+		if t, err := resolveTheme(themeConfig{Palette: w.themes[w.theme]}, nil); err == nil {
+			setTheme(t)
+		}
+		return
+	}
+	w.source = (w.source + d + len(passwordSources)) % len(passwordSources)
+	w.detail.Reset()
+	w.detail.EchoMode = textinput.EchoNormal
+	switch passwordSources[w.source] {
+	case "env":
+		w.detail.Placeholder = "CHAT_PASSWORD"
+	case "command":
+		w.detail.Placeholder = "pass show chat"
+	case "keychain":
+		w.detail.Placeholder = "password"
+		w.detail.EchoMode = textinput.EchoPassword
+		w.detail.EchoCharacter = '•'
+	}
+}
+
+func (w *setupWizard) Update(msg tea.Msg) (*setupWizard, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return w, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		w.err = ""
+		if w.step == setupStepServer {
+			setTheme(w.shown)
+			return nil, func() tea.Msg { return setupDoneMsg{} }
+		}
+		w.step--
+		w.focusField(0)
+		return w, nil
+	case key.Matches(keyMsg, keys.NextField):
+		w.focusField(w.field + 1)
+		return w, nil
+	case key.Matches(keyMsg, keys.PrevField):
+		w.focusField(w.field - 1)
+		return w, nil
+	case w.choosing() && key.Matches(keyMsg, keys.Left):
+		w.cycle(-1)
+		return w, nil
+	case w.choosing() && (key.Matches(keyMsg, keys.Right) || key.Matches(keyMsg, keys.Toggle)):
+		w.cycle(1)
+		return w, nil
+	case w.step == setupStepServer && w.field == 1 && key.Matches(keyMsg, keys.Toggle):
+		w.tls = !w.tls
+		return w, nil
+	case key.Matches(keyMsg, keys.Select):
+		if w.err = w.check(); w.err != "" {
+			return w, nil
+		}
+		if w.step == setupStepReview {
+			path, err := w.save()
+			if err != nil {
+				w.err = err.Error()
+				return w, nil
+			}
+			return nil, func() tea.Msg { return setupDoneMsg{path: path} }
+		}
+		w.step++
+		w.focusField(0)
+		return w, nil
+	}
+
+	var cmd tea.Cmd
+	switch {
+	case w.addr.Focused():
+		w.addr, cmd = w.addr.Update(msg)
+	case w.nick.Focused():
+		w.nick, cmd = w.nick.Update(msg)
+	case w.detail.Focused():
+		w.detail, cmd = w.detail.Update(msg)
+	}
+	return w, cmd
+}
+
+// check is what is wrong with the step's answers, if anything.
+func (w *setupWizard) check() string {
+	switch w.step {
+	case setupStepServer:
+		if _, _, err := net.SplitHostPort(strings.TrimSpace(w.addr.Value())); err != nil {
+			w.focusField(0)
+			return "Give the server as host:port, like chat.example.com:6697"
+		}
+	case setupStepNick:
+		if nick := strings.TrimSpace(w.nick.Value()); nick == "" || strings.ContainsAny(nick, " #@") {
+			return "Nicks can't be empty or contain spaces, # or @"
+		}
+	case setupStepPassword:
+		if passwordSources[w.source] != "ask" && strings.TrimSpace(w.detail.Value()) == "" {
+			w.focusField(1)
+			return "Fill this in, or pick ask"
+		}
+	}
+	return ""
+}
+
+// setupFile is what the wizard writes, in the shape of config.
+type setupFile struct {
+	Nick    string        `toml:"nick"`
+	Servers []setupServer `toml:"servers"`
+	Theme   struct {
+		Palette string `toml:"palette"`
+	} `toml:"theme"`
+}
+
+// setupServer is the server in setupFile, as configServer.
+type setupServer struct {
+	Addr     string `toml:"addr"`
+	TLS      bool   `toml:"tls,omitempty"`
+	Password string `toml:"password,omitempty"`
+}
+
+// setupPath is where the wizard writes the config file.
+func setupPath() (string, error) {
+	dirs := configDirs()
+	if len(dirs) == 0 {
+		return "", errors.New("there is no config directory to write to")
+	}
+	return filepath.Join(dirs[0], "gochat", configNames[0]), nil
+}
+
+// save writes the config file, and the password to the keychain if it
+// goes there, returning the file's path.
+func (w *setupWizard) save() (string, error) {
+	path, err := setupPath()
+	if err != nil {
+		return "", err
+	}
+	s := setupServer{Addr: strings.TrimSpace(w.addr.Value()), TLS: w.tls}
+	switch source := passwordSources[w.source]; source {
+	case "env", "command":
+		s.Password = source + ":" + strings.TrimSpace(w.detail.Value())
+	case "keychain":
+		item := "password " + s.Addr
+		if err := keyring.Set(keychainService, item, w.detail.Value()); err != nil {
+			return "", fmt.Errorf("the OS keychain can't be used: %w", err)
+		}
+		s.Password = keychainRef + item
+	}
+	f := setupFile{Nick: strings.TrimSpace(w.nick.Value()), Servers: []setupServer{s}}
+	f.Theme.Palette = w.themes[w.theme]
+
+	var b bytes.Buffer
+	b.WriteString("# Written by gochat's setup; the README lists what else can go here.\n\n")
+	enc := toml.NewEncoder(&b)
+	enc.Indent = ""
+	if err := enc.Encode(f); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	// Not over one that turned up meanwhile
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := out.Write(b.Bytes()); err != nil {
+		out.Close()
+		return "", err
+	}
+	return path, out.Close()
+}
+
+func (w *setupWizard) View(width, height int) string {
+	cw := min(56, width-4)
+
+	label := func(i int, s string) string {
+		if i == w.field {
+			return overlayPromptStyle.Render("› " + s)
+		}
+		return overlayHintStyle.Render("  " + s)
+	}
+	choice := func(i int, s string) string {
+		return label(i, overlayHintStyle.Render("‹ ")+s+overlayHintStyle.Render(" ›"))
+	}
+	check := "[ ]"
+	if w.tls {
+		check = "[x]"
+	}
+	hint := "enter next • esc back"
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Set up gochat · %d of %d", w.step+1, setupStepReview+1)), ""}
+	switch w.step {
+	case setupStepServer:
+		lines = append(lines,
+			"Which chat server do you use?", "",
+			label(0, "Address"),
+			"  "+w.addr.View(),
+			label(1, "TLS  "+check))
+		hint = "tab field • space toggle • enter next • esc skip setup"
+	case setupStepNick:
+		lines = append(lines, "What do you go by there?", "", label(0, "Nick"), "  "+w.nick.View())
+	case setupStepPassword:
+		lines = append(lines, "Where does your password come from?", "", choice(0, w.sourceLabel()))
+		if w.fields() > 1 {
+			lines = append(lines, label(1, w.detailLabel()), "  "+w.detail.View())
+		}
+		hint = "←/→ choose • tab field • " + hint
+	case setupStepTheme:
+		lines = append(lines, "Which colors?", "", choice(0, w.themes[w.theme]))
+		hint = "←/→ choose • " + hint
+	case setupStepReview:
+		path, _ := setupPath()
+		server := strings.TrimSpace(w.addr.Value())
+		if w.tls {
+			server += ", over TLS"
+		}
+		lines = append(lines,
+			"Server    "+server,
+			"Nick      "+strings.TrimSpace(w.nick.Value()),
+			"Password  "+w.sourceLabel(),
+			"Theme     "+w.themes[w.theme],
+			"",
+			overlayHintStyle.Render("Saved to this file, to change later:"),
+			overlayHintStyle.Width(cw-2).Render(path))
+		hint = "enter save and connect • esc back"
+	}
+	if w.err != "" {
+		lines = append(lines, "", errorTextStyle.Width(cw-2).Render(w.err))
+	}
+	lines = append(lines, "", overlayHintStyle.Render(hint))
+
+	form := overlayStyle.Width(cw).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, form)
+}
+
+// sourceLabel says where the password comes from, in words.
+func (w *setupWizard) sourceLabel() string {
+	detail := strings.TrimSpace(w.detail.Value())
+	switch passwordSources[w.source] {
+	case "env":
+		return "from the variable " + orEllipsis(detail)
+	case "command":
+		return "printed by a command " + orEllipsis(detail)
+	case "keychain":
+		return "kept in the OS keychain"
+	}
+	return "asked for when logging in"
+}
+
+// detailLabel names the field that goes with the password's source.
+func (w *setupWizard) detailLabel() string {
+	switch passwordSources[w.source] {
+	case "env":
+		return "Variable"
+	case "command":
+		return "Command"
+	}
+	return "Password"
+}
+
+// orEllipsis is s, or … while it is empty.
+func orEllipsis(s string) string {
+	if s == "" {
+		return "…"
+	}
+	return s
+}
+
+// finishSetup connects with the config file the wizard wrote at path, and
+// watches it from then on.
+func (m *model) finishSetup(path string) tea.Cmd {
+	if path == "" {
+		m.notice("Not set up: give a server as gochat connect host:port, or write a config file (see the README)")
+		return nil
+	}
+	c, err := readConfig(path)
+	var cmd tea.Cmd
+	if err == nil {
+		m.opts.nick = c.Nick
+		cmd, err = m.useConfig(c, c.Nick)
+	}
+	if err != nil {
+		m.notice("Set up, but can't connect: " + err.Error())
+		return nil
+	}
+	m.configWatch = watchConfig(path)
+	m.notice("Saved your settings to " + path)
+	return tea.Batch(cmd, m.waitForConfig())
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// plural formats a count with a naively pluralized noun ("1 mention",
+// "3 mentions").
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// unreadBadge renders the mention count if there are mentions, else the
+// unread count, or nothing when the buffer is read.
+func unreadBadge(ch *channel) string {
+	switch {
+	case ch.mentions > 0:
+		return mentionBadgeStyle.Render("@" + strconv.Itoa(ch.mentions))
+	case ch.unread > 0:
+		return unreadBadgeStyle.Render(strconv.Itoa(ch.unread))
+	}
+	return ""
+}
+
+// renderSidebarEntry renders one buffer line, with its badge right-aligned.
+func (m *model) renderSidebarEntry(ch *channel, width int) string {
+	badge := unreadBadge(ch)
+	name := ch.name
+	if m.isFavorite(ch.name) {
+		name = "★ " + name
+	}
+	if ch.archived {
+		name += " (archived)"
+	}
+	dot := ""
+	if ch.isDM() {
+		p := presenceOffline
+		if peer := ch.dmPeer(); peer != nil {
+			p = peer.presence
+		}
+		dot = presenceDot(p) + " "
+		name = strings.TrimPrefix(name, "@")
+		if _, changed := m.verifiedState(ch); changed {
+			name = "⚠ " + name
+		} else if m.encrypted(ch) {
+			name = icons.Lock + " " + name
+		}
+		// Leave room for the dot
+		width -= 2
+	}
+	name = truncate(name, width-lipgloss.Width(badge)-1)
+
+	style := sidebarEntryStyle
+	switch {
+	case ch == m.activeChannel():
+		style = sidebarActiveStyle
+	case m.isMuted(ch.name):
+		style = sidebarMutedStyle
+	case ch.mentions > 0 || ch.unread > 0:
+		style = sidebarUnreadStyle
+	}
+	name = style.Render(name)
+
+	gap := width - lipgloss.Width(name) - lipgloss.Width(badge)
+	if gap < 1 {
+		gap = 1
+	}
+	return dot + name + strings.Repeat(" ", gap) + badge
+}
+
+// renderDMEntry renders a DM buffer with a preview of its last message
+// under the name.
+func (m *model) renderDMEntry(ch *channel, width int) string {
+	line := m.renderSidebarEntry(ch, width)
+	last, ok := ch.lastMessage()
+	if !ok {
+		return line
+	}
+	preview := last.text
+	if last.nick == m.nick {
+		preview = "you: " + preview
+	}
+	preview = strings.Join(strings.Fields(preview), " ")
+	return line + "\n" + dmPreviewStyle.Render("  "+truncate(preview, width-2))
+}
+
+// dmsByActivity returns the DM buffers, most recently active first.
+func (m *model) dmsByActivity() []*channel {
+	var dms []*channel
+	for _, ch := range m.channels {
+		if ch.isDM() {
+			dms = append(dms, ch)
+		}
+	}
+	activity := func(ch *channel) time.Time {
+		if last, ok := ch.lastMessage(); ok {
+			return last.time
+		}
+		return ch.lastActive
+	}
+	sort.SliceStable(dms, func(i, j int) bool {
+		return activity(dms[i]).After(activity(dms[j]))
+	})
+	return dms
+}
+
+// sidebarRow records what a rendered sidebar line shows, so mouse events
+// can be mapped back to buffers and category headers.
+type sidebarRow struct {
+	buffer   string
+	category string
+}
+
+// sidebarBuilder accumulates sidebar lines alongside their sidebarRows.
+type sidebarBuilder struct {
+	lines []string
+	rows  []sidebarRow
+}
+
+func (b *sidebarBuilder) add(text string, row sidebarRow) {
+	for _, line := range strings.Split(text, "\n") {
+		b.lines = append(b.lines, line)
+		b.rows = append(b.rows, row)
+	}
+}
+
+func (b *sidebarBuilder) gap() {
+	if len(b.lines) > 0 {
+		b.add("", sidebarRow{})
+	}
+}
+
+// renderChannelList renders the left sidebar: starred buffers, categories,
+// uncategorized channels, then DMs.
+func (m *model) renderChannelList(width, height int) string {
+	var b sidebarBuilder
+	placed := make(map[string]bool)
+
+	var starred []*channel
+	for _, ch := range m.channels {
+		if m.isFavorite(ch.name) {
+			starred = append(starred, ch)
+			placed[ch.name] = true
+		}
+	}
+	if len(starred) > 0 {
+		b.add(memberGroupStyle.Render("STARRED"), sidebarRow{})
+		for _, ch := range starred {
+			b.add(m.renderSidebarEntry(ch, width), sidebarRow{buffer: ch.name})
+		}
+	}
+
+	for _, cat := range m.account().Categories {
+		var members []*channel
+		unread, mentions := 0, 0
+		for _, ch := range m.channels {
+			if !placed[ch.name] && slices.Contains(cat.Channels, ch.name) {
+				members = append(members, ch)
+				placed[ch.name] = true
+				unread += ch.unread
+				mentions += ch.mentions
+			}
+		}
+		b.gap()
+		b.add(m.renderCategoryHeader(cat, unread, mentions, width), sidebarRow{category: cat.Name})
+		if cat.Collapsed {
+			continue
+		}
+		for _, ch := range members {
+			b.add(m.renderSidebarEntry(ch, width), sidebarRow{buffer: ch.name})
+		}
+	}
+
+	b.gap()
+	b.add(memberGroupStyle.Render("CHANNELS"), sidebarRow{})
+	for _, ch := range m.channels {
+		// Archived channels only show up while they're being browsed
+		if ch.archived && ch != m.activeChannel() {
+			continue
+		}
+		if !placed[ch.name] && !ch.isDM() {
+			b.add(m.renderSidebarEntry(ch, width), sidebarRow{buffer: ch.name})
+		}
+	}
+
+	var dms []*channel
+	for _, ch := range m.dmsByActivity() {
+		if !placed[ch.name] {
+			dms = append(dms, ch)
+		}
+	}
+	if len(dms) > 0 {
+		b.gap()
+		b.add(memberGroupStyle.Render("DIRECT MESSAGES"), sidebarRow{})
+		for _, ch := range dms {
+			b.add(m.renderDMEntry(ch, width), sidebarRow{buffer: ch.name})
+		}
+	}
+
+	if height > 0 && len(b.lines) > height {
+		b.lines = b.lines[:height]
+		b.rows = b.rows[:height]
+	}
+	m.sidebarRows = b.rows
+	return lipgloss.NewStyle().Width(width).Render(strings.Join(b.lines, "\n"))
+}
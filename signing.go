@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// With "sign_messages" set, messages and edits are signed with Ed25519,
+// for servers that are trusted to pass them on but not to speak for
+// anyone, such as one bridging other networks. The client makes a signing
+// key per server and nick, keeps it in the local store and publishes the
+// public half along with its encryption key (see e2ee.go). It signs the
+// conversation, ID, nick and text of what it sends, the sealed text for a
+// DM. The server keeps the signature with the message, an edit's in place
+// of the one it replaces, and passes it on:
+//
+//	sig: <base64 of public key | signature>
+//
+// A nick's signing key is pinned the first time it is seen. One the server
+// says they have now replaces it with a notice, and the earlier ones stay
+// trusted for what they signed. A message gets a ⚠ after the nick when its
+// signature doesn't check out, it is signed with a key its sender was never
+// seen with, or it isn't signed though its sender was seen signing before
+// it was sent. Reply targets, times and reactions aren't signed.
+
+// signatureLength is a public key followed by a signature, decoded.
+const signatureLength = ed25519.PublicKeySize + ed25519.SignatureSize
+
+var errBadSignature = errors.New("signatures are a 32 byte key and a 64 byte signature, base64")
+
+// --- Server side ---
+
+// validSignature is whether sig could be a signature, or is empty. Only
+// clients check them.
+func validSignature(sig string) bool {
+	if sig == "" {
+		return true
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	return err == nil && len(raw) == signatureLength
+}
+
+// --- Client side ---
+
+// signState is what a network signs and checks messages with.
+type signState struct {
+	own   map[string][]ed25519.PrivateKey // ours, by nick, newest last
+	peers map[string]*[32]byte            // pinned, by nick, ours too
+	// trusted are every key a nick has been pinned to, to whose nick, and
+	// since when each nick was first seen signing
+	trusted map[[32]byte]string
+	since   map[string]time.Time
+	// asked are the keys looked up on this connection, lookups the nicks
+	// still to be
+	asked   map[[32]byte]bool
+	lookups []string
+}
+
+func newSignState() *signState {
+	return &signState{
+		own:     make(map[string][]ed25519.PrivateKey),
+		peers:   make(map[string]*[32]byte),
+		trusted: make(map[[32]byte]string),
+		since:   make(map[string]time.Time),
+		asked:   make(map[[32]byte]bool),
+	}
+}
+
+// trust pins key as nick's, seen signing at seen.
+func (s *signState) trust(nick string, key *[32]byte, seen time.Time) {
+	s.peers[nick] = key
+	s.trusted[*key] = nick
+	if first, ok := s.since[nick]; !ok || seen.Before(first) {
+		s.since[nick] = seen
+	}
+}
+
+// loadSigningKeys picks up the stored signing keys of every network.
+func (m *model) loadSigningKeys() {
+	for _, n := range m.networks {
+		n.signing = newSignState()
+		if m.store == nil {
+			continue
+		}
+		keys, err := m.store.loadSigningKeys(n.addr)
+		if err != nil {
+			m.lastErr = err
+			continue
+		}
+		for _, k := range keys {
+			public, ok := parseKey(k.public)
+			if !ok {
+				continue
+			}
+			if k.private != "" {
+				seed, ok := parseKey(k.private)
+				if !ok {
+					continue
+				}
+				n.signing.own[k.nick] = append(n.signing.own[k.nick], ed25519.NewKeyFromSeed(seed[:]))
+			}
+			n.signing.trust(k.nick, public, k.added)
+		}
+	}
+}
+
+// signing is the signing state of the network the model has the state
+// of, nil when there is none.
+func (m *model) signing() *signState {
+	if m.client != nil && m.client.network != nil {
+		return m.client.network.signing
+	}
+	if n := m.currentNetwork(); n != nil && !m.background {
+		return n.signing
+	}
+	return nil
+}
+
+// saveSigningKey keeps a signing key of the network of c in the store.
+func (m *model) saveSigningKey(c *client, k storedKey) {
+	if m.store == nil || c.network == nil {
+		return
+	}
+	if err := m.store.saveSigningKey(c.network.addr, k); err != nil {
+		m.lastErr = err
+	}
+}
+
+// signingKey is the public key to publish on the network of c as we
+// connect, made if we have none yet, or empty if we don't sign.
+func (m *model) signingKey(c *client) string {
+	s := m.signing()
+	if s == nil {
+		return ""
+	}
+	clear(s.asked)
+	if !m.settings.SignMessages {
+		return ""
+	}
+	if len(s.own[m.nick]) == 0 {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			m.lastErr = err
+			return ""
+		}
+		now := time.Now()
+		s.own[m.nick] = append(s.own[m.nick], private)
+		s.trust(m.nick, (*[32]byte)(public), now)
+		m.saveSigningKey(c, storedKey{nick: m.nick, public: base64.StdEncoding.EncodeToString(public),
+			private: base64.StdEncoding.EncodeToString(private.Seed()), added: now})
+	}
+	own := s.own[m.nick]
+	return base64.StdEncoding.EncodeToString(own[len(own)-1].Public().(ed25519.PublicKey))
+}
+
+// signedBytes is what a signature is over. A DM goes by its dmKey, the
+// same on both sides.
+func (m *model) signedBytes(buffer, id, nick, text string) []byte {
+	conv := buffer
+	if peer, ok := strings.CutPrefix(buffer, "@"); ok {
+		conv = dmKey(m.nick, peer)
+	}
+	out := []byte("gochat message signature\x00")
+	for _, field := range []string{conv, id, nick, text} {
+		out = binary.AppendUvarint(out, uint64(len(field)))
+		out = append(out, field...)
+	}
+	return out
+}
+
+// sign is the signature of text sent to buffer as message id, empty unless
+// we sign.
+func (m *model) sign(buffer, id, text string) string {
+	s := m.signing()
+	if s == nil || !m.settings.SignMessages || len(s.own[m.nick]) == 0 {
+		return ""
+	}
+	own := s.own[m.nick]
+	key := own[len(own)-1]
+	// The server trims what it is sent
+	sig := ed25519.Sign(key, m.signedBytes(buffer, id, m.nick, strings.TrimSpace(text)))
+	return base64.StdEncoding.EncodeToString(append([]byte(key.Public().(ed25519.PublicKey)), sig...))
+}
+
+// checkSignature reports whether a message or edit from nick, sent at
+// sent, is unverified. A key a nick without one signs with is pinned, and
+// one a nick was never seen with is looked up, see lookupSigners.
+func (m *model) checkSignature(buffer, id, nick, text, sig string, sent time.Time) bool {
+	s := m.signing()
+	if s == nil || nick == "" {
+		return false
+	}
+	if sig == "" {
+		first, signs := s.since[nick]
+		return signs && sent.After(first)
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || len(raw) != signatureLength {
+		return true
+	}
+	key := (*[32]byte)(raw[:32])
+	if !ed25519.Verify(key[:], m.signedBytes(buffer, id, nick, text), raw[32:]) {
+		return true
+	}
+	switch owner := s.trusted[*key]; {
+	case owner == nick:
+		return false
+	case owner == "" && s.peers[nick] == nil:
+		m.pinSigner(nick, key, sent, false)
+		return false
+	}
+	if !s.asked[*key] {
+		s.asked[*key] = true
+		s.lookups = append(s.lookups, nick)
+	}
+	return true
+}
+
+// lookupSigners asks the server for the keys of the nicks that signed
+// with one that wasn't trusted.
+func (m *model) lookupSigners() tea.Cmd {
+	s := m.signing()
+	if s == nil || m.client == nil || len(s.lookups) == 0 {
+		return nil
+	}
+	var cmds []tea.Cmd
+	asked := make(map[string]bool)
+	for _, nick := range s.lookups {
+		if !asked[nick] {
+			asked[nick] = true
+			_, cmd := m.client.send(frameGetKey, e2eKeyData{Nick: nick})
+			cmds = append(cmds, cmd)
+		}
+	}
+	s.lookups = nil
+	return tea.Batch(cmds...)
+}
+
+// applySigningKey pins the signing key the server says a nick has now.
+func (m *model) applySigningKey(k e2eKeyData) {
+	if key, ok := parseKey(k.Signing); ok && m.signing() != nil {
+		m.pinSigner(k.Nick, key, time.Now(), true)
+	}
+}
+
+// pinSigner trusts key as one of nick's signing keys, with a notice if
+// they had another. With current set it is the key they have now, even if
+// it was trusted before.
+func (m *model) pinSigner(nick string, key *[32]byte, seen time.Time, current bool) {
+	s := m.signing()
+	owner := s.trusted[*key]
+	if owner != "" && owner != nick {
+		// Someone else's
+		return
+	}
+	known := owner == nick
+	if known && (!current || *s.peers[nick] == *key) {
+		return
+	}
+	old := s.peers[nick]
+	s.trust(nick, key, seen)
+	if m.client != nil {
+		m.saveSigningKey(m.client, storedKey{nick: nick, public: encodeKey(key), added: seen})
+	}
+	if old == nil || known {
+		return
+	}
+	notice := "⚠ " + nick + "'s signing key changed. They may have a new device, or someone may be signing for them."
+	if dm := m.channelByName("@" + nick); dm != nil {
+		m.noticeIn(dm, notice)
+	} else {
+		m.notice(notice)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Slow mode lets each member post one message every so many seconds in a
+// channel, to calm a busy one down:
+//
+//	/slowmode 30     /slowmode off
+//
+// Moderators set it and, like everyone whose role may moderate there, are
+// exempt from it. The server turns away messages that come too soon; it
+// only remembers when each member last posted in memory, so a restart lets
+// everyone post once more. The client waits along: after posting it counts
+// down in the composer's border and holds on to what is typed meanwhile.
+
+// maxSlowMode is the longest wait slow mode can set.
+const maxSlowMode = 6 * time.Hour
+
+// slowModeError is a message sent before slow mode allows the next one.
+type slowModeError struct {
+	wait time.Duration
+}
+
+func (e *slowModeError) Error() string {
+	return fmt.Sprintf("slow mode is on, you can post again in %s", e.wait.Round(time.Second))
+}
+
+// --- Server side ---
+
+// handleSlowMode turns a channel's slow mode on, with the wait in seconds,
+// or off.
+func (srv *server) handleSlowMode(s *session, f frame) error {
+	var req slowModeData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	// Checked before converting, so a huge number can't wrap into range
+	if req.Seconds < 0 || req.Seconds > int(maxSlowMode/time.Second) {
+		return fmt.Errorf("slow mode waits at most %s", maxSlowMode)
+	}
+	wait := time.Duration(req.Seconds) * time.Second
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permModerate) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	next := *ch
+	next.slowMode = wait
+	if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(&next) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	ch.slowMode = wait
+	srv.mu.Unlock()
+
+	req.Nick = s.nick
+	srv.broadcast(req.Channel, newFrame(frameSlowModeSet, req))
+	return nil
+}
+
+// slowModeLocked is why s can't post in ch at now yet, if slow mode holds
+// them back.
+func (srv *server) slowModeLocked(s *session, ch *serverChannel, now time.Time) error {
+	if ch.slowMode <= 0 || srv.canLocked(ch, s.nick, permModerate) {
+		return nil
+	}
+	if wait := ch.lastPost[s.nick].Add(ch.slowMode).Sub(now); wait > 0 {
+		return &slowModeError{wait: wait}
+	}
+	return nil
+}
+
+// postedLocked records nick posting in ch at t, for slow mode.
+func (ch *serverChannel) postedLocked(nick string, t time.Time) {
+	if ch.slowMode <= 0 {
+		return
+	}
+	if ch.lastPost == nil {
+		ch.lastPost = make(map[string]time.Time)
+	}
+	ch.lastPost[nick] = t
+}
+
+// --- Client side ---
+
+// slowModeTickMsg redraws the slow mode countdown.
+type slowModeTickMsg struct{}
+
+// slowModeTick redraws the countdown, wait long, in a second, or once it
+// is over when motion is reduced.
+func slowModeTick(wait time.Duration) tea.Cmd {
+	if !reduceMotion {
+		wait = min(wait, time.Second)
+	}
+	return tea.Tick(wait, func(time.Time) tea.Msg { return slowModeTickMsg{} })
+}
+
+// slowWait is how long slow mode has us wait before posting in ch again.
+func (m *model) slowWait(ch *channel) time.Duration {
+	if ch == nil || ch.slowMode <= 0 || m.can(ch, permModerate) {
+		return 0
+	}
+	return max(time.Until(ch.lastPost.Add(ch.slowMode)), 0)
+}
+
+// postedIn starts the slow mode countdown in ch, if it has slow mode.
+func (m *model) postedIn(ch *channel) tea.Cmd {
+	if ch.slowMode <= 0 || m.can(ch, permModerate) {
+		return nil
+	}
+	ch.lastPost = time.Now()
+	return slowModeTick(ch.slowMode)
+}
+
+func cmdSlowMode(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Slow mode is for channels")
+		return nil
+	}
+	args = strings.TrimSuffix(strings.TrimSpace(args), "s")
+	if args == "" {
+		if ch.slowMode > 0 {
+			m.notice(fmt.Sprintf("Slow mode is on in %s: one message every %s", ch.name, ch.slowMode))
+		} else {
+			m.notice("Slow mode is off in " + ch.name)
+		}
+		return nil
+	}
+	secs := 0
+	if args != "off" && args != "0" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			m.notice("Usage: /slowmode <seconds|off>")
+			return nil
+		}
+		secs = n
+	}
+	if !m.allowed(ch, permModerate, "set slow mode") {
+		return nil
+	}
+	return m.request(frameSlowMode, slowModeData{Channel: ch.name, Seconds: secs})
+}
+
+// applySlowMode records ch's slow mode changing.
+func (m *model) applySlowMode(ch *channel, d slowModeData) {
+	ch.slowMode = time.Duration(d.Seconds) * time.Second
+	if d.Seconds == 0 {
+		m.noticeIn(ch, d.Nick+" turned slow mode off")
+		return
+	}
+	m.noticeIn(ch, fmt.Sprintf("%s turned slow mode on: one message every %s", d.Nick, ch.slowMode))
+}
+
+// labelBorder writes label into the top border of a box rendered with
+// border in color.
+func labelBorder(box, label string, border lipgloss.Border, color lipgloss.Color) string {
+	top, rest, ok := strings.Cut(box, "\n")
+	if !ok {
+		return box
+	}
+	text := border.Top + " " + label + " "
+	fill := lipgloss.Width(top) - 2 - lipgloss.Width(text)
+	if fill < 0 {
+		return box
+	}
+	top = lipgloss.NewStyle().Foreground(color).
+		Render(border.TopLeft + text + strings.Repeat(border.Top, fill) + border.TopRight)
+	return top + "\n" + rest
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// [sounds] in the config file says what an incoming message sounds like,
+// by what it is: a mention of us, a DM, or any other message in a buffer
+// that counts it as unread. Each is "bell" for the terminal bell, "none"
+// for silence, or a sound file, which player plays: the command, with
+// {file} standing for the file or else the file after it, by default
+// afplay on macOS and the first of paplay, pw-play and aplay found
+// elsewhere. Unset is silence too, except that DMs, being addressed to
+// us, go by mention then.
+//
+//	[sounds]
+//	mention = "bell"
+//	dm = "~/sounds/knock.wav"
+//	player = "mpv --really-quiet"
+//
+// Buffers muted or notifying of nothing stay silent, and a burst of
+// messages, as after reconnecting, sounds only once.
+
+// soundGap is how long after a sound another one waits.
+const soundGap = time.Second
+
+// soundConfig is [sounds] in the config file.
+type soundConfig struct {
+	Mention string `toml:"mention" yaml:"mention"`
+	DM      string `toml:"dm" yaml:"dm"`
+	Message string `toml:"message" yaml:"message"`
+	Player  string `toml:"player" yaml:"player"` // plays a sound file, see soundPlayer
+}
+
+// check reports a sound file that isn't there, or a player that can't be
+// found for one.
+func (s soundConfig) check() error {
+	files := false
+	for _, e := range []struct{ name, sound string }{{"mention", s.Mention}, {"dm", s.DM}, {"message", s.Message}} {
+		switch e.sound {
+		case "", "none", "bell":
+			continue
+		}
+		if _, err := os.Stat(expandHome(e.sound)); err != nil {
+			return fmt.Errorf("sounds: %s is bell, none or a sound file: %w", e.name, err)
+		}
+		files = true
+	}
+	if _, err := soundPlayer(s.Player); files && err != nil {
+		return fmt.Errorf("sounds: %w", err)
+	}
+	return nil
+}
+
+// forAlert is the sound for a message in ch that a calls for.
+func (s soundConfig) forAlert(ch *channel, a alert) string {
+	switch {
+	case ch.isDM() && a.unread:
+		return cmp.Or(s.DM, s.Mention)
+	case a.mention:
+		return s.Mention
+	case a.unread:
+		return s.Message
+	}
+	return ""
+}
+
+// expandHome puts the home directory in place of a leading ~/.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// soundPlayer is the command playing a sound file, player or else one
+// found.
+func soundPlayer(player string) ([]string, error) {
+	if player != "" {
+		return strings.Fields(player), nil
+	}
+	if runtime.GOOS == "darwin" {
+		return []string{"afplay"}, nil
+	}
+	for _, name := range []string{"paplay", "pw-play", "aplay"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return []string{name}, nil
+		}
+	}
+	return nil, errors.New("no player found for sound files (paplay, pw-play or aplay), set player")
+}
+
+// soundFor queues the sound a message in ch that a calls for, to play
+// once the message is handled, unless one just played.
+func (m *model) soundFor(ch *channel, a alert) {
+	sound := cmp.Or(a.sound, m.opts.sounds.forAlert(ch, a))
+	if sound == "" || sound == "none" || time.Since(m.lastSound) < soundGap || !m.notifies(ch, a) {
+		return
+	}
+	m.sound, m.lastSound = sound, time.Now()
+}
+
+// playSound plays the sound queued, if any.
+func (m *model) playSound() tea.Cmd {
+	sound := m.sound
+	m.sound = ""
+	switch sound {
+	case "":
+		return nil
+	case "bell":
+		return writeTerminal("\a")
+	}
+	player, err := soundPlayer(m.opts.sounds.Player)
+	if err != nil {
+		return func() tea.Msg { return errMsg{err} }
+	}
+	file := expandHome(sound)
+	args, replaced := make([]string, 0, len(player)+1), false
+	for _, arg := range player[1:] {
+		if strings.Contains(arg, "{file}") {
+			arg, replaced = strings.ReplaceAll(arg, "{file}", file), true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, file)
+	}
+	return func() tea.Msg {
+		if out, err := exec.Command(player[0], args...).CombinedOutput(); err != nil {
+			return errMsg{fmt.Errorf("playing %s: %w %s", sound, err, strings.TrimSpace(string(out)))}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// starredMessage is a message saved to find again later, from any buffer
+// and (with sync) any device.
+type starredMessage struct {
+	Network string    `json:"network"`
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	Nick    string    `json:"nick"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+}
+
+func (m *model) starIndex(network, channel, id string) int {
+	return slices.IndexFunc(m.account().Starred, func(s starredMessage) bool {
+		return s.Network == network && s.Channel == channel && s.ID == id
+	})
+}
+
+// cmdStar stars the selected or latest message, or unstars it.
+func cmdStar(m *model, _ string) tea.Cmd {
+	ch, msg := m.targetMessage()
+	if ch == nil || msg == nil || msg.id == "" {
+		m.notice("Nothing to star")
+		return nil
+	}
+	acct := m.account()
+	network := m.storeNetwork()
+	if i := m.starIndex(network, ch.name, msg.id); i >= 0 {
+		acct.Starred = slices.Delete(acct.Starred, i, i+1)
+		m.notice("Unstarred the message")
+	} else {
+		acct.Starred = append(acct.Starred, starredMessage{
+			Network: network,
+			Channel: ch.name,
+			ID:      msg.id,
+			Nick:    msg.nick,
+			Text:    msg.text,
+			Time:    msg.time,
+		})
+		m.notice("Starred the message, /starred lists them")
+	}
+	return saveSettingsCmd(m.settings)
+}
+
+// cmdStarred lists the starred messages, newest first, to jump to.
+func cmdStarred(m *model, _ string) tea.Cmd {
+	starred := m.account().Starred
+	if len(starred) == 0 {
+		m.notice("No starred messages, /star one first")
+		return nil
+	}
+	hits := make([]searchHit, 0, len(starred))
+	for i := len(starred) - 1; i >= 0; i-- {
+		s := starred[i]
+		hits = append(hits, searchHit{network: s.Network, channel: s.Channel, id: s.ID, nick: s.Nick, text: s.Text, time: s.Time})
+	}
+	m.overlay = newSearchResults(m, "Starred messages", hits)
+	return nil
+}
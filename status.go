@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Anyone can say what they are up to with a status, an emoji and a few
+// words, shown after their nick in member lists and by /whois:
+//
+//	/status 🍕 lunch until 1pm +1h
+//
+// A leading emoji is the status's own, and a trailing +duration clears it
+// by itself once that is over; /status clear does it sooner. The server
+// keeps statuses and tells whoever shares a channel or a DM about each
+// change. Running out needs no telling, everyone just stops showing it.
+
+const (
+	// maxStatusText is how long a status's text can be, in runes.
+	maxStatusText = 100
+	// maxStatusEmoji is how long its emoji can be, in bytes, enough for
+	// a family or a flag of ZWJ sequences.
+	maxStatusEmoji = 32
+)
+
+var errBadStatus = fmt.Errorf("a status is an emoji and up to %d characters of text, that hasn't run out", maxStatusText)
+
+// userStatus is what someone says they are up to, until Until unless
+// zero.
+type userStatus struct {
+	Emoji string    `json:"emoji,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Until time.Time `json:"until,omitzero"`
+}
+
+// active reports whether st is set and hasn't run out at now.
+func (st userStatus) active(now time.Time) bool {
+	return (st.Emoji != "" || st.Text != "") && (st.Until.IsZero() || now.Before(st.Until))
+}
+
+// label is st as shown, the emoji first.
+func (st userStatus) label() string {
+	return strings.TrimSpace(st.Emoji + " " + st.Text)
+}
+
+// isEmoji reports whether s is one emoji or a few: symbols, with the
+// modifiers, joiners and variation selectors that go with them.
+func isEmoji(s string) bool {
+	symbol := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbol = true
+		case unicode.In(r, unicode.Sk, unicode.Mn, unicode.Me, unicode.Cf):
+		default:
+			return false
+		}
+	}
+	return symbol && len(s) <= maxStatusEmoji
+}
+
+// --- Server side ---
+
+// handleSetStatus sets or clears the caller's status and tells those
+// watching them.
+func (srv *server) handleSetStatus(s *session, f frame) error {
+	var req userStatus
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	req.Text = strings.TrimSpace(strings.Join(strings.Fields(req.Text), " "))
+	if req.Emoji != "" && !isEmoji(req.Emoji) || len([]rune(req.Text)) > maxStatusText ||
+		!req.Until.IsZero() && !req.Until.After(s.stamp.Time) {
+		return errBadStatus
+	}
+	if !req.active(s.stamp.Time) {
+		req = userStatus{}
+	}
+
+	srv.mu.Lock()
+	if srv.statuses[s.nick] == req {
+		srv.mu.Unlock()
+		return nil
+	}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveStatus(s.nick, req) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	if req == (userStatus{}) {
+		delete(srv.statuses, s.nick)
+	} else {
+		srv.statuses[s.nick] = req
+	}
+	targets := srv.watchersLocked(s.nick)
+	srv.mu.Unlock()
+
+	out := newFrame(frameStatus, statusData{Nick: s.nick, userStatus: req})
+	for _, sess := range targets {
+		sess.conn.write(out)
+	}
+	return nil
+}
+
+// statusOfLocked is nick's status, if it hasn't run out.
+func (srv *server) statusOfLocked(nick string) userStatus {
+	if st := srv.statuses[nick]; st.active(time.Now()) {
+		return st
+	}
+	return userStatus{}
+}
+
+// --- Client side ---
+
+type statusMsg struct {
+	nick   string
+	status userStatus
+}
+
+// parseStatus reads what /status was given: an emoji first, if there is
+// one, and a +duration last.
+func parseStatus(args string, now time.Time) (userStatus, bool) {
+	var st userStatus
+	fields := strings.Fields(args)
+	if n := len(fields); n > 0 && strings.HasPrefix(fields[n-1], "+") {
+		d, ok := parseModDuration(fields[n-1][1:])
+		if !ok {
+			return st, false
+		}
+		st.Until, fields = now.Add(d), fields[:n-1]
+	}
+	if len(fields) > 0 && isEmoji(fields[0]) {
+		st.Emoji, fields = fields[0], fields[1:]
+	}
+	st.Text = strings.Join(fields, " ")
+	return st, st.active(now) && len([]rune(st.Text)) <= maxStatusText
+}
+
+// describeStatus is st for a notice, with when it runs out.
+func describeStatus(st userStatus) string {
+	if st.Until.IsZero() {
+		return st.label()
+	}
+	return st.label() + " (until " + clockLabel(st.Until) + ")"
+}
+
+// knownMember is what we know of nick from the buffers they are in.
+func (m *model) knownMember(nick string) *member {
+	for _, ch := range m.channels {
+		if mem, ok := ch.members[nick]; ok {
+			return mem
+		}
+	}
+	return nil
+}
+
+// renderStatus is what of mem's status fits in width cells after their
+// nick in the member list, dimmed.
+func renderStatus(mem *member, width int) string {
+	if width < 2 || !mem.status.active(time.Now()) {
+		return ""
+	}
+	return " " + timestampStyle.Render(truncate(mem.status.label(), width-1))
+}
+
+func cmdStatus(m *model, args string) tea.Cmd {
+	args = strings.TrimSpace(args)
+	switch args {
+	case "":
+		if me := m.knownMember(m.nick); me != nil && me.status.active(time.Now()) {
+			m.notice("Your status: " + describeStatus(me.status) + ", /status clear clears it")
+		} else {
+			m.notice("You have no status, /status 🍕 lunch +1h sets one")
+		}
+		return nil
+	case "clear":
+		m.notice("Status cleared")
+		return m.request(frameSetStatus, userStatus{})
+	}
+	st, ok := parseStatus(args, time.Now())
+	if !ok {
+		m.notice(fmt.Sprintf("Usage: /status [emoji] [text] [+duration] or /status clear, the text up to %d characters", maxStatusText))
+		return nil
+	}
+	m.notice("Status set: " + describeStatus(st))
+	return m.request(frameSetStatus, st)
+}
+
+func cmdWhois(m *model, args string) tea.Cmd {
+	nick := strings.TrimPrefix(strings.TrimSpace(args), "@")
+	if nick == "" {
+		m.notice("Usage: /whois <nick>")
+		return nil
+	}
+	mem := m.knownMember(nick)
+	if mem == nil {
+		m.notice("You don't share a channel or a DM with " + nick)
+		return nil
+	}
+	parts := []string{nick + " is " + strings.ToLower(mem.presence.String())}
+	if mem.status.active(time.Now()) {
+		parts = append(parts, describeStatus(mem.status))
+	}
+	var in []string
+	for _, ch := range m.channels {
+		them, ok := ch.members[nick]
+		if !ok || ch.isDM() {
+			continue
+		}
+		name := ch.name
+		if them.role != roleMember {
+			name += " (" + strings.ToLower(roleLabel(them.role)) + ")"
+		}
+		in = append(in, name)
+	}
+	slices.Sort(in)
+	if len(in) > 0 {
+		parts = append(parts, "in "+strings.Join(in, ", "))
+	}
+	m.notice(strings.Join(parts, " · "))
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// pingInterval is how often the client measures lag to the server.
+const pingInterval = 15 * time.Second
+
+// pingTickMsg is due for the connection c. Ticks for a connection that has
+// since gone away are dropped, which ends their chain.
+type pingTickMsg struct{ c *client }
+
+// ping sends a ping now and schedules the next one.
+func (m *model) ping() tea.Cmd {
+	if m.client == nil {
+		return nil
+	}
+	c := m.client
+	_, cmd := c.send(framePing, pingData{Sent: time.Now()})
+	return tea.Batch(cmd, tea.Tick(pingInterval, func(time.Time) tea.Msg { return pingTickMsg{c: c} }))
+}
+
+// handlePong records the round trip of a ping as the current lag.
+func (m *model) handlePong(f frame) {
+	var p pingData
+	if err := f.decode(&p); err != nil || p.Sent.IsZero() {
+		return
+	}
+	m.lag = time.Since(p.Sent)
+}
+
+func (f focusArea) mode() string {
+	switch f {
+	case focusBuffer:
+		return "BROWSE"
+	case focusSearch:
+		return "SEARCH"
+	default:
+		return "COMPOSE"
+	}
+}
+
+// connectionLabel describes the shown network's connection.
+func (m *model) connectionLabel() string {
+	n := m.currentNetwork()
+	switch {
+	case m.client != nil:
+		return "● connected"
+	case n != nil && n.connecting:
+		return "◌ connecting"
+	default:
+		return "○ offline"
+	}
+}
+
+// scrollLabel describes where the focused pane is scrolled to.
+func (m *model) scrollLabel() string {
+	p := m.currentPane()
+	if p == nil || p.follow || p.viewport.TotalLineCount() <= p.viewport.Height {
+		return "bottom"
+	}
+	if p.viewport.AtTop() {
+		return "top"
+	}
+	return fmt.Sprintf("%d%%", int(p.viewport.ScrollPercent()*100))
+}
+
+// defaultStatusFormat is the status line used unless settings or the
+// config file say otherwise. {name} is replaced by the named segment and {>} starts the
+// right-aligned part.
+const defaultStatusFormat = "{mode} │ {nick} │ {conn} │ {position} │ {typing}{>}{dnd} │ {unread} │ {lag} │ {scroll}"
+
+// statusSegment renders one named piece of the status line. An empty result
+// hides the segment along with the text joining it to its neighbour.
+type statusSegment func(m *model) string
+
+var statusSegments = map[string]statusSegment{}
+
+// registerStatusSegment makes a segment available as {name} in status line
+// formats.
+func registerStatusSegment(name string, seg statusSegment) {
+	statusSegments[name] = seg
+}
+
+func init() {
+	registerStatusSegment("mode", func(m *model) string { return m.focus.mode() })
+	registerStatusSegment("nick", segmentNick)
+	registerStatusSegment("network", segmentNetwork)
+	registerStatusSegment("conn", (*model).connectionLabel)
+	registerStatusSegment("position", segmentPosition)
+	registerStatusSegment("unread", segmentUnread)
+	registerStatusSegment("dnd", segmentDND)
+	registerStatusSegment("typing", segmentTyping)
+	registerStatusSegment("lag", segmentLag)
+	registerStatusSegment("scroll", (*model).scrollLabel)
+	registerStatusSegment("time", func(*model) string { return time.Now().Format("15:04") })
+}
+
+func segmentNick(m *model) string {
+	nick := m.nick
+	if m.client != nil && m.client.guest {
+		nick += " (guest)"
+	}
+	if n := m.currentNetwork(); n != nil && len(m.networks) > 1 {
+		return nick + "@" + n.name
+	}
+	return nick
+}
+
+func segmentNetwork(m *model) string {
+	if n := m.currentNetwork(); n != nil {
+		return n.name
+	}
+	return ""
+}
+
+func segmentPosition(m *model) string {
+	ch := m.activeChannel()
+	if ch == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %d/%d", ch.name, m.active+1, len(m.channels))
+}
+
+func segmentUnread(m *model) string {
+	unread, mentions := m.unreadTotals()
+	if unread == 0 {
+		return ""
+	}
+	totals := fmt.Sprintf("%d unread", unread)
+	if mentions > 0 {
+		totals += " · " + plural(mentions, "mention")
+	}
+	return totals
+}
+
+func segmentLag(m *model) string {
+	if m.client == nil || m.lag <= 0 {
+		return ""
+	}
+	if m.lag < time.Millisecond {
+		return "lag <1ms"
+	}
+	return "lag " + m.lag.Round(time.Millisecond).String()
+}
+
+// statusToken is a piece of a parsed status format: literal text, or the
+// name of a segment.
+type statusToken struct {
+	text    string
+	segment bool
+}
+
+// parseStatusFormat splits a format into literal and segment tokens. An
+// unclosed brace is kept as literal text.
+func parseStatusFormat(format string) []statusToken {
+	var tokens []statusToken
+	for format != "" {
+		open := strings.IndexByte(format, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(format[open:], '}')
+		if end < 0 {
+			break
+		}
+		if open > 0 {
+			tokens = append(tokens, statusToken{text: format[:open]})
+		}
+		tokens = append(tokens, statusToken{text: format[open+1 : open+end], segment: true})
+		format = format[open+end+1:]
+	}
+	if format != "" {
+		tokens = append(tokens, statusToken{text: format})
+	}
+	return tokens
+}
+
+// expandStatus renders tokens, dropping literal text that joins a segment
+// which came out empty.
+func (m *model) expandStatus(tokens []statusToken) string {
+	values := make([]string, len(tokens))
+	for i, t := range tokens {
+		switch {
+		case !t.segment:
+			values[i] = t.text
+		case statusSegments[t.text] != nil:
+			values[i] = statusSegments[t.text](m)
+		default:
+			values[i] = "{" + t.text + "}"
+		}
+	}
+
+	var b strings.Builder
+	wrote := false // a segment has been written since the last literal
+	for i, t := range tokens {
+		if t.segment {
+			if values[i] != "" {
+				b.WriteString(values[i])
+				wrote = true
+			}
+			continue
+		}
+		// Literal text between segments only shows if both sides did
+		nextEmpty := i+1 < len(tokens) && tokens[i+1].segment && values[i+1] == ""
+		if (i > 0 && !wrote) || nextEmpty {
+			continue
+		}
+		b.WriteString(t.text)
+		wrote = false
+	}
+	return b.String()
+}
+
+// statusFormat is the status line format: the one set with /statusline, else
+// the config file's, else the default.
+func (m *model) statusFormat() string {
+	return cmp.Or(m.settings.StatusFormat, m.opts.ui.StatusFormat, defaultStatusFormat)
+}
+
+// renderStatusText renders the status line format across width cells. An
+// error or a recent-buffer walk replaces the left side.
+func (m *model) renderStatusText(width int) string {
+	format := m.statusFormat()
+	leftFormat, rightFormat, _ := strings.Cut(format, "{>}")
+
+	l := m.expandStatus(parseStatusFormat(leftFormat))
+	if m.recentWalk > 0 {
+		l = m.renderRecentWalk()
+	}
+	if m.lastErr != nil {
+		l = "error: " + m.lastErr.Error()
+	}
+	r := m.expandStatus(parseStatusFormat(rightFormat))
+
+	gap := width - lipgloss.Width(l) - lipgloss.Width(r)
+	if gap < 1 {
+		return truncate(l, width)
+	}
+	return l + strings.Repeat(" ", gap) + r
+}
+
+func cmdStatusLine(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+		format := m.statusFormat()
+		names := make([]string, 0, len(statusSegments))
+		for name := range statusSegments {
+			names = append(names, "{"+name+"}")
+		}
+		sort.Strings(names)
+		m.notice("Status format: " + format)
+		m.notice("Segments: " + strings.Join(names, " ") + ", {>} starts the right side")
+		return nil
+	case "reset":
+		m.settings.StatusFormat = ""
+		m.notice("Status format reset")
+	default:
+		m.settings.StatusFormat = args
+		m.notice("Status format set")
+	}
+	return saveSettingsCmd(m.settings)
+}
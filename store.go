@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storeScrollback is how many messages per buffer are loaded from the store.
+const storeScrollback = 500
+
+// store keeps messages, buffer metadata and read positions on disk so
+// scrollback survives restarts and can be browsed offline. Everything is
+// keyed by network, the server address ("local" when offline).
+type store interface {
+	// saveMessages records messages in a buffer, ignoring system notices,
+	// messages without an ID and ones already stored.
+	saveMessages(network, channel string, msgs []message) error
+	// loadMessages returns the newest limit messages of a buffer, oldest
+	// first.
+	loadMessages(network, channel string, limit int) ([]message, error)
+	// loadMessagesBefore returns up to limit messages older than before,
+	// oldest first.
+	loadMessagesBefore(network, channel string, before time.Time, limit int) ([]message, error)
+	// pruneMessages deletes messages older than before (unless it is zero)
+	// and all but the newest keep of each buffer (unless keep is 0). An
+	// empty network or channel means every one. It returns what it deleted.
+	pruneMessages(network, channel string, before time.Time, keep int) ([]messageRef, error)
+	// editMessage replaces the text of a stored message, if it is stored,
+	// and whether its signature checked out (see signing.go).
+	editMessage(network, channel, id, text string, unverified bool) error
+	// deleteMessages deletes messages of a buffer by ID.
+	deleteMessages(network, channel string, ids []string) error
+	// saveChannel records a buffer's metadata and members.
+	saveChannel(network string, ch *channel) error
+	// deleteChannel forgets a buffer we left. Its messages are kept.
+	deleteChannel(network, name string) error
+	// loadChannels returns the stored buffers of a network with their
+	// members, scrollback and read position filled in.
+	loadChannels(network string) ([]*channel, error)
+	// saveReadPosition records the newest message seen in a buffer.
+	saveReadPosition(network, channel string, msg message) error
+	// saveSession records the nick and session a network logs in with,
+	// forgetting it if tokens is empty.
+	saveSession(network, nick string, tokens sessionTokens) error
+	// loadSession returns them, empty if there are none.
+	loadSession(network string) (string, sessionTokens, error)
+	// saveKey records an encryption key, see e2ee.go, replacing its
+	// private half and when it was added if it is stored already.
+	saveKey(network string, k storedKey) error
+	// verifyKey marks a peer's encryption key verified.
+	verifyKey(network, public string) error
+	// loadKeys returns the encryption keys of a network, oldest first.
+	loadKeys(network string) ([]storedKey, error)
+	// saveSigningKey records a signing key, see signing.go, like saveKey.
+	saveSigningKey(network string, k storedKey) error
+	// loadSigningKeys returns the signing keys of a network, oldest first.
+	loadSigningKeys(network string) ([]storedKey, error)
+	close() error
+}
+
+// messageRef identifies a stored message.
+type messageRef struct {
+	network, channel, id string
+}
+
+// Store backends, picked with the "store" setting.
+const (
+	storeSQLite = "sqlite"
+	storeBolt   = "bolt"
+)
+
+// openStore opens the configured backend in the gochat config directory,
+// creating it if needed. An encrypted store asks for its passphrase. With
+// the keychain setting, secrets go in the OS keychain (see keychain.go).
+func openStore(st settings) (store, error) {
+	s, err := openBackend(st)
+	if err != nil || !st.Keychain {
+		return s, err
+	}
+	ks, err := newKeychainStore(s)
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return ks, nil
+}
+
+// openBackend opens the configured backend, for openStore.
+func openBackend(st settings) (store, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "gochat")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var ask passphraseFunc
+	remember := func() error { return nil }
+	if st.EncryptStore {
+		ask = askPassphrase
+		if st.Keychain {
+			ask, remember = keychainPassphrase(ask)
+		}
+	}
+	switch st.Store {
+	case "", storeSQLite:
+		s, err := openSQLiteStore(filepath.Join(dir, "gochat.db"), ask)
+		if err != nil {
+			return nil, err
+		}
+		if err := remember(); err != nil {
+			s.close()
+			return nil, err
+		}
+		return s, nil
+	case storeBolt:
+		if st.EncryptStore {
+			return nil, fmt.Errorf("encrypt_store needs the %s store", storeSQLite)
+		}
+		return openBoltStore(filepath.Join(dir, "gochat.bolt"))
+	default:
+		return nil, fmt.Errorf("unknown store %q, want %s or %s", st.Store, storeSQLite, storeBolt)
+	}
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps the store in a bbolt file, for builds that would rather
+// avoid SQLite. Each network gets a bucket holding:
+//
+//	channels  name -> boltChannel, with its members
+//	reads     channel -> message ID
+//	messages  channel -> bucket of time+ID -> boltMessage
+//	ids       channel -> bucket of ID -> time+ID key
+//	keys      public key -> boltKey
+//	signing   public key -> boltKey, for signing keys
+//
+// and, as a plain key, session -> boltSession.
+type boltStore struct {
+	db *bolt.DB
+}
+
+var (
+	boltChannels   = []byte("channels")
+	boltReads      = []byte("reads")
+	boltMessages   = []byte("messages")
+	boltIDs        = []byte("ids")
+	boltKeys       = []byte("keys")
+	boltSigning    = []byte("signing")
+	boltSessionKey = []byte("session")
+)
+
+type boltChannel struct {
+	Topic    string          `json:"topic,omitempty"`
+	Private  bool            `json:"private,omitempty"`
+	Archived bool            `json:"archived,omitempty"`
+	Created  time.Time       `json:"created,omitempty"`
+	Members  map[string]role `json:"members,omitempty"` // nick -> role
+}
+
+type boltSession struct {
+	Nick   string        `json:"nick"`
+	Tokens sessionTokens `json:"tokens"`
+}
+
+type boltKey struct {
+	Nick     string    `json:"nick"`
+	Private  string    `json:"private,omitempty"`
+	Added    time.Time `json:"added"`
+	Verified bool      `json:"verified,omitempty"`
+}
+
+type boltMessage struct {
+	Nick    string    `json:"nick"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+	// Unverified is whether its signature didn't check out, see signing.go
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+// networkBucket returns the bucket for network, creating it and its
+// sub-buckets in a writable transaction. In a read-only one it may be nil.
+func networkBucket(tx *bolt.Tx, network string) (*bolt.Bucket, error) {
+	if !tx.Writable() {
+		return tx.Bucket([]byte(network)), nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(network))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{boltChannels, boltReads, boltMessages, boltIDs, boltKeys, boltSigning} {
+		if _, err := b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// messageKey orders messages by time, with the ID breaking ties.
+func messageKey(msg message) []byte {
+	key := make([]byte, 8, 8+len(msg.id))
+	binary.BigEndian.PutUint64(key, uint64(msg.time.UnixNano()))
+	return append(key, msg.id...)
+}
+
+func (s *boltStore) saveMessages(network, channel string, msgs []message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		byTime, err := b.Bucket(boltMessages).CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+		ids, err := b.Bucket(boltIDs).CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.system || msg.id == "" || ids.Get([]byte(msg.id)) != nil {
+				continue
+			}
+			data, err := json.Marshal(boltMessage{Nick: msg.nick, Text: msg.text, Time: msg.time, ReplyTo: msg.replyTo, Unverified: msg.unverified})
+			if err != nil {
+				return err
+			}
+			key := messageKey(msg)
+			if err := byTime.Put(key, data); err != nil {
+				return err
+			}
+			if err := ids.Put([]byte(msg.id), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) loadMessages(network, channel string, limit int) ([]message, error) {
+	var msgs []message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		msgs = boltLoadMessages(tx, network, channel, time.Time{}, limit)
+		return nil
+	})
+	return msgs, err
+}
+
+func (s *boltStore) loadMessagesBefore(network, channel string, before time.Time, limit int) ([]message, error) {
+	var msgs []message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		msgs = boltLoadMessages(tx, network, channel, before, limit)
+		return nil
+	})
+	return msgs, err
+}
+
+// boltLoadMessages walks back from before (or the newest message, if zero)
+// collecting up to limit messages.
+func boltLoadMessages(tx *bolt.Tx, network, channel string, before time.Time, limit int) []message {
+	b, _ := networkBucket(tx, network)
+	if b == nil {
+		return nil
+	}
+	byTime := b.Bucket(boltMessages).Bucket([]byte(channel))
+	if byTime == nil {
+		return nil
+	}
+	var msgs []message
+	c := byTime.Cursor()
+	k, v := c.Last()
+	if !before.IsZero() {
+		// Seek lands on the first key at or after before, step back from it
+		if k, _ = c.Seek(messageKey(message{time: before})); k != nil {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+	}
+	for ; k != nil && len(msgs) < limit; k, v = c.Prev() {
+		var bm boltMessage
+		if json.Unmarshal(v, &bm) != nil {
+			continue
+		}
+		msgs = append(msgs, message{
+			id:         string(k[8:]),
+			channel:    channel,
+			nick:       bm.Nick,
+			text:       bm.Text,
+			time:       bm.Time,
+			replyTo:    bm.ReplyTo,
+			unverified: bm.Unverified,
+		})
+	}
+	// Collected newest first
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs
+}
+
+func (s *boltStore) pruneMessages(network, channel string, before time.Time, keep int) ([]messageRef, error) {
+	var refs []messageRef
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if network != "" && string(name) != network {
+				return nil
+			}
+			msgs, ids := b.Bucket(boltMessages), b.Bucket(boltIDs)
+			if msgs == nil {
+				return nil
+			}
+			var buffers [][]byte
+			msgs.ForEach(func(k, _ []byte) error {
+				if channel == "" || string(k) == channel {
+					buffers = append(buffers, k)
+				}
+				return nil
+			})
+			for _, buf := range buffers {
+				byTime := msgs.Bucket(buf)
+				if byTime == nil {
+					continue
+				}
+				// Keys are in time order, so doomed ones come first
+				drop := byTime.Stats().KeyN - keep
+				if keep == 0 {
+					drop = 0
+				}
+				var doomed [][]byte
+				c := byTime.Cursor()
+				for k, _ := c.First(); k != nil; k, _ = c.Next() {
+					old := !before.IsZero() && int64(binary.BigEndian.Uint64(k)) < before.UnixNano()
+					if !old && len(doomed) >= drop {
+						break
+					}
+					// Copied, the key's memory isn't ours once we start deleting
+					doomed = append(doomed, append([]byte(nil), k...))
+				}
+				for _, k := range doomed {
+					if err := byTime.Delete(k); err != nil {
+						return err
+					}
+					if ids := ids.Bucket(buf); ids != nil {
+						if err := ids.Delete(k[8:]); err != nil {
+							return err
+						}
+					}
+					refs = append(refs, messageRef{network: string(name), channel: string(buf), id: string(k[8:])})
+				}
+			}
+			return nil
+		})
+	})
+	return refs, err
+}
+
+func (s *boltStore) editMessage(network, channel, id, text string, unverified bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		byTime, ids := boltBuffer(tx, network, channel)
+		if byTime == nil || ids == nil {
+			return nil
+		}
+		key := append([]byte(nil), ids.Get([]byte(id))...)
+		v := byTime.Get(key)
+		if v == nil {
+			return nil
+		}
+		var bm boltMessage
+		if err := json.Unmarshal(v, &bm); err != nil {
+			return err
+		}
+		bm.Text, bm.Unverified = text, unverified
+		data, err := json.Marshal(bm)
+		if err != nil {
+			return err
+		}
+		return byTime.Put(key, data)
+	})
+}
+
+func (s *boltStore) deleteMessages(network, channel string, msgIDs []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		byTime, ids := boltBuffer(tx, network, channel)
+		if byTime == nil || ids == nil {
+			return nil
+		}
+		for _, id := range msgIDs {
+			key := ids.Get([]byte(id))
+			if key == nil {
+				continue
+			}
+			// Copied, the key's memory isn't ours once we start deleting
+			key = append([]byte(nil), key...)
+			if err := byTime.Delete(key); err != nil {
+				return err
+			}
+			if err := ids.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltBuffer returns the messages and ids buckets of a buffer, nil if it
+// has no messages stored.
+func boltBuffer(tx *bolt.Tx, network, channel string) (byTime, ids *bolt.Bucket) {
+	b := tx.Bucket([]byte(network))
+	if b == nil {
+		return nil, nil
+	}
+	return b.Bucket(boltMessages).Bucket([]byte(channel)), b.Bucket(boltIDs).Bucket([]byte(channel))
+}
+
+func (s *boltStore) saveChannel(network string, ch *channel) error {
+	bc := boltChannel{Topic: ch.topic, Private: ch.private, Archived: ch.archived, Created: ch.created, Members: make(map[string]role)}
+	for _, mem := range ch.members {
+		bc.Members[mem.nick] = mem.role
+	}
+	data, err := json.Marshal(bc)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		return b.Bucket(boltChannels).Put([]byte(ch.name), data)
+	})
+}
+
+func (s *boltStore) deleteChannel(network, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		return b.Bucket(boltChannels).Delete([]byte(name))
+	})
+}
+
+func (s *boltStore) loadChannels(network string) ([]*channel, error) {
+	var list []*channel
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, _ := networkBucket(tx, network)
+		if b == nil {
+			return nil
+		}
+		reads := b.Bucket(boltReads)
+		return b.Bucket(boltChannels).ForEach(func(k, v []byte) error {
+			var bc boltChannel
+			if err := json.Unmarshal(v, &bc); err != nil {
+				return err
+			}
+			ch := newChannel(string(k), bc.Topic)
+			ch.private, ch.archived, ch.created = bc.Private, bc.Archived, bc.Created
+			for nick, r := range bc.Members {
+				ch.members[nick] = &member{nick: nick, role: r}
+			}
+			ch.messages = boltLoadMessages(tx, network, ch.name, time.Time{}, storeScrollback)
+			ch.readID = string(reads.Get(k))
+			list = append(list, ch)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *boltStore) saveReadPosition(network, channel string, msg message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		return b.Bucket(boltReads).Put([]byte(channel), []byte(msg.id))
+	})
+}
+
+func (s *boltStore) saveSession(network, nick string, tokens sessionTokens) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		if tokens.Refresh == "" {
+			return b.Delete(boltSessionKey)
+		}
+		data, err := json.Marshal(boltSession{Nick: nick, Tokens: tokens})
+		if err != nil {
+			return err
+		}
+		return b.Put(boltSessionKey, data)
+	})
+}
+
+func (s *boltStore) loadSession(network string) (string, sessionTokens, error) {
+	var sess boltSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, _ := networkBucket(tx, network)
+		if b == nil {
+			return nil
+		}
+		data := b.Get(boltSessionKey)
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &sess)
+	})
+	return sess.Nick, sess.Tokens, err
+}
+
+func (s *boltStore) saveKey(network string, k storedKey) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		keys := b.Bucket(boltKeys)
+		var old boltKey
+		if data := keys.Get([]byte(k.public)); data != nil {
+			if err := json.Unmarshal(data, &old); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(boltKey{Nick: k.nick, Private: k.private, Added: k.added, Verified: old.Verified})
+		if err != nil {
+			return err
+		}
+		return keys.Put([]byte(k.public), data)
+	})
+}
+
+func (s *boltStore) verifyKey(network, public string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		keys := b.Bucket(boltKeys)
+		data := keys.Get([]byte(public))
+		if data == nil {
+			return nil
+		}
+		var k boltKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			return err
+		}
+		k.Verified = true
+		if data, err = json.Marshal(k); err != nil {
+			return err
+		}
+		return keys.Put([]byte(public), data)
+	})
+}
+
+func (s *boltStore) loadKeys(network string) ([]storedKey, error) {
+	var keys []storedKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, _ := networkBucket(tx, network)
+		if b == nil || b.Bucket(boltKeys) == nil {
+			return nil
+		}
+		return b.Bucket(boltKeys).ForEach(func(public, data []byte) error {
+			var k boltKey
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+			keys = append(keys, storedKey{nick: k.Nick, public: string(public), private: k.Private, added: k.Added, verified: k.Verified})
+			return nil
+		})
+	})
+	slices.SortStableFunc(keys, func(a, b storedKey) int { return a.added.Compare(b.added) })
+	return keys, err
+}
+
+func (s *boltStore) saveSigningKey(network string, k storedKey) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := networkBucket(tx, network)
+		if err != nil {
+			return err
+		}
+		keys := b.Bucket(boltSigning)
+		old := boltKey{Private: k.private}
+		if data := keys.Get([]byte(k.public)); data != nil && k.private == "" {
+			if err := json.Unmarshal(data, &old); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(boltKey{Nick: k.nick, Private: old.Private, Added: k.added})
+		if err != nil {
+			return err
+		}
+		return keys.Put([]byte(k.public), data)
+	})
+}
+
+func (s *boltStore) loadSigningKeys(network string) ([]storedKey, error) {
+	var keys []storedKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, _ := networkBucket(tx, network)
+		if b == nil || b.Bucket(boltSigning) == nil {
+			return nil
+		}
+		return b.Bucket(boltSigning).ForEach(func(public, data []byte) error {
+			var k boltKey
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+			keys = append(keys, storedKey{nick: k.Nick, public: string(public), private: k.Private, added: k.Added})
+			return nil
+		})
+	})
+	slices.SortStableFunc(keys, func(a, b storedKey) int { return a.added.Compare(b.added) })
+	return keys, err
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore is the default store, a single SQLite database.
+type sqliteStore struct {
+	db     *sql.DB
+	cipher *storeCipher // nil unless the store is encrypted
+}
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	network  TEXT NOT NULL,
+	channel  TEXT NOT NULL,
+	id       TEXT NOT NULL,
+	nick     TEXT NOT NULL,
+	text     TEXT NOT NULL,
+	time     INTEGER NOT NULL,
+	reply_to TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (network, channel, id)
+);
+CREATE INDEX IF NOT EXISTS messages_by_time ON messages (network, channel, time);
+CREATE TABLE IF NOT EXISTS channels (
+	network  TEXT NOT NULL,
+	name     TEXT NOT NULL,
+	topic    TEXT NOT NULL DEFAULT '',
+	private  INTEGER NOT NULL DEFAULT 0,
+	archived INTEGER NOT NULL DEFAULT 0,
+	created  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, name)
+);
+CREATE TABLE IF NOT EXISTS members (
+	network TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	nick    TEXT NOT NULL,
+	role    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS members_by_channel ON members (network, channel);
+CREATE TABLE IF NOT EXISTS read_positions (
+	network TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	id      TEXT NOT NULL,
+	time    INTEGER NOT NULL,
+	PRIMARY KEY (network, channel)
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	network TEXT PRIMARY KEY,
+	nick    TEXT NOT NULL,
+	access  TEXT NOT NULL,
+	refresh TEXT NOT NULL,
+	expires INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS keys (
+	network TEXT NOT NULL,
+	public  TEXT NOT NULL,
+	nick    TEXT NOT NULL,
+	private TEXT NOT NULL DEFAULT '',
+	added   INTEGER NOT NULL,
+	PRIMARY KEY (network, public)
+);
+CREATE TABLE IF NOT EXISTS signing_keys (
+	network TEXT NOT NULL,
+	public  TEXT NOT NULL,
+	nick    TEXT NOT NULL,
+	private TEXT NOT NULL DEFAULT '',
+	added   INTEGER NOT NULL,
+	PRIMARY KEY (network, public)
+);
+CREATE TABLE IF NOT EXISTS unverified_messages (
+	network TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	id      TEXT NOT NULL,
+	PRIMARY KEY (network, channel, id)
+);
+CREATE TABLE IF NOT EXISTS verified_keys (
+	network TEXT NOT NULL,
+	public  TEXT NOT NULL,
+	PRIMARY KEY (network, public)
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// openSQLiteStore opens (creating if needed) the database at path. With
+// ask set the store is encrypted, and unlocked with the passphrase it
+// returns.
+func openSQLiteStore(path string, ask passphraseFunc) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// One connection, so writes never contend with each other
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteStore{db: db}
+	if err := s.unlock(ask); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// unlock sets up the cipher of an encrypted store, encrypting what is
+// there already the first time.
+func (s *sqliteStore) unlock(ask passphraseFunc) error {
+	var salt, check string
+	err := s.db.QueryRow(`SELECT value FROM meta WHERE key = 'salt'`).Scan(&salt)
+	if err == nil {
+		err = s.db.QueryRow(`SELECT value FROM meta WHERE key = 'check'`).Scan(&check)
+	}
+	encrypted := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	switch {
+	case !encrypted && ask == nil:
+		return nil
+	case encrypted && ask == nil:
+		return errors.New(`the store is encrypted, set "encrypt_store" in settings.json`)
+	case !encrypted:
+		return s.encrypt(ask)
+	}
+
+	for attempt := 0; attempt < storeUnlockTries; attempt++ {
+		pass, err := ask(false, attempt)
+		if err != nil {
+			return err
+		}
+		c, err := newStoreCipher(pass, []byte(salt))
+		if err != nil {
+			return err
+		}
+		if got, err := c.open(check); err == nil && got == storeCheck {
+			s.cipher = c
+			return nil
+		}
+	}
+	return errWrongPassphrase
+}
+
+// encrypt turns on encryption for a plain store, sealing the messages,
+// topics, members, sessions and keys already in it.
+func (s *sqliteStore) encrypt(ask passphraseFunc) error {
+	pass, err := ask(true, 0)
+	if err != nil {
+		return err
+	}
+	salt := rand.Text()
+	c, err := newStoreCipher(pass, []byte(salt))
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('salt', ?), ('check', ?)`, salt, c.seal(storeCheck)); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, nick, text FROM messages`, `UPDATE messages SET nick = ?, text = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, topic FROM channels`, `UPDATE channels SET topic = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, nick FROM members`, `UPDATE members SET nick = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, nick, access, refresh FROM sessions`, `UPDATE sessions SET nick = ?, access = ?, refresh = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, nick, private FROM keys`, `UPDATE keys SET nick = ?, private = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := sealColumns(tx, c, `SELECT rowid, nick, private FROM signing_keys`, `UPDATE signing_keys SET nick = ?, private = ? WHERE rowid = ?`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.cipher = c
+	// Don't leave the plain text behind in free pages
+	_, err = s.db.Exec(`VACUUM`)
+	return err
+}
+
+// sealColumns encrypts the text columns selected after the rowid, writing
+// them back with update (which takes them and then the rowid).
+func sealColumns(tx *sql.Tx, c *storeCipher, query, update string) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	cols, _ := rows.Columns()
+	var updates [][]any
+	for rows.Next() {
+		var rowid int64
+		vals := make([]string, len(cols)-1)
+		dest := []any{&rowid}
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return err
+		}
+		args := make([]any, 0, len(cols))
+		for _, v := range vals {
+			args = append(args, c.seal(v))
+		}
+		updates = append(updates, append(args, rowid))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, args := range updates {
+		if _, err := tx.Exec(update, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) saveMessages(network, channel string, msgs []message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO messages (network, channel, id, nick, text, time, reply_to)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, msg := range msgs {
+		if msg.system || msg.id == "" {
+			continue
+		}
+		res, err := stmt.Exec(network, channel, msg.id, s.cipher.seal(msg.nick), s.cipher.seal(msg.text), msg.time.UnixNano(), msg.replyTo)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 && msg.unverified {
+			if err := setUnverified(tx, network, channel, msg.id, true); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) loadMessages(network, channel string, limit int) ([]message, error) {
+	return s.queryMessages(`SELECT id, nick, text, time, reply_to, unverified FROM (
+			SELECT m.id, nick, text, time, reply_to, u.id IS NOT NULL AS unverified
+			FROM messages m LEFT JOIN unverified_messages u USING (network, channel, id)
+			WHERE network = ? AND channel = ?
+			ORDER BY time DESC LIMIT ?
+		) ORDER BY time`, channel, network, channel, limit)
+}
+
+func (s *sqliteStore) loadMessagesBefore(network, channel string, before time.Time, limit int) ([]message, error) {
+	return s.queryMessages(`SELECT id, nick, text, time, reply_to, unverified FROM (
+			SELECT m.id, nick, text, time, reply_to, u.id IS NOT NULL AS unverified
+			FROM messages m LEFT JOIN unverified_messages u USING (network, channel, id)
+			WHERE network = ? AND channel = ? AND time < ?
+			ORDER BY time DESC LIMIT ?
+		) ORDER BY time`, channel, network, channel, before.UnixNano(), limit)
+}
+
+// queryMessages runs a query selecting id, nick, text, time, reply_to and
+// unverified for messages in channel.
+func (s *sqliteStore) queryMessages(query, channel string, args ...any) ([]message, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []message
+	for rows.Next() {
+		msg := message{channel: channel}
+		var t int64
+		if err := rows.Scan(&msg.id, &msg.nick, &msg.text, &t, &msg.replyTo, &msg.unverified); err != nil {
+			return nil, err
+		}
+		msg.time = time.Unix(0, t)
+		var err error
+		if msg.nick, err = s.cipher.open(msg.nick); err != nil {
+			return nil, err
+		}
+		if msg.text, err = s.cipher.open(msg.text); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *sqliteStore) pruneMessages(network, channel string, before time.Time, keep int) ([]messageRef, error) {
+	rows, err := s.db.Query(`DELETE FROM messages
+		WHERE (?1 = '' OR network = ?1) AND (?2 = '' OR channel = ?2) AND (time < ?3 OR rowid IN (
+			SELECT rowid FROM (
+				SELECT rowid, ROW_NUMBER() OVER (PARTITION BY network, channel ORDER BY time DESC) AS n
+				FROM messages WHERE (?1 = '' OR network = ?1) AND (?2 = '' OR channel = ?2)
+			) WHERE ?4 > 0 AND n > ?4))
+		RETURNING network, channel, id`, network, channel, unixNano(before), keep)
+	if err != nil {
+		return nil, err
+	}
+	var refs []messageRef
+	for rows.Next() {
+		var r messageRef
+		if err := rows.Scan(&r.network, &r.channel, &r.id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(refs) > 0 {
+		_, err = s.db.Exec(`DELETE FROM unverified_messages WHERE NOT EXISTS (
+			SELECT 1 FROM messages m WHERE m.network = unverified_messages.network
+				AND m.channel = unverified_messages.channel AND m.id = unverified_messages.id)`)
+	}
+	return refs, err
+}
+
+func (s *sqliteStore) editMessage(network, channel, id, text string, unverified bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE messages SET text = ? WHERE network = ? AND channel = ? AND id = ?`,
+		s.cipher.seal(text), network, channel, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := setUnverified(tx, network, channel, id, unverified); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// setUnverified records whether the signature of a stored message checked
+// out.
+func setUnverified(tx *sql.Tx, network, channel, id string, unverified bool) error {
+	query := `DELETE FROM unverified_messages WHERE network = ? AND channel = ? AND id = ?`
+	if unverified {
+		query = `INSERT OR IGNORE INTO unverified_messages (network, channel, id) VALUES (?, ?, ?)`
+	}
+	_, err := tx.Exec(query, network, channel, id)
+	return err
+}
+
+func (s *sqliteStore) deleteMessages(network, channel string, ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM messages WHERE network = ? AND channel = ? AND id = ?`, network, channel, id); err != nil {
+			return err
+		}
+		if err := setUnverified(tx, network, channel, id, false); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) saveChannel(network string, ch *channel) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO channels (network, name, topic, private, archived, created)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (network, name) DO UPDATE SET
+			topic = excluded.topic, private = excluded.private,
+			archived = excluded.archived, created = excluded.created`,
+		network, ch.name, s.cipher.seal(ch.topic), ch.private, ch.archived, unixNano(ch.created)); err != nil {
+		return err
+	}
+	// Sealed nicks can't be matched, so the member list is replaced whole
+	if _, err := tx.Exec(`DELETE FROM members WHERE network = ? AND channel = ?`, network, ch.name); err != nil {
+		return err
+	}
+	for _, mem := range ch.members {
+		if _, err := tx.Exec(`INSERT INTO members (network, channel, nick, role) VALUES (?, ?, ?, ?)`,
+			network, ch.name, s.cipher.seal(mem.nick), mem.role); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) deleteChannel(network, name string) error {
+	if _, err := s.db.Exec(`DELETE FROM members WHERE network = ? AND channel = ?`, network, name); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM channels WHERE network = ? AND name = ?`, network, name)
+	return err
+}
+
+func (s *sqliteStore) loadChannels(network string) ([]*channel, error) {
+	rows, err := s.db.Query(`SELECT name, topic, private, archived, created FROM channels
+		WHERE network = ? ORDER BY name`, network)
+	if err != nil {
+		return nil, err
+	}
+	var list []*channel
+	for rows.Next() {
+		ch := newChannel("", "")
+		var created int64
+		if err := rows.Scan(&ch.name, &ch.topic, &ch.private, &ch.archived, &created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if created != 0 {
+			ch.created = time.Unix(0, created)
+		}
+		if ch.topic, err = s.cipher.open(ch.topic); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, ch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ch := range list {
+		if ch.messages, err = s.loadMessages(network, ch.name, storeScrollback); err != nil {
+			return nil, err
+		}
+		err := s.db.QueryRow(`SELECT id FROM read_positions WHERE network = ? AND channel = ?`,
+			network, ch.name).Scan(&ch.readID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err := s.loadMembers(network, ch); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// loadMembers fills in the last known members of ch, all offline until
+// the server says otherwise.
+func (s *sqliteStore) loadMembers(network string, ch *channel) error {
+	rows, err := s.db.Query(`SELECT nick, role FROM members WHERE network = ? AND channel = ?`, network, ch.name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		mem := &member{}
+		if err := rows.Scan(&mem.nick, &mem.role); err != nil {
+			return err
+		}
+		if mem.nick, err = s.cipher.open(mem.nick); err != nil {
+			return err
+		}
+		ch.members[mem.nick] = mem
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) saveReadPosition(network, channel string, msg message) error {
+	_, err := s.db.Exec(`INSERT INTO read_positions (network, channel, id, time) VALUES (?, ?, ?, ?)
+		ON CONFLICT (network, channel) DO UPDATE SET id = excluded.id, time = excluded.time`,
+		network, channel, msg.id, msg.time.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) saveSession(network, nick string, tokens sessionTokens) error {
+	if tokens.Refresh == "" {
+		_, err := s.db.Exec(`DELETE FROM sessions WHERE network = ?`, network)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO sessions (network, nick, access, refresh, expires) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (network) DO UPDATE SET
+			nick = excluded.nick, access = excluded.access, refresh = excluded.refresh, expires = excluded.expires`,
+		network, s.cipher.seal(nick), s.cipher.seal(tokens.Access), s.cipher.seal(tokens.Refresh), tokens.Expires.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) loadSession(network string) (string, sessionTokens, error) {
+	var nick string
+	var t sessionTokens
+	var expires int64
+	err := s.db.QueryRow(`SELECT nick, access, refresh, expires FROM sessions WHERE network = ?`, network).
+		Scan(&nick, &t.Access, &t.Refresh, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", t, nil
+	}
+	if err != nil {
+		return "", t, err
+	}
+	t.Expires = time.Unix(0, expires)
+	for _, v := range []*string{&nick, &t.Access, &t.Refresh} {
+		if *v, err = s.cipher.open(*v); err != nil {
+			return "", sessionTokens{}, err
+		}
+	}
+	return nick, t, nil
+}
+
+func (s *sqliteStore) saveKey(network string, k storedKey) error {
+	_, err := s.db.Exec(`INSERT INTO keys (network, public, nick, private, added) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (network, public) DO UPDATE SET private = excluded.private, added = excluded.added`,
+		network, k.public, s.cipher.seal(k.nick), s.cipher.seal(k.private), k.added.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) verifyKey(network, public string) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO verified_keys (network, public) VALUES (?, ?)`, network, public)
+	return err
+}
+
+func (s *sqliteStore) loadKeys(network string) ([]storedKey, error) {
+	rows, err := s.db.Query(`SELECT public, nick, private, added, v.public IS NOT NULL
+		FROM keys k LEFT JOIN verified_keys v USING (network, public) WHERE network = ? ORDER BY added`, network)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []storedKey
+	for rows.Next() {
+		var k storedKey
+		var added int64
+		if err := rows.Scan(&k.public, &k.nick, &k.private, &added, &k.verified); err != nil {
+			return nil, err
+		}
+		k.added = time.Unix(0, added)
+		for _, v := range []*string{&k.nick, &k.private} {
+			if *v, err = s.cipher.open(*v); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteStore) saveSigningKey(network string, k storedKey) error {
+	_, err := s.db.Exec(`INSERT INTO signing_keys (network, public, nick, private, added) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (network, public) DO UPDATE SET private = excluded.private, added = excluded.added`,
+		network, k.public, s.cipher.seal(k.nick), s.cipher.seal(k.private), k.added.UnixNano())
+	return err
+}
+
+func (s *sqliteStore) loadSigningKeys(network string) ([]storedKey, error) {
+	rows, err := s.db.Query(`SELECT public, nick, private, added FROM signing_keys WHERE network = ? ORDER BY added`, network)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []storedKey
+	for rows.Next() {
+		var k storedKey
+		var added int64
+		if err := rows.Scan(&k.public, &k.nick, &k.private, &added); err != nil {
+			return nil, err
+		}
+		k.added = time.Unix(0, added)
+		for _, v := range []*string{&k.nick, &k.private} {
+			if *v, err = s.cipher.open(*v); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
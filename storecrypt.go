@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const (
+	// storeKDFIterations is the PBKDF2-SHA256 work factor for turning the
+	// passphrase into a key, OWASP's current recommendation.
+	storeKDFIterations = 600_000
+	// storeUnlockTries is how many times a wrong passphrase is asked again.
+	storeUnlockTries = 3
+	// storeCheck is sealed into the store when it is first encrypted, so a
+	// wrong passphrase is caught before anything is read with it.
+	storeCheck = "gochat"
+	// sealedPrefix marks an encrypted column value.
+	sealedPrefix = "enc1:"
+)
+
+var errWrongPassphrase = errors.New("wrong store passphrase")
+
+// passphraseFunc asks for the store passphrase. confirm is set when the
+// store is being encrypted for the first time, attempt counts wrong tries.
+type passphraseFunc func(confirm bool, attempt int) (string, error)
+
+// storeCipher encrypts the sensitive columns of an encrypted store (nicks,
+// message text and topics) with AES-256-GCM. A nil storeCipher leaves
+// values as they are.
+type storeCipher struct {
+	aead cipher.AEAD
+}
+
+func newStoreCipher(passphrase string, salt []byte) (*storeCipher, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, storeKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &storeCipher{aead: aead}, nil
+}
+
+func (c *storeCipher) seal(s string) string {
+	if c == nil {
+		return s
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	rand.Read(nonce)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(s), nil))
+}
+
+func (c *storeCipher) open(s string) (string, error) {
+	if c == nil {
+		return s, nil
+	}
+	data, ok := strings.CutPrefix(s, sealedPrefix)
+	if !ok {
+		return "", errors.New("store value isn't encrypted")
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return "", errors.New("corrupt encrypted store value")
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return "", errWrongPassphrase
+	}
+	return string(plain), nil
+}
+
+// askPassphrase reads the store passphrase from $GOCHAT_STORE_PASSPHRASE,
+// for scripts, or else from the terminal without echoing it.
+func askPassphrase(confirm bool, attempt int) (string, error) {
+	if p := os.Getenv("GOCHAT_STORE_PASSPHRASE"); p != "" {
+		if attempt > 0 {
+			return "", errWrongPassphrase
+		}
+		return p, nil
+	}
+	fd := os.Stdin.Fd()
+	if !term.IsTerminal(fd) {
+		return "", errors.New("the store is encrypted, set GOCHAT_STORE_PASSPHRASE to unlock it without a terminal")
+	}
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		p, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(p), err
+	}
+	prompt := "Store passphrase: "
+	switch {
+	case confirm:
+		prompt = "New store passphrase: "
+	case attempt > 0:
+		prompt = "Wrong passphrase, try again: "
+	}
+	p, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errors.New("the store passphrase can't be empty")
+	}
+	if confirm {
+		again, err := read("Repeat it: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", errors.New("the passphrases don't match")
+		}
+	}
+	return p, nil
+}
@@ -2,82 +2,233 @@ package main
 
 import (
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
 )
 
-// UI Styles
+// UI Styles, drawn in the current theme's colors by setTheme (see theme.go)
 var (
+	// Header and Layout Styles
+	appStyle, logoStyle, networkSegmentStyle, channelStyle, dividerStyle, topicStyle,
+	searchBaseStyle, iconBoxStyle, bellActiveStyle, headerContainerStyle, statusLineStyle,
+	mainContentStyle, messageBoxStyle, leftSidebarStyle, rightSidebarStyle lipgloss.Style
+	// Member List Styles
+	memberGroupStyle, memberNickStyle, memberOfflineNickStyle, presenceOnlineStyle,
+	presenceAwayStyle, presenceOfflineStyle lipgloss.Style
+	// Overlay Styles
+	overlayStyle, overlayPromptStyle, overlayTitleStyle, overlayHintStyle, errorTextStyle,
+	overlaySelectedStyle, badgeStyle lipgloss.Style
+	// Buffer Tab Styles
+	tabBarStyle, tabStyle, tabActiveStyle, tabActivityStyle, tabHighlightStyle lipgloss.Style
+	// Channel Sidebar Styles
+	sidebarEntryStyle, sidebarUnreadStyle, sidebarActiveStyle, sidebarMutedStyle,
+	unreadBadgeStyle, dmPreviewStyle, mentionBadgeStyle lipgloss.Style
+	// Message Buffer Styles
+	timestampStyle, emptyBufferStyle, selectedMessageStyle, systemMessageStyle,
+	replyContextStyle, unreadLineStyle, reactionStyle, editedStyle, pendingMessageStyle,
+	unverifiedStyle, searchMatchStyle lipgloss.Style
+)
+
+// setTheme makes t the current theme, (re)drawing every style in it.
+func setTheme(t theme) {
+	palette = t
+
 	// Reduced horizontal padding to 0 to minimize sidebar margins as requested
-	appStyle = lipgloss.NewStyle().Padding(1, 0, 0, 0)
+	appStyle = lipgloss.NewStyle().Padding(chrome.gap(), 0, 0, 0)
 
 	// Header Styles
 	logoStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("212")).
-			MarginRight(1).
-			SetString("\uf489") //
+		Foreground(t.Accent).
+		MarginRight(1).
+		SetString(icons.Logo)
+
+	networkSegmentStyle = lipgloss.NewStyle().
+		Foreground(t.Subtext).
+		MarginRight(1)
 
 	channelStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Bold(true).
-			MarginRight(1).
-			SetString("#general")
+		Foreground(t.Text).
+		Bold(true).
+		MarginRight(1)
 
 	dividerStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("240")).
-			MarginRight(1).
-			SetString("|")
+		Foreground(t.Dim).
+		MarginRight(1).
+		SetString("|")
 
 	topicStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("243")). // Grey
-			MarginRight(1).                    // Reduced margin to fit new divider
-			SetString("TOPIC: Discussion")
+		Foreground(t.Muted).
+		MarginRight(1) // Reduced margin to fit new divider
 
 	searchBaseStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("240")).
-			Padding(0, 1)
+		Foreground(t.Dim).
+		Padding(0, 1)
 
 	iconBoxStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Padding(0, 1).
-			MarginLeft(1).
-			Align(lipgloss.Center)
+		Foreground(t.Text).
+		Padding(0, 1).
+		MarginLeft(1).
+		Align(lipgloss.Center)
+
+	// Bell icon when there is unseen activity
+	bellActiveStyle = iconBoxStyle.
+		Foreground(t.Accent).
+		Bold(true)
 
 	// The big wrapper for everything
-	headerContainerStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder()).
-				BorderForeground(lipgloss.Color("240")).
-				Padding(0, 1).
-				MarginTop(1)
+	headerContainerStyle = chrome.box(lipgloss.NormalBorder(), t.Dim).
+		Padding(0, 1).
+		MarginTop(chrome.gap())
 
 	// Status Line Style (Re-added)
 	statusLineStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FFFFFF")).
-			Background(lipgloss.Color("212")).
-			Padding(0, 1)
+		Foreground(t.Badge).
+		Background(t.Accent).
+		Padding(0, 1)
 
 	// Main Content Area Style (Empty Border Box)
-	mainContentStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder()).
-				BorderForeground(lipgloss.Color("240")).
-				Padding(0, 1)
-
-	// Message Input Box Style
-	messageBoxStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(lipgloss.Color("212")). // Pink border
-			Padding(0, 1).
-			MarginTop(0)
+	mainContentStyle = chrome.focusBox(lipgloss.NormalBorder(), t.Dim).
+		Padding(0, 1)
+
+	// Message Input Box Style, with a pink border
+	messageBoxStyle = chrome.focusBox(lipgloss.RoundedBorder(), t.Accent).
+		Padding(0, 1).
+		MarginTop(0)
 
 	// Sidebar Styles
 	// Added MarginTop(1) to align with Header
-	leftSidebarStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder()).
-				BorderForeground(lipgloss.Color("240")).
-				Padding(0, 1).
-				MarginTop(1)
-
-	rightSidebarStyle = lipgloss.NewStyle().
-				Border(lipgloss.NormalBorder()).
-				BorderForeground(lipgloss.Color("240")).
-				Padding(0, 1).
-				MarginTop(1)
-)
+	leftSidebarStyle = chrome.box(lipgloss.NormalBorder(), t.Dim).
+		Padding(0, 1).
+		MarginTop(chrome.gap())
+
+	rightSidebarStyle = chrome.box(lipgloss.NormalBorder(), t.Dim).
+		Padding(0, 1).
+		MarginTop(chrome.gap())
+
+	// Member List Styles
+	memberGroupStyle = lipgloss.NewStyle().
+		Foreground(t.Muted).
+		Bold(true)
+
+	memberNickStyle = lipgloss.NewStyle().
+		Foreground(t.Text)
+
+	memberOfflineNickStyle = lipgloss.NewStyle().
+		Foreground(t.Dim)
+
+	presenceOnlineStyle = lipgloss.NewStyle().Foreground(t.Online)
+	presenceAwayStyle = lipgloss.NewStyle().Foreground(t.Warning)
+	presenceOfflineStyle = lipgloss.NewStyle().Foreground(t.Dim)
+
+	// Overlay Styles
+	overlayStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(t.Accent).
+		Padding(0, 1)
+
+	overlayPromptStyle = lipgloss.NewStyle().Foreground(t.Accent)
+
+	overlayTitleStyle = lipgloss.NewStyle().
+		Foreground(t.Accent).
+		Bold(true)
+
+	overlayHintStyle = lipgloss.NewStyle().Foreground(t.Muted)
+
+	errorTextStyle = lipgloss.NewStyle().Foreground(t.Error)
+
+	overlaySelectedStyle = lipgloss.NewStyle().
+		Foreground(t.Text).
+		Background(t.Selection).
+		Bold(true)
+
+	badgeStyle = lipgloss.NewStyle().
+		Foreground(t.Badge).
+		Background(t.Accent).
+		Padding(0, 1)
+
+	// Buffer Tab Styles
+	tabBarStyle = lipgloss.NewStyle().Padding(0, 1)
+
+	tabStyle = lipgloss.NewStyle().Foreground(t.Muted)
+
+	tabActiveStyle = lipgloss.NewStyle().
+		Foreground(t.Accent).
+		Bold(true).
+		Underline(true)
+
+	// Buffer has new messages since it was last viewed
+	tabActivityStyle = lipgloss.NewStyle().
+		Foreground(t.Text).
+		Bold(true)
+
+	// Buffer has unread messages mentioning us
+	tabHighlightStyle = lipgloss.NewStyle().
+		Foreground(t.Error).
+		Bold(true)
+
+	// Channel Sidebar Styles
+	sidebarEntryStyle = lipgloss.NewStyle().Foreground(t.Muted)
+
+	sidebarUnreadStyle = lipgloss.NewStyle().
+		Foreground(t.Text).
+		Bold(true)
+
+	sidebarActiveStyle = lipgloss.NewStyle().
+		Foreground(t.Accent).
+		Bold(true)
+
+	// Muted buffers fade into the background
+	sidebarMutedStyle = lipgloss.NewStyle().
+		Foreground(t.Faint).
+		Faint(true)
+
+	unreadBadgeStyle = lipgloss.NewStyle().Foreground(t.Muted)
+
+	dmPreviewStyle = lipgloss.NewStyle().Foreground(t.Dim)
+
+	mentionBadgeStyle = lipgloss.NewStyle().
+		Foreground(t.Badge).
+		Background(t.Error).
+		Bold(true)
+
+	// Message Buffer Styles
+	timestampStyle = lipgloss.NewStyle().Foreground(t.Dim)
+
+	emptyBufferStyle = lipgloss.NewStyle().
+		Foreground(t.Dim).
+		Italic(true)
+
+	selectedMessageStyle = lipgloss.NewStyle().Background(t.Surface)
+
+	systemMessageStyle = lipgloss.NewStyle().
+		Foreground(t.Muted).
+		Italic(true)
+
+	replyContextStyle = lipgloss.NewStyle().Foreground(t.Dim)
+
+	unreadLineStyle = lipgloss.NewStyle().Foreground(t.Error)
+
+	reactionStyle = lipgloss.NewStyle().Foreground(t.Reaction)
+
+	editedStyle = lipgloss.NewStyle().Foreground(t.Dim)
+
+	pendingMessageStyle = lipgloss.NewStyle().Foreground(t.Pending)
+
+	unverifiedStyle = lipgloss.NewStyle().Foreground(t.Warning)
+
+	searchMatchStyle = lipgloss.NewStyle().
+		Foreground(t.Accent).
+		Bold(true)
+
+	if lipgloss.ColorProfile() == termenv.Ascii {
+		// Without colors, what only a background set apart is reversed
+		for _, style := range []*lipgloss.Style{&statusLineStyle, &overlaySelectedStyle, &badgeStyle, &mentionBadgeStyle, &selectedMessageStyle} {
+			*style = style.Reverse(true)
+		}
+	}
+}
+
+// focusedBorder marks the border of style as focused: thick and in the
+// accent color, so it doesn't take telling colors apart.
+func focusedBorder(style lipgloss.Style) lipgloss.Style {
+	return style.BorderStyle(lipgloss.ThickBorder()).BorderForeground(palette.Accent)
+}
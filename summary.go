@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// On starting up, once the server has caught us up, a summary of what is
+// unread pops up: "3 mentions in #dev, 12 unread in #general, 1 new DM from
+// bob", the mentions first, so there is a place to start. Enter goes to the
+// buffer under the cursor, esc to whichever was open. /summary brings it
+// back later and /summary off stops it popping up. The server answers our
+// first ping only after all it had to catch us up on, so that pong is when
+// the counts are complete.
+
+const summaryVisibleRows = 12
+
+// summaryEntry is one buffer with something unread.
+type summaryEntry struct {
+	buffer   string
+	unread   int
+	mentions int
+}
+
+func (e summaryEntry) label() string {
+	switch {
+	case strings.HasPrefix(e.buffer, "@"):
+		return plural(e.unread, "new DM") + " from " + e.buffer[1:]
+	case e.mentions > 0 && e.unread > e.mentions:
+		return fmt.Sprintf("%s in %s, %d unread", plural(e.mentions, "mention"), e.buffer, e.unread)
+	case e.mentions > 0:
+		return plural(e.mentions, "mention") + " in " + e.buffer
+	}
+	return fmt.Sprintf("%d unread in %s", e.unread, e.buffer)
+}
+
+// unreadSummary lists the buffers with something unread: those mentioning
+// us first, then DMs, then the rest, the busiest first in each.
+func (m *model) unreadSummary() []summaryEntry {
+	var list []summaryEntry
+	for _, ch := range m.channels {
+		unread, mentions := ch.unread, ch.mentions
+		if m.isReadingBottom(ch) {
+			// Shown, so read as far as badges go, but not yet below the line
+			unread, mentions = m.unreadAfter(ch, ch.markerID)
+		}
+		if unread > 0 || mentions > 0 {
+			list = append(list, summaryEntry{buffer: ch.name, unread: unread, mentions: mentions})
+		}
+	}
+	rank := func(e summaryEntry) int {
+		switch {
+		case e.mentions > 0:
+			return 0
+		case strings.HasPrefix(e.buffer, "@"):
+			return 1
+		}
+		return 2
+	}
+	slices.SortFunc(list, func(a, b summaryEntry) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(b.mentions, a.mentions),
+			cmp.Compare(b.unread, a.unread), strings.Compare(a.buffer, b.buffer))
+	})
+	return list
+}
+
+// showStartupSummary shows the unread summary, the first time the shown
+// network has caught us up since starting, if there is anything in it.
+func (m *model) showStartupSummary() {
+	if !m.summaryDue || m.background {
+		return
+	}
+	m.summaryDue = false
+	entries := m.unreadSummary()
+	if len(entries) == 0 || m.overlay != nil || m.login != nil || m.setup != nil {
+		return
+	}
+	m.showSummary(entries)
+}
+
+// showSummary opens the summary overlay, or says it in a line with
+// --plain, which has none.
+func (m *model) showSummary(entries []summaryEntry) {
+	if m.opts.plain {
+		labels := make([]string, len(entries))
+		for i, e := range entries {
+			labels[i] = e.label()
+		}
+		m.notice("Unread: " + strings.Join(labels, ", "))
+		return
+	}
+	m.overlay = &unreadSummaryView{entries: entries}
+}
+
+// unreadSummaryView is the overlay listing the unread summary.
+type unreadSummaryView struct {
+	entries []summaryEntry
+	cursor  int
+	offset  int
+}
+
+func (v *unreadSummaryView) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return v, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if v.cursor > 0 {
+			v.cursor--
+		}
+	case key.Matches(keyMsg, keys.Down):
+		if v.cursor < len(v.entries)-1 {
+			v.cursor++
+		}
+	case key.Matches(keyMsg, keys.Select):
+		name := v.entries[v.cursor].buffer
+		return nil, func() tea.Msg { return switchBufferMsg{name: name} }
+	}
+
+	if v.cursor < v.offset {
+		v.offset = v.cursor
+	}
+	if v.cursor >= v.offset+summaryVisibleRows {
+		v.offset = v.cursor - summaryVisibleRows + 1
+	}
+	return v, nil
+}
+
+func (v *unreadSummaryView) View(width, height int) string {
+	w := min(56, width-4)
+	lines := []string{overlayTitleStyle.Render("While you were away"), ""}
+	end := min(v.offset+summaryVisibleRows, len(v.entries))
+	for i := v.offset; i < end; i++ {
+		e := v.entries[i]
+		icon := " "
+		if e.mentions > 0 {
+			icon = "@"
+		}
+		row := icon + " " + truncate(e.label(), w-6)
+		if i == v.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render(row))
+		} else {
+			lines = append(lines, row)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("enter go there • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func cmdSummary(m *model, args string) tea.Cmd {
+	switch strings.TrimSpace(args) {
+	case "":
+		entries := m.unreadSummary()
+		if len(entries) == 0 {
+			m.notice("Nothing unread")
+			return nil
+		}
+		m.showSummary(entries)
+		return nil
+	case "on":
+		m.settings.StartupSummary = true
+		m.notice("The unread summary shows on starting up")
+	case "off":
+		m.settings.StartupSummary = false
+		m.notice("The unread summary only shows with /summary")
+	default:
+		m.notice("Usage: /summary [on|off]")
+		return nil
+	}
+	return saveSettingsCmd(m.settings)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sahilm/fuzzy"
+)
+
+const switcherMaxResults = 10
+
+type switcherKind int
+
+const (
+	switchChannel switcherKind = iota
+	switchDM
+	switchUser
+)
+
+type switcherEntry struct {
+	kind       switcherKind
+	name       string // channel or DM buffer name, or nick for users
+	unread     int
+	lastActive time.Time
+}
+
+func (e switcherEntry) label() string {
+	if e.kind == switchUser {
+		return "@" + e.name
+	}
+	return e.name
+}
+
+type switcherEntries []switcherEntry
+
+func (s switcherEntries) String(i int) string { return s[i].label() }
+func (s switcherEntries) Len() int            { return len(s) }
+
+// Messages emitted by the switcher and handled by the model.
+type switchBufferMsg struct{ name string }
+type openDMMsg struct{ nick string }
+
+// switcher is the ctrl+k quick-switch overlay over every channel, DM and
+// known user.
+type switcher struct {
+	input   textinput.Model
+	entries switcherEntries
+	results []switcherEntry
+	cursor  int
+}
+
+func newSwitcher(m *model) *switcher {
+	ti := newTextInput()
+	ti.Placeholder = "Jump to…"
+	ti.Prompt = "> "
+	ti.PromptStyle = overlayPromptStyle
+	ti.Focus()
+
+	s := &switcher{input: ti}
+
+	seen := map[string]bool{m.nick: true}
+	for _, ch := range m.channels {
+		kind := switchChannel
+		if ch.isDM() {
+			kind = switchDM
+			seen[strings.TrimPrefix(ch.name, "@")] = true
+		}
+		s.entries = append(s.entries, switcherEntry{
+			kind:       kind,
+			name:       ch.name,
+			unread:     ch.unread,
+			lastActive: ch.lastActive,
+		})
+	}
+	for _, ch := range m.channels {
+		for nick := range ch.members {
+			if seen[nick] {
+				continue
+			}
+			seen[nick] = true
+			s.entries = append(s.entries, switcherEntry{kind: switchUser, name: nick})
+		}
+	}
+
+	s.filter()
+	return s
+}
+
+// rankLess orders entries with unread activity first, then by recency.
+func rankLess(a, b switcherEntry) bool {
+	if (a.unread > 0) != (b.unread > 0) {
+		return a.unread > 0
+	}
+	if !a.lastActive.Equal(b.lastActive) {
+		return a.lastActive.After(b.lastActive)
+	}
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	return a.label() < b.label()
+}
+
+func (s *switcher) filter() {
+	query := strings.TrimSpace(s.input.Value())
+	s.results = s.results[:0]
+	s.cursor = 0
+
+	if query == "" {
+		s.results = append(s.results, s.entries...)
+		sort.SliceStable(s.results, func(i, j int) bool { return rankLess(s.results[i], s.results[j]) })
+	} else {
+		matches := fuzzy.FindFrom(query, s.entries)
+		sort.SliceStable(matches, func(i, j int) bool {
+			if matches[i].Score != matches[j].Score {
+				return matches[i].Score > matches[j].Score
+			}
+			return rankLess(s.entries[matches[i].Index], s.entries[matches[j].Index])
+		})
+		for _, match := range matches {
+			s.results = append(s.results, s.entries[match.Index])
+		}
+	}
+
+	if len(s.results) > switcherMaxResults {
+		s.results = s.results[:switcherMaxResults]
+	}
+}
+
+func (s *switcher) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return s, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		if s.cursor > 0 {
+			s.cursor--
+		}
+		return s, nil
+	case key.Matches(keyMsg, keys.Down):
+		if s.cursor < len(s.results)-1 {
+			s.cursor++
+		}
+		return s, nil
+	case key.Matches(keyMsg, keys.Select):
+		if len(s.results) == 0 {
+			return s, nil
+		}
+		e := s.results[s.cursor]
+		if e.kind == switchUser {
+			return nil, func() tea.Msg { return openDMMsg{nick: e.name} }
+		}
+		return nil, func() tea.Msg { return switchBufferMsg{name: e.name} }
+	}
+
+	var cmd tea.Cmd
+	prev := s.input.Value()
+	s.input, cmd = s.input.Update(msg)
+	if s.input.Value() != prev {
+		s.filter()
+	}
+	return s, cmd
+}
+
+func (s *switcher) View(width, height int) string {
+	w := 50
+	if width-4 < w {
+		w = width - 4
+	}
+
+	lines := []string{s.input.View(), ""}
+	if len(s.results) == 0 {
+		lines = append(lines, overlayHintStyle.Render("No matches"))
+	}
+	for i, e := range s.results {
+		label := truncate(e.label(), w-8)
+		if e.kind == switchUser {
+			label = overlayHintStyle.Render(label)
+		}
+		if e.unread > 0 {
+			label += " " + badgeStyle.Render(strconv.Itoa(e.unread))
+		}
+		if i == s.cursor {
+			lines = append(lines, overlaySelectedStyle.Width(w-2).Render("› "+label))
+		} else {
+			lines = append(lines, "  "+label)
+		}
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ move • enter open • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
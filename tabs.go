@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// tabIndex returns the 0-based buffer index for an alt+N key, or -1.
+func tabIndex(k string) int {
+	if len(k) != len("alt+1") || !strings.HasPrefix(k, "alt+") {
+		return -1
+	}
+	d := k[len(k)-1]
+	if d < '1' || d > '9' {
+		return -1
+	}
+	return int(d - '1')
+}
+
+// renderTabBar renders the open buffers as numbered tabs, colored by
+// activity like classic IRC clients' act bars, and records where each tab
+// is for clicking, the bar being drawn at x, y.
+func (m *model) renderTabBar(x, y, width int) string {
+	tabs := make([]string, 0, len(m.channels))
+	m.tabAreas = m.tabAreas[:0]
+	used := 0
+	for i, ch := range m.channels {
+		label := fmt.Sprintf("%d:%s", i+1, ch.name)
+		if i >= 9 {
+			label = ch.name
+		}
+		if ch.unread > 0 {
+			label += fmt.Sprintf("(%d)", ch.unread)
+		}
+		style := tabStyle
+		switch {
+		case i == m.active:
+			style = tabActiveStyle
+		case m.isMuted(ch.name):
+			style = sidebarMutedStyle
+		case ch.mentions > 0:
+			style = tabHighlightStyle
+		case ch.unread > 0:
+			style = tabActivityStyle
+		}
+		tab := style.Render(label)
+		tabs = append(tabs, tab)
+		m.tabAreas = append(m.tabAreas, rect{x: x + used, y: y, w: max(min(lipgloss.Width(tab), width-used), 0), h: 1})
+		used += lipgloss.Width(tab) + 1
+	}
+	return ansi.Truncate(strings.Join(tabs, " "), width, "…")
+}
+
+// handleTabMouse switches to the buffer whose tab is clicked.
+func (m *model) handleTabMouse(msg tea.MouseMsg) bool {
+	if msg.Action != tea.MouseActionRelease {
+		return false
+	}
+	for i, r := range m.tabAreas {
+		if r.contains(msg.X, msg.Y) {
+			m.setActive(i)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Desktop notifications can also go through the terminal, as an escape
+// sequence it turns into a notification of its own: OSC 9 for kitty,
+// WezTerm, iTerm2 and Windows Terminal, and OSC 777 for foot, Ghostty,
+// urxvt and the rest. That works over SSH, where notify-send would pop up
+// on the wrong machine if at all, so it is what is used there, and where
+// there is no notify-send. "/desktop osc" always uses it and "/desktop
+// native" never, "/desktop auto" going back to choosing. Inside tmux the
+// sequence is passed through to the terminal, which takes
+// "set -g allow-passthrough on".
+
+// How desktop notifications are shown, settings.DesktopVia.
+const (
+	desktopAuto   = ""
+	desktopOSC    = "osc"
+	desktopNative = "native"
+)
+
+// overSSH reports whether gochat runs in an SSH session.
+func overSSH() bool {
+	return os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+}
+
+// useOSCNotify reports whether notifications go through the terminal,
+// via asks for.
+func useOSCNotify(via string) bool {
+	switch via {
+	case desktopOSC:
+		return true
+	case desktopNative:
+		return false
+	}
+	return overSSH() || desktopNotifier() == ""
+}
+
+// oscNotifyCode is the OSC the terminal takes notifications in, 9 or 777.
+func oscNotifyCode() string {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case term == "xterm-kitty" || os.Getenv("KITTY_WINDOW_ID") != "",
+		program == "WezTerm" || strings.HasPrefix(term, "wezterm"),
+		program == "iTerm.app",
+		os.Getenv("WT_SESSION") != "":
+		return "9"
+	}
+	return "777"
+}
+
+// oscNotification is the escape sequence showing note, with what could
+// end it early taken out of the text, since anyone can write that.
+func oscNotification(note desktopNote) string {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f || r >= 0x80 && r < 0xa0 {
+				return ' '
+			}
+			return r
+		}, s)
+	}
+	var seq string
+	if oscNotifyCode() == "9" {
+		seq = "\x1b]9;" + clean(note.title+": "+note.body) + "\x1b\\"
+	} else {
+		// The title ends at the first ;
+		seq = "\x1b]777;notify;" + strings.ReplaceAll(clean(note.title), ";", ",") + ";" + clean(note.body) + "\x1b\\"
+	}
+	if os.Getenv("TMUX") != "" {
+		// Passed through, with the escapes inside doubled
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	return seq
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// The UI is drawn in the colors of a theme. Besides the default one,
+// catppuccin, gruvbox, solarized and high-contrast are built in, picked in the config file
+// and changed there as wanted:
+//
+//	[theme]
+//	palette = "gruvbox"
+//	accent = "#d3869b"
+//
+// Each comes in a version for dark terminals and one for light ones, going
+// by the background the terminal reports unless mode says which. Colors
+// changed under [theme.light] only apply to the light one.
+//
+// More can be defined under [themes], each starting from another palette
+// and picked the same way:
+//
+//	[themes.dusk]
+//	palette = "catppuccin"
+//	accent = "#fab387"
+//	nicks = ["#89b4fa", "#a6e3a1", "#f9e2af"]
+//
+// Colors are numbers of the 256-color palette or "#rrggbb".
+//
+// On terminals with fewer colors, the theme is drawn in what they have: 256
+// colors take the nearest of each, and 16 the terminal's own, each part of
+// the UI getting that of ansiTheme unless the theme already gave it one of
+// them. NO_COLOR, or a terminal with none, sets apart in reverse video what
+// only colors did. [ui] colors says which when the terminal can't be relied
+// on to tell.
+//
+// Focus never shows by color alone: the focused box has a thick border and
+// its prompt is bold, whatever the theme.
+
+// theme is the color of each part of the UI.
+type theme struct {
+	Accent    lipgloss.Color   `toml:"accent" yaml:"accent"`       // focus, titles, the current buffer
+	Text      lipgloss.Color   `toml:"text" yaml:"text"`           // nicks in lists, unread buffers, badges
+	Subtext   lipgloss.Color   `toml:"subtext" yaml:"subtext"`     // the network switcher
+	Badge     lipgloss.Color   `toml:"badge" yaml:"badge"`         // text on the accent and error colors
+	Dim       lipgloss.Color   `toml:"dim" yaml:"dim"`             // borders, timestamps, offline members
+	Muted     lipgloss.Color   `toml:"muted" yaml:"muted"`         // hints, topics, read buffers
+	Faint     lipgloss.Color   `toml:"faint" yaml:"faint"`         // muted buffers
+	Error     lipgloss.Color   `toml:"error" yaml:"error"`         // errors, mentions, the unread line
+	Warning   lipgloss.Color   `toml:"warning" yaml:"warning"`     // away members, unverified messages
+	Online    lipgloss.Color   `toml:"online" yaml:"online"`       // online members
+	Selection lipgloss.Color   `toml:"selection" yaml:"selection"` // the selected row of a list
+	Surface   lipgloss.Color   `toml:"surface" yaml:"surface"`     // the selected message
+	Reaction  lipgloss.Color   `toml:"reaction" yaml:"reaction"`
+	Pending   lipgloss.Color   `toml:"pending" yaml:"pending"` // messages not sent yet
+	Nicks     []lipgloss.Color `toml:"nicks" yaml:"nicks"`     // picked from for each nick
+}
+
+// builtinTheme is a palette, in its version for dark backgrounds and the
+// one for light ones.
+type builtinTheme struct {
+	dark, light theme
+}
+
+var defaultTheme = theme{
+	Accent:    "212",
+	Text:      "#FFFFFF",
+	Subtext:   "252",
+	Badge:     "#FFFFFF",
+	Dim:       "240",
+	Muted:     "243",
+	Faint:     "238",
+	Error:     "203",
+	Warning:   "214",
+	Online:    "42",
+	Selection: "237",
+	Surface:   "236",
+	Reaction:  "180",
+	Pending:   "245",
+	Nicks:     []lipgloss.Color{"39", "42", "81", "117", "141", "170", "208", "214"},
+}
+
+// builtinThemes are the palettes that can be picked by name.
+var builtinThemes = map[string]builtinTheme{
+	"default": {
+		dark: defaultTheme,
+		light: theme{
+			Accent:    "162",
+			Text:      "235",
+			Subtext:   "238",
+			Badge:     "#FFFFFF",
+			Dim:       "247",
+			Muted:     "242",
+			Faint:     "250",
+			Error:     "160",
+			Warning:   "166",
+			Online:    "28",
+			Selection: "254",
+			Surface:   "255",
+			Reaction:  "130",
+			Pending:   "245",
+			Nicks:     []lipgloss.Color{"25", "28", "31", "54", "90", "130", "166", "124"},
+		},
+	},
+	// Catppuccin Mocha, and Latte on light backgrounds
+	"catppuccin": {
+		dark: theme{
+			Accent:    "#cba6f7",
+			Text:      "#cdd6f4",
+			Subtext:   "#bac2de",
+			Badge:     "#1e1e2e",
+			Dim:       "#6c7086",
+			Muted:     "#9399b2",
+			Faint:     "#45475a",
+			Error:     "#f38ba8",
+			Warning:   "#f9e2af",
+			Online:    "#a6e3a1",
+			Selection: "#45475a",
+			Surface:   "#313244",
+			Reaction:  "#fab387",
+			Pending:   "#a6adc8",
+			Nicks:     []lipgloss.Color{"#89b4fa", "#a6e3a1", "#94e2d5", "#89dceb", "#b4befe", "#f5c2e7", "#fab387", "#f9e2af"},
+		},
+		light: theme{
+			Accent:    "#8839ef",
+			Text:      "#4c4f69",
+			Subtext:   "#5c5f77",
+			Badge:     "#eff1f5",
+			Dim:       "#9ca0b0",
+			Muted:     "#7c7f93",
+			Faint:     "#bcc0cc",
+			Error:     "#d20f39",
+			Warning:   "#df8e1d",
+			Online:    "#40a02b",
+			Selection: "#ccd0da",
+			Surface:   "#e6e9ef",
+			Reaction:  "#fe640b",
+			Pending:   "#6c6f85",
+			Nicks:     []lipgloss.Color{"#1e66f5", "#40a02b", "#179299", "#04a5e5", "#7287fd", "#ea76cb", "#fe640b", "#df8e1d"},
+		},
+	},
+	"gruvbox": {
+		dark: theme{
+			Accent:    "#fe8019",
+			Text:      "#ebdbb2",
+			Subtext:   "#d5c4a1",
+			Badge:     "#282828",
+			Dim:       "#665c54",
+			Muted:     "#928374",
+			Faint:     "#504945",
+			Error:     "#fb4934",
+			Warning:   "#fabd2f",
+			Online:    "#b8bb26",
+			Selection: "#504945",
+			Surface:   "#3c3836",
+			Reaction:  "#d79921",
+			Pending:   "#a89984",
+			Nicks:     []lipgloss.Color{"#83a598", "#b8bb26", "#8ec07c", "#d3869b", "#fabd2f", "#fe8019", "#458588", "#689d6a"},
+		},
+		light: theme{
+			Accent:    "#af3a03",
+			Text:      "#3c3836",
+			Subtext:   "#504945",
+			Badge:     "#fbf1c7",
+			Dim:       "#a89984",
+			Muted:     "#7c6f64",
+			Faint:     "#d5c4a1",
+			Error:     "#9d0006",
+			Warning:   "#b57614",
+			Online:    "#79740e",
+			Selection: "#ebdbb2",
+			Surface:   "#f2e5bc",
+			Reaction:  "#d65d0e",
+			Pending:   "#928374",
+			Nicks:     []lipgloss.Color{"#076678", "#79740e", "#427b58", "#8f3f71", "#b57614", "#af3a03", "#458588", "#689d6a"},
+		},
+	},
+	// Text at 7:1 contrast or more (WCAG AAA) against the background
+	"high-contrast": {
+		dark: theme{
+			Accent:    "#ffff00",
+			Text:      "#ffffff",
+			Subtext:   "#ffffff",
+			Badge:     "#000000",
+			Dim:       "#bcbcbc",
+			Muted:     "#d0d0d0",
+			Faint:     "#a8a8a8",
+			Error:     "#ff8080",
+			Warning:   "#ffc000",
+			Online:    "#00ff80",
+			Selection: "#0000a0",
+			Surface:   "#1c1c1c",
+			Reaction:  "#ffd75f",
+			Pending:   "#bcbcbc",
+			Nicks:     []lipgloss.Color{"#5fd7ff", "#00ff80", "#ffff5f", "#ff87ff", "#ffaf00", "#87afff", "#ff8787", "#d7ff87"},
+		},
+		light: theme{
+			Accent:    "#0000c0",
+			Text:      "#000000",
+			Subtext:   "#000000",
+			Badge:     "#ffffff",
+			Dim:       "#595959",
+			Muted:     "#262626",
+			Faint:     "#595959",
+			Error:     "#a00000",
+			Warning:   "#7a4000",
+			Online:    "#005a00",
+			Selection: "#ffff00",
+			Surface:   "#e4e4e4",
+			Reaction:  "#6b3800",
+			Pending:   "#4a4a4a",
+			Nicks:     []lipgloss.Color{"#00008b", "#005a00", "#8b0000", "#4b0082", "#005050", "#7a3e00", "#5f005f", "#003f7f"},
+		},
+	},
+	"solarized": {
+		dark: theme{
+			Accent:    "#268bd2",
+			Text:      "#93a1a1",
+			Subtext:   "#839496",
+			Badge:     "#fdf6e3",
+			Dim:       "#586e75",
+			Muted:     "#657b83",
+			Faint:     "#4e5f65",
+			Error:     "#dc322f",
+			Warning:   "#b58900",
+			Online:    "#859900",
+			Selection: "#073642",
+			Surface:   "#073642",
+			Reaction:  "#cb4b16",
+			Pending:   "#657b83",
+			Nicks:     []lipgloss.Color{"#268bd2", "#2aa198", "#859900", "#b58900", "#cb4b16", "#d33682", "#6c71c4", "#dc322f"},
+		},
+		light: theme{
+			Accent:    "#268bd2",
+			Text:      "#586e75",
+			Subtext:   "#657b83",
+			Badge:     "#fdf6e3",
+			Dim:       "#93a1a1",
+			Muted:     "#839496",
+			Faint:     "#c3c9c2",
+			Error:     "#dc322f",
+			Warning:   "#b58900",
+			Online:    "#859900",
+			Selection: "#eee8d5",
+			Surface:   "#eee8d5",
+			Reaction:  "#cb4b16",
+			Pending:   "#839496",
+			Nicks:     []lipgloss.Color{"#268bd2", "#2aa198", "#859900", "#b58900", "#cb4b16", "#d33682", "#6c71c4", "#dc322f"},
+		},
+	},
+}
+
+// ansiTheme is what 16-color terminals draw theme parts in, their own
+// colors, on dark backgrounds and light ones.
+var ansiTheme = builtinTheme{
+	dark: theme{
+		Accent:    "13",
+		Text:      "15",
+		Subtext:   "7",
+		Badge:     "0",
+		Dim:       "8",
+		Muted:     "7",
+		Faint:     "8",
+		Error:     "9",
+		Warning:   "11",
+		Online:    "10",
+		Selection: "8",
+		Surface:   "8",
+		Reaction:  "3",
+		Pending:   "7",
+		Nicks:     []lipgloss.Color{"12", "10", "14", "13", "11", "4", "2", "6"},
+	},
+	light: theme{
+		Accent:    "5",
+		Text:      "0",
+		Subtext:   "8",
+		Badge:     "15",
+		Dim:       "8",
+		Muted:     "8",
+		Faint:     "7",
+		Error:     "1",
+		Warning:   "3",
+		Online:    "2",
+		Selection: "7",
+		Surface:   "7",
+		Reaction:  "3",
+		Pending:   "8",
+		Nicks:     []lipgloss.Color{"4", "2", "6", "5", "3", "12", "1", "13"},
+	},
+}
+
+// palette is the current theme, see setTheme.
+var palette theme
+
+func init() {
+	setTheme(defaultTheme)
+}
+
+// themeConfig picks a theme in the config file: a palette, built in or
+// from [themes], with the colors set here changed, and those under light
+// too on light backgrounds.
+type themeConfig struct {
+	Palette string `toml:"palette" yaml:"palette"` // default unless set
+	// Mode is dark, light or auto (the default), which asks the terminal
+	// for its background. Only [theme]'s counts.
+	Mode  string `toml:"mode" yaml:"mode"`
+	theme `yaml:",inline"`
+	Light *theme `toml:"light" yaml:"light"`
+}
+
+// resolveTheme is the theme c picks, custom holding the config file's own.
+func resolveTheme(c themeConfig, custom map[string]themeConfig) (theme, error) {
+	dark, err := darkBackground(c.Mode)
+	if err != nil {
+		return defaultTheme, err
+	}
+	t, err := resolveThemeFrom(c, custom, dark, nil)
+	if err == nil && lipgloss.ColorProfile() == termenv.ANSI {
+		t = reduceTheme(t, dark)
+	}
+	return t, err
+}
+
+// setColors sets how many colors to draw in, as [ui] colors says: auto
+// (the default) goes by the terminal and NO_COLOR, truecolor, 256, 16 or
+// none say.
+func setColors(colors string) error {
+	switch colors {
+	case "", "auto":
+		lipgloss.SetColorProfile(termenv.NewOutput(os.Stdout).EnvColorProfile())
+	case "truecolor":
+		lipgloss.SetColorProfile(termenv.TrueColor)
+	case "256":
+		lipgloss.SetColorProfile(termenv.ANSI256)
+	case "16":
+		lipgloss.SetColorProfile(termenv.ANSI)
+	case "none":
+		lipgloss.SetColorProfile(termenv.Ascii)
+	default:
+		return fmt.Errorf("ui: colors is auto, truecolor, 256, 16 or none, not %q", colors)
+	}
+	return nil
+}
+
+// reduceTheme gives the parts of t not in one of the 16 colors that of
+// ansiTheme instead, which the nearest one would often blur together.
+func reduceTheme(t theme, dark bool) theme {
+	ansi := ansiTheme.dark
+	if !dark {
+		ansi = ansiTheme.light
+	}
+	dst, src := reflect.ValueOf(&t).Elem(), reflect.ValueOf(ansi)
+	for i := range dst.NumField() {
+		switch v := dst.Field(i).Interface().(type) {
+		case lipgloss.Color:
+			if ansiColor(v) {
+				continue
+			}
+		case []lipgloss.Color:
+			if !slices.ContainsFunc(v, func(c lipgloss.Color) bool { return !ansiColor(c) }) {
+				continue
+			}
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+	return t
+}
+
+// ansiColor is whether c is one of the 16 colors.
+func ansiColor(c lipgloss.Color) bool {
+	n, err := strconv.Atoi(string(c))
+	return err == nil && n < 16
+}
+
+// darkBackground is whether to use the dark versions of palettes in mode.
+func darkBackground(mode string) (bool, error) {
+	switch mode {
+	case "", "auto":
+		return lipgloss.HasDarkBackground(), nil
+	case "dark":
+		return true, nil
+	case "light":
+		return false, nil
+	}
+	return true, fmt.Errorf("theme: mode is dark, light or auto, not %q", mode)
+}
+
+// resolveThemeFrom resolves c, seen being the custom themes it was reached
+// through, which it can't start from again.
+func resolveThemeFrom(c themeConfig, custom map[string]themeConfig, dark bool, seen []string) (theme, error) {
+	t := defaultTheme
+	if c.Palette != "" {
+		base, ok := custom[c.Palette]
+		switch {
+		case ok && slices.Contains(seen, c.Palette):
+			return t, fmt.Errorf("theme %s starts from itself", c.Palette)
+		case ok:
+			var err error
+			if t, err = resolveThemeFrom(base, custom, dark, append(seen, c.Palette)); err != nil {
+				return t, err
+			}
+		default:
+			builtin, ok := builtinThemes[c.Palette]
+			if !ok {
+				return t, fmt.Errorf("there is no theme %q, want one of %s or one from [themes]", c.Palette, themeNames())
+			}
+			t = builtin.dark
+			if !dark {
+				t = builtin.light
+			}
+		}
+	} else if !dark {
+		t = builtinThemes["default"].light
+	}
+	t, err := overlayTheme(t, c.theme)
+	if err == nil && !dark && c.Light != nil {
+		t, err = overlayTheme(t, *c.Light)
+	}
+	return t, err
+}
+
+// overlayTheme changes the colors of t that changes sets.
+func overlayTheme(t, changes theme) (theme, error) {
+	dst, src := reflect.ValueOf(&t).Elem(), reflect.ValueOf(changes)
+	for i := range src.NumField() {
+		name := src.Type().Field(i).Tag.Get("toml")
+		switch v := src.Field(i).Interface().(type) {
+		case lipgloss.Color:
+			if v == "" {
+				continue
+			}
+			if !validColor(string(v)) {
+				return t, fmt.Errorf("theme: %s is %q, not a color number or #rrggbb", name, v)
+			}
+		case []lipgloss.Color:
+			if len(v) == 0 {
+				continue
+			}
+			for _, c := range v {
+				if !validColor(string(c)) {
+					return t, fmt.Errorf("theme: %q in %s isn't a color number or #rrggbb", c, name)
+				}
+			}
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+	return t, nil
+}
+
+var colorRE = regexp.MustCompile(`^(#[0-9a-fA-F]{6}|[0-9]{1,3})$`)
+
+func validColor(c string) bool {
+	if !colorRE.MatchString(c) {
+		return false
+	}
+	n, err := strconv.Atoi(c)
+	return err != nil || n < 256
+}
+
+func themeNames() string {
+	names := make([]string, 0, len(builtinThemes))
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The terminal's title follows the buffer in view and what is unread,
+// "gochat — #general (3 unread, 1 mention)", which inside tmux is the
+// pane's title too. With [ui] window_name = true the client also renames
+// its tmux or screen window, to "gochat", then "gochat 3" with unread
+// messages and "gochat 3!" when one mentions us, for their window lists to
+// show activity. tmux only lets it with "set -g allow-rename on".
+
+// windowTitle is the terminal title for what is shown.
+func (m *model) windowTitle() string {
+	title := "gochat"
+	if ch := m.activeChannel(); ch != nil {
+		title += " — " + ch.name
+	}
+	switch unread, mentions := m.unreadTotals(); {
+	case mentions > 0:
+		title += " (" + strconv.Itoa(unread) + " unread, " + plural(mentions, "mention") + ")"
+	case unread > 0:
+		title += " (" + strconv.Itoa(unread) + " unread)"
+	}
+	return title
+}
+
+// windowName is the tmux or screen window name for what is unread.
+func (m *model) windowName() string {
+	name := "gochat"
+	unread, mentions := m.unreadTotals()
+	if unread > 0 {
+		name += " " + strconv.Itoa(unread)
+	}
+	if mentions > 0 {
+		name += "!"
+	}
+	return name
+}
+
+// inMultiplexer is whether the client runs in tmux or screen.
+func inMultiplexer() bool {
+	return os.Getenv("TMUX") != "" || os.Getenv("STY") != ""
+}
+
+// updateTitle sets the terminal title, and the window name if asked to,
+// when they changed.
+func (m *model) updateTitle() tea.Cmd {
+	if m.background {
+		return nil
+	}
+	var cmds []tea.Cmd
+	if title := m.windowTitle(); title != m.title {
+		m.title = title
+		cmds = append(cmds, tea.SetWindowTitle(title))
+	}
+	if !m.opts.ui.WindowName || !inMultiplexer() {
+		return tea.Batch(cmds...)
+	}
+	if name := m.windowName(); name != m.windowNamed {
+		m.windowNamed = name
+		cmds = append(cmds, writeTerminal("\x1bk"+name+"\x1b\\"))
+	}
+	return tea.Batch(cmds...)
+}
+
+// writeTerminal writes seq straight to the terminal, for what Bubble Tea
+// has no command for and that doesn't move the cursor.
+func writeTerminal(seq string) tea.Cmd {
+	return func() tea.Msg {
+		io.WriteString(os.Stdout, seq)
+		return nil
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Logging in with a password gets the client a session: a short-lived
+// access token, a JWT the server can check without looking anything up,
+// and a refresh token, which the server keeps (hashed) until it expires or
+// is used. The client stores both and reconnects with them instead of the
+// password. Before the access token runs out the client trades the refresh
+// token for a new pair, and a server that doesn't take the access token
+// (it expired, or was signed by another instance or before a restart)
+// falls back to the refresh token too. Each refresh token works once.
+//
+// The tokens of one login, however often they are refreshed, are a device,
+// which the user can see and revoke, see devices.go. An access token names
+// its device and only works while the device does.
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// refreshEarly is how long before its access token expires a client
+	// refreshes it.
+	refreshEarly = time.Minute
+)
+
+var errSessionExpired = errors.New("your session has expired, log in again")
+
+// --- Server side ---
+
+// refreshToken is what the server keeps of one it issued.
+type refreshToken struct {
+	Hash string `json:"hash"` // SHA-256 of the token, hex
+	Nick string `json:"nick"`
+	// Device stays the same when the token is refreshed, with what the
+	// client said it is, where it connected from and when it logged in
+	Device  string    `json:"device,omitempty"`
+	Client  string    `json:"client,omitempty"`
+	Addr    string    `json:"addr,omitempty"`
+	Started time.Time `json:"started,omitzero"`
+	Created time.Time `json:"created"` // when the device was last active
+	Expires time.Time `json:"expires"`
+}
+
+// accessClaims are what an access token says.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Device string `json:"sid,omitempty"`
+}
+
+// tokenHash is how a refresh token is looked up.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokensLocked starts a session for s.nick, on a new device unless
+// it is refreshing one.
+func (srv *server) issueTokensLocked(s *session) (*sessionTokens, error) {
+	now := s.stamp.Time
+	if s.device == "" {
+		s.device, s.deviceStarted = newID(), now
+	}
+	expires := now.Add(accessTokenTTL)
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   s.nick,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+		Device: s.device,
+	}).SignedString(srv.tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	rt := refreshToken{Hash: tokenHash(refresh), Nick: s.nick, Device: s.device, Client: s.client, Addr: s.addr(),
+		Started: s.deviceStarted, Created: now, Expires: now.Add(refreshTokenTTL)}
+	if err := s.persistLocked(func(st serverStore) error { return st.saveRefreshToken(rt) }); err != nil {
+		return nil, err
+	}
+	srv.refreshTokens[rt.Hash] = rt
+	srv.publish(clusterEvent{Kind: clusterToken, Nick: s.nick, Token: &rt})
+	return &sessionTokens{Access: access, Refresh: refresh, Expires: expires}, nil
+}
+
+// checkAccessToken returns the device of token if it is a current access
+// token of nick, signed by this server, and the device wasn't revoked.
+func (srv *server) checkAccessToken(token, nick string) (string, bool) {
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) { return srv.tokenKey, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Subject != nick {
+		return "", false
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	_, ok := srv.deviceLocked(nick, claims.Device)
+	return claims.Device, ok
+}
+
+// refreshLocked trades a refresh token of s.nick for a new session. The
+// old one is used up either way.
+func (srv *server) refreshLocked(s *session, refresh string) (*sessionTokens, error) {
+	rt, ok := srv.refreshTokens[tokenHash(refresh)]
+	if !ok || rt.Nick != s.nick {
+		return nil, errSessionExpired
+	}
+	srv.revokeLocked(s, rt)
+	if !s.stamp.Time.Before(rt.Expires) {
+		return nil, errSessionExpired
+	}
+	// Tokens from before devices start one
+	s.device, s.deviceStarted = rt.Device, rt.Started
+	return srv.issueTokensLocked(s)
+}
+
+// revokeLocked forgets a refresh token, here and on the other instances.
+func (srv *server) revokeLocked(s *session, rt refreshToken) {
+	delete(srv.refreshTokens, rt.Hash)
+	s.persistLocked(func(st serverStore) error { return st.deleteRefreshToken(rt.Hash) })
+	srv.publish(clusterEvent{Kind: clusterTokenRevoked, Nick: rt.Nick, Token: &rt})
+}
+
+// resumeSession logs in with the tokens of a hello, if it has any. It
+// returns a new session when the refresh token had to be used, nil when
+// the access token was good.
+func (srv *server) resumeSession(s *session, hello helloData) (*sessionTokens, error) {
+	if hello.Token != "" {
+		if device, ok := srv.checkAccessToken(hello.Token, s.nick); ok {
+			s.device = device
+			return nil, nil
+		}
+	}
+	if hello.Refresh == "" {
+		return nil, errSessionExpired
+	}
+	if err := srv.recheckDirectory(s); err != nil {
+		return nil, err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.refreshLocked(s, hello.Refresh)
+}
+
+// handleRefresh trades the caller's refresh token for a new session
+// before its access token expires.
+func (srv *server) handleRefresh(s *session, f frame) error {
+	var req sessionTokens
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if err := srv.recheckDirectory(s); err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	tokens, err := srv.refreshLocked(s, req.Refresh)
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.reply(f, newFrame(frameTokens, tokens))
+	return nil
+}
+
+// --- Client side ---
+
+// refreshTickMsg says it is time to refresh the session of c.
+type refreshTickMsg struct{ c *client }
+
+// scheduleRefresh refreshes the session of c shortly before its access
+// token expires.
+func scheduleRefresh(c *client, tokens sessionTokens) tea.Cmd {
+	if tokens.Refresh == "" {
+		return nil
+	}
+	wait := max(time.Until(tokens.Expires)-refreshEarly, 0)
+	return tea.Tick(wait, func(time.Time) tea.Msg { return refreshTickMsg{c: c} })
+}
+
+// refreshSession asks for a new session with the refresh token of c's
+// network.
+func (m *model) refreshSession(c *client) tea.Cmd {
+	if c != m.client || c.network == nil {
+		return nil
+	}
+	_, cmd := c.send(frameRefresh, sessionTokens{Refresh: c.network.creds.tokens.Refresh})
+	return cmd
+}
+
+// applyTokens takes a new session for the network of c, and keeps it in
+// the store to reconnect with after a restart. The password isn't needed
+// anymore then.
+func (m *model) applyTokens(c *client, tokens sessionTokens) tea.Cmd {
+	n := c.network
+	if n == nil {
+		return nil
+	}
+	n.creds.tokens, n.creds.password = tokens, ""
+	m.saveSession(n)
+	return scheduleRefresh(c, tokens)
+}
+
+// saveSession stores the session of n, or forgets it if it has none.
+func (m *model) saveSession(n *network) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.saveSession(n.addr, n.creds.nick, n.creds.tokens); err != nil {
+		m.lastErr = err
+	}
+}
+
+// loadSessions picks up the stored sessions of the networks, so they
+// connect without asking for a password. One for another nick than --nick
+// asks for is left alone.
+func (m *model) loadSessions() {
+	if m.store == nil {
+		return
+	}
+	for _, n := range m.networks {
+		nick, tokens, err := m.store.loadSession(n.addr)
+		if err != nil {
+			m.lastErr = err
+			continue
+		}
+		if tokens.Refresh != "" && (m.opts.nick == "" || m.opts.nick == nick) {
+			n.creds.nick, n.creds.tokens = nick, tokens
+		}
+	}
+}
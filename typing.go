@@ -0,0 +1,186 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// While the composer has something that isn't a command in it, the buffer
+// shown hears that we are typing: once when it starts, again at most every
+// typingInterval while it goes on, and that we stopped once the message
+// went, the composer emptied or sat untouched for typingIdle. Keystrokes
+// between those send nothing. Those typing show in {typing} in the status
+// line, until they stop, post, or go quiet for typingTimeout, in case the
+// stop never came.
+
+const (
+	// typingInterval is how often we say again that we are typing.
+	typingInterval = 3 * time.Second
+	// typingIdle is how long the composer can go untouched before we say
+	// we stopped.
+	typingIdle = 5 * time.Second
+	// typingTimeout is how long someone shows as typing without saying so
+	// again.
+	typingTimeout = 8 * time.Second
+)
+
+// --- Server side ---
+
+// handleTyping tells a channel's other members, or the peer of a DM, that
+// the caller started or stopped typing there. Where they couldn't post it
+// is dropped without an error, as nothing waits for it.
+func (srv *server) handleTyping(s *session, f frame) error {
+	var req typingData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	req.Nick = s.nick
+
+	srv.mu.Lock()
+	var targets []*session
+	if peer, ok := strings.CutPrefix(req.Channel, "@"); ok {
+		if peer == s.nick || !srv.knownNickLocked(peer) {
+			srv.mu.Unlock()
+			return errNoSuchNick
+		}
+		if srv.mayPostLocked(s, nil) == nil {
+			targets = srv.sessionsOfLocked(peer)
+		}
+		req.Channel = "@" + s.nick
+	} else {
+		ch, ok := srv.channels[req.Channel]
+		if !ok {
+			srv.mu.Unlock()
+			return errNoSuchChannel
+		}
+		if _, member := ch.members[s.nick]; !member {
+			srv.mu.Unlock()
+			return errNotJoined
+		}
+		if !ch.archived && srv.mayPostLocked(s, ch) == nil && !ch.shadowBannedLocked(s.nick, s.stamp.Time) {
+			for sess := range srv.sessions {
+				if _, member := ch.members[sess.nick]; member && sess.nick != s.nick {
+					targets = append(targets, sess)
+				}
+			}
+		}
+	}
+	srv.mu.Unlock()
+
+	out := newFrame(frameTypingState, req)
+	for _, sess := range targets {
+		sess.conn.write(out)
+	}
+	return nil
+}
+
+// --- Client side ---
+
+type typingMsg struct {
+	channel, nick string
+	typing        bool
+}
+
+// typingExpiredMsg is due when someone would stop showing as typing, to
+// redraw the status line without them.
+type typingExpiredMsg struct{}
+
+// typingState is the typing we last told a server about.
+type typingState struct {
+	c       *client
+	channel string
+	sent    time.Time // when we last said we are typing
+	edited  time.Time // when the composer last changed
+}
+
+// typingIdleMsg is due typingIdle after the composer last changed, if it
+// hasn't since, for t.
+type typingIdleMsg struct{ t *typingState }
+
+// noteTyping tells the buffer shown that we are typing, if the composer
+// changed from before to something that would be posted there, and that
+// we stopped if it changed to anything else.
+func (m *model) noteTyping(before string) tea.Cmd {
+	text := m.messageInput.Value()
+	if text == before {
+		return nil
+	}
+	ch := m.activeChannel()
+	if ch == nil || m.client == nil || strings.TrimSpace(text) == "" || strings.HasPrefix(text, "/") || m.postBlocked(ch) != "" {
+		return m.stopTyping()
+	}
+	now := time.Now()
+	if t := m.typing; t != nil && t.c == m.client && t.channel == ch.name {
+		t.edited = now
+		if now.Sub(t.sent) < typingInterval {
+			return nil
+		}
+		t.sent = now
+		_, cmd := t.c.send(frameTyping, typingData{Channel: ch.name, Typing: true})
+		return cmd
+	}
+	stop := m.stopTyping()
+	t := &typingState{c: m.client, channel: ch.name, sent: now, edited: now}
+	m.typing = t
+	_, cmd := t.c.send(frameTyping, typingData{Channel: ch.name, Typing: true})
+	return tea.Batch(stop, cmd, tea.Tick(typingIdle, func(time.Time) tea.Msg { return typingIdleMsg{t: t} }))
+}
+
+// handleTypingIdle says we stopped typing if the composer has gone
+// untouched for typingIdle, or checks again when it will have.
+func (m *model) handleTypingIdle(msg typingIdleMsg) tea.Cmd {
+	if msg.t != m.typing {
+		return nil
+	}
+	idle := time.Since(msg.t.edited)
+	if idle >= typingIdle {
+		return m.stopTyping()
+	}
+	return tea.Tick(typingIdle-idle, func(time.Time) tea.Msg { return msg })
+}
+
+// stopTyping says we stopped typing, where we said we were.
+func (m *model) stopTyping() tea.Cmd {
+	t := m.typing
+	if t == nil {
+		return nil
+	}
+	m.typing = nil
+	_, cmd := t.c.send(frameTyping, typingData{Channel: t.channel})
+	return cmd
+}
+
+// typers returns who is typing in ch, by nick, forgetting those who have
+// gone quiet.
+func (c *channel) typers() []string {
+	var list []string
+	for nick, since := range c.typing {
+		if time.Since(since) >= typingTimeout {
+			delete(c.typing, nick)
+			continue
+		}
+		list = append(list, nick)
+	}
+	slices.Sort(list)
+	return list
+}
+
+func segmentTyping(m *model) string {
+	ch := m.activeChannel()
+	if ch == nil {
+		return ""
+	}
+	switch typers := ch.typers(); len(typers) {
+	case 0:
+		return ""
+	case 1:
+		return typers[0] + " is typing…"
+	case 2:
+		return typers[0] + " and " + typers[1] + " are typing…"
+	default:
+		return "several people are typing…"
+	}
+}
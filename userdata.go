@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Server admins, named with --admins, can export everything a user posted
+// as a JSON archive, and erase it. Erasing drops the user's messages and
+// the events naming them from every log, and puts a tombstone in their
+// place: a delete with no nick, which clients apply without a notice and
+// which reconnecting clients get in their catch-up like any other event.
+
+var errNotServerAdmin = errors.New("only server admins can do that")
+
+// --- Server side ---
+
+// conversationsLocked is every channel and DM log, by channel name or
+// dmKey.
+func (srv *server) conversationsLocked() map[string]*conversation {
+	convs := make(map[string]*conversation, len(srv.channels)+len(srv.dms))
+	for name, ch := range srv.channels {
+		convs[name] = &ch.conversation
+	}
+	for key, dm := range srv.dms {
+		convs[key] = dm
+	}
+	return convs
+}
+
+// bufferFor is the name nick knows a conversation by: a channel's name, or
+// @peer for one of their DMs. It returns false for DMs nick isn't in.
+func bufferFor(conv, nick string) (string, bool) {
+	a, b, isDM := strings.Cut(conv, "\x00")
+	switch {
+	case !isDM:
+		return conv, true
+	case a == nick:
+		return "@" + b, true
+	case b == nick:
+		return "@" + a, true
+	}
+	return "", false
+}
+
+// userArchiveLocked collects what nick has on the server. Messages come
+// with their earlier versions and, if deleted, when.
+func (srv *server) userArchiveLocked(nick string, now time.Time) userArchive {
+	a := userArchive{Nick: nick, Exported: now}
+	for conv, c := range srv.conversationsLocked() {
+		buffer, ok := bufferFor(conv, nick)
+		if !ok {
+			continue
+		}
+		posted := make(map[string]int) // index in a.Messages by ID
+		for _, ev := range c.events {
+			switch ev.Kind {
+			case eventMessage:
+				if ev.Message.Nick != nick {
+					continue
+				}
+				w := *ev.Message
+				w.Channel, w.Seq, w.Reactions = buffer, ev.Seq, nil
+				posted[w.ID] = len(a.Messages)
+				a.Messages = append(a.Messages, archivedMessage{wireMessage: w})
+			case eventEdit:
+				if i, ok := posted[ev.ID]; ok {
+					msg := &a.Messages[i]
+					msg.Revisions = append(msg.Revisions, revision{Text: msg.Text, Time: cmpTime(msg.Edited, msg.Time)})
+					msg.Text, msg.Edited, msg.Sig = ev.Text, ev.Time, ev.Sig
+				}
+			case eventDelete:
+				if i, ok := posted[ev.ID]; ok {
+					a.Messages[i].Deleted = ev.Time
+				}
+			case eventReaction:
+				if ev.Nick != nick {
+					continue
+				}
+				r := archivedReaction{Channel: buffer, ID: ev.ID, Emoji: ev.Emoji, Time: ev.Time}
+				a.Reactions = slices.DeleteFunc(a.Reactions, func(o archivedReaction) bool {
+					return o.Channel == r.Channel && o.ID == r.ID && o.Emoji == r.Emoji
+				})
+				if ev.Added {
+					a.Reactions = append(a.Reactions, r)
+				}
+			}
+		}
+	}
+	for name, ch := range srv.channels {
+		if r, member := ch.members[nick]; member {
+			a.Channels = append(a.Channels, archivedMembership{Channel: name, Role: r})
+		}
+	}
+	for _, r := range srv.reads[nick] {
+		a.Reads = append(a.Reads, r)
+	}
+	for _, e := range srv.kv[nick] {
+		a.Synced = append(a.Synced, e)
+	}
+
+	slices.SortFunc(a.Messages, func(x, y archivedMessage) int { return x.Time.Compare(y.Time) })
+	slices.SortFunc(a.Reactions, func(x, y archivedReaction) int { return x.Time.Compare(y.Time) })
+	slices.SortFunc(a.Channels, func(x, y archivedMembership) int { return strings.Compare(x.Channel, y.Channel) })
+	slices.SortFunc(a.Reads, func(x, y readData) int { return strings.Compare(x.Channel, y.Channel) })
+	slices.SortFunc(a.Synced, func(x, y kvData) int { return strings.Compare(x.Key, y.Key) })
+	return a
+}
+
+// cmpTime is t, or else fallback if t is zero.
+func cmpTime(t, fallback time.Time) time.Time {
+	if t.IsZero() {
+		return fallback
+	}
+	return t
+}
+
+// handleUserData sends an admin the archive of a user's data. Messages
+// are split over as many frames as it takes to stay under maxFrameSize,
+// the last one marked Done.
+func (srv *server) handleUserData(s *session, f frame) error {
+	var req userRef
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if !srv.isAdmin(s.nick) {
+		return errNotServerAdmin
+	}
+
+	srv.mu.Lock()
+	a := srv.userArchiveLocked(req.Nick, s.stamp.Time)
+	srv.mu.Unlock()
+	if len(a.Messages) == 0 && len(a.Reactions) == 0 && len(a.Channels) == 0 && len(a.Reads) == 0 && len(a.Synced) == 0 {
+		return errNoSuchNick
+	}
+
+	msgs := a.Messages
+	a.Messages = nil
+	size := 0
+	for _, msg := range msgs {
+		data, _ := json.Marshal(msg)
+		if size += len(data); size > maxFrameSize/2 && len(a.Messages) > 0 {
+			s.reply(f, newFrame(frameUserArchive, a))
+			a = userArchive{Nick: a.Nick, Exported: a.Exported}
+			size = len(data)
+		}
+		a.Messages = append(a.Messages, msg)
+	}
+	a.Done = true
+	s.reply(f, newFrame(frameUserArchive, a))
+	return nil
+}
+
+// erasableBy lists the messages nick posted in c, and the sequence numbers
+// of the other events that name them: their reactions and their joins and
+// parts. Deletes and removals they did are kept, those are about others.
+func (c *conversation) erasableBy(nick string) (ids []string, seqs []uint64) {
+	for _, ev := range c.events {
+		switch {
+		case ev.Kind == eventMessage && ev.Message.Nick == nick:
+			ids = append(ids, ev.Message.ID)
+		case ev.Kind == eventReaction && ev.Nick == nick,
+			(ev.Kind == eventJoin || ev.Kind == eventPart) && ev.Member == nick:
+			seqs = append(seqs, ev.Seq)
+		}
+	}
+	return ids, seqs
+}
+
+// forgetEvents drops events from the log by sequence number and replays
+// what is left, so the history, members and pins no longer have what they
+// added. The log keeps its numbering.
+func (c *conversation) forgetEvents(ch *serverChannel, seqs []uint64) {
+	gone := make(map[uint64]bool, len(seqs))
+	for _, seq := range seqs {
+		gone[seq] = true
+	}
+	evs := slices.DeleteFunc(c.events, func(ev serverEvent) bool { return gone[ev.Seq] })
+	c.events, c.history = nil, nil
+	var pinned []string
+	if ch != nil {
+		clear(ch.members)
+		pinned, ch.pins = messageIDs(ch.pins), nil
+	}
+	c.replay(ch, evs)
+	for _, id := range pinned {
+		if i := c.messageIndex(id); i >= 0 {
+			ch.pins = append(ch.pins, c.history[i])
+		}
+	}
+}
+
+// handleEraseUser erases a user, for admins: their messages, reactions and
+// membership everywhere, their read positions and synced values, and their
+// record in the store. Their messages are replaced by tombstones, sent to
+// everyone who had them, and channels they were in are sent again. Anyone
+// connected as them is disconnected.
+func (srv *server) handleEraseUser(s *session, f frame) error {
+	var req userRef
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+	if !srv.isAdmin(s.nick) {
+		return errNotServerAdmin
+	}
+	nick := req.Nick
+
+	type erased struct {
+		conv       string
+		tombstones []serverEvent
+	}
+	var done []erased
+	messages := 0
+
+	srv.mu.Lock()
+	for conv, c := range srv.conversationsLocked() {
+		if _, ok := bufferFor(conv, nick); !ok {
+			continue
+		}
+		ids, seqs := c.erasableBy(nick)
+		if len(ids) == 0 && len(seqs) == 0 {
+			continue
+		}
+		if err := s.persistLocked(func(st serverStore) error {
+			if err := st.forgetMessages(conv, ids); err != nil {
+				return err
+			}
+			return st.forgetEvents(conv, seqs)
+		}); err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		_, ch := srv.conversationLocked(conv)
+		c.forget(ids)
+		c.forgetEvents(ch, seqs)
+
+		tombstones := make([]serverEvent, len(ids))
+		for i, id := range ids {
+			tombstones[i] = serverEvent{Kind: eventDelete, Time: s.stamp.Time, ID: id}
+		}
+		tombstones, err := srv.appendLocked(s, conv, tombstones...)
+		if err != nil {
+			srv.mu.Unlock()
+			return err
+		}
+		done = append(done, erased{conv: conv, tombstones: tombstones})
+		messages += len(ids)
+	}
+	_, hadReads := srv.reads[nick]
+	_, hadKV := srv.kv[nick]
+	_, hadAccount := srv.accounts[nick]
+	delete(srv.reads, nick)
+	delete(srv.kv, nick)
+	delete(srv.accounts, nick)
+	delete(srv.e2eKeys, nick)
+	delete(srv.signingKeys, nick)
+	delete(srv.statuses, nick)
+	maps.DeleteFunc(srv.refreshTokens, func(_ string, t refreshToken) bool { return t.Nick == nick })
+	if err := s.persistLocked(func(st serverStore) error { return st.deleteUser(nick) }); err != nil {
+		srv.mu.Unlock()
+		return err
+	}
+	var theirs []*session
+	for sess := range srv.sessions {
+		if sess.nick == nick {
+			theirs = append(theirs, sess)
+		}
+	}
+	srv.mu.Unlock()
+	if len(done) == 0 && !hadReads && !hadKV && !hadAccount && len(theirs) == 0 {
+		return errNoSuchNick
+	}
+
+	for _, sess := range theirs {
+		sess.conn.close()
+	}
+	for _, e := range done {
+		a, b, isDM := strings.Cut(e.conv, "\x00")
+		if !isDM {
+			for _, fr := range eventFrames(e.conv, e.tombstones) {
+				srv.broadcast(e.conv, fr)
+			}
+			srv.resendChannel(e.conv)
+			continue
+		}
+		peer := a
+		if a == nick {
+			peer = b
+		}
+		srv.mu.Lock()
+		peers, _ := srv.dmSessionsLocked(nick, peer)
+		srv.mu.Unlock()
+		for _, sess := range peers {
+			for _, fr := range eventFrames("@"+nick, e.tombstones) {
+				sess.conn.write(fr)
+			}
+		}
+	}
+
+	s.reply(f, newFrame(frameUserErased, eraseResult{Nick: nick, Messages: messages}))
+	return nil
+}
+
+// resendChannel sends a channel's state again to everyone in it, after
+// its members and reactions changed under them.
+func (srv *server) resendChannel(name string) {
+	srv.mu.Lock()
+	var targets []*session
+	if ch, ok := srv.channels[name]; ok {
+		for sess := range srv.sessions {
+			if _, member := ch.members[sess.nick]; member {
+				targets = append(targets, sess)
+			}
+		}
+	}
+	srv.mu.Unlock()
+	for _, sess := range targets {
+		srv.sendChannelState(sess, name, 0)
+	}
+}
+
+// --- Client side ---
+
+// cmdUserData asks the server for a user's data, to be saved as JSON in
+// the current directory.
+func cmdUserData(m *model, args string) tea.Cmd {
+	nick := strings.TrimSpace(args)
+	if nick == "" || strings.ContainsAny(nick, " \t") {
+		m.notice("Usage: /userdata <nick>")
+		return nil
+	}
+	m.archive = nil
+	return m.request(frameUserData, userRef{Nick: nick})
+}
+
+// cmdErase asks the server to erase a user and everything they posted.
+func cmdErase(m *model, args string) tea.Cmd {
+	nick, confirm, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if nick == "" {
+		m.notice("Usage: /erase <nick> confirm")
+		return nil
+	}
+	if confirm != "confirm" {
+		m.notice("This erases everything " + nick + " posted, for everyone, and can't be undone. Run /erase " + nick + " confirm to go ahead")
+		return nil
+	}
+	return m.request(frameEraseUser, userRef{Nick: nick})
+}
+
+// applyUserArchive adds a part of a user's archive to what came before,
+// and writes the file once it is all there.
+func (m *model) applyUserArchive(part userArchive) {
+	if m.archive == nil || m.archive.Nick != part.Nick {
+		m.archive = &part
+	} else {
+		m.archive.Messages = append(m.archive.Messages, part.Messages...)
+	}
+	if !part.Done {
+		return
+	}
+	a := m.archive
+	m.archive = nil
+	a.Done = false
+
+	file := "userdata-" + a.Nick + "-" + a.Exported.Local().Format("20060102-150405") + ".json"
+	if dir, err := os.Getwd(); err == nil {
+		file = filepath.Join(dir, file)
+	}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err == nil {
+		err = os.WriteFile(file, append(data, '\n'), 0o600)
+	}
+	if err != nil {
+		m.notice("Export failed: " + err.Error())
+		return
+	}
+	m.notice(fmt.Sprintf("Exported %d messages of %s to %s", len(a.Messages), a.Nick, file))
+}
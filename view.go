@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -8,30 +11,33 @@ func (m *model) View() string {
 	if m.width == 0 {
 		return "Loading..."
 	}
+	if m.setup != nil {
+		return m.setup.View(m.width, m.height)
+	}
+	if m.login != nil {
+		return m.login.View(m.width, m.height)
+	}
 
 	// Sidebar Widths
-	// Fixed content width of 20
-	leftSidebarContentWidth := 20
-	rightSidebarContentWidth := 20
+	// Content widths come from the (resizable) layout settings
+	leftSidebarContentWidth := m.settings.Layout.LeftWidth
+	rightSidebarContentWidth := m.settings.Layout.RightWidth
 
 	// --- 1. HEADER ---
-	leftSide := lipgloss.JoinHorizontal(lipgloss.Center,
-		logoStyle.String(),
-		channelStyle.String(),
-		dividerStyle.String(),
-		topicStyle.String(),
-		dividerStyle.String(),
-	)
+	leftSide := m.renderHeaderLeft()
 	leftWidth := lipgloss.Width(leftSide)
 
-	bellIcon := iconBoxStyle.Render("\uf0f3") //
-	infoIcon := iconBoxStyle.Render("\uf05a") //
+	bellIcon := iconBoxStyle.Render(icons.Bell)
+	if m.activityUnseen > 0 {
+		bellIcon = bellActiveStyle.Render(fmt.Sprintf("%s %d", icons.Bell, m.activityUnseen))
+	}
+	infoIcon := iconBoxStyle.Render(icons.Info)
 	rightSide := lipgloss.JoinHorizontal(lipgloss.Center, bellIcon, infoIcon)
 	rightWidth := lipgloss.Width(rightSide)
 
 	// Header Container Width calculation
-	// headerContainerStyle has border(2) + padding(2) = 4 extra width
-	headerContentWidth := m.centerRenderedWidth - 4
+	// headerContainerStyle has border(2) + padding(2) = 4 extra width, by default
+	headerContentWidth := m.centerRenderedWidth - headerContainerStyle.GetHorizontalFrameSize()
 
 	// Search Bar Width Calculation
 	targetTotalWidth := headerContentWidth - leftWidth - rightWidth
@@ -44,12 +50,12 @@ func (m *model) View() string {
 	// Dynamic style for search input
 	var searchInputView string
 	if m.textInput.Focused() {
-		pinkStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("212"))
+		pinkStyle := lipgloss.NewStyle().Foreground(palette.Accent)
 		m.textInput.TextStyle = pinkStyle
-		m.textInput.PromptStyle = pinkStyle
+		m.textInput.PromptStyle = pinkStyle.Bold(true)
 		searchInputView = searchBaseStyle.Width(searchContentWidth).Render(m.textInput.View())
 	} else {
-		grayStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+		grayStyle := lipgloss.NewStyle().Foreground(palette.Dim)
 		m.textInput.TextStyle = grayStyle
 		m.textInput.PromptStyle = grayStyle
 		searchInputView = searchBaseStyle.Width(searchContentWidth).Render(m.textInput.View())
@@ -57,62 +63,111 @@ func (m *model) View() string {
 
 	headerContent := lipgloss.JoinHorizontal(lipgloss.Center, leftSide, searchInputView, rightSide)
 
+	iconX := leftWidth + lipgloss.Width(searchInputView)
+	m.searchArea = m.headerRect(leftWidth, lipgloss.Width(searchInputView))
+	m.bellArea = m.headerRect(iconX, lipgloss.Width(bellIcon))
+	m.infoArea = m.headerRect(iconX+m.bellArea.w, lipgloss.Width(infoIcon))
+
 	// Set width on container to ensure it fills space
 	header := headerContainerStyle.Width(headerContentWidth).Render(headerContent)
 
+	// --- 1b. BUFFER TABS ---
+	// Unbordered like the status line, so align to centerRenderedWidth-2.
+	tabBar := tabBarStyle.
+		Width(m.centerRenderedWidth - 2).
+		Render(m.renderTabBar(m.leftSidebarRenderedWidth()+tabBarStyle.GetPaddingLeft(), appStyle.GetPaddingTop()+lipgloss.Height(header), m.centerRenderedWidth-4))
+
 	// --- 2. STATUS LINE ---
 	// Status line has no border. Bordered elements render at centerRenderedWidth-2,
 	// so subtract 2 to align with them.
+	// statusLineStyle padding(2) around the text
+	statusText := m.renderStatusText(m.centerRenderedWidth - 2 - 2)
 	statusLine := statusLineStyle.
 		Width(m.centerRenderedWidth - 2).
-		Render("MESSAGE-BUFFER")
+		Render(statusText)
 
 	// --- 3. BOTTOM MESSAGE INPUT ---
-	promptColor := lipgloss.Color("240")
-	borderColor := lipgloss.Color("240")
-	if m.messageInput.Focused() {
-		promptColor = lipgloss.Color("212")
-		borderColor = lipgloss.Color("212")
+	m.messageInput.Placeholder = composerPlaceholder
+	why := m.postBlocked(m.activeChannel())
+	if why != "" {
+		m.messageInput.Placeholder = why
+	} else if m.replyTo != nil {
+		m.messageInput.Placeholder = "Replying to " + m.replyTo.nick + " (esc to cancel)"
+	}
+
+	promptColor := palette.Dim
+	borderColor := palette.Dim
+	focused := m.messageInput.Focused() && why == ""
+	if focused {
+		promptColor = palette.Accent
+		borderColor = palette.Accent
 	}
 
-	prompt := lipgloss.NewStyle().Foreground(promptColor).Render("> ")
-	icons := lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Render(" \uee49 \U000F0066")
+	prompt := lipgloss.NewStyle().Foreground(promptColor).Bold(focused).Render("> ")
+	composerIcons := lipgloss.NewStyle().Foreground(palette.Dim).Render(icons.Composer)
 
 	// Input Box Width Logic
-	// messageBox has border(2) + padding(2) = 4 extra width
-	messageBoxContentWidth := m.centerRenderedWidth - 4
+	// messageBox has border(2) + padding(2) = 4 extra width, by default
+	messageBoxContentWidth := m.centerRenderedWidth - messageBoxStyle.GetHorizontalFrameSize()
 
 	inputContent := lipgloss.JoinHorizontal(lipgloss.Top,
 		prompt,
 		m.messageInput.View(),
-		icons,
+		composerIcons,
 	)
 
 	currentMessageBoxStyle := messageBoxStyle.BorderForeground(borderColor)
+	if focused {
+		currentMessageBoxStyle = focusedBorder(messageBoxStyle)
+	}
 	messageBox := currentMessageBoxStyle.
 		Width(messageBoxContentWidth). // Sets content width
 		Render(inputContent)
+	if wait := m.slowWait(m.activeChannel()); wait > 0 {
+		label := fmt.Sprintf("slow mode %s", wait.Round(time.Second))
+		if reduceMotion {
+			label = "slow mode until " + time.Now().Add(wait).Format("15:04:05")
+		}
+		if currentMessageBoxStyle.GetBorderTop() {
+			messageBox = labelBorder(messageBox, label, currentMessageBoxStyle.GetBorderStyle(), borderColor)
+		} else {
+			// No border to write it in, so on a line of its own
+			label = lipgloss.NewStyle().Foreground(borderColor).PaddingLeft(2).Render(label)
+			messageBox = lipgloss.JoinVertical(lipgloss.Left, label, messageBox)
+		}
+	}
 
-	// --- 4. MAIN CONTENT (Border Box) ---
+	// --- 4. MAIN CONTENT (Border Boxes, one per pane) ---
 	headerH := lipgloss.Height(header)
+	tabBarH := lipgloss.Height(tabBar)
 	statusH := lipgloss.Height(statusLine)
 	messageH := lipgloss.Height(messageBox)
 
 	// Total Available Height Calculation
-	// m.height - appPadding Top(1)
-	availableMainHeight := m.height - 1 - headerH - statusH - messageH
-	if availableMainHeight < 0 {
-		availableMainHeight = 0
+	// m.height - appPadding Top(1), this is the outer height border included
+	availableMainHeight := m.height - appStyle.GetPaddingTop() - headerH - tabBarH - statusH - messageH
+	if availableMainHeight < 2 {
+		availableMainHeight = 2
 	}
 
-	mainContent := mainContentStyle.
-		Width(m.centerRenderedWidth - 4).
-		Height(availableMainHeight).
-		Render("")
+	m.mainArea = rect{
+		x: m.leftSidebarRenderedWidth(),
+		y: appStyle.GetPaddingTop() + headerH + tabBarH + statusH,
+		w: m.centerRenderedWidth - 2,
+		h: availableMainHeight,
+	}
+	mainContent := m.renderMain(m.mainArea.w, m.mainArea.h)
+	m.composerArea = rect{
+		x: m.mainArea.x,
+		y: m.mainArea.y + m.mainArea.h,
+		w: lipgloss.Width(messageBox),
+		h: messageH,
+	}
 
 	// Compose Center Column
 	centerColumn := lipgloss.JoinVertical(lipgloss.Left,
 		header,
+		tabBar,
 		statusLine,
 		mainContent,
 		messageBox,
@@ -121,10 +176,10 @@ func (m *model) View() string {
 	// --- 5. SIDEBARS ---
 	// Calculate sidebar height to match the center column exactly.
 	// centerColumn height includes all vertical components + their margins.
-	// Sidebars have MarginTop(1) and Border(2).
+	// Sidebars have MarginTop(1) and Border(2), by default.
 	// So Content Height = CenterHeight - Margin(1) - Border(2) = CenterHeight - 3.
 	sidebarHeight := lipgloss.Height(centerColumn)
-	sidebarContentHeight := sidebarHeight - 3
+	sidebarContentHeight := sidebarHeight - leftSidebarStyle.GetMarginTop() - leftSidebarStyle.GetVerticalBorderSize()
 
 	if sidebarContentHeight < 0 {
 		sidebarContentHeight = 0
@@ -133,19 +188,19 @@ func (m *model) View() string {
 	leftSidebar := leftSidebarStyle.
 		Width(leftSidebarContentWidth).
 		Height(sidebarContentHeight).
-		Render("")
-
-	rightSidebar := rightSidebarStyle.
-		Width(rightSidebarContentWidth).
-		Height(sidebarContentHeight).
-		Render("")
+		Render(m.renderChannelList(leftSidebarContentWidth-2, sidebarContentHeight))
+
+	columns := []string{leftSidebar, centerColumn}
+	if m.membersVisible() {
+		rightSidebar := rightSidebarStyle.
+			Width(rightSidebarContentWidth).
+			Height(sidebarContentHeight).
+			Render(renderMemberList(m.activeChannel(), rightSidebarContentWidth-2, sidebarContentHeight))
+		columns = append(columns, rightSidebar)
+	}
 
 	// --- 6. COMBINE COLUMNS ---
-	finalView := lipgloss.JoinHorizontal(lipgloss.Top,
-		leftSidebar,
-		centerColumn,
-		rightSidebar,
-	)
+	finalView := lipgloss.JoinHorizontal(lipgloss.Top, columns...)
 
-	return appStyle.Render(finalView)
+	return m.renderOverlay(appStyle.Render(finalView))
 }
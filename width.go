@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/charmbracelet/x/ansi"
+	"github.com/rivo/uniseg"
+)
+
+// Text is measured in terminal cells a grapheme cluster at a time, the way
+// lipgloss lays it out: a CJK character or most emoji take two cells, and
+// a flag or a family emoji, several runes each, is still one cluster. Text
+// is never cut inside one, whether to fit a width or a length in bytes.
+
+// truncate shortens s to at most w cells, marking the cut with an ellipsis.
+// Styles in s are kept.
+func truncate(s string, w int) string {
+	if w <= 0 {
+		return ""
+	}
+	return ansi.Truncate(s, w, "…")
+}
+
+// cutWidth is the longest start of s, which has no styles, that fits in w
+// cells.
+func cutWidth(s string, w int) string {
+	g := uniseg.NewGraphemes(s)
+	end, used := 0, 0
+	for g.Next() {
+		if used += g.Width(); used > w {
+			break
+		}
+		_, end = g.Positions()
+	}
+	return s[:end]
+}
+
+// cutBytes is the longest start of s that is at most n bytes.
+func cutBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	g := uniseg.NewGraphemes(s)
+	end := 0
+	for g.Next() {
+		_, to := g.Positions()
+		if to > n {
+			break
+		}
+		end = to
+	}
+	return s[:end]
+}
+
+// clusterStart is where the grapheme cluster of s that byte i falls in
+// starts.
+func clusterStart(s string, i int) int {
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		from, to := g.Positions()
+		if i < to {
+			return from
+		}
+	}
+	return len(s)
+}
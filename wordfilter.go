@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rivo/uniseg"
+)
+
+// The server can filter what is posted in channels against regular
+// expressions, each with what to do about a match:
+//
+//	block   turn the message away
+//	redact  post it with the matches starred out
+//	flag    post it as it is, for moderators to look at
+//
+// Server-wide rules come from --filter 'redact=(?i)\bdarn\b' (repeatable).
+// Moderators can give a channel rules of its own, which are checked first,
+// and switch the server's off there:
+//
+//	/filter flag https?://\S+    /filter remove 1    /filter server off
+//
+// Every match is written to the review log, with who posted what where,
+// which /filterlog shows a channel's moderators. Edits are filtered like
+// new messages; DMs aren't, the server can't read encrypted ones. A
+// redacted message no longer matches its signature, so signed ones show
+// as changed, which they were.
+
+// Filter actions.
+const (
+	filterBlock  = "block"
+	filterRedact = "redact"
+	filterFlag   = "flag"
+)
+
+const (
+	// maxFilterLog is how many matches the review log keeps.
+	maxFilterLog = 500
+	// maxChannelFilters is how many rules a channel can have.
+	maxChannelFilters = 50
+)
+
+var errFiltered = errors.New("your message was blocked by the word filter")
+
+// filterDone describes what each action did, for the review log.
+var filterDone = map[string]string{filterBlock: "blocked", filterRedact: "redacted", filterFlag: "flagged"}
+
+// filterRule is a pattern and what to do with messages matching it.
+type filterRule struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+	re      *regexp.Regexp
+}
+
+// parseFilterRule parses an action and a pattern.
+func parseFilterRule(action, pattern string) (filterRule, error) {
+	r := filterRule{Action: action, Pattern: pattern}
+	return r, r.compile()
+}
+
+// compile checks the rule and compiles its pattern.
+func (r *filterRule) compile() error {
+	switch r.Action {
+	case filterBlock, filterRedact, filterFlag:
+	default:
+		return fmt.Errorf("unknown filter action %q, want block, redact or flag", r.Action)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.re = re
+	return nil
+}
+
+// channelFilters are a channel's own rules.
+type channelFilters struct {
+	Rules    []filterRule `json:"rules,omitempty"`
+	NoServer bool         `json:"no_server,omitempty"` // the server's rules are off here
+}
+
+// compile compiles every rule, after they were loaded.
+func (f *channelFilters) compile() error {
+	for i := range f.Rules {
+		if err := f.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterHit is a rule matching a message, in the review log.
+type filterHit struct {
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	Action  string    `json:"action"`
+	Pattern string    `json:"pattern"`
+	Text    string    `json:"text"` // as it was sent
+	Time    time.Time `json:"time"`
+}
+
+// --- Server side ---
+
+// addFilter parses a --filter value, action=pattern.
+func (srv *server) addFilter(v string) error {
+	action, pattern, ok := strings.Cut(v, "=")
+	if !ok {
+		return errors.New("want action=pattern, the action being block, redact or flag")
+	}
+	r, err := parseFilterRule(action, pattern)
+	if err != nil {
+		return err
+	}
+	srv.filters = append(srv.filters, r)
+	return nil
+}
+
+// filterLocked runs text posted by s in ch through ch's rules and the
+// server's, logging what matched. It is what to post instead, or
+// errFiltered.
+func (srv *server) filterLocked(s *session, ch *serverChannel, text string) (string, error) {
+	rules := ch.filters.Rules
+	if !ch.filters.NoServer {
+		rules = slices.Concat(rules, srv.filters)
+	}
+	sent := text
+	for _, r := range rules {
+		if !r.re.MatchString(text) {
+			continue
+		}
+		srv.logFilterHitLocked(s, filterHit{
+			Channel: ch.name, Nick: s.nick, Action: r.Action, Pattern: r.Pattern, Text: sent, Time: s.stamp.Time,
+		})
+		switch r.Action {
+		case filterBlock:
+			return "", errFiltered
+		case filterRedact:
+			text = r.re.ReplaceAllStringFunc(text, func(match string) string {
+				return strings.Repeat("*", uniseg.GraphemeClusterCount(match))
+			})
+		}
+	}
+	return text, nil
+}
+
+// logFilterHitLocked adds a hit to the review log. The message goes
+// through even if the log can't be saved.
+func (srv *server) logFilterHitLocked(s *session, hit filterHit) {
+	srv.filterLog = append(srv.filterLog, hit)
+	if n := len(srv.filterLog) - maxFilterLog; n > 0 {
+		srv.filterLog = slices.Delete(srv.filterLog, 0, n)
+	}
+	s.persistLocked(func(st serverStore) error { return st.logFilterHit(hit) })
+}
+
+// handleFilter changes a channel's rules, if asked to, and replies with
+// them. It takes a role that may moderate there.
+func (srv *server) handleFilter(s *session, f frame) error {
+	var req filterRequest
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	d, err := srv.changeFiltersLocked(s, req)
+	srv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.reply(f, newFrame(frameFilters, d))
+	return nil
+}
+
+// changeFiltersLocked is handleFilter's work under srv.mu.
+func (srv *server) changeFiltersLocked(s *session, req filterRequest) (filtersData, error) {
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		return filtersData{}, errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permModerate) {
+		return filtersData{}, errNotPermitted
+	}
+	next := ch.filters
+	next.Rules = slices.Clone(next.Rules)
+	switch {
+	case req.Add != nil:
+		r, err := parseFilterRule(req.Add.Action, req.Add.Pattern)
+		if err != nil {
+			return filtersData{}, err
+		}
+		if len(next.Rules) >= maxChannelFilters {
+			return filtersData{}, fmt.Errorf("a channel can have at most %d filter rules", maxChannelFilters)
+		}
+		next.Rules = append(next.Rules, r)
+	case req.Remove > 0:
+		if req.Remove > len(next.Rules) {
+			return filtersData{}, fmt.Errorf("%s has no filter rule %d", ch.name, req.Remove)
+		}
+		next.Rules = slices.Delete(next.Rules, req.Remove-1, req.Remove)
+	case req.Server != nil:
+		next.NoServer = !*req.Server
+	}
+	if req.Add != nil || req.Remove > 0 || req.Server != nil {
+		saved := *ch
+		saved.filters = next
+		if err := s.persistLocked(func(st serverStore) error { return st.saveChannel(&saved) }); err != nil {
+			return filtersData{}, err
+		}
+		ch.filters = next
+	}
+	return filtersData{Channel: ch.name, Rules: next.Rules, NoServer: next.NoServer, ServerRules: len(srv.filters)}, nil
+}
+
+// handleFilterLog replies with a channel's part of the review log, for
+// those whose role may moderate there.
+func (srv *server) handleFilterLog(s *session, f frame) error {
+	var req filterLogData
+	if err := f.decode(&req); err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	ch, ok := srv.channels[req.Channel]
+	if !ok {
+		srv.mu.Unlock()
+		return errNoSuchChannel
+	}
+	if _, member := ch.members[s.nick]; !member || !srv.canLocked(ch, s.nick, permModerate) {
+		srv.mu.Unlock()
+		return errNotPermitted
+	}
+	for _, hit := range srv.filterLog {
+		if hit.Channel == req.Channel {
+			req.Hits = append(req.Hits, hit)
+		}
+	}
+	srv.mu.Unlock()
+
+	s.reply(f, newFrame(frameFilterHits, req))
+	return nil
+}
+
+// --- Client side ---
+
+// cmdFilter shows or changes the current channel's filter rules.
+func cmdFilter(m *model, args string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("Word filters are for channels")
+		return nil
+	}
+	req := filterRequest{Channel: ch.name}
+	word, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+	switch word {
+	case "":
+	case filterBlock, filterRedact, filterFlag:
+		if rest == "" {
+			m.notice("Usage: /filter " + word + " <regexp>")
+			return nil
+		}
+		r, err := parseFilterRule(word, rest)
+		if err != nil {
+			m.notice("Bad filter pattern: " + err.Error())
+			return nil
+		}
+		req.Add = &r
+	case "remove":
+		n, err := strconv.Atoi(rest)
+		if err != nil || n <= 0 {
+			m.notice("Usage: /filter remove <number from /filter>")
+			return nil
+		}
+		req.Remove = n
+	case "server":
+		on := rest == "on"
+		if !on && rest != "off" {
+			m.notice("Usage: /filter server on|off")
+			return nil
+		}
+		req.Server = &on
+	default:
+		m.notice("Usage: /filter [block|redact|flag <regexp> | remove <n> | server on|off]")
+		return nil
+	}
+	if !m.allowed(ch, permModerate, "manage the word filter") {
+		return nil
+	}
+	return m.request(frameFilter, req)
+}
+
+// showFilters lists a channel's filter rules, as the server replied.
+func (m *model) showFilters(d filtersData) {
+	ch := m.channelByName(d.Channel)
+	if ch == nil {
+		return
+	}
+	server := fmt.Sprintf("%d server rules apply here", d.ServerRules)
+	if d.NoServer {
+		server = fmt.Sprintf("the %d server rules are off here", d.ServerRules)
+	}
+	if len(d.Rules) == 0 {
+		m.noticeIn(ch, ch.name+" has no filter rules of its own, "+server)
+		return
+	}
+	m.noticeIn(ch, ch.name+"'s filter rules, checked before the server's ("+server+"):")
+	for i, r := range d.Rules {
+		m.noticeIn(ch, fmt.Sprintf("%d. %s %s", i+1, r.Action, r.Pattern))
+	}
+}
+
+func cmdFilterLog(m *model, _ string) tea.Cmd {
+	ch := m.activeChannel()
+	if ch == nil || ch.isDM() {
+		m.notice("The filter log is per channel")
+		return nil
+	}
+	if !m.allowed(ch, permModerate, "see the filter log") {
+		return nil
+	}
+	return m.request(frameFilterLog, filterLogData{Channel: ch.name})
+}
+
+// filterLogView is the overlay listing a channel's filter matches, newest
+// first.
+type filterLogView struct {
+	channel string
+	hits    []filterHit
+	offset  int // hits scrolled past
+}
+
+func newFilterLogView(d filterLogData) *filterLogView {
+	slices.Reverse(d.Hits)
+	return &filterLogView{channel: d.Channel, hits: d.Hits}
+}
+
+func (v *filterLogView) Update(msg tea.Msg) (overlay, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return v, nil
+	}
+	switch {
+	case key.Matches(keyMsg, keys.Cancel), key.Matches(keyMsg, keys.Select):
+		return nil, nil
+	case key.Matches(keyMsg, keys.Up):
+		v.offset = max(v.offset-1, 0)
+	case key.Matches(keyMsg, keys.Down):
+		v.offset = min(v.offset+1, max(len(v.hits)-1, 0))
+	}
+	return v, nil
+}
+
+func (v *filterLogView) View(width, height int) string {
+	w := min(70, width-4)
+	inner := w - 2
+
+	lines := []string{overlayTitleStyle.Render(fmt.Sprintf("Filter log of %s (%d)", v.channel, len(v.hits)))}
+	if len(v.hits) == 0 {
+		lines = append(lines, "", overlayHintStyle.Render("Nothing matched the filter here"))
+	}
+	used := 4
+	for i, hit := range v.hits[v.offset:] {
+		entry := lipgloss.JoinVertical(lipgloss.Left,
+			"",
+			overlayHintStyle.Render(hit.Time.Local().Format("2 Jan 15:04")+" "+filterDone[hit.Action]+" by "+truncate(hit.Pattern, inner-30)),
+			nickStyle(hit.Nick).Render(hit.Nick)+" "+lipgloss.NewStyle().Width(inner-lipgloss.Width(hit.Nick)-1).Render(hit.Text),
+		)
+		if used += lipgloss.Height(entry); used > height-2 && i > 0 {
+			break
+		}
+		lines = append(lines, entry)
+	}
+	lines = append(lines, "", overlayHintStyle.Render("↑/↓ scroll • esc close"))
+
+	return overlayStyle.Width(w).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}